@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+)
+
+// Copy buffer sizes compared by the read and write benchmarks.
+var benchBufferSizes = []int64{32 << 10, 128 << 10, 1 << 20, 4 << 20}
+
+// Size of every file in the small file benchmark.
+const benchSmallFileSize = 4 << 10
+
+var (
+	benchSource     string
+	benchDest       string
+	benchFileSize   string
+	benchSmallFiles int
+	benchMaxWorkers int
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure read, write and small-file throughput to tune gosync for this hardware",
+	Long: `bench writes scratch files below the given source and destination directories and measures
+	sequential read and write throughput for several buffer sizes, and how many small files per second
+	a sync gets through with a growing number of workers. It suggests a --workers value from the results.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fileSize, err := parseSize(benchFileSize)
+		if err != nil || fileSize <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --file-size value %q\n", benchFileSize)
+			os.Exit(1)
+		}
+		if benchMaxWorkers < 1 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --max-workers value %d, expected 1 or more\n", benchMaxWorkers)
+			os.Exit(1)
+		}
+
+		if err := runBench(fileSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Benchmark failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// Result of one benchmark round.
+type benchResult struct {
+	label string
+	value float64 // Bytes or files per second
+}
+
+func runBench(fileSize int64) error {
+	sourceDir, err := os.MkdirTemp(benchSource, "gosync-bench-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(sourceDir)
+
+	destDir, err := os.MkdirTemp(benchDest, "gosync-bench-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(destDir)
+
+	fmt.Printf("-- Go Sync Bench ---\n")
+	fmt.Printf("Source: %s\n", benchSource)
+	fmt.Printf("Destination: %s\n", benchDest)
+	fmt.Printf("File size: %s, small files: %d\n", formatBytes(fileSize), benchSmallFiles)
+	fmt.Printf("-------------------------------------------------- \n")
+
+	// Sequential writes to the destination
+	var writes []benchResult
+	for _, bufferSize := range benchBufferSizes {
+		elapsed, err := benchWrite(filepath.Join(destDir, "write.bin"), fileSize, bufferSize)
+		if err != nil {
+			return fmt.Errorf("destination write: %w", err)
+		}
+		writes = append(writes, benchResult{formatBytes(bufferSize), float64(fileSize) / elapsed.Seconds()})
+	}
+	printBenchResults("Destination write", "BUFFER", writes, formatRate)
+
+	// Sequential reads from the source, of a file written there first
+	readPath := filepath.Join(sourceDir, "read.bin")
+	if _, err := benchWrite(readPath, fileSize, 1<<20); err != nil {
+		return fmt.Errorf("source write: %w", err)
+	}
+	var reads []benchResult
+	for _, bufferSize := range benchBufferSizes {
+		elapsed, err := benchRead(readPath, bufferSize)
+		if err != nil {
+			return fmt.Errorf("source read: %w", err)
+		}
+		reads = append(reads, benchResult{formatBytes(bufferSize), float64(fileSize) / elapsed.Seconds()})
+	}
+	printBenchResults("Source read", "BUFFER", reads, formatRate)
+
+	// Syncs of many small files, where metadata operations dominate
+	smallDir := filepath.Join(sourceDir, "small")
+	if err := writeSmallFiles(smallDir, benchSmallFiles); err != nil {
+		return fmt.Errorf("source write: %w", err)
+	}
+	var syncs []benchResult
+	for workers := 1; workers <= benchMaxWorkers; workers *= 2 {
+		elapsed, err := benchSync(smallDir, filepath.Join(destDir, fmt.Sprintf("small-%d", workers)), workers)
+		if err != nil {
+			return fmt.Errorf("small file sync: %w", err)
+		}
+		syncs = append(syncs, benchResult{fmt.Sprint(workers), float64(benchSmallFiles) / elapsed.Seconds()})
+	}
+	printBenchResults("Small file sync", "WORKERS", syncs, func(v float64) string { return fmt.Sprintf("%.0f files/s", v) })
+
+	// Prefer fewer workers unless more of them are clearly faster
+	fmt.Printf("\nSuggested: --workers %s\n", fastest(syncs, 0.05).label)
+	fmt.Printf("Fastest buffer size: %s write, %s read\n", fastest(writes, 0.05).label, fastest(reads, 0.05).label)
+
+	return nil
+}
+
+// Writes size bytes to a new file at path in chunks of bufferSize and syncs it to disk.
+func benchWrite(path string, size, bufferSize int64) (time.Duration, error) {
+	buffer := make([]byte, bufferSize)
+	for i := range buffer {
+		buffer[i] = byte(i * 31) // Not all zeroes, so compressing filesystems can't cheat
+	}
+
+	startTime := time.Now()
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	for written := int64(0); written < size; written += bufferSize {
+		if _, err := file.Write(buffer[:min(bufferSize, size-written)]); err != nil {
+			return 0, err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+
+	return time.Since(startTime), nil
+}
+
+// Reads the file at path in chunks of bufferSize, evicting it from the page cache first where possible.
+func benchRead(path string, bufferSize int64) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	dropCache(file)
+
+	startTime := time.Now()
+	if _, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{file}, make([]byte, bufferSize)); err != nil {
+		return 0, err
+	}
+
+	return time.Since(startTime), nil
+}
+
+func writeSmallFiles(dir string, count int) error {
+	data := make([]byte, benchSmallFileSize)
+	for i := 0; i < count; i++ {
+		// Spread the files over directories the way real trees are
+		path := filepath.Join(dir, fmt.Sprintf("d%03d", i/100), fmt.Sprintf("f%05d", i))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Syncs source to a new destination with the given number of workers.
+func benchSync(source, dest string, workers int) (time.Duration, error) {
+	syncerTool := syncer.NewSyncer(source, dest,
+		syncer.WithOptions(&syncer.SyncOptions{LogWriter: io.Discard}),
+		syncer.WithWorkers(workers))
+
+	result, err := syncerTool.Start()
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Duration, nil
+}
+
+// Returns the first result within tolerance of the best one.
+func fastest(results []benchResult, tolerance float64) benchResult {
+	best := results[0]
+	for _, result := range results {
+		if result.value > best.value {
+			best = result
+		}
+	}
+
+	for _, result := range results {
+		if result.value >= best.value*(1-tolerance) {
+			return result
+		}
+	}
+	return best
+}
+
+func printBenchResults(title, column string, results []benchResult, format func(float64) string) {
+	fmt.Printf("\n%s:\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  %s\tTHROUGHPUT\n", column)
+	for _, result := range results {
+		fmt.Fprintf(w, "  %s\t%s\n", result.label, format(result.value))
+	}
+	w.Flush()
+}
+
+func formatRate(bytesPerSecond float64) string {
+	return formatBytes(int64(bytesPerSecond)) + "/s"
+}
+
+func init() {
+	benchCmd.Flags().StringVarP(&benchSource, "source", "s", os.TempDir(), "Directory on the source filesystem to benchmark in.")
+	benchCmd.Flags().StringVarP(&benchDest, "dest", "d", os.TempDir(), "Directory on the destination filesystem to benchmark in.")
+	benchCmd.Flags().StringVar(&benchFileSize, "file-size", "256MB", "Size of the file used for the read and write benchmarks.")
+	benchCmd.Flags().IntVar(&benchSmallFiles, "small-files", 2000, "Number of 4 KiB files used for the small file benchmark.")
+	benchCmd.Flags().IntVar(&benchMaxWorkers, "max-workers", 4*runtime.NumCPU(), "Highest worker count tried, doubling from 1.")
+
+	rootCmd.AddCommand(benchCmd)
+}
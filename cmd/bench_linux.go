@@ -0,0 +1,14 @@
+//go:build linux
+
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Asks the kernel to evict the file from the page cache so reads come from the disk.
+func dropCache(file *os.File) {
+	unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
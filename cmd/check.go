@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+)
+
+var ignoreMtimes bool
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Exit non-zero if the destination is out of sync with the source, changing nothing",
+	Long: `check compares --source and --dest as a sync with the same flags would and exits with 1 if the
+	destination is out of sync, listing what differs, or with 0 if it is current. It changes
+	nothing, so pipelines can assert that a mirror is up to date:
+
+	  gosync check -s ./site -d s3://www-example/ --delete --ignore-mtimes
+
+	Files only at the destination count with --delete. With --ignore-mtimes files of the same size
+	are hashed instead of judged by their modification times, and times alone don't count, for
+	trees checked out or unpacked anew. Errors exit with 2.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if opts.SourcePath == "" || len(destinations) != 1 {
+			cmd.Help()
+			fmt.Fprintln(os.Stderr, "\nError: --source and one --dest are required.")
+			os.Exit(2)
+		}
+
+		// Remotes of rclone crypt have no marker to tell
+		opts.Decrypt = opts.EncryptFormat == syncer.EncryptRclone
+		if err := askPassphraseFor(opts.SourcePath, destinations[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if err := validateOptions(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		differences, err := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts)).Check(ctx, ignoreMtimes)
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Check interrupted")
+			os.Exit(130)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Check failed: %v\n", err)
+			os.Exit(2)
+		}
+
+		if len(differences) > 0 {
+			printDifferences(differences)
+			fmt.Printf("\n %s is out of sync with %s, %d path(s) differ\n", opts.DestinationPath, opts.SourcePath, len(differences))
+			os.Exit(exitDifferent)
+		}
+		fmt.Printf("%s is in sync with %s\n", opts.DestinationPath, opts.SourcePath)
+	},
+}
+
+func init() {
+	checkCmd.Flags().BoolVar(&ignoreMtimes, "ignore-mtimes", false, "If present modification times don't count, files of the same size are hashed instead.")
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var configPath string
+
+// The settings of a config file. Profiles and jobs hold options by their flag names.
+type configFile struct {
+	Profiles map[string]yaml.Node `yaml:"profiles"` // Options gosync run is given by name
+
+	Workers   int                  `yaml:"workers"`   // Copy workers shared by all jobs of the daemon
+	Bandwidth string               `yaml:"bandwidth"` // Combined write rate of all jobs, e.g. 20MB
+	Jobs      map[string]yaml.Node `yaml:"jobs"`      // Scheduled syncs of the daemon
+}
+
+// Reads the config file given by --config, or gosync/config.yaml in the user config
+// directory.
+func loadConfig() (*configFile, error) {
+	if configPath == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("%v, give --config.", err)
+		}
+		configPath = filepath.Join(configDir, "gosync", "config.yaml")
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %v.", err)
+	}
+
+	config := &configFile{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v.", configPath, err)
+	}
+	return config, nil
+}
+
+// Puts every sync flag back to its default, along with what validateOptions derived
+// from them, so the flags can be set again for another job.
+func resetOptions(flags *pflag.FlagSet) {
+	*opts = syncer.SyncOptions{}
+	cronSchedule = nil
+
+	flags.VisitAll(func(f *pflag.Flag) {
+		// --config and --log-file belong to the process, not to one job
+		if rootCmd.PersistentFlags().Lookup(f.Name) != nil {
+			return
+		}
+
+		switch value := f.Value.(type) {
+		case pathRuleFlag:
+			// Appends to opts.PathRules, emptied above
+		case pflag.SliceValue:
+			value.Replace(nil)
+		default:
+			value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+}
+
+// Sets the flags named by the keys of a YAML mapping to its values, in the order they are
+// written, as if they were given on the command line. A list sets a repeatable flag once
+// per item. Flags already given on the command line keep their value.
+func setFlags(flags *pflag.FlagSet, settings *yaml.Node) error {
+	if settings.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected option: value pairs", settings.Line)
+	}
+
+	// Visit would include flags set for an earlier job, resetOptions only clears Changed
+	given := make(map[string]bool)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			given[f.Name] = true
+		}
+	})
+
+	for i := 0; i+1 < len(settings.Content); i += 2 {
+		key, value := settings.Content[i], settings.Content[i+1]
+		if flags.Lookup(key.Value) == nil {
+			return fmt.Errorf("line %d: unknown option %q", key.Line, key.Value)
+		}
+		if given[key.Value] {
+			continue
+		}
+
+		values := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, item := range values {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: expected a value or a list of values for %s", item.Line, key.Value)
+			}
+			if err := flags.Set(key.Value, item.Value); err != nil {
+				return fmt.Errorf("line %d: %v", item.Line, err)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file with the profiles of gosync run and the jobs of gosync daemon, gosync/config.yaml in the user config directory by default.")
+}
@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/schedule"
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var daemonAPI string
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run the sync jobs of a config file, each on its own schedule",
+	Long: `daemon keeps running and syncs every job of the config file at the times of its schedule.
+	A job takes the options gosync does, by their flag names, and needs source, dest and schedule:
+
+	  workers: 8          # copy workers shared by all jobs, NumCPU by default
+	  bandwidth: 20MB     # combined write rate of all jobs per second, unlimited by default
+	  jobs:
+	    photos:
+	      source: /home/me/Pictures
+	      dest: /mnt/backup/pictures
+	      schedule: "0 * * * *"
+	      delete: true
+	      exclude: ["*.tmp", "cache/"]
+	      pre-cmd: mount /mnt/backup
+
+	Jobs run side by side on the shared workers, a job never alongside itself. Interrupting the
+	daemon lets running syncs finish.
+
+	SIGHUP, or a POST to /reload of --api, reads the config file again without a restart: new
+	jobs start, removed jobs stop once their running sync is done, changed jobs go on with their
+	new settings from their next sync, and workers and bandwidth change right away. A config
+	that doesn't load leaves the running one in place.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, jobs, err := loadDaemonConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		bandwidth, err := daemonBandwidth(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		pool := syncer.NewPool(config.Workers, bandwidth)
+		defer pool.Close()
+
+		ctx, stop := notifyContext()
+		defer stop()
+
+		fmt.Printf("-- Go Sync Daemon ---\n")
+		fmt.Printf("Config: %s\n", configPath)
+		for _, job := range jobs {
+			printJob("Job", job)
+		}
+		fmt.Printf("-------------------------------------------------- \n")
+
+		// Caught before any job starts, SIGHUP would kill the daemon until then
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		defer signal.Stop(hangup)
+
+		d := &daemon{ctx: ctx, pool: pool, running: make(map[string]*runningJob)}
+		for _, job := range jobs {
+			d.start(job, nil)
+		}
+
+		if daemonAPI != "" {
+			if err := d.serveAPI(daemonAPI); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				stop()
+			}
+		}
+
+		// Reloads until stopped
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-hangup:
+				d.reload()
+			}
+		}
+		d.wg.Wait()
+
+		fmt.Println("Daemon stopped")
+	},
+}
+
+// Reads the combined bandwidth of all jobs from the config, 0 when unlimited.
+func daemonBandwidth(config *configFile) (int64, error) {
+	if config.Bandwidth == "" {
+		return 0, nil
+	}
+	bandwidth, err := parseSize(config.Bandwidth)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth value: %v", err)
+	}
+	return bandwidth, nil
+}
+
+// Prints what job syncs when, after label.
+func printJob(label string, job *daemonJob) {
+	fmt.Printf("%s %s: %s -> %s, %s\n", label, job.name, job.opts.SourcePath, strings.Join(append([]string{job.opts.DestinationPath}, job.opts.ExtraDestinations...), ", "), job.scheduleSpec)
+}
+
+// The jobs a running daemon syncs, which reloads of the config replace.
+type daemon struct {
+	ctx  context.Context // Done when the daemon stops, which stops running syncs too
+	pool *syncer.Pool
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex // Held for the whole of a reload
+	running map[string]*runningJob
+}
+
+// A job the daemon syncs on its schedule until it is retired.
+type runningJob struct {
+	job    *daemonJob
+	retire context.CancelFunc // Stops it once the sync it is running, if any, is done
+	done   chan struct{}
+}
+
+// Syncs job on its schedule, once previous, the job it replaces, is done.
+func (d *daemon) start(job *daemonJob, previous *runningJob) {
+	job.opts.Pool = d.pool
+	ctx, retire := context.WithCancel(d.ctx)
+	running := &runningJob{job: job, retire: retire, done: make(chan struct{})}
+	d.running[job.name] = running
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(running.done)
+
+		// A job never runs alongside itself, not even across a reload
+		if previous != nil {
+			select {
+			case <-previous.done:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// Retiring only stops the wait for the next sync, the one running goes on
+		runOnSchedule(ctx, "["+job.name+"] ", job.schedule, job.jitter, func(context.Context) { job.run(d.ctx) })
+	}()
+}
+
+// Reads the config file again and applies what changed in it. A config that doesn't
+// load changes nothing.
+func (d *daemon) reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ctx.Err() != nil {
+		return errors.New("the daemon is stopping.")
+	}
+
+	config, jobs, err := loadDaemonConfig()
+	if err == nil {
+		var bandwidth int64
+		if bandwidth, err = daemonBandwidth(config); err == nil {
+			d.pool.Resize(config.Workers)
+			d.pool.SetBandwidth(bandwidth)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Reload failed, keeping the running config: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("\nReloaded config %s\n", configPath)
+	loaded := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		loaded[job.name] = true
+		running, ok := d.running[job.name]
+		switch {
+		case !ok:
+			printJob("Added job", job)
+			d.start(job, nil)
+		case running.job.settings != job.settings:
+			printJob("Changed job", job)
+			running.retire()
+			d.start(job, running)
+		}
+	}
+	for name, running := range d.running {
+		if !loaded[name] {
+			fmt.Printf("Removed job %s, it stops once its running sync is done\n", name)
+			running.retire()
+			delete(d.running, name)
+		}
+	}
+	return nil
+}
+
+// Serves the HTTP API of the daemon on address until it stops. It has no authentication,
+// so it is meant to listen on localhost.
+func (d *daemon) serveAPI(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v.", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := d.reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		fmt.Fprintln(w, "Config reloaded")
+	})
+
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	go func() {
+		<-d.ctx.Done()
+		server.Close()
+	}()
+	fmt.Printf("API: http://%s\n", listener.Addr())
+	return nil
+}
+
+// A sync job of the daemon, with the options its settings amount to.
+type daemonJob struct {
+	name         string
+	settings     string // As written in the config, to tell whether a reload changed them
+	opts         syncer.SyncOptions
+	scheduleSpec string
+	schedule     *schedule.Schedule
+	jitter       time.Duration
+	reportPath   string
+	preCmd       string
+	postCmd      string
+}
+
+// Reads the config file and the jobs in it, sorted by name.
+func loadDaemonConfig() (*configFile, []*daemonJob, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(config.Jobs) == 0 {
+		return nil, nil, fmt.Errorf("config %s has no jobs.", configPath)
+	}
+	if config.Workers < 0 {
+		return nil, nil, fmt.Errorf("invalid workers value %d, expected 0 or more.", config.Workers)
+	}
+
+	var jobs []*daemonJob
+	for name, settings := range config.Jobs {
+		job, err := loadDaemonJob(name, &settings)
+		if err != nil {
+			return nil, nil, fmt.Errorf("job %s: %v", name, err)
+		}
+		written, _ := yaml.Marshal(&settings)
+		job.settings = string(written)
+		jobs = append(jobs, job)
+	}
+	slices.SortFunc(jobs, func(a, b *daemonJob) int { return strings.Compare(a.name, b.name) })
+	return config, jobs, nil
+}
+
+// Turns the settings of a job into its options, through the flags of gosync so they are
+// checked the same way.
+func loadDaemonJob(name string, settings *yaml.Node) (*daemonJob, error) {
+	flags := rootCmd.Flags()
+	resetOptions(flags)
+	if err := setFlags(flags, settings); err != nil {
+		return nil, fmt.Errorf("%v.", err)
+	}
+
+	if opts.SourcePath == "" || len(destinations) == 0 {
+		return nil, fmt.Errorf("source and dest are required.")
+	}
+	if scheduleSpec == "" {
+		return nil, fmt.Errorf("schedule is required.")
+	}
+	if tui {
+		return nil, fmt.Errorf("tui can't be used in jobs.")
+	}
+	if showProgress || progressFiles {
+		return nil, fmt.Errorf("progress can't be shown in jobs.")
+	}
+	if err := validateOptions(); err != nil {
+		return nil, err
+	}
+
+	return &daemonJob{
+		name:         name,
+		opts:         *opts,
+		scheduleSpec: scheduleSpec,
+		schedule:     cronSchedule,
+		jitter:       jitter,
+		reportPath:   reportPath,
+		preCmd:       preCmd,
+		postCmd:      postCmd,
+	}, nil
+}
+
+// Runs one sync of the job and reports it in a line.
+func (j *daemonJob) run(ctx context.Context) {
+	opts := j.opts
+	if err := runHook(ctx, "pre-cmd", j.preCmd, &opts); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Synchronization skipped: %v\n", j.name, err)
+		return
+	}
+	syncerTool := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(&opts))
+
+	result, err := syncerTool.StartContext(ctx)
+	// Also when the sync was stopped, its result is worth knowing
+	if err := runHook(context.WithoutCancel(ctx), "post-cmd", j.postCmd, &opts, resultEnv(result, err)...); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] Error: %v\n", j.name, err)
+	}
+	if err != nil && !filesFailed(err) {
+		fmt.Fprintf(os.Stderr, "[%s] Synchronization failed: %v\n", j.name, err)
+		return
+	}
+
+	fmt.Printf("[%s] Synchronization completed in %v: %d files copied (%s), %d deleted, %d errors\n",
+		j.name, result.Duration.Round(time.Millisecond), result.FilesCopied, formatBytes(result.BytesCopied), result.FilesDeleted, len(result.Errors))
+	for _, fileErr := range result.Errors {
+		fmt.Fprintf(os.Stderr, "[%s] Failed: %v\n", j.name, fileErr)
+	}
+
+	if j.reportPath != "" {
+		if err := writeReport(j.reportPath, syncerTool.Summary()); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Could not write report: %v\n", j.name, err)
+		}
+	}
+}
+
+func init() {
+	daemonCmd.Flags().StringVar(&daemonAPI, "api", "", "Address to serve the HTTP API on, e.g. localhost:8731, where a POST to /reload reloads the config. It has no authentication.")
+
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit code of a diff that found differences, like diff(1). Errors exit with 2.
+const exitDifferent = 1
+
+var diffCmd = &cobra.Command{
+	Use:   "diff SRC DST",
+	Short: "List the differences between two trees without syncing",
+	Long: `diff compares SRC and DST like a sync would and lists every path that differs, without changing
+	anything:
+
+	  only-in-source    SRC has it, DST doesn't
+	  only-in-dest      DST has it, SRC doesn't
+	  content-differs   size or contents differ, or one has a file where the other has a directory
+	  metadata-differs  same contents, different mode or modification time
+
+	Either side can be a remote location as --dest takes it. Filters apply, and --compare or -c
+	decide when contents are hashed. gosync exits with 1 when the trees differ, like diff.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if opts.SourcePath != "" || len(destinations) > 0 {
+			fmt.Fprintln(os.Stderr, "Error: diff takes the trees as arguments, not --source and --dest.")
+			os.Exit(2)
+		}
+		opts.SourcePath, destinations = args[0], args[1:]
+
+		// Remotes of rclone crypt have no marker to tell
+		opts.Decrypt = opts.EncryptFormat == syncer.EncryptRclone
+		if err := askPassphraseFor(args...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if err := validateOptions(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		differences, err := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts)).Diff(ctx)
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Diff interrupted")
+			os.Exit(130)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Diff failed: %v\n", err)
+			os.Exit(2)
+		}
+
+		printDifferences(differences)
+		if len(differences) > 0 {
+			os.Exit(exitDifferent)
+		}
+	},
+}
+
+// Prints a line for each difference, its kind and path along with the details.
+func printDifferences(differences []syncer.Difference) {
+	for _, difference := range differences {
+		if len(difference.Details) > 0 {
+			fmt.Printf("%-17s %s (%s)\n", difference.Kind, difference.Path, strings.Join(difference.Details, ", "))
+		} else {
+			fmt.Printf("%-17s %s\n", difference.Kind, difference.Path)
+		}
+	}
+}
+
+// Asks for the passphrase when one of locations is encrypted, or Decrypt is set.
+func askPassphraseFor(locations ...string) error {
+	for _, location := range locations {
+		encrypted, err := syncer.Encrypted(location)
+		if err != nil {
+			return err
+		}
+		if encrypted || opts.Decrypt {
+			return askPassphrase(false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Severity of a doctor finding.
+type findingLevel string
+
+const (
+	levelOK   findingLevel = "OK"
+	levelWarn findingLevel = "WARN"
+	levelFail findingLevel = "FAIL"
+	levelInfo findingLevel = "INFO"
+)
+
+// Result of a single diagnostic check, with a hint on how to fix it when it isn't OK.
+type finding struct {
+	level   findingLevel
+	check   string
+	message string
+	hint    string
+}
+
+// Destination clocks further off than this break modification time comparisons.
+const maxClockSkew = 2 * time.Second
+
+// Checks only available on some platforms, registered by their init functions.
+var platformChecks []func(source, dest string, tree treeInfo) finding
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check source and destination for common problems before a sync",
+	Long: `doctor inspects the source and destination of a planned sync and reports problems that
+	would make it fail or behave unexpectedly, together with what to do about them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if opts.SourcePath == "" || opts.DestinationPath == "" {
+			cmd.Help()
+			fmt.Fprintln(os.Stderr, "\nError: --source and --dest are required arguments.")
+			os.Exit(1)
+		}
+
+		findings := runDoctor(opts.SourcePath, opts.DestinationPath)
+
+		failed := false
+		for _, f := range findings {
+			fmt.Printf("[%-4s] %s: %s\n", f.level, f.check, f.message)
+			if f.hint != "" && f.level != levelOK {
+				fmt.Printf("       → %s\n", f.hint)
+			}
+			failed = failed || f.level == levelFail
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// What a walk of the source found out that several checks need.
+type treeInfo struct {
+	files       int64
+	directories int64
+	bytes       int64
+	longestPath int
+}
+
+func runDoctor(source, dest string) []finding {
+	findings := []finding{checkSource(source)}
+	if findings[0].level == levelFail {
+		return findings
+	}
+
+	destFinding, destDir := checkDestination(dest)
+	findings = append(findings, destFinding)
+
+	tree := walkTree(source)
+
+	// Everything below needs a writable destination directory to probe
+	if destFinding.level != levelFail {
+		findings = append(findings,
+			checkCaseSensitivity(source, destDir),
+			checkFreeSpace(destDir, tree),
+			checkClockSkew(destDir),
+		)
+	}
+
+	for _, check := range platformChecks {
+		findings = append(findings, check(source, dest, tree))
+	}
+
+	return findings
+}
+
+func checkSource(source string) finding {
+	const check = "source"
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return finding{levelFail, check, err.Error(), "Check the --source path for typos and that it is mounted."}
+	}
+	if !info.IsDir() {
+		return finding{levelFail, check, source + " is not a directory", "Pass the directory containing the files to sync."}
+	}
+	if _, err := os.ReadDir(source); err != nil {
+		return finding{levelFail, check, err.Error(), "Run gosync as a user that can read the source."}
+	}
+
+	return finding{levelOK, check, source + " is readable", ""}
+}
+
+// Returns the finding for the destination and the closest existing directory, which a run would create it in.
+func checkDestination(dest string) (finding, string) {
+	const check = "destination"
+
+	dir := dest
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return finding{levelFail, check, dir + " is not a directory", "Pass a directory as --dest."}, dir
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(dir) == dir {
+			return finding{levelFail, check, err.Error(), "Check the --dest path and that it is mounted."}, dir
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	probe, err := os.CreateTemp(dir, ".gosync-doctor-")
+	if err != nil {
+		return finding{levelFail, check, dir + " is not writable: " + err.Error(), "Run gosync as a user that can write the destination."}, dir
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if dir != dest {
+		return finding{levelOK, check, dest + " doesn't exist yet and will be created in " + dir, ""}, dir
+	}
+	return finding{levelOK, check, dest + " is writable", ""}, dir
+}
+
+func walkTree(source string) treeInfo {
+	var tree treeInfo
+	filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if len(path) > tree.longestPath {
+			tree.longestPath = len(path)
+		}
+
+		if d.IsDir() {
+			tree.directories++
+		} else if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			tree.files++
+			tree.bytes += info.Size()
+		}
+		return nil
+	})
+	return tree
+}
+
+// Reports whether the filesystem holding dir treats names differing only in case as the same file.
+func caseInsensitive(dir string) (bool, error) {
+	probe, err := os.CreateTemp(dir, ".gosync-case-")
+	if err != nil {
+		return false, err
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+
+	upper := filepath.Join(dir, strings.ToUpper(filepath.Base(probe.Name())))
+	_, err = os.Stat(upper)
+	return err == nil, nil
+}
+
+func checkCaseSensitivity(source, destDir string) finding {
+	const check = "case sensitivity"
+
+	// The source may be read-only, so only a failed destination probe is an error
+	destInsensitive, err := caseInsensitive(destDir)
+	if err != nil {
+		return finding{levelWarn, check, "could not probe destination: " + err.Error(), ""}
+	}
+	sourceInsensitive, err := caseInsensitive(source)
+	if err != nil {
+		return finding{levelInfo, check, "could not probe source, it is probably read-only", ""}
+	}
+
+	if destInsensitive && !sourceInsensitive {
+		return finding{levelWarn, check, "source is case-sensitive but destination is not",
+			"Files whose names differ only in case, like README and readme, will overwrite each other."}
+	}
+	return finding{levelOK, check, "destination distinguishes names like the source does", ""}
+}
+
+func checkFreeSpace(destDir string, tree treeInfo) finding {
+	const check = "free space"
+
+	free, err := freeSpace(destDir)
+	if err != nil {
+		return finding{levelWarn, check, "could not determine free space: " + err.Error(), ""}
+	}
+
+	message := fmt.Sprintf("%s free, source holds %s in %d files", formatBytes(int64(free)), formatBytes(tree.bytes), tree.files)
+	if int64(free) < tree.bytes {
+		// Only a first copy needs all of it, later runs copy changes only
+		return finding{levelWarn, check, message, "A full copy won't fit. Free up space unless most files are already in the destination."}
+	}
+	return finding{levelOK, check, message, ""}
+}
+
+// Compares the time the destination filesystem stamps on a new file with the local clock.
+// Network filesystems use the server's clock, which breaks modification time comparisons.
+func checkClockSkew(destDir string) finding {
+	const check = "clock skew"
+
+	before := time.Now()
+	probe, err := os.CreateTemp(destDir, ".gosync-clock-")
+	if err != nil {
+		return finding{levelWarn, check, "could not probe destination: " + err.Error(), ""}
+	}
+	defer os.Remove(probe.Name())
+	defer probe.Close()
+
+	probe.Write([]byte{0})
+	info, err := probe.Stat()
+	if err != nil {
+		return finding{levelWarn, check, "could not probe destination: " + err.Error(), ""}
+	}
+
+	skew := info.ModTime().Sub(before)
+	if skew < 0 {
+		skew = -skew
+	}
+
+	message := fmt.Sprintf("destination clock is %v off", skew.Round(time.Millisecond))
+	if skew > maxClockSkew {
+		return finding{levelWarn, check, message, fmt.Sprintf("Sync the clocks with NTP, or pass --modify-window %v.", skew.Round(time.Second)+time.Second)}
+	}
+	return finding{levelOK, check, message, ""}
+}
+
+func init() {
+	doctorCmd.Flags().StringVarP(&opts.SourcePath, "source", "s", "", "The path to source directory. (Required)")
+	doctorCmd.Flags().StringVarP(&opts.DestinationPath, "dest", "d", "", "The path to destination directory. (Required)")
+
+	rootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	platformChecks = append(platformChecks, checkInotifyLimit)
+}
+
+// Watching a tree takes one inotify watch per directory, shared with every other watching process.
+func checkInotifyLimit(source, dest string, tree treeInfo) finding {
+	const check = "inotify watches"
+
+	data, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return finding{levelInfo, check, "could not read the limit: " + err.Error(), ""}
+	}
+	limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return finding{levelInfo, check, "could not read the limit: " + err.Error(), ""}
+	}
+
+	message := fmt.Sprintf("limit is %d, source has %d directories", limit, tree.directories)
+	if tree.directories > limit/2 {
+		return finding{levelWarn, check, message,
+			fmt.Sprintf("Watching the source needs more watches, raise it with: sysctl fs.inotify.max_user_watches=%d", 2*tree.directories+8192)}
+	}
+	return finding{levelOK, check, message, ""}
+}
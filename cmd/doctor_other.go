@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package cmd
+
+import "errors"
+
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("not supported on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// Returns the bytes available to unprivileged users on the filesystem holding path.
+func freeSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package cmd
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// Paths longer than this need long path support unless they use the \\?\ prefix.
+const maxLegacyPath = 260
+
+func init() {
+	platformChecks = append(platformChecks, checkLongPaths)
+}
+
+// Returns the bytes available to the current user on the volume holding path.
+func freeSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+func checkLongPaths(source, dest string, tree treeInfo) finding {
+	const check = "long paths"
+
+	enabled := false
+	if key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\FileSystem`, registry.QUERY_VALUE); err == nil {
+		value, _, err := key.GetIntegerValue("LongPathsEnabled")
+		enabled = err == nil && value == 1
+		key.Close()
+	}
+
+	// Destination paths are as long as the source ones, give or take the root
+	longest := tree.longestPath - len(source) + len(dest)
+	status := "disabled"
+	if enabled {
+		status = "enabled"
+	}
+	message := fmt.Sprintf("longest destination path is %d characters, long path support is %s", longest, status)
+	if longest >= maxLegacyPath && !enabled {
+		return finding{levelWarn, check, message,
+			`Enable it with: reg add HKLM\SYSTEM\CurrentControlSet\Control\FileSystem /v LongPathsEnabled /t REG_DWORD /d 1`}
+	}
+	return finding{levelOK, check, message, ""}
+}
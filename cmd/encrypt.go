@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Environment variables holding the passphrase of encrypted destinations and sources, and
+// the second one of the rclone format.
+const (
+	passphraseEnv  = "GOSYNC_PASSPHRASE"
+	passphrase2Env = "GOSYNC_PASSPHRASE2"
+)
+
+// Passphrase asked for on the terminal.
+var passphrase string
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Sync a destination encrypted with --encrypt back, decrypting the files",
+	Long: `restore syncs a destination written with --encrypt back to --dest, decrypting the files and their
+	names on the way. It takes the flags of a sync, the key is given like for --encrypt:
+
+	  gosync restore -s /mnt/untrusted/backup -d /home/me/restored --key-file ~/.gosync.key
+
+	Without --key-file the passphrase is read from GOSYNC_PASSPHRASE, or asked for. Remotes of rclone
+	crypt are restored with --encrypt-format rclone, and --encrypt-names when they encrypt names.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if opts.Encrypt {
+			fmt.Fprintln(os.Stderr, "Error: restore decrypts, --encrypt can't be given.")
+			os.Exit(1)
+		}
+		// Remotes written by rclone itself have no marker to tell
+		opts.Decrypt = opts.EncryptFormat == syncer.EncryptRclone
+		if opts.SourcePath != "" && !opts.Decrypt {
+			encrypted, err := syncer.Encrypted(opts.SourcePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			if !encrypted {
+				fmt.Fprintf(os.Stderr, "Error: %s is not encrypted by gosync.\n", opts.SourcePath)
+				os.Exit(1)
+			}
+		}
+		if err := askPassphrase(false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		runSync(cmd)
+	},
+}
+
+// Asks for the passphrase on the terminal unless a key is given otherwise, twice when
+// confirm is set, so a typo doesn't lock files away.
+func askPassphrase(confirm bool) error {
+	if keyFile != "" || os.Getenv(passphraseEnv) != "" || passphrase != "" || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	first, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("could not read passphrase: %w.", err)
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		second, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return fmt.Errorf("could not read passphrase: %w.", err)
+		}
+		if !bytes.Equal(first, second) {
+			return fmt.Errorf("the passphrases don't match.")
+		}
+	}
+	passphrase = string(first)
+	return nil
+}
+
+// Gives options read from a plan back the passphrases left out of it, from the
+// environment or asked for, when they are needed.
+func reloadPassphrase(options *syncer.SyncOptions) error {
+	key := &options.EncryptionKey
+	key.Salt = os.Getenv(passphrase2Env)
+	if key.KeyFile != "" {
+		return nil
+	}
+	if !options.Encrypt && !options.Decrypt {
+		encrypted, err := syncer.Encrypted(options.SourcePath)
+		if err != nil || !encrypted {
+			return err
+		}
+	}
+
+	if err := askPassphrase(false); err != nil {
+		return err
+	}
+	key.Passphrase = passphrase
+	if key.Passphrase == "" {
+		key.Passphrase = os.Getenv(passphraseEnv)
+	}
+	return nil
+}
+
+// Sets the key of encrypted destinations and sources from --key-file, or else the
+// passphrase asked for or given in the environment.
+func loadEncryptionKey() error {
+	opts.EncryptionKey = syncer.EncryptionKey{KeyFile: keyFile, Salt: os.Getenv(passphrase2Env)}
+	if keyFile == "" {
+		opts.EncryptionKey.Passphrase = passphrase
+		if passphrase == "" {
+			opts.EncryptionKey.Passphrase = os.Getenv(passphraseEnv)
+		}
+	}
+
+	if !syncer.ValidEncryptFormat(opts.EncryptFormat) {
+		return fmt.Errorf("invalid --encrypt-format value %q, expected gosync or rclone.", opts.EncryptFormat)
+	}
+	if opts.EncryptNames && !opts.Encrypt && !opts.Decrypt {
+		return fmt.Errorf("--encrypt-names needs --encrypt.")
+	}
+	if opts.Encrypt && opts.EncryptionKey.KeyFile == "" && opts.EncryptionKey.Passphrase == "" {
+		return fmt.Errorf("--encrypt needs --key-file or a passphrase in %s.", passphraseEnv)
+	}
+	return nil
+}
@@ -1,6 +1,6 @@
 package main
 
-import "gosync/cmd"
+import "github.com/bipinmdr07/gosync/cmd"
 
 func main() {
 	cmd.Execute()
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+)
+
+// Runs command with the shell, before or after a sync of o as flag tells, with what
+// the sync is about in its environment along with env. Its output goes to ours.
+func runHook(ctx context.Context, flag, command string, o *syncer.SyncOptions, env ...string) error {
+	if command == "" {
+		return nil
+	}
+
+	shell := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		shell = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	shell.Stdout, shell.Stderr = os.Stdout, os.Stderr
+	shell.Env = append(os.Environ(),
+		"GOSYNC_SOURCE="+o.SourcePath,
+		"GOSYNC_DEST="+o.DestinationPath,
+		"GOSYNC_DRY_RUN="+strconv.FormatBool(o.DryRun))
+	shell.Env = append(shell.Env, env...)
+
+	if err := shell.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w.", flag, err)
+	}
+	return nil
+}
+
+// Returns the environment --post-cmd learns how a sync went from: GOSYNC_STATUS success,
+// partial when paths failed, interrupted when it was stopped or failed, with the totals
+// of result and the error.
+func resultEnv(result syncer.Result, err error) []string {
+	status := "success"
+	if filesFailed(err) {
+		status = "partial"
+	} else if errors.Is(err, context.Canceled) {
+		status = "interrupted"
+	} else if err != nil {
+		status = "failed"
+	}
+
+	env := []string{
+		"GOSYNC_STATUS=" + status,
+		"GOSYNC_FILES_COPIED=" + strconv.FormatInt(result.FilesCopied, 10),
+		"GOSYNC_FILES_DELETED=" + strconv.FormatInt(result.FilesDeleted, 10),
+		"GOSYNC_BYTES_COPIED=" + strconv.FormatInt(result.BytesCopied, 10),
+		"GOSYNC_ERRORS=" + strconv.Itoa(len(result.Errors)),
+		"GOSYNC_DURATION=" + strconv.FormatFloat(result.Duration.Seconds(), 'f', 3, 64),
+	}
+	if err != nil {
+		env = append(env, "GOSYNC_ERROR="+err.Error())
+	}
+	return env
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Whether deletions are confirmed on the terminal first.
+var interactive bool
+
+// Held while asking, so the destinations of a fan-out ask one after the other.
+var confirmMu sync.Mutex
+
+// Has deletions confirmed with --interactive, which only applies to those of --delete
+// and needs the terminal to itself.
+func setInteractive() error {
+	if !interactive {
+		return nil
+	}
+	switch {
+	case !opts.Delete:
+		return fmt.Errorf("--interactive confirms the deletions of --delete, which isn't given.")
+	case opts.TwoWay:
+		return fmt.Errorf("--interactive can't be used with --two-way.")
+	case tui:
+		return fmt.Errorf("--interactive can't be used with --tui.")
+	case scheduleSpec != "":
+		return fmt.Errorf("--interactive can't be used with --schedule.")
+	}
+	opts.ConfirmDeletions = confirmDeletions
+	return nil
+}
+
+// Lists the files about to be deleted from destination and asks whether to go ahead,
+// with all of them, none or each asked about in turn. Anything but yes keeps them.
+func confirmDeletions(destination string, paths []string) []string {
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "\n%d file(s) would be deleted from %s:\n", len(paths), destination)
+	for _, relPath := range paths {
+		fmt.Fprintf(os.Stderr, "  %s\n", relPath)
+	}
+
+	input := bufio.NewReader(os.Stdin)
+	switch ask(input, "Delete them? [y/N/e(ach)] ") {
+	case "y", "yes":
+		return paths
+	case "e", "each":
+	default:
+		fmt.Fprintln(os.Stderr, "Keeping them.")
+		return nil
+	}
+
+	var approved []string
+	for _, relPath := range paths {
+		if answer := ask(input, fmt.Sprintf("Delete %s? [y/N] ", relPath)); answer == "y" || answer == "yes" {
+			approved = append(approved, relPath)
+		}
+	}
+	return approved
+}
+
+// Prints prompt and returns the answer read, in lower case, empty at the end of input.
+func ask(input *bufio.Reader, prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := input.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(os.Stderr)
+	}
+	return strings.ToLower(strings.TrimSpace(line))
+}
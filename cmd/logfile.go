@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bipinmdr07/gosync/internal/logfile"
+)
+
+var (
+	logFilePath string
+	logMaxSize  string
+	logMaxAge   time.Duration
+	logKeep     int
+
+	logFile *logfile.File // Opened once, every sync of the process logs to it
+)
+
+// Opens --log-file unless it is open already, and has opts log to it.
+func setLogFile() error {
+	if logFilePath == "" {
+		return nil
+	}
+
+	if logFile == nil {
+		maxSize, err := parseSize(logMaxSize)
+		if err != nil {
+			return fmt.Errorf("invalid --log-max-size value: %v", err)
+		}
+		if logMaxAge < 0 {
+			return fmt.Errorf("invalid --log-max-age value %v, expected 0 or more.", logMaxAge)
+		}
+		if logKeep < 0 {
+			return fmt.Errorf("invalid --log-keep value %d, expected 0 or more.", logKeep)
+		}
+		if logFile, err = logfile.Open(logFilePath, maxSize, logMaxAge, logKeep); err != nil {
+			return fmt.Errorf("could not open log file: %v.", err)
+		}
+	}
+	opts.LogFile = logFile
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logFilePath, "log-file", "", "Also log operations, warnings and errors to this file as JSON lines, whatever the console shows, debug entries too with --verbose.")
+	rootCmd.PersistentFlags().StringVar(&logMaxSize, "log-max-size", "10MB", "Size, e.g. 10MB, past which --log-file is rotated, never by size when 0.")
+	rootCmd.PersistentFlags().DurationVar(&logMaxAge, "log-max-age", 0, "Age, e.g. 24h, at which --log-file is rotated, never by age when 0.")
+	rootCmd.PersistentFlags().IntVar(&logKeep, "log-keep", 5, "Number of rotated log files kept, as --log-file.1 for the most recent and so on.")
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"golang.org/x/term"
+)
+
+const (
+	// How often --progress redraws on a terminal, and prints a line otherwise.
+	progressInterval    = 500 * time.Millisecond
+	progressLogInterval = 10 * time.Second
+
+	// Rates are averaged over this much of the recent past, so the ETA doesn't jump around.
+	rateWindow = 10 * time.Second
+
+	// Copies at least this large get a line of their own with --progress-files.
+	largeTransfer = 16 << 20
+)
+
+// Tracks how fast a sync copies and gets through its files.
+type progressRate struct {
+	samples []rateSample
+}
+
+// Returns a progressRate for a sync that started at started.
+func newProgressRate(started time.Time) progressRate {
+	return progressRate{samples: []rateSample{{time: started}}}
+}
+
+type rateSample struct {
+	time      time.Time
+	copied    int64
+	processed int64
+}
+
+// Adds a snapshot taken at now and returns the bytes copied and processed per second
+// since the oldest snapshot within rateWindow.
+func (r *progressRate) update(p syncer.Progress, now time.Time) (copied, processed float64) {
+	r.samples = append(r.samples, rateSample{now, p.BytesCopied, p.BytesProcessed})
+	for len(r.samples) > 2 && now.Sub(r.samples[1].time) >= rateWindow {
+		r.samples = r.samples[1:]
+	}
+
+	first := r.samples[0]
+	seconds := now.Sub(first.time).Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+	return float64(p.BytesCopied-first.copied) / seconds, float64(p.BytesProcessed-first.processed) / seconds
+}
+
+// Returns how long the files left take at rate bytes per second, or false until all
+// files are found and something moved.
+func estimate(p syncer.Progress, rate float64) (time.Duration, bool) {
+	if !p.Walked || rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(max(p.BytesQueued-p.BytesProcessed, 0)) / rate * float64(time.Second)), true
+}
+
+// Returns how much of the sync is done, by bytes when sizes are known and by files
+// otherwise. Until all files are found this is of those found so far.
+func doneRatio(p syncer.Progress) float64 {
+	switch {
+	case p.BytesQueued > 0:
+		return min(float64(p.BytesProcessed)/float64(p.BytesQueued), 1)
+	case p.FilesQueued > 0:
+		return float64(p.FilesProcessed) / float64(p.FilesQueued)
+	}
+	return 0
+}
+
+func formatETA(eta time.Duration, ok bool) string {
+	if !ok {
+		return "ETA --"
+	}
+	return "ETA " + eta.Round(time.Second).String()
+}
+
+// Shows the progress of a running sync on stderr, redrawn in place on a terminal and a
+// line at a time otherwise. Log output written to it goes above the display.
+type progressDisplay struct {
+	mu       sync.Mutex
+	out      *os.File
+	terminal bool
+	files    bool // Also show large copies in progress
+	syncer   *syncer.Syncer
+	rate     progressRate
+	view     string // What is on the terminal now
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newProgressDisplay(files bool) *progressDisplay {
+	return &progressDisplay{
+		out:      os.Stderr,
+		terminal: term.IsTerminal(int(os.Stderr.Fd())),
+		files:    files,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Starts showing the progress of syncerTool until finish is called.
+func (d *progressDisplay) start(syncerTool *syncer.Syncer) {
+	d.syncer = syncerTool
+	d.rate = newProgressRate(time.Now())
+	interval := progressInterval
+	if !d.terminal {
+		interval = progressLogInterval
+	}
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case now := <-ticker.C:
+				d.show(now)
+			}
+		}
+	}()
+}
+
+// Stops the display, leaving the final state on the terminal.
+func (d *progressDisplay) finish() {
+	close(d.stop)
+	<-d.done
+
+	d.show(time.Now())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.terminal && d.view != "" {
+		fmt.Fprintln(d.out)
+		d.view = ""
+	}
+}
+
+func (d *progressDisplay) show(now time.Time) {
+	view := d.render(d.syncer.Progress(), now)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.terminal {
+		fmt.Fprintln(d.out, view)
+		return
+	}
+	d.clear()
+	io.WriteString(d.out, view)
+	d.view = view
+}
+
+// Writes log output above the display. Callers write whole lines.
+func (d *progressDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.terminal || d.view == "" {
+		return d.out.Write(p)
+	}
+
+	d.clear()
+	n, err := d.out.Write(p)
+	io.WriteString(d.out, d.view)
+	return n, err
+}
+
+// Erases the display, leaving the cursor where it started. Callers must hold mu.
+func (d *progressDisplay) clear() {
+	if lines := strings.Count(d.view, "\n"); lines > 0 {
+		fmt.Fprintf(d.out, "\x1b[%dA", lines)
+	}
+	io.WriteString(d.out, "\r\x1b[J")
+}
+
+func (d *progressDisplay) render(p syncer.Progress, now time.Time) string {
+	copyRate, processRate := d.rate.update(p, now)
+	ratio := doneRatio(p)
+
+	total := ""
+	if !p.Walked {
+		total = "+" // More may be found
+	}
+	line := fmt.Sprintf("%3.0f%%  %s/%s%s  %d/%d%s files  %s/s  %s",
+		ratio*100, formatBytes(p.BytesProcessed), formatBytes(p.BytesQueued), total,
+		p.FilesProcessed, p.FilesQueued, total, formatBytes(int64(copyRate)), formatETA(estimate(p, processRate)))
+
+	width := 80
+	if d.terminal {
+		if w, _, err := term.GetSize(int(d.out.Fd())); err == nil && w > 0 {
+			width = w
+		}
+	}
+	if barWidth := min(width-len(line)-4, 30); barWidth >= 10 {
+		line = progressBar(ratio, barWidth) + "  " + line
+	}
+
+	var b strings.Builder
+	b.WriteString(line)
+	if d.files {
+		for _, t := range p.Active {
+			if t.Size < largeTransfer {
+				continue
+			}
+			speed := float64(t.Copied) / now.Sub(t.Started).Seconds()
+			fmt.Fprintf(&b, "\n  %3.0f%%  %s/%s  %s/s  %s", float64(t.Copied)/float64(t.Size)*100,
+				formatBytes(t.Copied), formatBytes(t.Size), formatBytes(int64(speed)), truncatePath(t.Path, width-50))
+		}
+	}
+	return b.String()
+}
+
+// Draws a bar width characters wide filled to ratio.
+func progressBar(ratio float64, width int) string {
+	filled := int(ratio * float64(width-2))
+	bar := strings.Repeat("=", filled)
+	if filled < width-2 {
+		bar += ">" + strings.Repeat(" ", width-3-filled)
+	}
+	return "[" + bar + "]"
+}
+
+// Shortens path from the left to at most limit characters.
+func truncatePath(path string, limit int) string {
+	if limit < 10 || len(path) <= limit {
+		return path
+	}
+	return "…" + path[len(path)-limit+1:]
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+)
+
+// Reports whether err only tells that some paths failed, which printErrors lists, and
+// the sync went through otherwise.
+func filesFailed(err error) bool {
+	var failed *syncer.FilesFailedError
+	return errors.As(err, &failed)
+}
+
+// Most failed paths listed after the summary, the rest are only counted.
+const maxErrorsShown = 20
+
+// Prints the paths that failed and why to stdout.
+func printErrors(errs []syncer.FileError) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Printf("\nFailed:\n")
+	for i, err := range errs {
+		if i == maxErrorsShown {
+			fmt.Printf("  and %d more\n", len(errs)-i)
+			break
+		}
+		fmt.Printf("  %s\n", err.Error())
+	}
+}
+
+// Prints the end of run statistics to stdout.
+func printSummary(summary syncer.Summary) {
+	fmt.Printf("\n--- Summary ---\n")
+	fmt.Printf("Files scanned: %d", summary.FilesScanned)
+	if summary.ScanDuration > 0 {
+		fmt.Printf(" (all found in %v)", summary.ScanDuration.Round(time.Microsecond))
+	}
+	fmt.Println()
+	fmt.Printf("Files copied: %d (%s)\n", summary.FilesCopied, formatBytes(summary.BytesCopied))
+	if summary.FilesSkipped > 0 {
+		fmt.Printf("Files skipped: %d (up to date or filtered out)\n", summary.FilesSkipped)
+	}
+	if summary.DirectoriesCreated > 0 {
+		fmt.Printf("Directories created: %d\n", summary.DirectoriesCreated)
+	}
+	if summary.FilesLinked > 0 {
+		fmt.Printf("Hard links created: %d\n", summary.FilesLinked)
+	}
+	if summary.FilesKeptNewer > 0 {
+		fmt.Printf("Newer at destination, kept: %d\n", summary.FilesKeptNewer)
+	}
+	if summary.SourceFilesRemoved > 0 {
+		fmt.Printf("Source files removed: %d\n", summary.SourceFilesRemoved)
+	}
+	if summary.FilesRenamed > 0 {
+		fmt.Printf("Files moved at destination: %d\n", summary.FilesRenamed)
+	}
+	if summary.DeleteDuration > 0 {
+		rate := float64(summary.FilesDeleted) / summary.DeleteDuration.Seconds()
+		fmt.Printf("Deleted: %d in %v (%.0f/s)\n", summary.FilesDeleted, summary.DeleteDuration.Round(time.Microsecond), rate)
+	}
+	if summary.FilesDeferred > 0 {
+		fmt.Printf("Deferred: %d (modified within --min-age, picked up by the next run)\n", summary.FilesDeferred)
+	}
+	fmt.Printf("Errors: %d\n", summary.Errors)
+	if summary.FilesUnreadable > 0 {
+		fmt.Printf("Unreadable: %d (no permission or locked, see --skip-unreadable and --unreadable-list)\n", summary.FilesUnreadable)
+	}
+	if summary.FilesUnstable > 0 {
+		fmt.Printf("Unstable: %d (changed while being copied on every attempt, not synced)\n", summary.FilesUnstable)
+	}
+	if summary.SecurityNotApplied > 0 {
+		fmt.Printf("Security attributes not applied: %d (see warnings, preserving them usually needs root)\n", summary.SecurityNotApplied)
+	}
+
+	// Throughput is over the time spent copying, not waiting to delete or watch
+	for _, phase := range summary.Phases {
+		if phase.Phase == "copying" && summary.BytesCopied > 0 && phase.Duration > 0 {
+			fmt.Printf("Throughput: %s/s\n", formatBytes(int64(float64(summary.BytesCopied)/phase.Duration.Seconds())))
+		}
+	}
+	if len(summary.Phases) > 0 {
+		phases := make([]string, len(summary.Phases))
+		for i, phase := range summary.Phases {
+			phases[i] = fmt.Sprintf("%s %v", phase.Phase, phase.Duration.Round(time.Microsecond))
+		}
+		fmt.Printf("Phases: %s\n", strings.Join(phases, ", "))
+	}
+	// Scanning held up by a full queue means the copies are what takes the time
+	if summary.MaxQueueDepth > 0 {
+		fmt.Printf("Queue: at most %d of %d files waiting", summary.MaxQueueDepth, summary.QueueSize)
+		if summary.QueueFullDuration > 0 {
+			fmt.Printf(", scanning waited %v for copies", summary.QueueFullDuration.Round(time.Millisecond))
+		}
+		fmt.Println()
+	}
+
+	if len(summary.Directories) > 0 {
+		fmt.Printf("\nPer directory:\n")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  DIRECTORY\tFILES\tBYTES\tERRORS")
+		for _, dir := range summary.Directories {
+			fmt.Fprintf(w, "  %s\t%d\t%s\t%d\n", dir.Path, dir.FilesCopied, formatBytes(dir.BytesCopied), dir.Errors)
+		}
+		w.Flush()
+	}
+
+	printTransfers("Largest transfers", summary.Largest)
+	printTransfers("Slowest transfers", summary.Slowest)
+}
+
+// Prints a ranked list of transfers as a table.
+func printTransfers(title string, transfers []syncer.Transfer) {
+	if len(transfers) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%s:\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  PATH\tSIZE\tDURATION\tSPEED")
+	for _, t := range transfers {
+		speed := "-"
+		if t.Rate > 0 {
+			speed = formatBytes(int64(t.Rate)) + "/s"
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%v\t%s\n", t.Path, formatBytes(t.Bytes), t.Duration.Round(time.Microsecond), speed)
+	}
+	w.Flush()
+}
+
+// Writes the statistics as indented JSON to path.
+func writeReport(path string, summary syncer.Summary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -1,59 +1,436 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"gosync/pkg/syncer"
+	"github.com/bipinmdr07/gosync/pkg/filter"
+	"github.com/bipinmdr07/gosync/pkg/schedule"
+	"github.com/bipinmdr07/gosync/pkg/syncer"
 
 	"github.com/spf13/cobra"
 )
 
 var opts = &syncer.SyncOptions{}
 
+var (
+	reportPath string
+	maxMemory  string
+	blockSize  string
+	bufferSize string
+	checksum   bool
+	maxDelete  string
+	ioLimits   []string
+	links      bool
+	copyLinks  bool
+	skipLinks  bool
+	tui        bool
+
+	showProgress  bool
+	progressFiles bool
+
+	destinations  []string
+	conflictRules []string
+	transforms    []string
+
+	scheduleSpec string
+	jitter       time.Duration
+	cronSchedule *schedule.Schedule
+
+	preCmd  string
+	postCmd string
+
+	keyFile string
+)
+
+// Exit code of a sync that went through with some paths failing, like rsync's for a
+// partial transfer.
+const exitFilesFailed = 23
+
+// Largest --block-size accepted, every worker holds two blocks in memory.
+const maxBlockSize = 256 << 20
+
+// Range of --buffer-size accepted, every worker holds a buffer.
+const (
+	minBufferSize = 4 << 10
+	maxBufferSize = 64 << 20
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "gosync",
-	Short: "One-way directory synchronization utility",
+	Short: "Directory synchronization utility",
 	Long: `gosync is a fast, concurrent CLI utility for one-way synchronization of directories.
-	It intelligently copies only new or modified files from source to destination.`,
+	It intelligently copies only new or modified files from source to destination,
+	or with --two-way carries changes over in both directions.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// validate mandatory flags
-		if opts.SourcePath == "" || opts.DestinationPath == "" {
-			cmd.Help()
-			fmt.Fprintln(os.Stderr, "\nError: --source and --dest are required arguments.")
-			os.Exit(1) // Exit after error
+		runSync(cmd)
+	},
+}
+
+// Syncs with the options the flags of cmd were given, then exits.
+func runSync(cmd *cobra.Command) {
+	// validate mandatory flags
+	if opts.SourcePath == "" || len(destinations) == 0 {
+		cmd.Help()
+		fmt.Fprintln(os.Stderr, "\nError: --source and --dest are required arguments.")
+		os.Exit(1) // Exit after error
+	}
+
+	if opts.Encrypt {
+		if err := askPassphrase(true); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+	}
+	if err := validateOptions(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-		// new Syncer instance
-		syncerTool := syncer.NewSyncer(opts)
+	// Make the garbage collector work harder instead of growing past the budget
+	if opts.MaxMemory > 0 {
+		debug.SetMemoryLimit(opts.MaxMemory)
+	}
 
-		fmt.Printf("-- Go Sync CLI ---\n")
-		fmt.Printf("Source: %s\n", opts.SourcePath)
-		fmt.Printf("Destination: %s\n", opts.DestinationPath)
-		fmt.Printf("Workers: %d\n", syncerTool.Options.Workers)
-		fmt.Printf("Dry Run: %t\n", opts.DryRun)
-		fmt.Printf("Delete Extra Files: %t\n", opts.Delete)
-		fmt.Printf("-------------------------------------------------- \n")
+	// Log lines would tear up the dashboard
+	if tui {
+		opts.LogWriter = io.Discard
+	}
+	var display *progressDisplay
+	if showProgress {
+		display = newProgressDisplay(progressFiles)
+		opts.LogWriter = display
+	}
+	opts.Totals = tui || showProgress
 
-		startTime := time.Now()
-		err := syncerTool.Start()
-		elapsed := time.Since(startTime)
+	// new Syncer instance
+	syncerTool := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts))
 
-		// Handle result
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Synchronization failed: %v\n", err)
+	printHeader(syncerTool)
+
+	if cronSchedule != nil {
+		runScheduled()
+		os.Exit(0)
+	}
+
+	if err := runHook(context.Background(), "--pre-cmd", preCmd, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Synchronization aborted: %v\n", err)
+		os.Exit(1)
+	}
+
+	var result syncer.Result
+	var err error
+	var interrupted bool
+	if tui {
+		result, interrupted, err = runWithDashboard(syncerTool)
+	} else {
+		// Interrupting stops the sync cleanly, a second interrupt kills it
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+		if display != nil {
+			display.start(syncerTool)
+		}
+		result, err = syncerTool.StartContext(ctx)
+		if display != nil {
+			display.finish()
+		}
+		interrupted = ctx.Err() != nil
+		stop()
+	}
+
+	// Run after interrupted syncs too, under a context of its own since theirs is done
+	if interrupted && !errors.Is(err, context.Canceled) {
+		err = context.Canceled
+	}
+	postErr := runHook(context.Background(), "--post-cmd", postCmd, opts, resultEnv(result, err)...)
+	if postErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", postErr)
+	}
+	if interrupted {
+		fmt.Fprintln(os.Stderr, "Synchronization interrupted")
+		os.Exit(130)
+	}
+
+	// Handle result, paths that failed are listed with the summary
+	if err != nil && !filesFailed(err) {
+		fmt.Fprintf(os.Stderr, "Synchronization failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	summary := syncerTool.Summary()
+	printSummary(summary)
+	printErrors(result.Errors)
+
+	if reportPath != "" {
+		if err := writeReport(reportPath, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write report: %v\n", err)
 			os.Exit(1)
 		}
+	}
 
-		fmt.Printf("\n Synchronization completed in %v\n", elapsed)
+	if err != nil {
+		fmt.Printf("\n Synchronization completed in %v, %d path(s) failed\n", result.Duration, len(result.Errors))
+		os.Exit(exitFilesFailed)
+	}
+	fmt.Printf("\n Synchronization completed in %v\n", result.Duration)
 
-		os.Exit(0)
-	},
+	if postErr != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func printHeader(syncerTool *syncer.Syncer) {
+	fmt.Printf("-- Go Sync CLI ---\n")
+	fmt.Printf("Source: %s\n", opts.SourcePath)
+	fmt.Printf("Destination: %s\n", destinationList())
+	fmt.Printf("Workers: %d\n", syncerTool.Options.Workers)
+	fmt.Printf("Dry Run: %t\n", opts.DryRun)
+	fmt.Printf("Delete Extra Files: %t\n", opts.Delete)
+	fmt.Printf("-------------------------------------------------- \n")
+}
+
+// Returns the destinations of the sync, separated by commas.
+func destinationList() string {
+	return strings.Join(append([]string{opts.DestinationPath}, opts.ExtraDestinations...), ", ")
+}
+
+// Collects --include and --exclude patterns and regexes into one list, so they keep the order they
+// were given in across both flags.
+type pathRuleFlag struct {
+	include bool
+	regex   bool
+}
+
+func (f pathRuleFlag) String() string { return "" }
+func (f pathRuleFlag) Type() string   { return "pattern" }
+
+func (f pathRuleFlag) Set(pattern string) error {
+	opts.PathRules = append(opts.PathRules, filter.PathRule{Pattern: pattern, Include: f.include, Regex: f.regex})
+	return nil
+}
+
+// Checks flag values that cobra can't validate on its own.
+func validateOptions() error {
+	// The first destination is the one every sync has, the others are fanned out to
+	opts.DestinationPath, opts.ExtraDestinations = "", nil
+	if len(destinations) > 0 {
+		opts.DestinationPath, opts.ExtraDestinations = destinations[0], destinations[1:]
+	}
+
+	switch opts.Junctions {
+	case syncer.JunctionSkip, syncer.JunctionFollow, syncer.JunctionRecreate:
+	default:
+		return fmt.Errorf("invalid --junctions value %q, expected skip, follow or recreate.", opts.Junctions)
+	}
+
+	switch {
+	case links && copyLinks, links && skipLinks, copyLinks && skipLinks:
+		return fmt.Errorf("only one of --links, --copy-links and --skip-links can be given.")
+	case links:
+		opts.Symlinks = syncer.SymlinkRecreate
+	case copyLinks:
+		opts.Symlinks = syncer.SymlinkCopy
+	case skipLinks:
+		opts.Symlinks = syncer.SymlinkSkip
+	}
+
+	if opts.PartialDir != "" && (!filepath.IsLocal(opts.PartialDir) || filepath.Base(opts.PartialDir) != opts.PartialDir) {
+		return fmt.Errorf("invalid --partial-dir value %q, expected the name of a directory.", opts.PartialDir)
+	}
+
+	if opts.BackupDir != "" && !filepath.IsLocal(opts.BackupDir) {
+		return fmt.Errorf("invalid --backup-dir value %q, expected a relative path inside of the destination.", opts.BackupDir)
+	}
+	if strings.ContainsAny(opts.BackupSuffix, `/\`) {
+		return fmt.Errorf("invalid --suffix value %q, expected no path separators.", opts.BackupSuffix)
+	}
+	if opts.BackupKeep > 0 && !strings.Contains(opts.BackupSuffix, "N") {
+		return fmt.Errorf("--backup-keep needs a numbered --suffix like ~N~.")
+	}
+
+	if _, err := filter.CompilePathRules(opts.PathRules); err != nil {
+		return fmt.Errorf("%v.", err)
+	}
+	for _, pattern := range opts.ProtectPatterns {
+		if _, err := filter.CompilePathRules([]filter.PathRule{{Pattern: pattern}}); err != nil {
+			return fmt.Errorf("%v.", err)
+		}
+	}
+
+	// Unlimited unless given, 0 refuses any deletion like rsync's --max-delete=0
+	opts.MaxDelete, opts.MaxDeletePercent = nil, nil
+	if maxDelete != "" {
+		var err error
+		if percent, ok := strings.CutSuffix(maxDelete, "%"); ok {
+			var limit float64
+			limit, err = strconv.ParseFloat(percent, 64)
+			opts.MaxDeletePercent = &limit
+		} else {
+			var limit int
+			limit, err = strconv.Atoi(maxDelete)
+			opts.MaxDelete = &limit
+		}
+		if err != nil || strings.HasPrefix(maxDelete, "-") {
+			return fmt.Errorf("invalid --max-delete value %q, expected a number of files or a percentage like 10%%.", maxDelete)
+		}
+	}
+	if err := setInteractive(); err != nil {
+		return err
+	}
+
+	if opts.Retries < 0 {
+		return fmt.Errorf("invalid --retries value %d, expected 0 or more.", opts.Retries)
+	}
+	if opts.UnstableRetries < 0 {
+		return fmt.Errorf("invalid --unstable-retries value %d, expected 0 or more.", opts.UnstableRetries)
+	}
+
+	switch opts.Placeholders {
+	case syncer.PlaceholderSkip, syncer.PlaceholderHydrate, syncer.PlaceholderStub:
+	default:
+		return fmt.Errorf("invalid --placeholders value %q, expected skip, hydrate or stub.", opts.Placeholders)
+	}
+
+	if !syncer.ValidCasePolicy(opts.CaseCollisions) {
+		return fmt.Errorf("invalid --case-collisions value %q, expected fail, skip, rename or ignore.", opts.CaseCollisions)
+	}
+
+	if !syncer.ValidConflictPolicy(opts.Conflicts) {
+		return fmt.Errorf("invalid --conflict value %q, expected fail, newest, source, dest or keep-both.", opts.Conflicts)
+	}
+	opts.ConflictRules = nil
+	for _, rule := range conflictRules {
+		pattern, policy, ok := strings.Cut(rule, "=")
+		if !ok || pattern == "" || !syncer.ValidConflictPolicy(syncer.ConflictPolicy(policy)) {
+			return fmt.Errorf("invalid --conflict-rule value %q, expected a glob, = and fail, newest, source, dest or keep-both.", rule)
+		}
+		opts.ConflictRules = append(opts.ConflictRules, syncer.ConflictRule{Pattern: pattern, Policy: syncer.ConflictPolicy(policy)})
+	}
+
+	opts.Transforms = nil
+	for _, rule := range transforms {
+		pattern, spec, ok := strings.Cut(rule, "=")
+		if !ok || pattern == "" {
+			return fmt.Errorf("invalid --transform value %q, expected a glob, = and one of %s.", rule, strings.Join(syncer.Transformers(), ", "))
+		}
+		if _, err := syncer.NewTransformer(spec); err != nil {
+			return fmt.Errorf("invalid --transform value %q: %v", rule, err)
+		}
+		if _, err := filter.CompilePathRules([]filter.PathRule{{Pattern: pattern}}); err != nil {
+			return fmt.Errorf("%v.", err)
+		}
+		opts.Transforms = append(opts.Transforms, syncer.TransformRule{Pattern: pattern, Transform: spec})
+	}
+
+	showProgress = showProgress || progressFiles
+	if tui && showProgress {
+		return fmt.Errorf("only one of --tui and --progress can be given.")
+	}
+
+	if scheduleSpec != "" {
+		var err error
+		if cronSchedule, err = schedule.Parse(scheduleSpec); err != nil {
+			return fmt.Errorf("%v.", err)
+		}
+		if cronSchedule.Next(time.Now()).IsZero() {
+			return fmt.Errorf("schedule %q never runs.", scheduleSpec)
+		}
+		if tui {
+			return fmt.Errorf("--tui can't be used with --schedule.")
+		}
+		if showProgress {
+			return fmt.Errorf("--progress can't be used with --schedule.")
+		}
+	}
+	if jitter < 0 {
+		return fmt.Errorf("invalid --jitter value %v, expected 0 or more.", jitter)
+	}
+	if opts.ListingCache < 0 {
+		return fmt.Errorf("invalid --listing-cache value %v, expected 0 or more.", opts.ListingCache)
+	}
+
+	if checksum {
+		opts.Compare = syncer.CompareChecksum
+	}
+
+	switch opts.Compare {
+	case syncer.CompareSizeMtime, syncer.CompareAdaptive, syncer.CompareChecksum:
+	default:
+		return fmt.Errorf("invalid --compare value %q, expected size-mtime, adaptive or checksum.", opts.Compare)
+	}
+
+	if err := loadEncryptionKey(); err != nil {
+		return err
+	}
+
+	if !syncer.ValidCompression(opts.Compression) {
+		return fmt.Errorf("invalid --compress value %q, expected none, zstd or s2.", opts.Compression)
+	}
+
+	switch opts.Hash {
+	case syncer.HashSHA256, syncer.HashBLAKE3, syncer.HashXXHash64, syncer.HashMD5:
+	default:
+		return fmt.Errorf("invalid --hash value %q, expected sha256, blake3, xxhash64 or md5.", opts.Hash)
+	}
+
+	if maxMemory != "" {
+		limit, err := parseSize(maxMemory)
+		if err != nil {
+			return fmt.Errorf("invalid --max-memory value: %v", err)
+		}
+		opts.MaxMemory = limit
+	}
+
+	if blockSize != "" {
+		size, err := parseSize(blockSize)
+		if err != nil {
+			return fmt.Errorf("invalid --block-size value: %v", err)
+		}
+		if size > maxBlockSize {
+			return fmt.Errorf("--block-size can be at most %d bytes", maxBlockSize)
+		}
+		opts.BlockSize = size
+	}
+
+	if bufferSize != "" {
+		size, err := parseSize(bufferSize)
+		if err != nil {
+			return fmt.Errorf("invalid --buffer-size value: %v", err)
+		}
+		if size < minBufferSize || size > maxBufferSize {
+			return fmt.Errorf("--buffer-size must be between %d and %d bytes", minBufferSize, maxBufferSize)
+		}
+		opts.BufferSize = int(size)
+	}
+
+	for _, limit := range ioLimits {
+		if err := parseIOLimit(limit, opts); err != nil {
+			return err
+		}
+	}
+
+	return setLogFile()
 }
 
 func Execute() {
+	if runAsService() {
+		return
+	}
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -61,11 +438,102 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&opts.SourcePath, "source", "s", "", "The path to source directory. (Required)")
-	rootCmd.Flags().StringVarP(&opts.DestinationPath, "dest", "d", "", "The path to destination directory. (Required)")
+	rootCmd.Flags().StringVarP(&opts.SourcePath, "source", "s", "", "The path to source directory, or a remote location in any of the forms --dest takes. (Required)")
+	rootCmd.Flags().StringArrayVarP(&destinations, "dest", "d", nil, "The path to destination directory, user@host:/path or sftp://user@host:port/path to sync over SSH, s3://bucket/prefix, webdav(s)://user@host/path, rsync://host/module/path of an rsync daemon, rsync+ssh://user@host/path, or gosync://host:port/path of a gosync serve. Repeat to sync to several destinations at once, walking source once. (Required)")
 
+	rootCmd.Flags().StringVar(&scheduleSpec, "schedule", "", "Keep running and sync at the times of this cron expression, e.g. \"*/15 * * * *\" or @hourly. A sync still running when the next is due makes that one skipped.")
+	rootCmd.Flags().DurationVar(&jitter, "jitter", 0, "With --schedule, delay every sync by a random duration up to this, e.g. 2m, so machines on the same schedule don't all sync at once.")
+	rootCmd.Flags().StringVar(&preCmd, "pre-cmd", "", "Shell command run before every sync, e.g. to mount the destination. The sync is aborted when it fails. GOSYNC_SOURCE and GOSYNC_DEST are set for it.")
+	rootCmd.Flags().StringVar(&postCmd, "post-cmd", "", "Shell command run after every sync, failed ones too, e.g. to send a notification. GOSYNC_STATUS is set to success, partial, failed or interrupted for it, GOSYNC_FILES_COPIED, GOSYNC_ERRORS and the like to the totals.")
+	rootCmd.Flags().BoolVar(&opts.StateIndex, "state-index", false, "If present the files in sync are remembered in --state-dir, and those unchanged in source since aren't looked at in destination again. Changes made to destination by other means go unnoticed.")
+	rootCmd.Flags().BoolVar(&opts.TwoWay, "two-way", false, "If present changes, deletions included, are carried over in both directions, telling them apart by the state the last run left.")
+	rootCmd.Flags().StringVar(&opts.StateDir, "state-dir", "", "Directory the state of --two-way syncs, --state-index and --listing-cache is kept in, gosync in the user cache directory by default.")
+	rootCmd.Flags().DurationVar(&opts.ListingCache, "listing-cache", 0, "Reuse the listing of an S3 destination for this long between runs, e.g. 6h, instead of listing it on every run. Objects found changed by other means drop it. Never when 0.")
+	rootCmd.Flags().StringVar((*string)(&opts.Conflicts), "conflict", string(syncer.ConflictFail), "What --two-way does with files changed on both sides: fail to report them, newest, source or dest to keep that version, or keep-both.")
+	rootCmd.Flags().StringArrayVar(&conflictRules, "conflict-rule", nil, "Policy for conflicts on paths matching an --exclude style glob, as GLOB=POLICY, e.g. *.log=newest. The first matching rule applies (repeatable).")
+	rootCmd.Flags().StringVar(&opts.ConflictSuffix, "conflict-suffix", "", "Appended to the name the destination's version is kept under by --conflict keep-both, .conflict by default.")
 	rootCmd.Flags().BoolVar(&opts.Delete, "delete", false, "If present delete extra files and folders from destination.")
+	rootCmd.Flags().BoolVarP(&opts.Update, "update", "u", false, "If present destination files modified after the source file are skipped instead of overwritten.")
+	rootCmd.Flags().BoolVar(&opts.Existing, "existing", false, "If present only files already in destination are updated, no new ones are created.")
+	rootCmd.Flags().BoolVar(&opts.IgnoreExisting, "ignore-existing", false, "If present only files missing from destination are created, existing ones are never touched.")
+	rootCmd.Flags().BoolVar(&opts.RemoveSourceFiles, "remove-source-files", false, "If present source files are removed once destination holds them, copied (and verified with --verify) or found identical by contents or modification time. Directories stay.")
+	rootCmd.Flags().StringVar(&maxDelete, "max-delete", "", "Refuse to delete anything when more than this many destination files, or this percentage of them like 10%, would be deleted. 0 refuses any deletion.")
+	rootCmd.Flags().BoolVar(&opts.Force, "force", false, "If present delete even beyond --max-delete.")
+	rootCmd.Flags().StringVar((*string)(&opts.CaseCollisions), "case-collisions", string(syncer.CaseFail), "What is done with source paths differing only in case, like Foo.txt and foo.txt, when destination is case-insensitive: fail to report them, skip, rename to sync them as foo.case-2.txt, or ignore to let one overwrite the other.")
+	rootCmd.Flags().BoolVar(&interactive, "interactive", false, "If present list the files --delete would remove and ask before deleting them, all at once or one by one.")
+	rootCmd.Flags().BoolVarP(&opts.Backup, "backup", "b", false, "If present the previous version of deleted and overwritten files is kept next to them, with --suffix appended to the name.")
+	rootCmd.Flags().StringVar(&opts.BackupSuffix, "suffix", "", "Appended to the names of backups, ~ by default with --backup. An N is replaced by a version number, e.g. ~N~ keeps file~1~, file~2~ and so on, 1 the most recent.")
+	rootCmd.Flags().IntVar(&opts.BackupKeep, "backup-keep", 0, "Number of versions kept with a numbered --suffix, older ones are removed. All are kept when 0.")
+	rootCmd.Flags().StringVar(&opts.BackupDir, "backup-dir", "", "Directory inside destination, e.g. .gosync-backup, deleted and overwritten files are moved to instead of lost, below a timestamped directory per run.")
+	rootCmd.Flags().StringArrayVar(&opts.ProtectPatterns, "exclude-from-delete", nil, "Never delete destination paths matching this --exclude style glob, e.g. logs/ (repeatable).")
+	rootCmd.Flags().BoolVarP(&links, "links", "l", false, "If present symlinks in source are recreated at destination with the same target.")
+	rootCmd.Flags().BoolVarP(&copyLinks, "copy-links", "L", false, "If present symlinks in source are replaced by what they point to at destination (the default).")
+	rootCmd.Flags().BoolVar(&skipLinks, "skip-links", false, "If present symlinks in source are left out of the sync.")
+	rootCmd.Flags().BoolVar(&opts.NoResolveSource, "no-resolve-source", false, "If present a source that is itself a symlink is walked through as given instead of resolved to the directory it points to.")
+	rootCmd.Flags().BoolVarP(&opts.Sparse, "sparse", "S", false, "If present holes in sparse source files are kept as holes at destination instead of written as zeros.")
+	rootCmd.Flags().BoolVarP(&opts.Owner, "owner", "o", false, "If present destination files are given the owner of the source file, needs root.")
+	rootCmd.Flags().BoolVarP(&opts.Group, "group", "g", false, "If present destination files are given the group of the source file.")
+	rootCmd.Flags().BoolVarP(&opts.Devices, "devices", "D", false, "If present device files, named pipes and sockets in source are recreated at a local destination instead of left out.")
+	rootCmd.Flags().BoolVarP(&opts.Archive, "archive", "a", false, "If present symlinks, groups, devices and, as root, owners are kept as far as source and destination allow, like -lgD with -o as root.")
+	rootCmd.Flags().BoolVarP(&opts.Xattrs, "xattrs", "X", false, "If present extended attributes of source files are copied, on Linux those in the user and security namespaces.")
+	rootCmd.Flags().BoolVar(&opts.ADS, "ads", false, "If present alternate data streams of source files, like Zone.Identifier, are copied when both sides are on NTFS (Windows only).")
+	rootCmd.Flags().BoolVar(&opts.VSS, "vss", false, "If present the source is read from a Volume Shadow Copy snapshot of its volume, so files other programs hold locked (Outlook PSTs, databases) are copied consistently. Windows only, needs administrator rights.")
+	rootCmd.Flags().StringVar(&opts.PartialDir, "partial-dir", "", "Name of a directory, e.g. .gosync-partial, next to them new files are written to and interrupted copies kept in to be resumed by the next run.")
+	rootCmd.Flags().IntVar(&opts.Retries, "retries", 0, "Times a file is tried again after a failed stat, open or copy, e.g. on a flaky network share.")
+	rootCmd.Flags().DurationVar(&opts.RetryDelay, "retry-delay", time.Second, "Wait before the first retry, doubled for every further retry up to a minute.")
+	rootCmd.Flags().BoolVar(&opts.SkipUnreadable, "skip-unreadable", false, "If present source files and directories that can't be read for lack of permission or a lock held by another program are skipped with a warning instead of failing the sync.")
+	rootCmd.Flags().StringVar(&opts.UnreadableList, "unreadable-list", "", "Write the paths of source files and directories that couldn't be read to this file, one per line.")
+	rootCmd.Flags().IntVar(&opts.UnstableRetries, "unstable-retries", 2, "Times a file whose size or modification time changed while it was copied is copied again, --retry-delay apart, before it is reported as unstable and left out.")
+	rootCmd.Flags().StringArrayVar(&opts.LinkDest, "link-dest", nil, "Earlier snapshot, relative to destination unless absolute, unchanged files are hard linked from instead of copied into an empty destination (repeatable).")
+	rootCmd.Flags().BoolVarP(&opts.HardLinks, "hard-links", "H", false, "If present files hard linked together in source are copied once and hard linked the same way at destination.")
+	rootCmd.Flags().BoolVar(&opts.DetectRenames, "detect-renames", false, "If present with --delete, files moved within source are moved at destination when found there by size and hash, instead of copied again.")
 	rootCmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "If present what operation are performed without changing anything.")
+	rootCmd.Flags().BoolVar(&opts.DirsOnly, "dirs-only", false, "If present only the directory tree is recreated, with modes and modification times, no files are copied.")
+	rootCmd.Flags().BoolVar(&opts.SortPlan, "sort", false, "If present dry run operations are printed sorted by path, so runs can be diffed.")
+	rootCmd.Flags().BoolVar(&tui, "tui", false, "If present show a full-screen live dashboard while syncing.")
+	rootCmd.Flags().BoolVar(&showProgress, "progress", false, "If present show a progress bar with files and bytes done, throughput and ETA while syncing.")
+	rootCmd.Flags().BoolVar(&progressFiles, "progress-files", false, "If present show --progress along with the progress of each copy of 16 MiB or more.")
 	rootCmd.Flags().BoolVarP(&opts.Verbose, "verbose", "v", false, "If present enable detailed logging of operation.")
 	rootCmd.Flags().IntVar(&opts.Workers, "workers", runtime.NumCPU(), "Specifies the number of concurrent file copy workers.")
+	rootCmd.Flags().StringVar((*string)(&opts.Compare), "compare", string(syncer.CompareSizeMtime), "How existing files are compared: size-mtime, adaptive to hash only when modification times are ambiguous, or checksum to always hash.")
+	rootCmd.Flags().BoolVarP(&checksum, "checksum", "c", false, "If present hash both sides of files of the same size to decide whether to copy them, same as --compare checksum.")
+	rootCmd.Flags().DurationVar(&opts.ModifyWindow, "modify-window", 0, "Treat modification times closer than this as equal.")
+	rootCmd.Flags().DurationVar(&opts.AmbiguityWindow, "ambiguity-window", 2*time.Second, "In adaptive mode, hash files whose modification times are closer than this.")
+	rootCmd.Flags().DurationVar(&opts.MinAge, "min-age", 0, "Skip files modified more recently than this (e.g. 30s), they may still be being written. The next run picks them up, or with --watch the next sync once they are old enough.")
+	rootCmd.Flags().BoolVar(&opts.ExcludeCaches, "exclude-caches", false, "If present skip directories containing a CACHEDIR.TAG, and never delete them from destination.")
+	rootCmd.Flags().StringSliceVar(&opts.ExcludeMarkers, "exclude-marker", nil, "Skip directories containing a file of this name, e.g. .nosync, and never delete them from destination (repeatable).")
+	rootCmd.Flags().StringSliceVar(&opts.IncludeTypes, "include-type", nil, "Only sync files whose detected content type matches, e.g. text/* (repeatable).")
+	rootCmd.Flags().StringSliceVar(&opts.ExcludeTypes, "exclude-type", nil, "Skip files whose detected content type matches, e.g. video/* (repeatable).")
+	rootCmd.Flags().Var(pathRuleFlag{include: false}, "exclude", "Skip paths matching this rsync style glob (repeatable). Rules apply in the order given together with --include, the first match decides.")
+	rootCmd.Flags().Var(pathRuleFlag{include: true}, "include", "Sync paths matching this rsync style glob even if a later --exclude matches (repeatable), e.g. --include '*/' --include '*.go' --exclude '*'.")
+	rootCmd.Flags().Var(pathRuleFlag{include: false, regex: true}, "exclude-regex", "Skip paths matching this Go regular expression (repeatable), e.g. '\\.log\\.[0-9]{2,}$'. Directories are matched with a trailing slash. Ordered together with --exclude and --include.")
+	rootCmd.Flags().Var(pathRuleFlag{include: true, regex: true}, "include-regex", "Sync paths matching this Go regular expression (repeatable), ordered together with --exclude and --include.")
+	rootCmd.Flags().StringSliceVar(&opts.IncludeOwners, "include-owner", nil, "Only sync files owned by user, :group or user:group (repeatable).")
+	rootCmd.Flags().StringSliceVar(&opts.ExcludeOwners, "exclude-owner", nil, "Skip files owned by user, :group or user:group (repeatable).")
+	rootCmd.Flags().BoolVar(&opts.WholeFile, "whole-file", false, "If present always send whole files to remote destinations, never only the blocks that changed.")
+	rootCmd.Flags().StringArrayVar(&transforms, "transform", nil, "Rewrite the contents of files matching an --exclude style glob while copying, as GLOB=TRANSFORM, e.g. '*.log=gzip', '*.txt=lf', '*.bat=crlf' or '*.env=redact:password=\\S+' (repeatable). Transformed files are copied again only when modified after the destination.")
+	rootCmd.Flags().StringVar((*string)(&opts.Compression), "compress", string(syncer.CompressionNone), "Compress file contents sent to and read from gosync:// servers: none, zstd for slow links or s2 for faster ones. Files like .jpg or .zip that are compressed already are sent as they are.")
+	rootCmd.Flags().BoolVar(&opts.Encrypt, "encrypt", false, "Store destination files encrypted with AES-256-GCM, for untrusted storage. The key is read from --key-file, or derived from the passphrase in GOSYNC_PASSPHRASE or asked for. Use gosync restore to get the files back.")
+	rootCmd.Flags().BoolVar(&opts.EncryptNames, "encrypt-names", false, "With --encrypt, encrypt file and directory names too. Only applies to the first sync to a destination, later ones keep what it chose. With gosync restore of an rclone crypt remote, its names are encrypted.")
+	rootCmd.Flags().StringVar((*string)(&opts.EncryptFormat), "encrypt-format", string(syncer.EncryptGosync), "Format --encrypt writes new destinations in: gosync, or rclone to read them as rclone crypt remotes with the same password. GOSYNC_PASSPHRASE2 is rclone's password2.")
+	rootCmd.Flags().StringVar(&keyFile, "key-file", "", "File whose contents are the key of --encrypt, and of encrypted sources.")
+	rootCmd.Flags().BoolVar(&opts.Dedup, "dedup", false, "Store destination files as manifests of content-addressed chunks kept in .gosync-chunks, so contents several files or backups share take space once. Syncing from such a destination restores the files.")
+	rootCmd.Flags().BoolVar(&opts.StoreCompressed, "store-compressed", false, "Store destination files compressed with zstd as NAME.zst, for archiving large text or log trees. Syncing from such a destination decompresses them again.")
+	rootCmd.Flags().BoolVar(&opts.Verify, "verify", false, "If present read every copied file back and compare it with the source, copying it again on a mismatch.")
+	rootCmd.Flags().StringVar((*string)(&opts.Hash), "hash", string(syncer.HashSHA256), "Hash used to compare contents and store checksums: sha256, blake3, xxhash64 (fastest) or md5.")
+	rootCmd.Flags().StringVar(&opts.Manifest, "manifest", "", "Write the path, size, modification time and --hash of every destination file to this JSON file after each sync. Later runs take the hashes of unchanged files from it.")
+	rootCmd.Flags().BoolVar(&opts.StoreChecksums, "store-checksums", false, "Record the hash of copied files in the user.gosync.<hash> extended attribute.")
+	rootCmd.Flags().BoolVar(&opts.PreserveSELinux, "preserve-selinux", false, "Copy SELinux contexts (security.selinux) instead of using the destination's default labels.")
+	rootCmd.Flags().BoolVar(&opts.PreserveCapabilities, "preserve-caps", false, "Copy file capabilities (security.capability). Without it they are dropped.")
+	rootCmd.Flags().StringVar(&blockSize, "block-size", "", "Update existing local files in place, rewriting only the blocks of this size (e.g. 1MB) that differ. Hard links to them see the change.")
+	rootCmd.Flags().IntVar(&opts.QueueSize, "queue-size", 1024, "Number of files scanning may find ahead of the copies before it waits for them. Larger queues keep scanning going while big files copy, at a little memory per file.")
+	rootCmd.Flags().StringVar(&bufferSize, "buffer-size", "", "Size of the buffers file contents are copied through, 128KiB by default. Larger ones (e.g. 4MiB) suit fast networks and disks, smaller ones save memory with many workers.")
+	rootCmd.Flags().StringSliceVar(&ioLimits, "io-limit", nil, "Limit reads and writes of local files to this much per second and disk, as a size like 20MB or operations like 500iops (repeatable).")
+	rootCmd.Flags().BoolVar(&opts.NiceIO, "nice-io", false, "If present pause after every read of a local file for as long as it took, leaving disks at least half their time for other programs.")
+	rootCmd.Flags().StringVar(&maxMemory, "max-memory", "", "Approximate memory budget (e.g. 512MB). Uses fewer workers and an on-disk index to stay within it.")
+	rootCmd.Flags().IntVar(&opts.StatsDepth, "stats-depth", 1, "Number of leading directories the per-directory statistics are grouped by.")
+	rootCmd.Flags().IntVar(&opts.TopN, "top", 5, "Number of largest and slowest transfers listed in the summary, 0 to disable.")
+	rootCmd.Flags().StringVar(&reportPath, "report", "", "Write the run statistics as JSON to this file.")
+	rootCmd.Flags().StringVar((*string)(&opts.Placeholders), "placeholders", string(syncer.PlaceholderSkip), "How to handle cloud online-only placeholder files: skip, hydrate or stub.")
+	rootCmd.Flags().StringVar((*string)(&opts.Junctions), "junctions", string(syncer.JunctionSkip), "How to handle NTFS junctions in source: skip, follow or recreate.")
 }
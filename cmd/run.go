@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run PROFILE",
+	Short: "Sync with the options of a profile from the config file",
+	Long: `run syncs with the options of a named profile in the config file, given by their flag names.
+	Flags given on the command line take precedence over the profile:
+
+	  profiles:
+	    backup-photos:
+	      source: /home/me/Pictures
+	      dest: /mnt/backup/pictures
+	      delete: true
+	      workers: 4
+	      exclude: ["*.tmp", "cache/"]
+
+	gosync run backup-photos --dry-run previews the profile without changing anything.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		profile, ok := config.Profiles[args[0]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: no profile %q in %s.\n", args[0], configPath)
+			os.Exit(1)
+		}
+		if err := setFlags(cmd.Flags(), &profile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: profile %s: %v.\n", args[0], err)
+			os.Exit(1)
+		}
+
+		runSync(cmd)
+	},
+}
+
+func init() {
+	// Profiles take everything gosync does, and the same flags override them
+	runCmd.Flags().AddFlagSet(rootCmd.Flags())
+
+	// So do restores, diffs and checks, set up here once the flags are defined
+	restoreCmd.Flags().AddFlagSet(rootCmd.Flags())
+	diffCmd.Flags().AddFlagSet(rootCmd.Flags())
+	checkCmd.Flags().AddFlagSet(rootCmd.Flags())
+
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(restoreCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(checkCmd)
+}
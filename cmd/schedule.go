@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/schedule"
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+)
+
+// Syncs at every time of cronSchedule, each delayed by up to jitter, until interrupted.
+// Failed syncs are reported and the next one runs as planned.
+func runScheduled() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Schedule: %s\n", scheduleSpec)
+	runOnSchedule(ctx, "", cronSchedule, jitter, runScheduledSync)
+	fmt.Println("Schedule stopped")
+}
+
+// Calls run at every time of s, each delayed by up to jitter, until ctx is done. A run
+// still going at the next time isn't joined by another one, the times it ran past are
+// skipped. Messages are prefixed with prefix.
+func runOnSchedule(ctx context.Context, prefix string, s *schedule.Schedule, jitter time.Duration, run func(context.Context)) {
+	for {
+		scheduled := s.Next(time.Now())
+		if scheduled.IsZero() {
+			return
+		}
+		next := scheduled
+		if jitter > 0 {
+			next = next.Add(rand.N(jitter))
+		}
+		fmt.Printf("\n%sNext sync at %s\n", prefix, next.Format(time.DateTime))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		// A sync running when ctx is done stops, leaving the files it was copying as they were
+		run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if missed := missedRuns(s, scheduled, time.Now()); missed > 0 {
+			fmt.Fprintf(os.Stderr, "%sSync ran past %d scheduled time(s), skipping them.\n", prefix, missed)
+		}
+	}
+}
+
+// Runs one sync of a schedule.
+func runScheduledSync(ctx context.Context) {
+	if err := runHook(ctx, "--pre-cmd", preCmd, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Synchronization skipped: %v\n", err)
+		return
+	}
+	syncerTool := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts))
+
+	result, err := syncerTool.StartContext(ctx)
+	// Also when the sync was stopped, its result is worth knowing
+	if err := runHook(context.WithoutCancel(ctx), "--post-cmd", postCmd, opts, resultEnv(result, err)...); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	if err != nil && !filesFailed(err) {
+		fmt.Fprintf(os.Stderr, "Synchronization failed: %v\n", err)
+		return
+	}
+
+	summary := syncerTool.Summary()
+	printSummary(summary)
+	printErrors(result.Errors)
+
+	if reportPath != "" {
+		if err := writeReport(reportPath, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write report: %v\n", err)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("\n Synchronization completed in %v, %d path(s) failed\n", result.Duration, len(result.Errors))
+		return
+	}
+	fmt.Printf("\n Synchronization completed in %v\n", result.Duration)
+}
+
+// Counts the times of s after scheduled that passed by now.
+func missedRuns(s *schedule.Schedule, scheduled, now time.Time) int {
+	missed := 0
+	for t := s.Next(scheduled); !t.IsZero() && t.Before(now); t = s.Next(t) {
+		missed++
+	}
+	return missed
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveRoot      string
+	serveListen    string
+	serveTokenFile string
+	serveVerbose   bool
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Expose a directory to gosync://host:port/path destinations on other machines",
+	Long: `serve listens for gosync clients and lets them sync into the given root directory, without
+	SFTP or rsync on this machine. Clients authenticate with a shared token, taken from GOSYNC_TOKEN or
+	--token-file here and from GOSYNC_TOKEN on the client, and the server proves it knows the token too
+	before the client sends anything. The token never crosses the network, but the connection is not
+	encrypted: file names and contents can be read, and changed, by anyone on the way. Tunnel it through
+	SSH or a VPN over untrusted networks.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if serveRoot == "" {
+			cmd.Help()
+			fmt.Fprintln(os.Stderr, "\nError: --root is a required argument.")
+			os.Exit(1)
+		}
+
+		token := os.Getenv("GOSYNC_TOKEN")
+		if serveTokenFile != "" {
+			data, err := os.ReadFile(serveTokenFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading token file: %v\n", err)
+				os.Exit(1)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+
+		listener, err := net.Listen("tcp", serveListen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", serveListen, err)
+			os.Exit(1)
+		}
+
+		err = syncer.Serve(listener, syncer.ServeOptions{Root: serveRoot, Token: token, Verbose: serveVerbose})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Serving failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveRoot, "root", "", "Directory clients sync to. (Required)")
+	serveCmd.Flags().StringVar(&serveListen, "listen", ":"+strconv.Itoa(syncer.DefaultServePort), "Address to listen on.")
+	serveCmd.Flags().StringVar(&serveTokenFile, "token-file", "", "File holding the token clients authenticate with, instead of GOSYNC_TOKEN.")
+	serveCmd.Flags().BoolVarP(&serveVerbose, "verbose", "v", false, "Log every request, not only connections.")
+
+	rootCmd.AddCommand(serveCmd)
+}
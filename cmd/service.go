@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var serviceName string
+
+// Closed when the system asks the service gosync runs as to stop.
+var serviceStop = make(chan struct{})
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Run gosync daemon or watch at boot as a Windows service or launchd agent",
+	Long: `service registers gosync daemon or gosync watch with the system, so that it starts by itself
+	and is restarted when it fails:
+
+	  gosync service install -- daemon --config /etc/gosync.yaml
+	  gosync service start
+
+	On Windows it becomes a service starting at boot, installed from an administrator prompt, which
+	logs to %ProgramData%\gosync\NAME.log unless --log-file is given and reports starts, stops and
+	failures to the Application event log. On macOS it becomes a launchd agent of the current user
+	starting at login, whose output goes to ~/Library/Logs/gosync/NAME.log.
+
+	Windows services don't run in the current directory nor as the current user, so give absolute
+	paths there, --config included.`,
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install -- daemon|watch [FLAGS]",
+	Short: "Register gosync daemon or watch with the given flags to run at boot",
+	Run: func(cmd *cobra.Command, args []string) {
+		if cmd.ArgsLenAtDash() != 0 || len(args) == 0 || (args[0] != "daemon" && args[0] != "watch") {
+			cmd.Help()
+			fmt.Fprintln(os.Stderr, "\nError: the command to run, daemon or watch, is required after --.")
+			os.Exit(1)
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not find the gosync executable: %v.\n", err)
+			os.Exit(1)
+		}
+		if err := installService(serviceName, exe, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Installed service %s: gosync %s\n", serviceName, args[0])
+	},
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop and unregister the service",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := uninstallService(serviceName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Uninstalled service %s\n", serviceName)
+	},
+}
+
+var serviceStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the installed service now instead of at the next boot",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := startService(serviceName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Started service %s\n", serviceName)
+	},
+}
+
+// Like signal.NotifyContext for interrupts, and also done once the service gosync runs as
+// is stopped.
+func notifyContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-serviceStop:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, stop
+}
+
+func init() {
+	serviceCmd.PersistentFlags().StringVar(&serviceName, "name", "gosync", "Name of the service, to install several side by side.")
+
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+	serviceCmd.AddCommand(serviceStartCmd)
+	rootCmd.AddCommand(serviceCmd)
+}
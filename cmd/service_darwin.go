@@ -0,0 +1,132 @@
+//go:build darwin
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Label of the launchd agent of the service named name.
+func serviceLabel(name string) string {
+	return "com.github.bipinmdr07." + name
+}
+
+func servicePlist(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the home directory: %v.", err)
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", serviceLabel(name)+".plist"), nil
+}
+
+func installService(name, exe string, args []string) error {
+	plistPath, err := servicePlist(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plistPath); err == nil {
+		return fmt.Errorf("service %s is installed already, uninstall it first.", name)
+	}
+
+	home, _ := os.UserHomeDir()
+	logDir := filepath.Join(home, "Library", "Logs", "gosync")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("could not create log directory: %v.", err)
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		workDir = home
+	}
+
+	// Started at login and again when it fails, but no more often than every 30 seconds
+	var plist strings.Builder
+	plist.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&plist, "\t<key>Label</key>\n\t<string>%s</string>\n", plistEscape(serviceLabel(name)))
+	plist.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{exe}, args...) {
+		fmt.Fprintf(&plist, "\t\t<string>%s</string>\n", plistEscape(arg))
+	}
+	plist.WriteString("\t</array>\n")
+	fmt.Fprintf(&plist, "\t<key>WorkingDirectory</key>\n\t<string>%s</string>\n", plistEscape(workDir))
+	plist.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	plist.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	plist.WriteString("\t<key>ThrottleInterval</key>\n\t<integer>30</integer>\n")
+	logPath := plistEscape(filepath.Join(logDir, name+".log"))
+	fmt.Fprintf(&plist, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", logPath)
+	fmt.Fprintf(&plist, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", logPath)
+	plist.WriteString("</dict>\n</plist>\n")
+
+	if err := os.MkdirAll(filepath.Dir(plistPath), 0755); err != nil {
+		return fmt.Errorf("could not create %s: %v.", filepath.Dir(plistPath), err)
+	}
+	if err := os.WriteFile(plistPath, []byte(plist.String()), 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v.", plistPath, err)
+	}
+	if err := launchctl("bootstrap", launchDomain(), plistPath); err != nil {
+		os.Remove(plistPath)
+		return fmt.Errorf("could not load service %s: %v.", name, err)
+	}
+	return nil
+}
+
+func uninstallService(name string) error {
+	plistPath, err := servicePlist(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plistPath); err != nil {
+		return fmt.Errorf("service %s is not installed.", name)
+	}
+
+	// Unloading fails when it was unloaded by hand, which is fine for removing it
+	launchctl("bootout", launchDomain()+"/"+serviceLabel(name))
+	if err := os.Remove(plistPath); err != nil {
+		return fmt.Errorf("could not uninstall service %s: %v.", name, err)
+	}
+	return nil
+}
+
+func startService(name string) error {
+	if err := launchctl("kickstart", launchDomain()+"/"+serviceLabel(name)); err != nil {
+		return fmt.Errorf("could not start service %s: %v.", name, err)
+	}
+	return nil
+}
+
+// The launchd domain of the agents of the current user.
+func launchDomain() string {
+	return "gui/" + strconv.Itoa(os.Getuid())
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(out)); message != "" {
+			return fmt.Errorf("%v: %s", err, message)
+		}
+		return err
+	}
+	return nil
+}
+
+func plistEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Reports whether gosync was started as a service and has run as one.
+func runAsService() bool {
+	return false // launchd stops agents with SIGTERM, like anything else
+}
@@ -0,0 +1,24 @@
+//go:build !windows && !darwin
+
+package cmd
+
+import "errors"
+
+var errNoServices = errors.New("services can only be installed on Windows and macOS, use a systemd unit or cron @reboot entry running gosync daemon here.")
+
+func installService(name, exe string, args []string) error {
+	return errNoServices
+}
+
+func uninstallService(name string) error {
+	return errNoServices
+}
+
+func startService(name string) error {
+	return errNoServices
+}
+
+// Reports whether gosync was started as a service and has run as one.
+func runAsService() bool {
+	return false
+}
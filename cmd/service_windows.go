@@ -0,0 +1,187 @@
+//go:build windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func installService(name, exe string, args []string) error {
+	// Services have no console, so they log to a file unless told where to
+	if !hasFlag(args, "--log-file") {
+		logDir := filepath.Join(os.Getenv("ProgramData"), "gosync")
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			return fmt.Errorf("could not create log directory: %v.", err)
+		}
+		args = append(args, "--log-file", filepath.Join(logDir, name+".log"))
+	}
+
+	m, err := connectServices()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		StartType:   mgr.StartAutomatic,
+		DisplayName: "gosync " + name,
+		Description: "Runs gosync " + strings.Join(args, " "),
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("could not install service %s: %v.", name, err)
+	}
+	defer s.Close()
+
+	// Restarted when it fails, backing off, with the count reset after a day without failures
+	restart := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}
+	if err := s.SetRecoveryActions(restart, 24*60*60); err != nil {
+		s.Delete()
+		return fmt.Errorf("could not set restart policy of service %s: %v.", name, err)
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		s.Delete()
+		return fmt.Errorf("could not set restart policy of service %s: %v.", name, err)
+	}
+
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil && !strings.Contains(err.Error(), "exists") {
+		s.Delete()
+		return fmt.Errorf("could not register event log source %s: %v.", name, err)
+	}
+	return nil
+}
+
+func uninstallService(name string) error {
+	m, err := connectServices()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %v.", name, err)
+	}
+	defer s.Close()
+
+	// Stopping fails when it isn't running, which is fine for removing it
+	s.Control(svc.Stop)
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("could not uninstall service %s: %v.", name, err)
+	}
+	eventlog.Remove(name)
+	return nil
+}
+
+func startService(name string) error {
+	m, err := connectServices()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %v.", name, err)
+	}
+	defer s.Close()
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("could not start service %s: %v.", name, err)
+	}
+	return nil
+}
+
+func connectServices() (*mgr.Mgr, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the service manager, run this as administrator: %v.", err)
+	}
+	return m, nil
+}
+
+// Reports whether args set flag, as --flag value or --flag=value.
+func hasFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether gosync was started as a service and has run as one.
+func runAsService() bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	if err := svc.Run("", serviceHandler{}); err != nil {
+		os.Exit(1)
+	}
+	return true
+}
+
+// Runs the command gosync was installed with while the service manager lets it.
+type serviceHandler struct{}
+
+func (serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	events, eventsErr := eventlog.Open(args[0])
+	if eventsErr == nil {
+		defer events.Close()
+	}
+	report := func(failed bool, message string) {
+		if eventsErr != nil {
+			return
+		}
+		if failed {
+			events.Error(1, message)
+		} else {
+			events.Info(1, message)
+		}
+	}
+
+	// Commands exiting on errors end the process, which counts as a crash and restarts it too
+	done := make(chan error, 1)
+	go func() {
+		done <- rootCmd.Execute()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	report(false, "gosync started")
+
+	var stopOnce sync.Once
+	for {
+		select {
+		case err := <-done:
+			status <- svc.Status{State: svc.StopPending}
+			if err != nil {
+				report(true, fmt.Sprintf("gosync failed: %v", err))
+				return false, 1
+			}
+			report(false, "gosync stopped")
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				stopOnce.Do(func() { close(serviceStop) })
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+)
+
+// Multipliers for the size suffixes accepted on the command line.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1000,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1000 * 1000,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1000 * 1000 * 1000,
+	"GIB": 1 << 30,
+	"T":   1 << 40,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"TIB": 1 << 40,
+}
+
+// Parses a human readable size such as 512MB, 1.5GiB or 4096 into bytes.
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	split := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(value)
+	}
+
+	number, err := strconv.ParseFloat(value[:split], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(value[split:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", value)
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+// Parses a --io-limit value, a number of operations like 500iops or a size per second
+// like 20MB, into opts.
+func parseIOLimit(value string, opts *syncer.SyncOptions) error {
+	if number, ok := strings.CutSuffix(strings.ToLower(strings.TrimSpace(value)), "iops"); ok {
+		ops, err := strconv.Atoi(strings.TrimSpace(number))
+		if err != nil || ops <= 0 {
+			return fmt.Errorf("invalid --io-limit value %q, expected a positive number of iops.", value)
+		}
+		opts.IOOps = ops
+		return nil
+	}
+
+	size, err := parseSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil || size <= 0 {
+		return fmt.Errorf("invalid --io-limit value %q, expected a size per second like 20MB or iops like 500iops.", value)
+	}
+	opts.IOLimit = size
+	return nil
+}
+
+// Formats a byte count using binary units, e.g. 1.5 MiB.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+)
+
+// Version of the plan file format, apply refuses others.
+const planVersion = 1
+
+// Most differences listed when apply refuses a plan.
+const maxDriftShown = 20
+
+// What gosync plan writes and gosync apply carries out.
+type planFile struct {
+	Version    int                       `json:"version"`
+	Created    time.Time                 `json:"created"`
+	Dir        string                    `json:"dir"` // Working directory relative paths of Options are taken from
+	Options    syncer.SyncOptions        `json:"options"`
+	Operations []syncer.PlannedOperation `json:"operations"`
+}
+
+var planOutput string
+
+var planCmd = &cobra.Command{
+	Use:   "plan --output PLAN",
+	Short: "Write what a sync would do to a file, to be reviewed and applied later",
+	Long: `plan does a dry run like gosync --dry-run and writes the operations it would carry out
+	to a JSON file, along with the options and the state of every path involved. It takes the
+	same flags as gosync.
+
+	gosync apply carries the plan out later, unless anything it involves changed since.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if opts.SourcePath == "" || len(destinations) == 0 {
+			cmd.Help()
+			fmt.Fprintln(os.Stderr, "\nError: --source and --dest are required arguments.")
+			os.Exit(1)
+		}
+		if planOutput == "" {
+			fmt.Fprintln(os.Stderr, "Error: --output is required.")
+			os.Exit(1)
+		}
+		if tui || showProgress || progressFiles {
+			fmt.Fprintln(os.Stderr, "Error: --tui and --progress can't be used with plan.")
+			os.Exit(1)
+		}
+
+		if err := validateOptions(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if cronSchedule != nil {
+			fmt.Fprintln(os.Stderr, "Error: --schedule can't be used with plan.")
+			os.Exit(1)
+		}
+		if opts.MaxMemory > 0 {
+			debug.SetMemoryLimit(opts.MaxMemory)
+		}
+
+		dir, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		opts.DryRun = true
+		opts.RecordPlan = true
+		syncerTool := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts))
+		printHeader(syncerTool)
+
+		// The options are saved as given, apply sets them up again
+		plan := planFile{Version: planVersion, Created: time.Now(), Dir: dir, Options: *opts}
+		plan.Options.DryRun = false
+		plan.Options.RecordPlan = false
+		plan.Options.LogWriter = nil
+		plan.Options.LogFile = nil
+
+		result, err := syncerTool.Start()
+		if err != nil && !filesFailed(err) {
+			fmt.Fprintf(os.Stderr, "Planning failed: %v\n", err)
+			os.Exit(1)
+		}
+		plan.Operations = syncerTool.Plan()
+		printSummary(syncerTool.Summary())
+		printErrors(result.Errors)
+
+		if err := writePlan(planOutput, plan); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write plan: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("\n %d operations planned, written to %s. Carry them out with gosync apply %s\n", len(plan.Operations), planOutput, planOutput)
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply PLAN",
+	Short: "Carry out a plan written by gosync plan",
+	Long: `apply carries out a plan written by gosync plan, with the options it was made with. It plans
+	again first and refuses if that doesn't come to exactly the same operations on paths in the
+	same state, because the source or the destination changed since. Changes made while the plan
+	is carried out aren't caught.
+
+	Relative paths are taken from the directory the plan was made in.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		plan, err := readPlan(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.Chdir(plan.Dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not change to the directory of the plan: %v\n", err)
+			os.Exit(1)
+		}
+		if plan.Options.MaxMemory > 0 {
+			debug.SetMemoryLimit(plan.Options.MaxMemory)
+		}
+		if err := reloadPassphrase(&plan.Options); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Planned again without a word, only differences are of interest
+		check := plan.Options
+		check.DryRun = true
+		check.RecordPlan = true
+		check.LogWriter = io.Discard
+		checker := syncer.NewSyncer(check.SourcePath, check.DestinationPath, syncer.WithOptions(&check))
+		if _, err := checker.Start(); err != nil && !filesFailed(err) {
+			fmt.Fprintf(os.Stderr, "Planning again failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		if drift := planDrift(plan.Operations, checker.Plan()); len(drift) > 0 {
+			fmt.Fprintf(os.Stderr, "Refusing to apply %s, the trees changed since it was made:\n", args[0])
+			for i, line := range drift {
+				if i == maxDriftShown {
+					fmt.Fprintf(os.Stderr, "  and %d more\n", len(drift)-i)
+					break
+				}
+				fmt.Fprintf(os.Stderr, "  %s\n", line)
+			}
+			os.Exit(1)
+		}
+		if len(plan.Operations) == 0 {
+			fmt.Println("Nothing to do, the plan is empty.")
+			os.Exit(0)
+		}
+
+		*opts = plan.Options
+		if err := setLogFile(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		syncerTool := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts))
+		printHeader(syncerTool)
+
+		result, err := syncerTool.Start()
+		if err != nil && !filesFailed(err) {
+			fmt.Fprintf(os.Stderr, "Synchronization failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		printSummary(syncerTool.Summary())
+		printErrors(result.Errors)
+		if err != nil {
+			fmt.Printf("\n Plan applied in %v, %d path(s) failed\n", result.Duration, len(result.Errors))
+			os.Exit(exitFilesFailed)
+		}
+		fmt.Printf("\n Plan applied in %v\n", result.Duration)
+	},
+}
+
+// Writes plan as indented JSON to path.
+func writePlan(path string, plan planFile) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func readPlan(path string) (*planFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan planFile
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("could not read plan %s: %w.", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("plan %s has version %d, expected %d.", path, plan.Version, planVersion)
+	}
+	return &plan, nil
+}
+
+// Describes how the operations planned now differ from those of the plan, a line each.
+func planDrift(planned, current []syncer.PlannedOperation) []string {
+	type key struct{ action, path, target string }
+	now := make(map[key]syncer.PlannedOperation, len(current))
+	for _, operation := range current {
+		now[key{operation.Action, operation.Path, operation.Target}] = operation
+	}
+
+	var drift []string
+	for _, operation := range planned {
+		k := key{operation.Action, operation.Path, operation.Target}
+		again, ok := now[k]
+		delete(now, k)
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("%s %s: no longer needed", operation.Action, operation.Path))
+		case !samePathState(operation.Source, again.Source):
+			drift = append(drift, fmt.Sprintf("%s %s: source changed", operation.Action, operation.Path))
+		case !samePathState(operation.Destination, again.Destination):
+			drift = append(drift, fmt.Sprintf("%s %s: destination changed", operation.Action, operation.Path))
+		}
+	}
+	for _, operation := range current {
+		if _, ok := now[key{operation.Action, operation.Path, operation.Target}]; ok {
+			drift = append(drift, fmt.Sprintf("%s %s: not in the plan", operation.Action, operation.Path))
+		}
+	}
+	return drift
+}
+
+func samePathState(a, b *syncer.PathState) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.Size == b.Size && a.ModTime.Equal(b.ModTime)
+}
+
+func init() {
+	// Plans are made with everything gosync takes
+	planCmd.Flags().AddFlagSet(rootCmd.Flags())
+	// -o is taken by --owner
+	planCmd.Flags().StringVar(&planOutput, "output", "", "File the plan is written to as JSON.")
+
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(applyCmd)
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// How often the dashboard polls the syncer for progress.
+const tuiRefreshInterval = 250 * time.Millisecond
+
+var (
+	titleStyle   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	headingStyle = lipgloss.NewStyle().Bold(true).MarginTop(1)
+	dimStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	okStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+)
+
+type tickMsg time.Time
+
+// Sent once Start has returned.
+type syncDoneMsg struct {
+	result syncer.Result
+	err    error
+}
+
+// Bubble Tea model rendering a live dashboard of a running sync.
+type dashboard struct {
+	syncer      *syncer.Syncer
+	cancel      context.CancelFunc // Stops the sync
+	progress    syncer.Progress
+	rate        progressRate
+	copyRate    float64
+	eta         string
+	bar         progress.Model
+	started     time.Time
+	width       int
+	result      syncer.Result
+	err         error
+	done        bool
+	interrupted bool
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(tuiRefreshInterval, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+func (m *dashboard) Init() tea.Cmd {
+	return tick()
+}
+
+func (m *dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		// Quitting stops the sync cleanly and waits for it, quitting again leaves right away
+		if msg.String() == "ctrl+c" || msg.String() == "q" {
+			if m.interrupted {
+				return m, tea.Quit
+			}
+			m.interrupted = true
+			m.cancel()
+		}
+
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.bar.Width = msg.Width - 4
+
+	case tickMsg:
+		m.progress = m.syncer.Progress()
+		var processRate float64
+		m.copyRate, processRate = m.rate.update(m.progress, time.Time(msg))
+		m.eta = formatETA(estimate(m.progress, processRate))
+		return m, tick()
+
+	case syncDoneMsg:
+		m.progress = m.syncer.Progress()
+		m.result, m.err = msg.result, msg.err
+		m.done = true
+		return m, tea.Quit
+	}
+
+	return m, nil
+}
+
+func (m *dashboard) View() string {
+	p := m.progress
+	elapsed := time.Since(m.started)
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s  %s → %s\n", titleStyle.Render("gosync"), opts.SourcePath, destinationList())
+	quit := "(q to quit)"
+	if m.interrupted {
+		quit = "(stopping, q again to quit now)"
+	}
+	fmt.Fprintf(&b, "%s\n\n", dimStyle.Render(fmt.Sprintf("phase: %s  elapsed: %v  workers: %d  queued: %d/%d  %s", p.Phase, elapsed.Round(time.Second), m.syncer.Options.Workers, p.QueueDepth, m.syncer.Options.QueueSize, quit)))
+
+	// The total isn't known until the walk is done, so this tracks the files found so far
+	b.WriteString(m.bar.ViewAs(doneRatio(p)))
+	fmt.Fprintf(&b, "\n%d/%d files processed, %d copied, %s at %s/s, %s, %s\n",
+		p.FilesProcessed, p.FilesQueued, p.FilesCopied, formatBytes(p.BytesCopied),
+		formatBytes(int64(m.copyRate)), m.eta, errorCount(p.Errors))
+
+	b.WriteString(headingStyle.Render("Workers") + "\n")
+	if len(p.Active) == 0 {
+		b.WriteString(dimStyle.Render("  idle") + "\n")
+	}
+	for _, t := range p.Active {
+		speed := float64(t.Copied) / time.Since(t.Started).Seconds()
+		fmt.Fprintf(&b, "  %s  %s/%s  %s/s\n", m.truncate(t.Path, 40), formatBytes(t.Copied), formatBytes(t.Size), formatBytes(int64(speed)))
+	}
+
+	b.WriteString(headingStyle.Render("Recent errors") + "\n")
+	if len(p.RecentErrors) == 0 {
+		b.WriteString(dimStyle.Render("  none") + "\n")
+	}
+	for _, e := range p.RecentErrors {
+		b.WriteString(errorStyle.Render("  "+m.truncate(e.Error(), 4)) + "\n")
+	}
+
+	b.WriteString(headingStyle.Render("Recently completed") + "\n")
+	for _, t := range p.RecentCopies {
+		b.WriteString(okStyle.Render("  ✓ ") + m.truncate(t.Path, 20) + dimStyle.Render("  "+formatBytes(t.Bytes)) + "\n")
+	}
+
+	return b.String()
+}
+
+// Shortens text from the left so it fits the terminal, leaving reserved columns for the rest of the line.
+func (m *dashboard) truncate(text string, reserved int) string {
+	limit := m.width - reserved
+	if m.width == 0 || limit < 10 || len(text) <= limit {
+		return text
+	}
+	return "…" + text[len(text)-limit+1:]
+}
+
+func errorCount(errors int64) string {
+	if errors == 0 {
+		return "0 errors"
+	}
+	return errorStyle.Render(fmt.Sprintf("%d errors", errors))
+}
+
+// Runs the sync while rendering the dashboard. Reports whether the sync was interrupted,
+// by the user quitting the dashboard or a signal, and otherwise its result and error.
+func runWithDashboard(syncerTool *syncer.Syncer) (result syncer.Result, interrupted bool, err error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	model := &dashboard{
+		syncer:  syncerTool,
+		cancel:  stop,
+		bar:     progress.New(progress.WithDefaultGradient()),
+		rate:    newProgressRate(time.Now()),
+		eta:     formatETA(0, false),
+		started: time.Now(),
+	}
+
+	program := tea.NewProgram(model, tea.WithAltScreen())
+	go func() {
+		result, err := syncerTool.StartContext(ctx)
+		program.Send(syncDoneMsg{result: result, err: err})
+	}()
+
+	if _, err := program.Run(); err != nil {
+		stop()
+		return syncer.Result{}, false, fmt.Errorf("could not run dashboard: %w", err)
+	}
+
+	return model.result, ctx.Err() != nil, model.err
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit code of a verify that found the destination differing, errors exit with 1.
+const exitVerifyFailed = 2
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check a destination against a manifest or the source, hashing every file",
+	Long: `verify reads every file of --dest back and checks it against the manifest a sync wrote with
+	--manifest, or against --source, hashing the files on both sides. It changes nothing and trusts
+	no checksums stored by earlier syncs, so it checks the destination independently of how it was
+	written:
+
+	  gosync verify --dest /mnt/backup --manifest /var/lib/backup.manifest.json
+	  gosync verify --source /home/me --dest /mnt/backup --exclude '*.tmp'
+
+	Files that differ, are missing or are in excess are listed, and gosync exits with 2. The
+	filters of a sync apply, and encrypted destinations take their key as for restore.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(destinations) != 1 || (opts.SourcePath == "") == (opts.Manifest == "") {
+			cmd.Help()
+			fmt.Fprintln(os.Stderr, "\nError: one --dest and either --source or --manifest are required.")
+			os.Exit(1)
+		}
+
+		// Remotes of rclone crypt have no marker to tell
+		opts.Decrypt = opts.EncryptFormat == syncer.EncryptRclone
+		if err := askPassphraseFor(destinations[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := validateOptions(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		against := opts.SourcePath
+		if opts.Manifest != "" {
+			against = opts.Manifest
+		}
+		fmt.Printf("-- Go Sync CLI ---\n")
+		fmt.Printf("Verifying %s against %s\n", opts.DestinationPath, against)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		result, err := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts)).Audit(ctx)
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Verification interrupted")
+			os.Exit(130)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verification failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(result.Findings) > 0 {
+			fmt.Println()
+			for _, finding := range result.Findings {
+				if finding.Err != nil {
+					fmt.Printf("  %-10s %s: %v\n", finding.Problem, finding.Path, finding.Err)
+				} else {
+					fmt.Printf("  %-10s %s\n", finding.Problem, finding.Path)
+				}
+			}
+			fmt.Printf("\n %d file(s) checked, %d problem(s) found\n", result.Checked, len(result.Findings))
+			os.Exit(exitVerifyFailed)
+		}
+		fmt.Printf("\n %d file(s) checked, the destination matches\n", result.Checked)
+	},
+}
+
+func init() {
+	// Filters, keys and hashes are given as for a sync
+	verifyCmd.Flags().AddFlagSet(rootCmd.Flags())
+
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/bipinmdr07/gosync/pkg/syncer"
+
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Sync, then keep syncing source changes as they happen",
+	Long: `watch syncs the source to the destination like gosync does, then keeps watching the source
+	and syncs the files and directories created, modified or deleted in it within moments, without
+	scanning the whole tree again. It takes the same flags as gosync and runs until interrupted.
+	Only local sources can be watched. Sources on network filesystems are scanned for changes every
+	--poll-interval instead, as are those with more directories than the system has watches for.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if opts.SourcePath == "" || len(destinations) == 0 {
+			cmd.Help()
+			fmt.Fprintln(os.Stderr, "\nError: --source and --dest are required arguments.")
+			os.Exit(1)
+		}
+		if tui {
+			fmt.Fprintln(os.Stderr, "Error: --tui can't be used with watch.")
+			os.Exit(1)
+		}
+		if showProgress || progressFiles {
+			fmt.Fprintln(os.Stderr, "Error: --progress can't be used with watch.")
+			os.Exit(1)
+		}
+		if interactive {
+			fmt.Fprintln(os.Stderr, "Error: --interactive can't be used with watch.")
+			os.Exit(1)
+		}
+		if opts.WatchDelay < 0 {
+			fmt.Fprintln(os.Stderr, "Error: --watch-delay can't be negative.")
+			os.Exit(1)
+		}
+		if opts.ReconcileInterval < 0 {
+			fmt.Fprintln(os.Stderr, "Error: --reconcile-interval can't be negative.")
+			os.Exit(1)
+		}
+		if opts.PollInterval <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: --poll-interval has to be positive.")
+			os.Exit(1)
+		}
+
+		if err := validateOptions(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if cronSchedule != nil {
+			fmt.Fprintln(os.Stderr, "Error: --schedule can't be used with watch.")
+			os.Exit(1)
+		}
+		if opts.MaxMemory > 0 {
+			debug.SetMemoryLimit(opts.MaxMemory)
+		}
+
+		syncerTool := syncer.NewSyncer(opts.SourcePath, opts.DestinationPath, syncer.WithOptions(opts))
+		printHeader(syncerTool)
+
+		ctx, stop := notifyContext()
+		defer stop()
+
+		if err := runHook(ctx, "--pre-cmd", preCmd, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Synchronization aborted: %v\n", err)
+			os.Exit(1)
+		}
+
+		startTime := time.Now()
+		err := syncerTool.Watch(ctx)
+		elapsed := time.Since(startTime)
+		if err := runHook(context.Background(), "--post-cmd", postCmd, opts, resultEnv(syncer.Result{Duration: elapsed}, err)...); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Synchronization failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		summary := syncerTool.Summary()
+		printSummary(summary)
+
+		if reportPath != "" {
+			if err := writeReport(reportPath, summary); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not write report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("\n Watched for %v\n", elapsed)
+	},
+}
+
+func init() {
+	// Everything gosync takes applies to the syncs of watch as well
+	watchCmd.Flags().AddFlagSet(rootCmd.Flags())
+	watchCmd.Flags().DurationVar(&opts.WatchDelay, "watch-delay", time.Second, "How long source has to be quiet before its changes are synced, so files being written are copied once.")
+	watchCmd.Flags().DurationVar(&opts.ReconcileInterval, "reconcile-interval", 0, "How often to compare the whole source and destination again, catching changes the watcher missed. Never when 0.")
+	watchCmd.Flags().BoolVar(&opts.Poll, "poll", false, "If present scan the source for changes instead of having the system report them. Network filesystems and running out of watches fall back to it by themselves.")
+	watchCmd.Flags().DurationVar(&opts.PollInterval, "poll-interval", 10*time.Second, "How often a polled source is scanned for changes.")
+
+	rootCmd.AddCommand(watchCmd)
+}
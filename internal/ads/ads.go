@@ -0,0 +1,11 @@
+package ads
+
+import "errors"
+
+// ErrUnsupported is returned on platforms and file systems without alternate data streams.
+var ErrUnsupported = errors.New("alternate data streams are not supported")
+
+// Path returns the path the stream name of the file at path is opened at.
+func Path(path, name string) string {
+	return path + ":" + name
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package ads
+
+// List returns the names of the alternate data streams of the file at path.
+func List(path string) ([]string, error) {
+	return nil, ErrUnsupported
+}
+
+// Supported reports whether the volume holding path supports alternate data streams.
+func Supported(path string) bool {
+	return false
+}
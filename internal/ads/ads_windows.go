@@ -0,0 +1,83 @@
+//go:build windows
+
+package ads
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32             = windows.NewLazySystemDLL("kernel32.dll")
+	procFindFirstStreamW = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = kernel32.NewProc("FindNextStreamW")
+)
+
+// WIN32_FIND_STREAM_DATA, a stream's size and its name as ":name:$DATA".
+type findStreamData struct {
+	size int64
+	name [windows.MAX_PATH + 36]uint16
+}
+
+// List returns the names of the alternate data streams of the file at path, leaving out
+// the main stream.
+func List(path string) ([]string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data findStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(pathPtr)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(handle) == windows.InvalidHandle {
+		if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+			return nil, nil // Only the main stream, or a directory without streams
+		}
+		return nil, err
+	}
+	defer windows.FindClose(windows.Handle(handle))
+
+	var names []string
+	for {
+		// Data streams are named ":name:$DATA", the main stream "::$DATA"
+		name := windows.UTF16ToString(data.name[:])
+		if stream, ok := strings.CutSuffix(strings.TrimPrefix(name, ":"), ":$DATA"); ok && stream != "" {
+			names = append(names, stream)
+		}
+
+		if ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data))); ok == 0 {
+			if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+				return names, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// Supported reports whether the volume holding path supports alternate data streams.
+// FAT and exFAT volumes and most network shares of other systems don't.
+func Supported(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	// The path may not exist yet, its volume is found all the same
+	pathPtr, err := windows.UTF16PtrFromString(abs)
+	if err != nil {
+		return false
+	}
+	volume := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(pathPtr, &volume[0], uint32(len(volume))); err != nil {
+		return false
+	}
+
+	var flags uint32
+	if err := windows.GetVolumeInformation(&volume[0], nil, 0, nil, nil, &flags, nil, 0); err != nil {
+		return false
+	}
+	return flags&windows.FILE_NAMED_STREAMS != 0
+}
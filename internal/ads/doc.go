@@ -0,0 +1,3 @@
+// Package ads lists the alternate data streams of files on NTFS, like the Zone.Identifier
+// stream Windows marks downloaded files with.
+package ads
@@ -0,0 +1,3 @@
+// Package junction reads and creates NTFS junctions, the directory mount points
+// that Windows uses in place of directory symlinks.
+package junction
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package junction
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// ErrUnsupported is returned by Read and Create outside of Windows.
+var ErrUnsupported = errors.New("junctions are only supported on Windows")
+
+// Is reports whether the entry at path is a junction. Junctions only exist on NTFS,
+// so nothing is ever a junction here.
+func Is(path string, d fs.DirEntry) bool {
+	return false
+}
+
+// Read returns the target directory of the junction at path.
+func Read(path string) (string, error) {
+	return "", ErrUnsupported
+}
+
+// Create creates a junction at link pointing to target.
+func Create(link, target string) error {
+	return ErrUnsupported
+}
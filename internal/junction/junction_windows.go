@@ -0,0 +1,103 @@
+//go:build windows
+
+package junction
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unicode/utf16"
+
+	"golang.org/x/sys/windows"
+)
+
+// Is reports whether the entry at path is an NTFS junction (a mount point reparse point).
+func Is(path string, d fs.DirEntry) bool {
+	// Junctions show up as directories, symlinks or irregular files depending on the Go version
+	if d.Type()&(fs.ModeDir|fs.ModeSymlink|fs.ModeIrregular) == 0 {
+		return false
+	}
+
+	namePtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+
+	var data windows.Win32finddata
+	handle, err := windows.FindFirstFile(namePtr, &data)
+	if err != nil {
+		return false
+	}
+	windows.FindClose(handle)
+
+	// For reparse points the reparse tag is reported in Reserved0
+	return data.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0 &&
+		data.Reserved0 == windows.IO_REPARSE_TAG_MOUNT_POINT
+}
+
+// Read returns the target directory of the junction at path.
+func Read(path string) (string, error) {
+	return os.Readlink(path)
+}
+
+// Create creates a junction at link pointing to target.
+func Create(link, target string) error {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(link, os.ModePerm); err != nil {
+		return err
+	}
+
+	linkPtr, err := windows.UTF16PtrFromString(link)
+	if err != nil {
+		os.Remove(link)
+		return err
+	}
+
+	handle, err := windows.CreateFile(linkPtr, windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING,
+		windows.FILE_FLAG_OPEN_REPARSE_POINT|windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		os.Remove(link)
+		return err
+	}
+
+	buffer := mountPointReparseBuffer(target)
+	var returned uint32
+	err = windows.DeviceIoControl(handle, windows.FSCTL_SET_REPARSE_POINT, &buffer[0], uint32(len(buffer)), nil, 0, &returned, nil)
+	windows.CloseHandle(handle)
+	if err != nil {
+		os.Remove(link)
+		return &fs.PathError{Op: "createjunction", Path: link, Err: err}
+	}
+
+	return nil
+}
+
+// Builds a REPARSE_DATA_BUFFER describing a mount point that redirects to target.
+func mountPointReparseBuffer(target string) []byte {
+	substituteName := utf16.Encode([]rune(`\??\` + target))
+	printName := utf16.Encode([]rune(target))
+
+	// Both names are stored null terminated, one after the other
+	pathBuffer := append(append(substituteName, 0), append(printName, 0)...)
+
+	dataLength := 8 + 2*len(pathBuffer)
+	buffer := make([]byte, 8+dataLength)
+
+	binary.LittleEndian.PutUint32(buffer[0:], windows.IO_REPARSE_TAG_MOUNT_POINT)
+	binary.LittleEndian.PutUint16(buffer[4:], uint16(dataLength))
+	binary.LittleEndian.PutUint16(buffer[8:], 0)                                  // SubstituteNameOffset
+	binary.LittleEndian.PutUint16(buffer[10:], uint16(2*len(substituteName)))     // SubstituteNameLength
+	binary.LittleEndian.PutUint16(buffer[12:], uint16(2*(len(substituteName)+1))) // PrintNameOffset
+	binary.LittleEndian.PutUint16(buffer[14:], uint16(2*len(printName)))          // PrintNameLength
+
+	for i, char := range pathBuffer {
+		binary.LittleEndian.PutUint16(buffer[16+2*i:], char)
+	}
+
+	return buffer
+}
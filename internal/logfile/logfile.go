@@ -0,0 +1,125 @@
+// Package logfile writes logs to a file that is rotated once it grows too large or too
+// old, so long-running syncs keep a history without filling the disk.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File is an append-only log file. Before a write would take it past MaxSize, or once it
+// was started MaxAge ago, it is renamed to Path.1, older ones to Path.2 and so on, and a
+// new one is started. Keep rotated files are kept, older ones are removed. A file found
+// on Open counts as started when it was last written. Safe for concurrent use.
+type File struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// Open opens the log file at path for appending, creating it and its directory if
+// needed. A maxSize or maxAge of 0 never rotates by size or age.
+func Open(path string, maxSize int64, maxAge time.Duration, keep int) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f := &File{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size, f.started = file, info.Size(), time.Now()
+	if info.Size() > 0 {
+		f.started = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating it first if it is due. A log line is never split
+// across files. When rotating fails the file is written on.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && (f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize || f.maxAge > 0 && time.Since(f.started) >= f.maxAge) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not rotate log file %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Moves the file to the first rotated name and starts a new one. Callers must hold mu.
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.keep == 0 {
+		return f.reopen(os.Remove(f.path))
+	}
+
+	if err := os.Remove(f.rotated(f.keep)); err != nil && !os.IsNotExist(err) {
+		return f.reopen(err)
+	}
+	for n := f.keep - 1; n >= 1; n-- {
+		if err := os.Rename(f.rotated(n), f.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return f.reopen(err)
+		}
+	}
+	return f.reopen(os.Rename(f.path, f.rotated(1)))
+}
+
+// Opens the log file again after rotating failed with err, or succeeded when err is nil.
+func (f *File) reopen(err error) error {
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (f *File) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+// Close closes the file, later writes fail.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,3 @@
+// Package placeholder detects cloud online-only files (OneDrive, Dropbox, iCloud)
+// whose contents would be downloaded when they are read.
+package placeholder
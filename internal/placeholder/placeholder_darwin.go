@@ -0,0 +1,21 @@
+//go:build darwin
+
+package placeholder
+
+import (
+	"os"
+	"syscall"
+)
+
+// SF_DATALESS marks iCloud Drive files that have been evicted and only exist as metadata.
+const sfDataless = 0x40000000
+
+// Is reports whether info describes a cloud placeholder that would be downloaded when read.
+func Is(info os.FileInfo) bool {
+	if info == nil {
+		return false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Flags&sfDataless != 0
+}
@@ -0,0 +1,11 @@
+//go:build !windows && !darwin
+
+package placeholder
+
+import "os"
+
+// Is reports whether info describes a cloud placeholder. No placeholder mechanism
+// is detectable on this platform.
+func Is(info os.FileInfo) bool {
+	return false
+}
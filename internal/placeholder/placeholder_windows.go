@@ -0,0 +1,25 @@
+//go:build windows
+
+package placeholder
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Attributes set by the Cloud Files API (OneDrive, Dropbox, iCloud for Windows) on files whose data is not local.
+const placeholderAttributes = windows.FILE_ATTRIBUTE_RECALL_ON_DATA_ACCESS |
+	windows.FILE_ATTRIBUTE_RECALL_ON_OPEN |
+	windows.FILE_ATTRIBUTE_OFFLINE
+
+// Is reports whether info describes a cloud placeholder that would be downloaded when read.
+func Is(info os.FileInfo) bool {
+	if info == nil {
+		return false
+	}
+
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && data.FileAttributes&placeholderAttributes != 0
+}
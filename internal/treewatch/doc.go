@@ -0,0 +1,4 @@
+// Package treewatch watches a whole directory tree with a single native watch,
+// ReadDirectoryChangesW on Windows and FSEvents on macOS, where watching every
+// directory on its own would exhaust handles and take minutes to set up.
+package treewatch
@@ -0,0 +1,55 @@
+//go:build darwin && cgo
+
+package treewatch
+
+/*
+#include <CoreServices/CoreServices.h>
+*/
+import "C"
+
+import (
+	"path/filepath"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Called by FSEvents on its dispatch queue with a batch of changes.
+//
+//export treewatchEvents
+func treewatchEvents(stream C.ConstFSEventStreamRef, info unsafe.Pointer, count C.size_t, paths unsafe.Pointer, flags *C.FSEventStreamEventFlags, ids *C.FSEventStreamEventId) {
+	w := cgo.Handle(uintptr(info)).Value().(*Watcher)
+	names := unsafe.Slice((**C.char)(paths), int(count))
+	eventFlags := unsafe.Slice(flags, int(count))
+
+	for i, name := range names {
+		flag := eventFlags[i]
+		if flag&(C.kFSEventStreamEventFlagUserDropped|C.kFSEventStreamEventFlagKernelDropped) != 0 {
+			if !w.sendError(ErrOverflow) {
+				return
+			}
+			continue
+		}
+
+		relPath, err := filepath.Rel(w.root, C.GoString(name))
+		if err != nil || !filepath.IsLocal(relPath) {
+			continue
+		}
+
+		// Anything below a directory may have changed, the root included
+		if flag&C.kFSEventStreamEventFlagMustScanSubDirs != 0 {
+			if !w.send(relPath) {
+				return
+			}
+			continue
+		}
+
+		// Entries added to or removed from a directory are reported by themselves
+		if relPath == "." || flag&C.kFSEventStreamEventFlagItemIsDir != 0 &&
+			flag&(C.kFSEventStreamEventFlagItemCreated|C.kFSEventStreamEventFlagItemRemoved|C.kFSEventStreamEventFlagItemRenamed) == 0 {
+			continue
+		}
+		if !w.send(relPath) {
+			return
+		}
+	}
+}
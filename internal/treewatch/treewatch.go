@@ -0,0 +1,72 @@
+package treewatch
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnsupported is returned by New on platforms without a native recursive watch.
+var ErrUnsupported = errors.New("recursive watching is not supported on this platform")
+
+// ErrOverflow is reported when changes came faster than they were taken and some were
+// dropped. Only looking at the whole tree again catches up with them.
+var ErrOverflow = errors.New("too many changes, some were dropped")
+
+// Watcher reports changes anywhere below the directory it watches.
+type Watcher struct {
+	root   string // Watched directory as the system reports it
+	events chan string
+	errors chan error
+	done   chan struct{}
+	once   sync.Once
+	stop   func() // Ends the native watch, closing events once nothing is sent anymore
+}
+
+func newWatcher(root string) *Watcher {
+	return &Watcher{
+		root:   root,
+		events: make(chan string),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+	}
+}
+
+// Events returns the paths that were created, modified, removed or renamed, relative to
+// the watched directory. Directories only count when created, removed or renamed. It is
+// closed when the watch ends.
+func (w *Watcher) Events() <-chan string {
+	return w.events
+}
+
+// Errors returns what went wrong watching, ErrOverflow among it.
+func (w *Watcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Close ends the watch.
+func (w *Watcher) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		w.stop()
+	})
+	return nil
+}
+
+// Hands relPath to whoever watches. Reports false once the watcher is closed.
+func (w *Watcher) send(relPath string) bool {
+	select {
+	case w.events <- relPath:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *Watcher) sendError(err error) bool {
+	select {
+	case w.errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
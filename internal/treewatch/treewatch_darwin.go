@@ -0,0 +1,89 @@
+//go:build darwin && cgo
+
+package treewatch
+
+/*
+#cgo LDFLAGS: -framework CoreServices
+#include <stdlib.h>
+#include <dispatch/dispatch.h>
+#include <CoreServices/CoreServices.h>
+
+void treewatchEvents(ConstFSEventStreamRef stream, void *info, size_t count, void *paths, FSEventStreamEventFlags *flags, FSEventStreamEventId *ids);
+
+static void treewatchNothing(void *context) {}
+
+static FSEventStreamRef treewatchStart(const char *root, uintptr_t handle, double latency, dispatch_queue_t *queue) {
+	CFStringRef path = CFStringCreateWithCString(NULL, root, kCFStringEncodingUTF8);
+	CFArrayRef paths = CFArrayCreate(NULL, (const void **)&path, 1, &kCFTypeArrayCallBacks);
+	FSEventStreamContext context = {0, (void *)handle, NULL, NULL, NULL};
+	FSEventStreamRef stream = FSEventStreamCreate(NULL, (FSEventStreamCallback)treewatchEvents, &context, paths,
+		kFSEventStreamEventIdSinceNow, latency, kFSEventStreamCreateFlagFileEvents | kFSEventStreamCreateFlagNoDefer);
+	CFRelease(paths);
+	CFRelease(path);
+	if (stream == NULL) {
+		return NULL;
+	}
+
+	*queue = dispatch_queue_create("gosync.treewatch", DISPATCH_QUEUE_SERIAL);
+	FSEventStreamSetDispatchQueue(stream, *queue);
+	if (!FSEventStreamStart(stream)) {
+		FSEventStreamInvalidate(stream);
+		FSEventStreamRelease(stream);
+		dispatch_release(*queue);
+		return NULL;
+	}
+	return stream;
+}
+
+static void treewatchStop(FSEventStreamRef stream, dispatch_queue_t queue) {
+	FSEventStreamStop(stream);
+	FSEventStreamInvalidate(stream);
+	FSEventStreamRelease(stream);
+
+	// Callbacks already queued are done once this returns
+	dispatch_sync_f(queue, NULL, treewatchNothing);
+	dispatch_release(queue);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// How long FSEvents gathers changes before reporting them, in seconds.
+const latency = 0.1
+
+// New starts watching the directory tree at root.
+func New(root string) (*Watcher, error) {
+	// Changes are reported at real paths, with symlinks like /var resolved
+	real, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if real, err = filepath.EvalSymlinks(real); err != nil {
+		return nil, err
+	}
+
+	w := newWatcher(real)
+	handle := cgo.NewHandle(w)
+	cRoot := C.CString(real)
+	defer C.free(unsafe.Pointer(cRoot))
+
+	var queue C.dispatch_queue_t
+	stream := C.treewatchStart(cRoot, C.uintptr_t(handle), C.double(latency), &queue)
+	if stream == nil {
+		handle.Delete()
+		return nil, errors.New("could not start an FSEvents stream")
+	}
+
+	w.stop = func() {
+		C.treewatchStop(stream, queue)
+		handle.Delete()
+		close(w.events)
+	}
+	return w, nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !(darwin && cgo)
+
+package treewatch
+
+// New starts watching the directory tree at root.
+func New(root string) (*Watcher, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,110 @@
+//go:build windows
+
+package treewatch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Network shares refuse buffers larger than this.
+const bufferSize = 64 * 1024
+
+// Permissions and attributes alone don't count as changes.
+const notifyFilter = windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+	windows.FILE_NOTIFY_CHANGE_SIZE | windows.FILE_NOTIFY_CHANGE_LAST_WRITE | windows.FILE_NOTIFY_CHANGE_CREATION
+
+// New starts watching the directory tree at root.
+func New(root string) (*Watcher, error) {
+	rootPtr, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateFile(rootPtr, windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	ioEvent, err := windows.CreateEvent(nil, 0, 0, nil)
+	if err != nil {
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+	stopEvent, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(ioEvent)
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+
+	w := newWatcher(root)
+	w.stop = func() { windows.SetEvent(stopEvent) }
+	go w.read(handle, ioEvent, stopEvent)
+	return w, nil
+}
+
+// Reads changes until the watcher is closed or the directory can't be watched anymore.
+func (w *Watcher) read(handle, ioEvent, stopEvent windows.Handle) {
+	defer close(w.events)
+	defer windows.CloseHandle(stopEvent)
+	defer windows.CloseHandle(ioEvent)
+	defer windows.CloseHandle(handle)
+
+	buffer := make([]byte, bufferSize)
+	overlapped := &windows.Overlapped{HEvent: ioEvent}
+	for {
+		err := windows.ReadDirectoryChanges(handle, &buffer[0], uint32(len(buffer)), true, notifyFilter, nil, overlapped, 0)
+		if err != nil {
+			w.sendError(os.NewSyscallError("ReadDirectoryChanges", err))
+			return
+		}
+
+		// The pending read has to end before its buffer goes away
+		var n uint32
+		if event, err := windows.WaitForMultipleObjects([]windows.Handle{ioEvent, stopEvent}, false, windows.INFINITE); err != nil || event != windows.WAIT_OBJECT_0 {
+			windows.CancelIoEx(handle, overlapped)
+			windows.GetOverlappedResult(handle, overlapped, &n, true)
+			return
+		}
+
+		// Changes that didn't fit the buffer are gone
+		err = windows.GetOverlappedResult(handle, overlapped, &n, false)
+		if errors.Is(err, windows.ERROR_NOTIFY_ENUM_DIR) || err == nil && n == 0 {
+			if !w.sendError(ErrOverflow) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			w.sendError(os.NewSyscallError("ReadDirectoryChanges", err))
+			return
+		}
+
+		for offset := uint32(0); ; {
+			info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buffer[offset]))
+			name := unsafe.Slice(&info.FileName, info.FileNameLength/2)
+			relPath := filepath.Clean(windows.UTF16ToString(name))
+
+			// Entries added to or removed from a directory modify it too, they are reported by themselves
+			modifiedDir := false
+			if info.Action == windows.FILE_ACTION_MODIFIED {
+				fileInfo, err := os.Lstat(filepath.Join(w.root, relPath))
+				modifiedDir = err == nil && fileInfo.IsDir()
+			}
+			if !modifiedDir && !w.send(relPath) {
+				return
+			}
+
+			if info.NextEntryOffset == 0 {
+				break
+			}
+			offset += info.NextEntryOffset
+		}
+	}
+}
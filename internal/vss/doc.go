@@ -0,0 +1,3 @@
+// Package vss creates Volume Shadow Copy snapshots on Windows, read-only views of a volume
+// frozen in time in which files other programs hold locked can be read.
+package vss
@@ -0,0 +1,29 @@
+package vss
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupported is returned on platforms without shadow copies.
+var ErrUnsupported = errors.New("shadow copies are only available on Windows")
+
+// Snapshot is a shadow copy of a volume.
+type Snapshot struct {
+	ID     string // Shadow copy ID, like {6E3D1C5C-...}
+	Device string // Device the shadow copy is read through, like \\?\GLOBALROOT\Device\HarddiskVolumeShadowCopy3
+	Volume string // Root of the volume snapshotted, like C:\
+}
+
+// Path returns where the path on the snapshotted volume is found in the shadow copy.
+func (s *Snapshot) Path(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if len(abs) < len(s.Volume) || !strings.EqualFold(abs[:len(s.Volume)], s.Volume) {
+		return "", errors.New(path + " is not on volume " + s.Volume)
+	}
+	return s.Device + `\` + abs[len(s.Volume):], nil
+}
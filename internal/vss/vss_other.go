@@ -0,0 +1,13 @@
+//go:build !windows
+
+package vss
+
+// Create snapshots the volume holding path.
+func Create(path string) (*Snapshot, error) {
+	return nil, ErrUnsupported
+}
+
+// Delete removes the shadow copy.
+func (s *Snapshot) Delete() error {
+	return ErrUnsupported
+}
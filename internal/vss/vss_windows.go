@@ -0,0 +1,84 @@
+//go:build windows
+
+package vss
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Return values of Win32_ShadowCopy.Create worth explaining.
+var createErrors = map[string]string{
+	"1":  "access denied, shadow copies need administrator rights",
+	"3":  "the volume was not found",
+	"4":  "the volume does not support shadow copies",
+	"8":  "the Volume Shadow Copy service is not running",
+	"9":  "another shadow copy is being created",
+	"10": "the shadow copy storage limit was reached",
+}
+
+// Create snapshots the volume holding path through WMI, which needs administrator rights.
+func Create(path string) (*Snapshot, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	pathPtr, err := windows.UTF16PtrFromString(abs)
+	if err != nil {
+		return nil, err
+	}
+	buffer := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(pathPtr, &buffer[0], uint32(len(buffer))); err != nil {
+		return nil, fmt.Errorf("could not find the volume of %s: %w", path, err)
+	}
+	volume := windows.UTF16ToString(buffer)
+
+	output, err := powershell(fmt.Sprintf(`$r = Invoke-CimMethod -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{Volume=%s; Context='ClientAccessible'}
+if ($r.ReturnValue -ne 0) { Write-Output $r.ReturnValue; exit 1 }
+$s = Get-CimInstance Win32_ShadowCopy | Where-Object { $_.ID -eq $r.ShadowID }
+Write-Output $s.ID
+Write-Output $s.DeviceObject`, quote(volume)))
+	if err != nil {
+		if reason, ok := createErrors[output]; ok {
+			return nil, fmt.Errorf("could not snapshot %s: %s", volume, reason)
+		}
+		return nil, fmt.Errorf("could not snapshot %s: %w", volume, err)
+	}
+
+	id, device, ok := strings.Cut(output, "\n")
+	if !ok || device == "" {
+		return nil, fmt.Errorf("could not snapshot %s: no shadow copy device in %q", volume, output)
+	}
+	return &Snapshot{ID: strings.TrimSpace(id), Device: strings.TrimSpace(device), Volume: volume}, nil
+}
+
+// Delete removes the shadow copy.
+func (s *Snapshot) Delete() error {
+	_, err := powershell(fmt.Sprintf(`Get-CimInstance Win32_ShadowCopy | Where-Object { $_.ID -eq %s } | Remove-CimInstance`, quote(s.ID)))
+	return err
+}
+
+// Runs script in PowerShell and returns its trimmed output, with the error output in the
+// error when it fails.
+func powershell(script string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script)
+	command.Stdout, command.Stderr = &stdout, &stderr
+	err := command.Run()
+
+	output := strings.TrimSpace(strings.ReplaceAll(stdout.String(), "\r\n", "\n"))
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, err
+}
+
+// Quotes s as a PowerShell string literal.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,2 @@
+// Package xattr reads and writes extended attributes on Linux and macOS.
+package xattr
@@ -0,0 +1,6 @@
+package xattr
+
+import "errors"
+
+// ErrNotFound is returned by Get and FGet when the file has no attribute of that name.
+var ErrNotFound = errors.New("extended attribute not found")
@@ -0,0 +1,6 @@
+package xattr
+
+import "golang.org/x/sys/unix"
+
+// macOS reports a missing attribute as ENOATTR.
+const errnoNotFound = unix.ENOATTR
@@ -0,0 +1,6 @@
+package xattr
+
+import "golang.org/x/sys/unix"
+
+// Linux reports a missing attribute as ENODATA.
+const errnoNotFound = unix.ENODATA
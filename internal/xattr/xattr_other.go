@@ -0,0 +1,31 @@
+//go:build !linux && !darwin
+
+package xattr
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrUnsupported is returned on platforms without extended attributes.
+var ErrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// FSet sets the extended attribute name on the open file.
+func FSet(file *os.File, name string, value []byte) error {
+	return ErrUnsupported
+}
+
+// FGet returns the value of the extended attribute name on the open file.
+func FGet(file *os.File, name string) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// FList returns the names of the extended attributes set on the open file.
+func FList(file *os.File) ([]string, error) {
+	return nil, ErrUnsupported
+}
+
+// Get returns the value of the extended attribute name on the file at path.
+func Get(path, name string) ([]byte, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,77 @@
+//go:build linux || darwin
+
+package xattr
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// FSet sets the extended attribute name on the open file.
+func FSet(file *os.File, name string, value []byte) error {
+	if err := unix.Fsetxattr(int(file.Fd()), name, value, 0); err != nil {
+		return &os.PathError{Op: "fsetxattr", Path: file.Name(), Err: err}
+	}
+	return nil
+}
+
+// Get returns the value of the extended attribute name on the file at path.
+func Get(path, name string) ([]byte, error) {
+	value, err := get(func(dest []byte) (int, error) { return unix.Getxattr(path, name, dest) })
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+	return value, nil
+}
+
+// FGet returns the value of the extended attribute name on the open file.
+func FGet(file *os.File, name string) ([]byte, error) {
+	value, err := get(func(dest []byte) (int, error) { return unix.Fgetxattr(int(file.Fd()), name, dest) })
+	if err != nil {
+		return nil, &os.PathError{Op: "fgetxattr", Path: file.Name(), Err: err}
+	}
+	return value, nil
+}
+
+// FList returns the names of the extended attributes set on the open file.
+func FList(file *os.File) ([]string, error) {
+	list, err := get(func(dest []byte) (int, error) { return unix.Flistxattr(int(file.Fd()), dest) })
+	if err != nil {
+		return nil, &os.PathError{Op: "flistxattr", Path: file.Name(), Err: err}
+	}
+
+	// The names are NUL terminated
+	var names []string
+	for _, name := range strings.Split(string(list), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Reads an attribute value through getxattr, asking for its size first.
+func get(getxattr func(dest []byte) (int, error)) ([]byte, error) {
+	size, err := getxattr(nil)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	value := make([]byte, size)
+	size, err = getxattr(value)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return value[:size], nil
+}
+
+// Maps the platform's missing attribute errno to ErrNotFound.
+func notFound(err error) error {
+	if err == errnoNotFound {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+)
+
+// Number of leading bytes http.DetectContentType looks at.
+const sniffLength = 512
+
+// SniffContentType returns the media type of the file at filePath, detected from its
+// first bytes, e.g. "text/plain" or "image/png".
+func SniffContentType(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return SniffContentTypeReader(file)
+}
+
+// SniffContentTypeReader is like SniffContentType for the contents read from r. Only
+// the first bytes are read.
+func SniffContentTypeReader(r io.Reader) (string, error) {
+	buffer := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	// Drop parameters such as "; charset=utf-8" so patterns only deal with the media type
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(buffer[:n]))
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+
+	return mediaType, nil
+}
+
+// MatchContentType reports whether contentType matches any of the patterns, e.g. "video/*" or "text/plain".
+func MatchContentType(contentType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, contentType); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,5 @@
+// Package filter decides which source files take part in a sync: gitignore style
+// patterns from a .gosyncignore file, rsync style include and exclude globs, sniffed
+// content types and file ownership.
+// The matchers are independent of a running Syncer and can be used on their own.
+package filter
@@ -0,0 +1,51 @@
+package filter
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+// IgnoreFile is the name of the file in the source root holding gitignore style patterns
+// of paths that are never synced.
+const IgnoreFile = ".gosyncignore"
+
+// Ignore matches relative paths against the patterns of an ignore file.
+type Ignore struct {
+	matcher *ignore.GitIgnore
+}
+
+// LoadIgnore reads the IgnoreFile in dir. It returns nil and no error when there is none.
+func LoadIgnore(dir string) (*Ignore, error) {
+	ignoreFilePath := filepath.Join(dir, IgnoreFile)
+
+	if _, err := os.Stat(ignoreFilePath); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	matcher, err := ignore.CompileIgnoreFile(ignoreFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ignore{matcher: matcher}, nil
+}
+
+// ParseIgnore reads the patterns of an IgnoreFile from r, for sources that aren't a
+// local directory.
+func ParseIgnore(r io.Reader) (*Ignore, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ignore{matcher: ignore.CompileIgnoreLines(strings.Split(string(data), "\n")...)}, nil
+}
+
+// Matches reports whether relPath is ignored. A nil Ignore matches nothing.
+func (i *Ignore) Matches(relPath string) bool {
+	return i != nil && i.matcher.MatchesPath(relPath)
+}
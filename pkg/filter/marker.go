@@ -0,0 +1,45 @@
+package filter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CacheDirTag is the name of the file marking a cache directory, see https://bford.info/cachedir/.
+const CacheDirTag = "CACHEDIR.TAG"
+
+// Every valid CACHEDIR.TAG starts with this, so stray files of the same name don't count.
+var cacheDirSignature = []byte("Signature: 8a477f597d28d172789f06886806bc55")
+
+// IsCacheDir reports whether dir holds a CACHEDIR.TAG with a valid signature.
+func IsCacheDir(dir string) bool {
+	file, err := os.Open(filepath.Join(dir, CacheDirTag))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	return IsCacheDirTag(file)
+}
+
+// IsCacheDirTag reports whether the CACHEDIR.TAG contents read from r start with the
+// signature, for tags that aren't in a local directory.
+func IsCacheDirTag(r io.Reader) bool {
+	header := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, cacheDirSignature)
+}
+
+// HasMarker returns the first of the marker file names that exists in dir, if any.
+func HasMarker(dir string, markers []string) (string, bool) {
+	for _, marker := range markers {
+		if _, err := os.Lstat(filepath.Join(dir, marker)); err == nil {
+			return marker, true
+		}
+	}
+	return "", false
+}
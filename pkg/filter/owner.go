@@ -0,0 +1,79 @@
+package filter
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// OwnerRule matches files by numeric owner and group. A negative id matches anything.
+type OwnerRule struct {
+	UID int64
+	GID int64
+}
+
+// ParseOwnerRule parses an owner spec in chown syntax: "user", ":group" or "user:group",
+// by name or numeric id.
+func ParseOwnerRule(spec string) (OwnerRule, error) {
+	rule := OwnerRule{UID: -1, GID: -1}
+	userPart, groupPart, _ := strings.Cut(spec, ":")
+
+	if userPart != "" {
+		uid, err := strconv.ParseInt(userPart, 10, 64)
+		if err != nil {
+			u, lookupErr := user.Lookup(userPart)
+			if lookupErr != nil {
+				return rule, fmt.Errorf("unknown user %q in owner filter %q", userPart, spec)
+			}
+			uid, _ = strconv.ParseInt(u.Uid, 10, 64)
+		}
+		rule.UID = uid
+	}
+
+	if groupPart != "" {
+		gid, err := strconv.ParseInt(groupPart, 10, 64)
+		if err != nil {
+			g, lookupErr := user.LookupGroup(groupPart)
+			if lookupErr != nil {
+				return rule, fmt.Errorf("unknown group %q in owner filter %q", groupPart, spec)
+			}
+			gid, _ = strconv.ParseInt(g.Gid, 10, 64)
+		}
+		rule.GID = gid
+	}
+
+	if rule.UID < 0 && rule.GID < 0 {
+		return rule, fmt.Errorf("empty owner filter %q", spec)
+	}
+
+	return rule, nil
+}
+
+// ParseOwnerRules parses every spec with ParseOwnerRule, stopping at the first invalid one.
+func ParseOwnerRules(specs []string) ([]OwnerRule, error) {
+	rules := make([]OwnerRule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := ParseOwnerRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Matches reports whether a file owned by uid and gid satisfies the rule.
+func (r OwnerRule) Matches(uid, gid uint32) bool {
+	return (r.UID < 0 || r.UID == int64(uid)) && (r.GID < 0 || r.GID == int64(gid))
+}
+
+// MatchAnyOwner reports whether any of the rules matches uid and gid.
+func MatchAnyOwner(rules []OwnerRule, uid, gid uint32) bool {
+	for _, rule := range rules {
+		if rule.Matches(uid, gid) {
+			return true
+		}
+	}
+	return false
+}
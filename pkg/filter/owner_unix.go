@@ -0,0 +1,17 @@
+//go:build unix
+
+package filter
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileOwner returns the numeric owner and group of the file described by info.
+func FileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
@@ -0,0 +1,134 @@
+package filter
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PathRule is an --include or --exclude glob. Patterns work like rsync's: one without a
+// slash matches the name of a file or directory at any depth, one with a slash the end
+// of its path, or the whole path when it starts with a slash. A trailing slash matches
+// directories only. * and ? don't match a slash, ** matches anything.
+//
+// With Regex set the pattern is a Go regular expression searched for in the slash
+// separated path instead, with a slash appended to the paths of directories.
+type PathRule struct {
+	Pattern string
+	Include bool
+	Regex   bool
+}
+
+// PathRules decides on a path by the first of a list of rules matching it. Paths no
+// rule matches are included.
+type PathRules struct {
+	rules []compiledPathRule
+}
+
+type compiledPathRule struct {
+	include bool
+	dirOnly bool
+	regex   bool
+	re      *regexp.Regexp
+}
+
+// CompilePathRules checks the patterns of rules and prepares them for matching, in order.
+func CompilePathRules(rules []PathRule) (*PathRules, error) {
+	compiled := &PathRules{}
+	for _, rule := range rules {
+		if rule.Regex {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path regex %q: %w", rule.Pattern, err)
+			}
+			compiled.rules = append(compiled.rules, compiledPathRule{include: rule.Include, regex: true, re: re})
+			continue
+		}
+
+		pattern := rule.Pattern
+		dirOnly := strings.HasSuffix(pattern, "/")
+		pattern = strings.TrimSuffix(pattern, "/")
+		if pattern == "" {
+			return nil, fmt.Errorf("empty path pattern %q", rule.Pattern)
+		}
+
+		expr, err := globExpr(strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", rule.Pattern, err)
+		}
+		if strings.HasPrefix(pattern, "/") {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "(^|/)" + expr + "$"
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", rule.Pattern, err)
+		}
+		compiled.rules = append(compiled.rules, compiledPathRule{include: rule.Include, dirOnly: dirOnly, re: re})
+	}
+	return compiled, nil
+}
+
+// Excludes reports whether relPath, a directory if isDir, is matched by an exclude rule
+// before any include rule. A nil PathRules excludes nothing.
+func (r *PathRules) Excludes(relPath string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+
+	slashPath := filepath.ToSlash(relPath)
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		path := slashPath
+		if rule.regex && isDir {
+			path += "/"
+		}
+		if rule.re.MatchString(path) {
+			return !rule.include
+		}
+	}
+	return false
+}
+
+// Translates a glob into a regular expression matching the same paths.
+func globExpr(pattern string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			// A ] right after the opening bracket is part of the class
+			end := strings.IndexByte(pattern[min(i+2, len(pattern)):], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : min(i+2, len(pattern))+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = min(i+2, len(pattern)) + end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
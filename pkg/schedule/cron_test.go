@@ -0,0 +1,86 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"-5 * * * *",
+		"@often",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// A Thursday
+	from := time.Date(2026, 1, 15, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(1, 15, 10, 8)},
+		{"*/15 * * * *", at(1, 15, 10, 15)},
+		{"5/15 * * * *", at(1, 15, 10, 20)},
+		{"0,30 * * * *", at(1, 15, 10, 30)},
+		{"0 * * * *", at(1, 15, 11, 0)},
+		{"30 9 * * *", at(1, 16, 9, 30)},
+		{"0 8-17/3 * * 1-5", at(1, 15, 11, 0)},
+		{"0 18-23/3 * * *", at(1, 15, 18, 0)},
+		{"0 0 1 * *", at(2, 1, 0, 0)},
+		{"0 12 * jun-aug *", at(6, 1, 12, 0)},
+		{"0 0 * * mon", at(1, 19, 0, 0)},
+		{"0 0 * * 0", at(1, 18, 0, 0)},
+		{"0 0 * * 7", at(1, 18, 0, 0)},
+		{"@weekly", at(1, 18, 0, 0)},
+		{"@hourly", at(1, 15, 11, 0)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+
+		// Restricting both days runs on either
+		{"0 0 13 * fri", at(1, 16, 0, 0)},
+		{"0 0 17 * sun", at(1, 17, 0, 0)},
+		{"0 0 13 * *", at(2, 13, 0, 0)},
+
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A time the schedule runs at isn't the next one
+	s, _ := Parse("*/15 * * * *")
+	if got, want := s.Next(at(1, 15, 10, 15)), at(1, 15, 10, 30); !got.Equal(want) {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+}
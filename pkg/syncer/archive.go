@@ -0,0 +1,26 @@
+package syncer
+
+import "os"
+
+// Turns on what Archive stands for, as far as the source and destination can keep it.
+// Permissions, modification times and the whole tree are always synced.
+func (s *Syncer) applyArchive() {
+	if !s.Options.Archive {
+		return
+	}
+	local := s.local != nil && s.localSource != nil
+	if s.Options.Symlinks == "" {
+		s.Options.Symlinks = SymlinkCopy
+		if local && !s.Options.TwoWay {
+			s.Options.Symlinks = SymlinkRecreate
+		}
+	}
+	if !local {
+		return // Owners and special files only exist in local directories
+	}
+
+	// Like rsync, files only keep their owner when running as root, who can give them away
+	s.Options.Owner = s.Options.Owner || os.Geteuid() == 0
+	s.Options.Group = true
+	s.Options.Devices = true
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package syncer
+
+import "os"
+
+// Only Windows has hidden, system and archive attributes, the mode covers the rest.
+
+func copyAttributes(srcInfo os.FileInfo, destination *os.File) error {
+	return nil
+}
+
+func copyDirAttributes(srcInfo os.FileInfo, path string) error {
+	return nil
+}
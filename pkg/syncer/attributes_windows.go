@@ -0,0 +1,77 @@
+//go:build windows
+
+package syncer
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Attributes carried over from source entries, Chmod alone only maps read-only.
+const preservedAttributes = windows.FILE_ATTRIBUTE_READONLY |
+	windows.FILE_ATTRIBUTE_HIDDEN |
+	windows.FILE_ATTRIBUTE_SYSTEM |
+	windows.FILE_ATTRIBUTE_ARCHIVE
+
+// FILE_BASIC_INFO, times left zero are not changed by SetFileInformationByHandle.
+type fileBasicInfo struct {
+	creationTime, lastAccessTime, lastWriteTime, changeTime int64
+	fileAttributes                                          uint32
+	_                                                       uint32
+}
+
+// Gives the open destination file the attributes of the source entry srcInfo describes,
+// through its handle. Sources that aren't local Windows files have none to give.
+func copyAttributes(srcInfo os.FileInfo, destination *os.File) error {
+	data, ok := srcInfo.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return nil
+	}
+
+	handle := windows.Handle(destination.Fd())
+	var info fileBasicInfo
+	if err := windows.GetFileInformationByHandleEx(handle, windows.FileBasicInfo, (*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		return &os.PathError{Op: "GetFileInformationByHandleEx", Path: destination.Name(), Err: err}
+	}
+	attributes := info.fileAttributes&^preservedAttributes | data.FileAttributes&preservedAttributes
+	if attributes == info.fileAttributes {
+		return nil
+	}
+
+	info = fileBasicInfo{fileAttributes: attributes}
+	if attributes == 0 {
+		info.fileAttributes = windows.FILE_ATTRIBUTE_NORMAL // Zero would leave them unchanged
+	}
+	if err := windows.SetFileInformationByHandle(handle, windows.FileBasicInfo, (*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		return &os.PathError{Op: "SetFileInformationByHandle", Path: destination.Name(), Err: err}
+	}
+	return nil
+}
+
+// Gives the directory at path the attributes of the source directory srcInfo describes.
+func copyDirAttributes(srcInfo os.FileInfo, path string) error {
+	data, ok := srcInfo.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return nil
+	}
+
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	current, err := windows.GetFileAttributes(pathPtr)
+	if err != nil {
+		return &os.PathError{Op: "GetFileAttributes", Path: path, Err: err}
+	}
+	attributes := current&^preservedAttributes | data.FileAttributes&preservedAttributes
+	if attributes == current {
+		return nil
+	}
+	if err := windows.SetFileAttributes(pathPtr, attributes); err != nil {
+		return &os.PathError{Op: "SetFileAttributes", Path: path, Err: err}
+	}
+	return nil
+}
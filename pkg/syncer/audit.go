@@ -0,0 +1,214 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// AuditProblem tells how a destination file found by Audit differs from what was expected.
+type AuditProblem string
+
+const (
+	AuditMismatch   AuditProblem = "mismatch"   // Size or contents differ
+	AuditMissing    AuditProblem = "missing"    // Expected, but not at the destination
+	AuditExtra      AuditProblem = "extra"      // At the destination, but not expected
+	AuditUnreadable AuditProblem = "unreadable" // Couldn't be read to be hashed, see Err
+)
+
+// A destination file that isn't what it should be.
+type AuditFinding struct {
+	Path    string
+	Problem AuditProblem
+	Err     error // Why the file couldn't be read, with AuditUnreadable
+}
+
+// AuditResult is what Audit found.
+type AuditResult struct {
+	Checked  int            // Files at the destination that were expected there and compared
+	Findings []AuditFinding // Sorted by path
+}
+
+// What a file of the destination is expected to be, from the manifest or the source.
+type auditExpectation struct {
+	size int64
+	hash []byte // From the manifest, nil when the source is to be hashed
+}
+
+// Audit reads the destination back and checks it against the manifest at Manifest, or
+// when none is set, against the source, hashing every file whose size matches. It reads
+// independently of any sync, trusts no stored checksums and changes nothing. Filters
+// apply as in a sync, and Decrypt has the destination decrypted without its marker.
+func (s *Syncer) Audit(ctx context.Context) (AuditResult, error) {
+	s.ctx = ctx
+
+	var err error
+	if s.pathRules, err = filter.CompilePathRules(s.Options.PathRules); err != nil {
+		return AuditResult{}, err
+	}
+
+	if s.dest, err = s.openReadable(s.Options.DestinationPath, "the destination", s.Options.Decrypt); err != nil {
+		return AuditResult{}, err
+	}
+	defer s.dest.Close()
+	if local, ok := s.dest.(*localBackend); ok {
+		s.local = local
+	}
+
+	algorithm := s.Options.Hash
+	var expected map[string]auditExpectation
+	if s.Options.Manifest != "" {
+		if expected, algorithm, err = readAuditManifest(s.Options.Manifest); err != nil {
+			return AuditResult{}, err
+		}
+	} else {
+		if s.src, err = s.openReadable(s.Options.SourcePath, "the source", false); err != nil {
+			return AuditResult{}, err
+		}
+		defer s.src.Close()
+		srcEntries, err := s.listTree(s.src)
+		if err != nil {
+			return AuditResult{}, fmt.Errorf("could not list source: %w.", err)
+		}
+		expected = make(map[string]auditExpectation, len(srcEntries))
+		for relPath, info := range srcEntries {
+			if info.Mode().IsRegular() {
+				expected[relPath] = auditExpectation{size: info.Size()}
+			}
+		}
+	}
+
+	destEntries, err := s.listTree(s.dest)
+	if err != nil {
+		return AuditResult{}, fmt.Errorf("could not list destination: %w.", err)
+	}
+
+	var result AuditResult
+	var mu sync.Mutex
+	report := func(relPath string, problem AuditProblem, err error) {
+		s.logger.Warn().Err(err).Str("action", "AUDIT_"+strings.ToUpper(string(problem))).Str("path", relPath).Msg("Destination file doesn't match")
+		mu.Lock()
+		defer mu.Unlock()
+		result.Findings = append(result.Findings, AuditFinding{Path: relPath, Problem: problem, Err: err})
+	}
+
+	for relPath := range expected {
+		if info, ok := destEntries[relPath]; !ok || !info.Mode().IsRegular() {
+			report(relPath, AuditMissing, nil)
+		}
+	}
+
+	// Files of matching size are hashed by the workers, the others differ anyway
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(s.Options.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for relPath := range paths {
+				if problem, err := s.auditFile(relPath, expected[relPath], algorithm); problem != "" {
+					report(relPath, problem, err)
+				} else {
+					s.logger.Debug().Str("action", "AUDIT_OK").Str("path", relPath).Msg("Destination file matches")
+				}
+			}
+		}()
+	}
+	for relPath, info := range destEntries {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		want, ok := expected[relPath]
+		switch {
+		case !ok:
+			report(relPath, AuditExtra, nil)
+			continue
+		case want.size != info.Size():
+			report(relPath, AuditMismatch, nil)
+		case ctx.Err() == nil:
+			paths <- relPath
+		}
+		result.Checked++
+	}
+	close(paths)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	sort.Slice(result.Findings, func(i, j int) bool { return result.Findings[i].Path < result.Findings[j].Path })
+	return result, nil
+}
+
+// Hashes the destination file at relPath, and the source file too unless want has its
+// hash, and tells the problem if they differ.
+func (s *Syncer) auditFile(relPath string, want auditExpectation, algorithm HashAlgorithm) (AuditProblem, error) {
+	destSum, err := s.auditHash(s.dest, relPath, algorithm)
+	if err != nil {
+		return AuditUnreadable, err
+	}
+
+	wantSum := want.hash
+	if wantSum == nil {
+		if wantSum, err = s.auditHash(s.src, relPath, algorithm); err != nil {
+			return AuditUnreadable, fmt.Errorf("reading source: %w", err)
+		}
+	}
+	if !bytes.Equal(destSum, wantSum) {
+		return AuditMismatch, nil
+	}
+	return "", nil
+}
+
+func (s *Syncer) auditHash(tree Backend, relPath string, algorithm HashAlgorithm) ([]byte, error) {
+	file, err := tree.Open(relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := algorithm.newHash()
+	if _, err := s.copy(hash, s.limitReader(file, s.ioBudget(file))); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// Reads the files a manifest written with Manifest lists, and the algorithm of their hashes.
+func readAuditManifest(path string) (map[string]auditExpectation, HashAlgorithm, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var manifest manifestFile
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("could not read manifest %s: %w.", path, err)
+	}
+	if manifest.Version != manifestVersion {
+		return nil, "", fmt.Errorf("manifest %s has version %d, expected %d.", path, manifest.Version, manifestVersion)
+	}
+	switch manifest.Hash {
+	case HashSHA256, HashBLAKE3, HashXXHash64, HashMD5:
+	default:
+		return nil, "", fmt.Errorf("manifest %s has hashes of unknown algorithm %q.", path, manifest.Hash)
+	}
+
+	expected := make(map[string]auditExpectation, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		sum, err := hex.DecodeString(entry.Hash)
+		if err != nil || !filepath.IsLocal(filepath.FromSlash(entry.Path)) {
+			return nil, "", fmt.Errorf("manifest %s has an invalid entry for %q.", path, entry.Path)
+		}
+		expected[filepath.FromSlash(entry.Path)] = auditExpectation{size: entry.Size, hash: sum}
+	}
+	return expected, manifest.Hash, nil
+}
@@ -0,0 +1,289 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backend is a tree of files a sync reads from or writes to: a local directory, a
+// remote server or an object store. All paths are relative to its root and use the
+// local separator, "." being the root itself. Sources are only read with Stat, Walk
+// and Open, and have files removed with RemoveSourceFiles. Implementations must be
+// safe for use by concurrent workers.
+type Backend interface {
+	Stat(relPath string) (fs.FileInfo, error) // Follows symlinks, fails with fs.ErrNotExist for missing files
+	MkdirAll(relPath string) error
+	Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) // Replaces any file already at relPath
+	Open(relPath string) (io.ReadCloser, error)
+	Chmod(relPath string, mode fs.FileMode) error
+	Chtimes(relPath string, modTime time.Time) error
+	Remove(relPath string) error // Removes a file or an empty directory
+
+	// Walks the tree in lexical order like filepath.WalkDir, passing relative paths.
+	Walk(fn fs.WalkDirFunc) error
+
+	// Returns an error unless writing to relPath stays inside of the tree after
+	// following symlinks. With followFinal unset relPath itself may point anywhere.
+	// Backends without symlinks return nil.
+	Contained(relPath string, followFinal bool) error
+
+	// Returns the granularity modification times are stored with, 0 when they are exact.
+	ModTimePrecision() time.Duration
+
+	Close() error
+}
+
+// BackendFile is a file being written to a Backend. Its contents may only be stored
+// once it is closed, so the error of Close must be checked.
+type BackendFile interface {
+	io.WriteCloser
+	Sync() error
+	Chmod(mode fs.FileMode) error
+	SetModTime(modTime time.Time) error
+}
+
+// BackendOpener opens the backend at location, the source or destination exactly as
+// given, e.g. "s3://bucket/prefix".
+type BackendOpener func(location string) (Backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]BackendOpener)
+)
+
+// RegisterBackend makes locations of the form scheme://... open through open, so
+// other packages can add storage without changes to the syncer. It is meant to be
+// called from init functions, and panics if scheme is already registered.
+func RegisterBackend(scheme string, open BackendOpener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[scheme]; ok {
+		panic("syncer: backend registered twice for scheme " + scheme)
+	}
+	backends[scheme] = open
+}
+
+// OpenBackend opens location: a scheme:// URL of a registered backend, user@host:/path
+// for SFTP, or else a local directory.
+func OpenBackend(location string) (Backend, error) {
+	// Schemes have at least two letters, so Windows drive letters never match
+	if scheme, _, ok := strings.Cut(location, "://"); ok && len(scheme) > 1 {
+		backendsMu.RLock()
+		open, registered := backends[scheme]
+		backendsMu.RUnlock()
+
+		if !registered {
+			return nil, fmt.Errorf("no backend for %s:// locations.", scheme)
+		}
+		return open(location)
+	}
+
+	if _, ok := parseSFTPLocation(location); ok {
+		return openSFTPBackend(location)
+	}
+
+	return &localBackend{root: location}, nil
+}
+
+// Reports whether OpenBackend opens location as a remote rather than a local directory.
+func isRemoteLocation(location string) bool {
+	if scheme, _, ok := strings.Cut(location, "://"); ok && len(scheme) > 1 {
+		return true
+	}
+	_, ok := parseSFTPLocation(location)
+	return ok
+}
+
+// A directory on a local filesystem. Files are created and updated through open file
+// descriptors and symlinks are resolved beneath the root, see fileops.go and contain.go.
+type localBackend struct {
+	root string
+
+	partialDir  string   // Directory next to them new files are written to, see partial.go
+	partialDirs sync.Map // Partial directories created, by path
+}
+
+func (d *localBackend) path(relPath string) string {
+	return filepath.Join(d.root, relPath)
+}
+
+func (d *localBackend) Stat(relPath string) (fs.FileInfo, error) {
+	return os.Stat(d.path(relPath))
+}
+
+func (d *localBackend) MkdirAll(relPath string) error {
+	return os.MkdirAll(d.path(relPath), os.ModePerm)
+}
+
+func (d *localBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	if info, err := os.Lstat(d.path(relPath)); err == nil && info.IsDir() {
+		return nil, &os.PathError{Op: "create", Path: d.path(relPath), Err: errors.New("exists and is not a regular file")}
+	}
+
+	// Written next to the file it replaces, which stays intact until the new one is kept.
+	// The file stays private to us until the permissions are applied.
+	if d.partialDir != "" {
+		if err := d.makePartialDir(relPath); err != nil {
+			return nil, err
+		}
+	}
+	file, err := createDestination(d.root, d.tempPath(relPath), 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{File: file, target: d.path(relPath), partial: d.partialDir != ""}, nil
+}
+
+func (d *localBackend) Open(relPath string) (io.ReadCloser, error) {
+	return os.Open(d.path(relPath))
+}
+
+func (d *localBackend) Chmod(relPath string, mode fs.FileMode) error {
+	return os.Chmod(d.path(relPath), mode)
+}
+
+func (d *localBackend) Chtimes(relPath string, modTime time.Time) error {
+	return os.Chtimes(d.path(relPath), time.Now(), modTime)
+}
+
+func (d *localBackend) Remove(relPath string) error {
+	return os.Remove(d.path(relPath))
+}
+
+func (d *localBackend) Rename(oldRelPath, newRelPath string) error {
+	if err := d.Contained(oldRelPath, false); err != nil {
+		return err
+	}
+	if err := d.Contained(newRelPath, false); err != nil {
+		return err
+	}
+	return os.Rename(d.path(oldRelPath), d.path(newRelPath))
+}
+
+func (d *localBackend) Walk(fn fs.WalkDirFunc) error {
+	// WalkDir doesn't descend into a root that is a symlink, unless told to with a separator
+	root := d.root
+	if info, err := os.Lstat(root); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		root += string(filepath.Separator)
+	}
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		relPath, _ := filepath.Rel(root, path)
+		return fn(relPath, entry, err)
+	})
+}
+
+func (d *localBackend) Contained(relPath string, followFinal bool) error {
+	checkPath := relPath
+	if !followFinal {
+		checkPath = filepath.Dir(relPath)
+	}
+	if checkPath == "." {
+		return nil
+	}
+
+	return resolveBeneath(d.root, checkPath)
+}
+
+func (d *localBackend) ModTimePrecision() time.Duration {
+	return 0
+}
+
+func (d *localBackend) Close() error {
+	return nil
+}
+
+// A file created in a local destination. Metadata is set through the descriptor. New
+// files are written under a temporary name and only renamed over target once kept, so an
+// interrupted copy never leaves a half written file where a later run would take it for
+// an up-to-date one.
+type localFile struct {
+	*os.File
+	target  string // Path the file is renamed to when kept, empty for files written in place
+	partial bool   // Left behind when not kept, for the next run to resume
+	kept    bool
+	closed  bool
+	err     error
+}
+
+// Marks a new file as complete, Close moves it into place instead of discarding it.
+func (f *localFile) keep() {
+	f.kept = true
+}
+
+// Files that are discarded on Close unless marked complete first.
+type keeper interface {
+	keep()
+}
+
+func (f *localFile) Close() error {
+	if f.target == "" {
+		return f.File.Close()
+	}
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+
+	f.err = f.File.Close()
+	if f.err == nil && f.kept {
+		f.err = os.Rename(f.File.Name(), f.target)
+		if f.err == nil {
+			return nil
+		}
+	}
+	if !f.partial {
+		os.Remove(f.File.Name())
+	}
+	return f.err
+}
+
+func (f *localFile) SetModTime(modTime time.Time) error {
+	return setFileTimes(f.File, time.Now(), modTime)
+}
+
+// Writes a small file gosync keeps itself to relPath in backend, like the marker of a
+// destination storing files compressed.
+func writeBackendFile(backend Backend, relPath string, content []byte) error {
+	if err := backend.MkdirAll(filepath.Dir(relPath)); err != nil {
+		return err
+	}
+	file, err := backend.Create(relPath, &ownFileInfo{name: filepath.Base(relPath), size: int64(len(content)), modTime: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		return err
+	}
+	if k, ok := file.(keeper); ok {
+		k.keep()
+	}
+	return file.Close()
+}
+
+// Describes a file gosync writes itself, for backends that take what they store along
+// with a file from the source's info.
+type ownFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *ownFileInfo) Name() string       { return i.name }
+func (i *ownFileInfo) Size() int64        { return i.size }
+func (i *ownFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (i *ownFileInfo) ModTime() time.Time { return i.modTime }
+func (i *ownFileInfo) IsDir() bool        { return false }
+func (i *ownFileInfo) Sys() any           { return nil }
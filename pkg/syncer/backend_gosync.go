@@ -0,0 +1,579 @@
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where a gosync backend lives, parsed from gosync://host:port/path.
+type gosyncLocation struct {
+	address string // host:port
+	path    string // Below the directory served, slash separated
+}
+
+func init() {
+	RegisterBackend("gosync", openGosyncBackend)
+}
+
+func openGosyncBackend(location string) (Backend, error) {
+	parsed, ok := parseGosyncLocation(location)
+	if !ok {
+		return nil, fmt.Errorf("invalid gosync location %q, expected gosync://host:port/path.", location)
+	}
+
+	backend, err := dialGosync(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", location, err)
+	}
+	return backend, nil
+}
+
+func parseGosyncLocation(dest string) (gosyncLocation, bool) {
+	if !strings.HasPrefix(dest, "gosync://") {
+		return gosyncLocation{}, false
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil || u.Hostname() == "" {
+		return gosyncLocation{}, false
+	}
+
+	location := gosyncLocation{address: u.Host, path: u.Path}
+	if u.Port() == "" {
+		location.address = net.JoinHostPort(u.Hostname(), strconv.Itoa(DefaultServePort))
+	}
+	return location, true
+}
+
+// A directory served by gosync serve on another machine. The whole tree is listed with a
+// single request the first time a file is looked up, so comparing files doesn't cost a
+// round trip each.
+type gosyncBackend struct {
+	conn   *remoteConn
+	codecs []string    // Compression the server supports
+	codec  Compression // Contents are compressed with, unless empty
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan remoteResponse
+	err     error // Why the connection is gone, once it is
+
+	listOnce sync.Once
+	cacheMu  sync.Mutex
+	listing  map[string]remoteEntry // Entries by slash separated path, nil when listing failed
+	stale    map[string]struct{}    // Paths changed since they were listed, looked up again
+}
+
+// Connects and authenticates with the token in GOSYNC_TOKEN.
+func dialGosync(location gosyncLocation) (*gosyncBackend, error) {
+	token := os.Getenv("GOSYNC_TOKEN")
+	if token == "" {
+		return nil, errors.New("GOSYNC_TOKEN must be set to the token of the server")
+	}
+
+	conn, err := net.DialTimeout("tcp", location.address, remoteHandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &gosyncBackend{
+		conn:    newRemoteConn(conn),
+		pending: make(map[uint64]chan remoteResponse),
+		stale:   make(map[string]struct{}),
+	}
+	if d.codecs, err = d.conn.login(token, location.path); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go d.receive()
+	return d, nil
+}
+
+// Compresses file contents sent and received with codec from now on.
+func (d *gosyncBackend) setCompression(codec Compression) error {
+	if !slices.Contains(d.codecs, string(codec)) {
+		return fmt.Errorf("the server doesn't support %s compression, it supports %s.", codec, strings.Join(append([]string{"none"}, d.codecs...), ", "))
+	}
+	d.codec = codec
+	return nil
+}
+
+// Hands responses to the requests waiting for them until the connection is closed.
+func (d *gosyncBackend) receive() {
+	for {
+		var response remoteResponse
+		err := d.conn.receive(&response)
+
+		d.mu.Lock()
+		if err != nil {
+			d.err = fmt.Errorf("connection to server lost: %w", err)
+			for id, responses := range d.pending {
+				close(responses)
+				delete(d.pending, id)
+			}
+			d.mu.Unlock()
+			return
+		}
+
+		responses, ok := d.pending[response.ID]
+		if ok && !response.More {
+			delete(d.pending, response.ID)
+		}
+		d.mu.Unlock()
+
+		if ok {
+			responses <- response
+		}
+	}
+}
+
+// Sends request and returns the channel its responses arrive on. The channel is closed
+// if the connection is lost.
+func (d *gosyncBackend) start(request remoteRequest) (<-chan remoteResponse, error) {
+	responses := make(chan remoteResponse, 1)
+
+	d.mu.Lock()
+	if d.err != nil {
+		d.mu.Unlock()
+		return nil, d.err
+	}
+	d.nextID++
+	request.ID = d.nextID
+	d.pending[request.ID] = responses
+	d.mu.Unlock()
+
+	if err := d.conn.send(request); err != nil {
+		d.mu.Lock()
+		delete(d.pending, request.ID)
+		d.mu.Unlock()
+		return nil, err
+	}
+	return responses, nil
+}
+
+// Sends request and waits for its response.
+func (d *gosyncBackend) call(request remoteRequest) (remoteResponse, error) {
+	responses, err := d.start(request)
+	if err != nil {
+		return remoteResponse{}, err
+	}
+
+	response, ok := <-responses
+	if !ok {
+		return response, d.connectionError()
+	}
+	return response, d.responseError(request, response)
+}
+
+func (d *gosyncBackend) connectionError() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+func (d *gosyncBackend) responseError(request remoteRequest, response remoteResponse) error {
+	switch {
+	case response.Err == "":
+		return nil
+	case response.NotExist:
+		return &fs.PathError{Op: request.Op, Path: request.Path, Err: fs.ErrNotExist}
+	default:
+		return &fs.PathError{Op: request.Op, Path: request.Path, Err: errors.New(response.Err)}
+	}
+}
+
+// Returns every entry of the tree in walk order. The batches are collected before any of
+// them is used, so the connection never waits on a slow caller.
+func (d *gosyncBackend) list() ([]remoteEntry, error) {
+	request := remoteRequest{Op: remoteList, Path: "."}
+	responses, err := d.start(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []remoteEntry
+	for {
+		response, ok := <-responses
+		if !ok {
+			return nil, d.connectionError()
+		}
+		if err := d.responseError(request, response); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, response.Entries...)
+		if !response.More {
+			return entries, nil
+		}
+	}
+}
+
+// Marks paths as changed, so they are looked up on the server again.
+func (d *gosyncBackend) invalidate(relPaths ...string) {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+
+	for _, relPath := range relPaths {
+		d.stale[filepath.ToSlash(relPath)] = struct{}{}
+	}
+}
+
+func (d *gosyncBackend) Stat(relPath string) (fs.FileInfo, error) {
+	d.listOnce.Do(func() {
+		entries, err := d.list()
+		if err != nil {
+			return // Every file is looked up on its own then
+		}
+
+		listing := make(map[string]remoteEntry, len(entries))
+		for _, entry := range entries {
+			listing[entry.Path] = entry
+		}
+
+		d.cacheMu.Lock()
+		d.listing = listing
+		d.cacheMu.Unlock()
+	})
+
+	slashPath := filepath.ToSlash(relPath)
+
+	d.cacheMu.Lock()
+	entry, listed := d.listing[slashPath]
+	_, stale := d.stale[slashPath]
+	complete := d.listing != nil
+	d.cacheMu.Unlock()
+
+	if !stale && listed {
+		return remoteFileInfo{entry}, nil
+	}
+	if !stale && complete {
+		return nil, &fs.PathError{Op: remoteStat, Path: slashPath, Err: fs.ErrNotExist}
+	}
+
+	response, err := d.call(remoteRequest{Op: remoteStat, Path: slashPath})
+	if err != nil {
+		return nil, err
+	}
+	return remoteFileInfo{response.Info}, nil
+}
+
+func (d *gosyncBackend) MkdirAll(relPath string) error {
+	// Any of the parents may be created along the way
+	var created []string
+	for dir := relPath; dir != "."; dir = filepath.Dir(dir) {
+		created = append(created, dir)
+	}
+	d.invalidate(created...)
+
+	_, err := d.call(remoteRequest{Op: remoteMkdir, Path: filepath.ToSlash(relPath)})
+	return err
+}
+
+// The contents are sent in chunks as they are written, and the file is committed with
+// its metadata once it is closed.
+func (d *gosyncBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	d.invalidate(relPath)
+
+	response, err := d.call(remoteRequest{Op: remoteCreate, Path: filepath.ToSlash(relPath)})
+	if err != nil {
+		return nil, err
+	}
+	return &gosyncFile{dest: d, relPath: filepath.ToSlash(relPath), handle: response.Handle, codec: chunkCodec(d.codec, relPath)}, nil
+}
+
+// The server computes the checksums and rebuilds the file, see delta.go.
+func (d *gosyncBackend) BlockSums(relPath string, blockSize int) ([]blockSum, error) {
+	response, err := d.call(remoteRequest{Op: remoteSums, Path: filepath.ToSlash(relPath), BlockSize: blockSize})
+	if err != nil {
+		return nil, err
+	}
+	return response.Sums, nil
+}
+
+func (d *gosyncBackend) CreateDelta(relPath string, blockSize int) (deltaFile, error) {
+	d.invalidate(relPath)
+
+	response, err := d.call(remoteRequest{Op: remoteCreateDelta, Path: filepath.ToSlash(relPath), BlockSize: blockSize})
+	if err != nil {
+		return nil, err
+	}
+	return &gosyncDeltaFile{gosyncFile: gosyncFile{dest: d, relPath: filepath.ToSlash(relPath), handle: response.Handle, codec: chunkCodec(d.codec, relPath)}}, nil
+}
+
+func (d *gosyncBackend) Open(relPath string) (io.ReadCloser, error) {
+	response, err := d.call(remoteRequest{Op: remoteOpen, Path: filepath.ToSlash(relPath)})
+	if err != nil {
+		return nil, err
+	}
+	return &gosyncReader{dest: d, relPath: filepath.ToSlash(relPath), handle: response.Handle, codec: chunkCodec(d.codec, relPath)}, nil
+}
+
+func (d *gosyncBackend) Chmod(relPath string, mode fs.FileMode) error {
+	d.invalidate(relPath)
+	_, err := d.call(remoteRequest{Op: remoteChmod, Path: filepath.ToSlash(relPath), Mode: mode})
+	return err
+}
+
+func (d *gosyncBackend) Chtimes(relPath string, modTime time.Time) error {
+	d.invalidate(relPath)
+	_, err := d.call(remoteRequest{Op: remoteChtimes, Path: filepath.ToSlash(relPath), ModTime: modTime})
+	return err
+}
+
+func (d *gosyncBackend) Remove(relPath string) error {
+	d.invalidate(relPath)
+	_, err := d.call(remoteRequest{Op: remoteRemove, Path: filepath.ToSlash(relPath)})
+	return err
+}
+
+func (d *gosyncBackend) Rename(oldRelPath, newRelPath string) error {
+	d.invalidate(oldRelPath, newRelPath)
+	_, err := d.call(remoteRequest{Op: remoteRename, Path: filepath.ToSlash(oldRelPath), Target: filepath.ToSlash(newRelPath)})
+	return err
+}
+
+func (d *gosyncBackend) Walk(fn fs.WalkDirFunc) error {
+	entries, err := d.list()
+	if err != nil {
+		return fn(".", nil, err)
+	}
+
+	skipped := "" // Directory whose remaining contents are skipped
+	for _, entry := range entries {
+		relPath := filepath.FromSlash(entry.Path)
+		if skipped != "" && isWithin(skipped, relPath) {
+			continue
+		}
+
+		d := fs.FileInfoToDirEntry(remoteFileInfo{entry})
+		err := fn(relPath, d, nil)
+		if errors.Is(err, filepath.SkipDir) {
+			if relPath == "." {
+				return nil
+			}
+			if d.IsDir() {
+				skipped = relPath
+			} else {
+				skipped = filepath.Dir(relPath)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The server resolves every path beneath the directory it serves.
+func (d *gosyncBackend) Contained(relPath string, followFinal bool) error {
+	return nil
+}
+
+// Modification times are transferred exactly.
+func (d *gosyncBackend) ModTimePrecision() time.Duration {
+	return 0
+}
+
+func (d *gosyncBackend) Close() error {
+	return d.conn.conn.Close()
+}
+
+// A file being written on the server.
+type gosyncFile struct {
+	dest    *gosyncBackend
+	relPath string
+	handle  uint64
+	mode    fs.FileMode // Applied when committed, unless zero
+	modTime time.Time   // Applied when committed, unless zero
+	codec   Compression // Contents are compressed with, unless empty
+	kept    bool
+	closed  bool
+	err     error
+}
+
+func (f *gosyncFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), remoteChunkSize)]
+		data, codec := compressChunk(f.codec, chunk)
+		if _, err := f.dest.call(remoteRequest{Op: remoteWrite, Path: f.relPath, Handle: f.handle, Data: data, Codec: codec}); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// The server syncs the file when it is committed.
+func (f *gosyncFile) Sync() error {
+	return nil
+}
+
+func (f *gosyncFile) Chmod(mode fs.FileMode) error {
+	f.mode = mode
+	return nil
+}
+
+func (f *gosyncFile) SetModTime(modTime time.Time) error {
+	f.modTime = modTime
+	return nil
+}
+
+// Marks the file as complete, Close commits it instead of having the server discard it.
+func (f *gosyncFile) keep() {
+	f.kept = true
+}
+
+func (f *gosyncFile) Close() error {
+	if f.closed {
+		return f.err
+	}
+	if !f.kept {
+		return f.release()
+	}
+	return f.commit(nil)
+}
+
+// Closes the file on the server without committing it, which discards it.
+func (f *gosyncFile) release() error {
+	f.closed = true
+
+	_, f.err = f.dest.call(remoteRequest{Op: remoteRelease, Path: f.relPath, Handle: f.handle})
+	return f.err
+}
+
+// Closes the file on the server with its metadata, and for a delta the SHA-256 it must have.
+func (f *gosyncFile) commit(sum []byte) error {
+	f.closed = true
+
+	_, f.err = f.dest.call(remoteRequest{Op: remoteCommit, Path: f.relPath, Handle: f.handle, Data: sum, Mode: f.mode, ModTime: f.modTime})
+	return f.err
+}
+
+// A file being rebuilt on the server from its old version. Operations are collected and
+// sent in batches, so runs of small changes don't cost a round trip each.
+type gosyncDeltaFile struct {
+	gosyncFile
+	ops      []deltaOp
+	buffered int // Bytes of data in ops
+	expected []byte
+}
+
+func (f *gosyncDeltaFile) Write(p []byte) (int, error) {
+	f.ops = append(f.ops, deltaOp{Data: bytes.Clone(p)})
+	f.buffered += len(p)
+	return len(p), f.flushIfFull()
+}
+
+func (f *gosyncDeltaFile) CopyBlocks(first, count int64) error {
+	f.ops = append(f.ops, deltaOp{Block: first, Count: count})
+	return f.flushIfFull()
+}
+
+func (f *gosyncDeltaFile) Expect(sum []byte) {
+	f.expected = sum
+}
+
+func (f *gosyncDeltaFile) flushIfFull() error {
+	if f.buffered < remoteChunkSize && len(f.ops) < remoteListBatch {
+		return nil
+	}
+	return f.flush()
+}
+
+func (f *gosyncDeltaFile) flush() error {
+	if len(f.ops) == 0 {
+		return nil
+	}
+
+	// The literal data is compressed as a whole or not at all
+	request := remoteRequest{Op: remotePatch, Path: f.relPath, Handle: f.handle, Ops: f.ops}
+	if f.codec != "" {
+		compressed := make([]deltaOp, len(f.ops))
+		size, compressedSize := 0, 0
+		for i, op := range f.ops {
+			compressed[i] = op
+			if op.Data != nil {
+				compressed[i].Data = encodeChunk(f.codec, op.Data)
+				size += len(op.Data)
+				compressedSize += len(compressed[i].Data)
+			}
+		}
+		if compressedSize < size {
+			request.Ops, request.Codec = compressed, f.codec
+		}
+	}
+
+	_, err := f.dest.call(request)
+	f.ops, f.buffered = nil, 0
+	return err
+}
+
+func (f *gosyncDeltaFile) Close() error {
+	if f.closed {
+		return f.err
+	}
+	if !f.kept {
+		return f.release()
+	}
+
+	if err := f.flush(); err != nil {
+		f.release()
+		f.err = err
+		return err
+	}
+	return f.commit(f.expected)
+}
+
+// A file being read from the server, a chunk at a time.
+type gosyncReader struct {
+	dest    *gosyncBackend
+	relPath string
+	handle  uint64
+	codec   Compression // Asked of the server, unless empty
+	buffer  []byte
+	eof     bool
+}
+
+func (r *gosyncReader) Read(p []byte) (int, error) {
+	if len(r.buffer) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		response, err := r.dest.call(remoteRequest{Op: remoteRead, Path: r.relPath, Handle: r.handle, Codec: r.codec})
+		if err != nil {
+			return 0, err
+		}
+		if r.buffer, err = decompressChunk(response.Codec, response.Data); err != nil {
+			return 0, err
+		}
+		r.eof = response.EOF
+		if len(r.buffer) == 0 && r.eof {
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	return n, nil
+}
+
+func (r *gosyncReader) Close() error {
+	_, err := r.dest.call(remoteRequest{Op: remoteRelease, Path: r.relPath, Handle: r.handle})
+	return err
+}
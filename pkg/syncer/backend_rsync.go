@@ -0,0 +1,455 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterBackend("rsync", openRsyncBackend)
+	RegisterBackend("rsync+ssh", openRsyncBackend)
+}
+
+func openRsyncBackend(location string) (Backend, error) {
+	parsed, ok := parseRsyncLocation(location)
+	if !ok {
+		return nil, fmt.Errorf("invalid rsync location %q, expected rsync://host:port/module/path or rsync+ssh://user@host:port/path.", location)
+	}
+
+	backend := &rsyncBackend{location: parsed}
+	if err := backend.relist(); err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", location, err)
+	}
+	return backend, nil
+}
+
+// A tree on a machine with rsync, reached through its daemon or over ssh. The server
+// sends the whole tree once, and then the files read one at a time over the same
+// session. rsync takes every file of a session up front, so each change is sent in a
+// session of its own.
+type rsyncBackend struct {
+	location rsyncLocation
+
+	pullMu sync.Mutex
+	pull   *rsyncPull // Files are read through
+
+	pushMu sync.Mutex // Changes are sent one at a time
+
+	mu       sync.Mutex
+	listing  map[string]rsyncFile // What the server holds by slash separated path
+	outdated bool                 // Changes were sent since the pull started, its numbering misses them
+}
+
+// Lists the tree again in a new session, which files are read through from then on.
+func (d *rsyncBackend) relist() error {
+	if d.pull != nil {
+		d.pull.close()
+		d.pull = nil
+	}
+
+	// Nothing may change while the server lists the tree
+	d.pushMu.Lock()
+	defer d.pushMu.Unlock()
+
+	pull, err := d.location.pull()
+	if err != nil {
+		return err
+	}
+	listing := make(map[string]rsyncFile, len(pull.files))
+	for _, file := range pull.files {
+		listing[file.path] = file
+	}
+
+	d.mu.Lock()
+	d.listing = listing
+	d.outdated = false
+	d.mu.Unlock()
+	d.pull = pull
+	return nil
+}
+
+// Returns the entry at slashPath from the listing, following symlinks within the tree.
+func (d *rsyncBackend) lookup(op, slashPath string) (rsyncFile, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for range 40 {
+		file, ok := d.listing[slashPath]
+		if !ok {
+			break
+		}
+		if file.mode&rsyncTypeMask != rsyncTypeSymlink {
+			return file, nil
+		}
+		if path.IsAbs(file.target) {
+			break
+		}
+		slashPath = path.Join(path.Dir(slashPath), file.target)
+		if slashPath == ".." || strings.HasPrefix(slashPath, "../") {
+			break
+		}
+	}
+	return rsyncFile{}, &fs.PathError{Op: op, Path: slashPath, Err: fs.ErrNotExist}
+}
+
+func (d *rsyncBackend) Stat(relPath string) (fs.FileInfo, error) {
+	file, err := d.lookup("stat", filepath.ToSlash(relPath))
+	if err != nil {
+		return nil, err
+	}
+	return rsyncFileInfo{file: file, name: filepath.Base(relPath)}, nil
+}
+
+func (d *rsyncBackend) MkdirAll(relPath string) error {
+	slashPath := filepath.ToSlash(relPath)
+	if file, err := d.lookup("mkdir", slashPath); err == nil {
+		if !file.isDir() {
+			return &fs.PathError{Op: "mkdir", Path: slashPath, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	return d.upload([]rsyncUpload{{rsyncFile: newRsyncDir(slashPath)}}, false, false, nil)
+}
+
+// The contents are kept in a temporary file until closed, and then sent with the file.
+func (d *rsyncBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	slashPath := filepath.ToSlash(relPath)
+	if file, err := d.lookup("create", slashPath); err == nil && file.isDir() {
+		return nil, &fs.PathError{Op: "create", Path: slashPath, Err: errors.New("exists and is not a regular file")}
+	}
+
+	spool, err := os.CreateTemp("", "gosync-rsync-*")
+	if err != nil {
+		return nil, err
+	}
+	return &rsyncWriter{File: spool, dest: d, relPath: slashPath, mode: srcInfo.Mode().Perm(), modTime: srcInfo.ModTime()}, nil
+}
+
+// The file is received whole before it is read, so the session is free for the next.
+func (d *rsyncBackend) Open(relPath string) (io.ReadCloser, error) {
+	d.pullMu.Lock()
+	defer d.pullMu.Unlock()
+
+	d.mu.Lock()
+	outdated := d.outdated
+	d.mu.Unlock()
+	if outdated || d.pull == nil {
+		if err := d.relist(); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := d.lookup("open", filepath.ToSlash(relPath))
+	if err != nil {
+		return nil, err
+	}
+	if !file.isRegular() {
+		return nil, &fs.PathError{Op: "open", Path: file.path, Err: errors.New("not a regular file")}
+	}
+
+	spool, err := os.CreateTemp("", "gosync-rsync-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := d.pull.fetch(file.path, spool); err != nil {
+		// Where the session stands is unknown, the next read starts another
+		if d.pull.conn != nil {
+			d.pull.conn.close()
+		}
+		d.pull = nil
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, err
+	}
+	return &rsyncReader{File: spool}, nil
+}
+
+func (d *rsyncBackend) Chmod(relPath string, mode fs.FileMode) error {
+	file, err := d.lookup("chmod", filepath.ToSlash(relPath))
+	if err != nil {
+		return err
+	}
+	file.mode = file.mode&rsyncTypeMask | uint32(mode.Perm())
+	return d.upload([]rsyncUpload{{rsyncFile: file}}, false, file.isDir(), nil)
+}
+
+func (d *rsyncBackend) Chtimes(relPath string, modTime time.Time) error {
+	file, err := d.lookup("chtimes", filepath.ToSlash(relPath))
+	if err != nil {
+		return err
+	}
+	file.modTime = modTime.Unix()
+	return d.upload([]rsyncUpload{{rsyncFile: file}}, false, file.isDir(), nil)
+}
+
+// The server deletes what isn't sent from the directories that are, so the parent goes
+// with rules that protect everything else in it.
+func (d *rsyncBackend) Remove(relPath string) error {
+	slashPath := filepath.ToSlash(relPath)
+
+	d.mu.Lock()
+	file, ok := d.listing[slashPath]
+	empty := true
+	for other := range d.listing {
+		if strings.HasPrefix(other, slashPath+"/") {
+			empty = false
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	if !ok || slashPath == "." {
+		return &fs.PathError{Op: "remove", Path: slashPath, Err: fs.ErrNotExist}
+	}
+	if file.isDir() && !empty {
+		return &fs.PathError{Op: "remove", Path: slashPath, Err: errors.New("directory not empty")}
+	}
+	return d.upload(nil, false, false, []string{slashPath})
+}
+
+// Walks the listing in the order filepath.WalkDir would.
+func (d *rsyncBackend) Walk(fn fs.WalkDirFunc) error {
+	d.pullMu.Lock()
+	d.mu.Lock()
+	outdated := d.outdated
+	d.mu.Unlock()
+	var err error
+	if outdated {
+		err = d.relist()
+	}
+	d.pullMu.Unlock()
+	if err != nil {
+		return fn(".", nil, err)
+	}
+
+	d.mu.Lock()
+	root, exists := d.listing["."]
+	relPaths := make([]string, 0, len(d.listing))
+	entries := make(map[string]fs.DirEntry, len(d.listing))
+	for slashPath, file := range d.listing {
+		relPath := filepath.FromSlash(slashPath)
+		relPaths = append(relPaths, relPath)
+		entries[relPath] = fs.FileInfoToDirEntry(rsyncFileInfo{file: file, name: path.Base(slashPath)})
+	}
+	d.mu.Unlock()
+
+	if !exists {
+		return fn(".", nil, &fs.PathError{Op: "lstat", Path: d.location.path, Err: fs.ErrNotExist})
+	}
+	if err := fn(".", fs.FileInfoToDirEntry(rsyncFileInfo{file: root, name: "."}), nil); err != nil || !root.isDir() {
+		return ignoreSkipDir(err)
+	}
+	sort.Slice(relPaths, func(i, j int) bool { return walkOrderLess(relPaths[i], relPaths[j]) })
+
+	skipped := "" // Directory whose remaining contents are skipped
+	for _, relPath := range relPaths {
+		if relPath == "." || skipped != "" && isWithin(skipped, relPath) {
+			continue
+		}
+
+		entry := entries[relPath]
+		err := fn(relPath, entry, nil)
+		if errors.Is(err, filepath.SkipDir) {
+			if entry.IsDir() {
+				skipped = relPath
+			} else {
+				skipped = filepath.Dir(relPath)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rsync confines what it writes to the module or the login, symlinks included.
+func (d *rsyncBackend) Contained(relPath string, followFinal bool) error {
+	return nil
+}
+
+// Protocol 29 transfers times in whole seconds.
+func (d *rsyncBackend) ModTimePrecision() time.Duration {
+	return time.Second
+}
+
+func (d *rsyncBackend) Close() error {
+	d.pullMu.Lock()
+	defer d.pullMu.Unlock()
+
+	if d.pull == nil {
+		return nil
+	}
+	err := d.pull.close()
+	d.pull = nil
+	return err
+}
+
+// Sends changed entries with their parent directories, or deletes the paths in deletions,
+// and updates the listing to match. Directories the server doesn't have yet are created.
+func (d *rsyncBackend) upload(changed []rsyncUpload, replace, dirTimes bool, deletions []string) error {
+	d.pushMu.Lock()
+	defer d.pushMu.Unlock()
+
+	d.mu.Lock()
+	uploads := make(map[string]rsyncUpload)
+	addParents := func(slashPath string) {
+		for dir := path.Dir(slashPath); ; dir = path.Dir(dir) {
+			if _, ok := uploads[dir]; ok {
+				break
+			}
+			if file, ok := d.listing[dir]; ok && file.isDir() {
+				uploads[dir] = rsyncUpload{rsyncFile: file}
+			} else {
+				uploads[dir] = rsyncUpload{rsyncFile: newRsyncDir(dir)}
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+	for _, upload := range changed {
+		addParents(upload.path)
+	}
+	for _, slashPath := range deletions {
+		addParents(slashPath)
+	}
+	for _, upload := range changed {
+		uploads[upload.path] = upload
+	}
+	d.mu.Unlock()
+
+	list := make([]rsyncUpload, 0, len(uploads))
+	for _, upload := range uploads {
+		list = append(list, upload)
+	}
+	if err := d.location.push(list, replace, dirTimes, deletions); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for slashPath, upload := range uploads {
+		d.listing[slashPath] = upload.rsyncFile
+	}
+	for _, slashPath := range deletions {
+		delete(d.listing, slashPath)
+	}
+	d.outdated = true
+	return nil
+}
+
+// An entry for a directory the server may not have yet.
+func newRsyncDir(slashPath string) rsyncFile {
+	return rsyncFile{path: slashPath, mode: rsyncTypeDir | 0o755, modTime: time.Now().Unix()}
+}
+
+// The user rsync daemons are logged in to as when the location names none.
+func currentUsername() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+type rsyncFileInfo struct {
+	file rsyncFile
+	name string
+}
+
+func (i rsyncFileInfo) Name() string       { return i.name }
+func (i rsyncFileInfo) Size() int64        { return i.file.size }
+func (i rsyncFileInfo) ModTime() time.Time { return time.Unix(i.file.modTime, 0) }
+func (i rsyncFileInfo) IsDir() bool        { return i.file.isDir() }
+func (i rsyncFileInfo) Sys() any           { return nil }
+
+func (i rsyncFileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.file.mode & 0o777)
+	switch i.file.mode & rsyncTypeMask {
+	case rsyncTypeDir:
+		mode |= fs.ModeDir
+	case rsyncTypeSymlink:
+		mode |= fs.ModeSymlink
+	}
+	return mode
+}
+
+// A file being written for the server, sent once it is closed.
+type rsyncWriter struct {
+	*os.File
+	dest    *rsyncBackend
+	relPath string // Slash separated
+	mode    fs.FileMode
+	modTime time.Time
+	kept    bool
+	closed  bool
+	err     error
+}
+
+// The server syncs the file when it is received.
+func (f *rsyncWriter) Sync() error {
+	return nil
+}
+
+func (f *rsyncWriter) Chmod(mode fs.FileMode) error {
+	f.mode = mode
+	return nil
+}
+
+func (f *rsyncWriter) SetModTime(modTime time.Time) error {
+	f.modTime = modTime
+	return nil
+}
+
+// Marks the file as complete, Close sends it instead of discarding it.
+func (f *rsyncWriter) keep() {
+	f.kept = true
+}
+
+func (f *rsyncWriter) Close() error {
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+	defer os.Remove(f.File.Name())
+	defer f.File.Close()
+
+	if !f.kept {
+		return nil
+	}
+	info, err := f.File.Stat()
+	if err != nil {
+		f.err = err
+		return err
+	}
+	file := rsyncFile{path: f.relPath, mode: rsyncTypeRegular | uint32(f.mode.Perm()), size: info.Size(), modTime: f.modTime.Unix()}
+	f.err = f.dest.upload([]rsyncUpload{{rsyncFile: file, contents: f.File}}, true, false, nil)
+	return f.err
+}
+
+// A file received from the server, removed once read.
+type rsyncReader struct {
+	*os.File
+}
+
+func (r *rsyncReader) Close() error {
+	err := r.File.Close()
+	os.Remove(r.File.Name())
+	return err
+}
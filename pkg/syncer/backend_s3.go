@@ -0,0 +1,729 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size of the parts large files are uploaded in. S3 allows at most 10,000 parts of at least 5 MiB.
+const s3PartSize = 16 << 20
+
+// Object metadata holding what S3 doesn't keep itself.
+const (
+	s3MtimeHeader = "X-Amz-Meta-Gosync-Mtime" // Source modification time in Unix nanoseconds
+	s3ModeHeader  = "X-Amz-Meta-Gosync-Mode"  // Source permission bits in octal
+)
+
+// Where an S3 backend lives, parsed from s3://bucket/prefix.
+type s3Location struct {
+	bucket string
+	prefix string // Key prefix without leading or trailing slashes, empty for the whole bucket
+}
+
+func init() {
+	RegisterBackend("s3", openS3Backend)
+}
+
+func openS3Backend(location string) (Backend, error) {
+	parsed, ok := parseS3Location(location)
+	if !ok {
+		return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix.", location)
+	}
+
+	backend, err := newS3Backend(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", location, err)
+	}
+	return backend, nil
+}
+
+func parseS3Location(dest string) (s3Location, bool) {
+	if !strings.HasPrefix(dest, "s3://") {
+		return s3Location{}, false
+	}
+
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(dest, "s3://"), "/")
+	if bucket == "" {
+		return s3Location{}, false
+	}
+	return s3Location{bucket: bucket, prefix: strings.Trim(prefix, "/")}, true
+}
+
+// A bucket, or a prefix of one, in S3 or a compatible object store. Directories don't
+// exist there, they are implied by the keys of the objects below them.
+type s3Backend struct {
+	client    *http.Client
+	endpoint  *url.URL
+	pathStyle bool // Address the bucket in the path instead of the host name
+	bucket    string
+	prefix    string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	region       string
+
+	cache *listingCache // Objects listed by an earlier run, with ListingCache
+}
+
+// Sets up an S3 backend from the standard AWS environment variables. AWS_ENDPOINT_URL
+// points it at a compatible store such as MinIO instead.
+func newS3Backend(location s3Location) (*s3Backend, error) {
+	d := &s3Backend{
+		client:       &http.Client{},
+		bucket:       location.bucket,
+		prefix:       location.prefix,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		region:       os.Getenv("AWS_REGION"),
+	}
+
+	if d.accessKey == "" || d.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if d.region == "" {
+		d.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if d.region == "" {
+		d.region = "us-east-1"
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+
+	if endpoint != "" {
+		// Compatible stores are usually addressed by path
+		d.pathStyle = true
+	} else {
+		endpoint = "https://s3." + d.region + ".amazonaws.com"
+		// Certificates don't cover bucket names with dots in them
+		d.pathStyle = strings.Contains(d.bucket, ".")
+	}
+
+	var err error
+	if d.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+
+	return d, nil
+}
+
+func (d *s3Backend) key(relPath string) string {
+	return strings.TrimPrefix(path.Join(d.prefix, filepath.ToSlash(relPath)), "/")
+}
+
+func (d *s3Backend) Stat(relPath string) (fs.FileInfo, error) {
+	key := d.key(relPath)
+
+	resp, err := d.do(http.MethodHead, key, nil, nil, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.cache.check(relPath, false, "")
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: key, Err: err}
+	}
+	resp.Body.Close()
+	d.cache.check(relPath, true, resp.Header.Get("ETag"))
+
+	info := &s3FileInfo{name: path.Base(key), size: resp.ContentLength, mode: 0o644}
+	info.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	if nanos, err := strconv.ParseInt(resp.Header.Get(s3MtimeHeader), 10, 64); err == nil {
+		info.modTime = time.Unix(0, nanos)
+	}
+	if mode, err := strconv.ParseUint(resp.Header.Get(s3ModeHeader), 8, 32); err == nil {
+		info.mode = fs.FileMode(mode).Perm()
+	}
+
+	return info, nil
+}
+
+// Prefixes spring into existence with the first object below them.
+func (d *s3Backend) MkdirAll(relPath string) error {
+	return nil
+}
+
+// The upload is buffered in parts, and only written once it is kept and closed.
+func (d *s3Backend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	upload := &s3Upload{dest: d, relPath: relPath, key: d.key(relPath), metadata: http.Header{}}
+	upload.SetModTime(srcInfo.ModTime())
+	upload.Chmod(srcInfo.Mode())
+	return upload, nil
+}
+
+func (d *s3Backend) Open(relPath string) (io.ReadCloser, error) {
+	key := d.key(relPath)
+
+	resp, err := d.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: key, Err: err}
+	}
+	return resp.Body, nil
+}
+
+func (d *s3Backend) Chmod(relPath string, mode fs.FileMode) error {
+	return d.updateMetadata(relPath, func(metadata http.Header) {
+		metadata.Set(s3ModeHeader, strconv.FormatUint(uint64(mode.Perm()), 8))
+	})
+}
+
+func (d *s3Backend) Chtimes(relPath string, modTime time.Time) error {
+	return d.updateMetadata(relPath, func(metadata http.Header) {
+		metadata.Set(s3MtimeHeader, strconv.FormatInt(modTime.UnixNano(), 10))
+	})
+}
+
+// Objects are immutable, so their metadata is changed by copying them onto themselves.
+func (d *s3Backend) updateMetadata(relPath string, update func(metadata http.Header)) error {
+	key := d.key(relPath)
+
+	resp, err := d.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return &fs.PathError{Op: "stat", Path: key, Err: err}
+	}
+	resp.Body.Close()
+
+	header := http.Header{}
+	for name, values := range resp.Header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			header[name] = values
+		}
+	}
+	update(header)
+	header.Set("X-Amz-Copy-Source", s3Escape("/"+d.bucket+"/"+key, false))
+	header.Set("X-Amz-Metadata-Directive", "REPLACE")
+
+	resp, err = d.do(http.MethodPut, key, nil, header, nil)
+	if err != nil {
+		return &fs.PathError{Op: "copy", Path: key, Err: err}
+	}
+	if err := checkS3Body(resp); err != nil {
+		return err
+	}
+	d.cache.moved(relPath, relPath)
+	return nil
+}
+
+// Objects can't be renamed, but they can be copied within the store and the original
+// deleted, which saves the upload.
+func (d *s3Backend) Rename(oldRelPath, newRelPath string) error {
+	oldKey, newKey := d.key(oldRelPath), d.key(newRelPath)
+
+	header := http.Header{"X-Amz-Copy-Source": {s3Escape("/"+d.bucket+"/"+oldKey, false)}}
+	resp, err := d.do(http.MethodPut, newKey, nil, header, nil)
+	if err != nil {
+		return &fs.PathError{Op: "copy", Path: oldKey, Err: err}
+	}
+	if err := checkS3Body(resp); err != nil {
+		return err
+	}
+	d.cache.moved(oldRelPath, newRelPath)
+	return d.Remove(oldRelPath)
+}
+
+// Deleting a key that doesn't exist succeeds, which is also what removing a directory amounts to.
+func (d *s3Backend) Remove(relPath string) error {
+	key := d.key(relPath)
+
+	resp, err := d.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: key, Err: err}
+	}
+	resp.Body.Close()
+	d.cache.update(relPath, nil)
+	return nil
+}
+
+// Lists every object below the prefix and walks them with their implied directories in
+// the order filepath.WalkDir would, which the on-disk source index relies on.
+func (d *s3Backend) Walk(fn fs.WalkDirFunc) error {
+	files, err := d.list()
+	if err != nil {
+		return fn(".", nil, err)
+	}
+
+	entries := make(map[string]fs.DirEntry, len(files))
+	for relPath, info := range files {
+		entries[relPath] = fs.FileInfoToDirEntry(info)
+
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			if _, ok := entries[dir]; ok {
+				break
+			}
+			entries[dir] = fs.FileInfoToDirEntry(&s3FileInfo{name: filepath.Base(dir), mode: fs.ModeDir | 0o755})
+		}
+	}
+
+	relPaths := make([]string, 0, len(entries))
+	for relPath := range entries {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Slice(relPaths, func(i, j int) bool { return walkOrderLess(relPaths[i], relPaths[j]) })
+
+	root := fs.FileInfoToDirEntry(&s3FileInfo{name: ".", mode: fs.ModeDir | 0o755})
+	if err := fn(".", root, nil); err != nil {
+		return ignoreSkipDir(err)
+	}
+
+	skipped := "" // Directory whose remaining contents are skipped
+	for _, relPath := range relPaths {
+		if skipped != "" && isWithin(skipped, relPath) {
+			continue
+		}
+
+		entry := entries[relPath]
+		err := fn(relPath, entry, nil)
+		if errors.Is(err, filepath.SkipDir) {
+			if entry.IsDir() {
+				skipped = relPath
+			} else {
+				skipped = filepath.Dir(relPath)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ignoreSkipDir(err error) error {
+	if errors.Is(err, filepath.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+// Returns every object below the prefix by relative path, following continuation tokens,
+// or as an earlier run listed them if that is recent enough.
+func (d *s3Backend) list() (map[string]*s3FileInfo, error) {
+	if cached, ok := d.cache.files(); ok {
+		files := make(map[string]*s3FileInfo, len(cached))
+		for relPath, file := range cached {
+			files[relPath] = &s3FileInfo{name: filepath.Base(relPath), size: file.Size, modTime: file.ModTime, mode: 0o644}
+		}
+		return files, nil
+	}
+
+	listPrefix := ""
+	if d.prefix != "" {
+		listPrefix = d.prefix + "/"
+	}
+
+	files := make(map[string]*s3FileInfo)
+	listed := make(map[string]listedFile)
+	query := url.Values{"list-type": {"2"}, "prefix": {listPrefix}}
+
+	for {
+		resp, err := d.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+				ETag         string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			relPath := strings.TrimPrefix(object.Key, listPrefix)
+			if relPath == "" || strings.HasSuffix(relPath, "/") {
+				continue // Folder placeholders created by consoles
+			}
+			files[filepath.FromSlash(relPath)] = &s3FileInfo{
+				name:    path.Base(relPath),
+				size:    object.Size,
+				modTime: object.LastModified,
+				mode:    0o644,
+			}
+			listed[filepath.FromSlash(relPath)] = listedFile{Size: object.Size, ModTime: object.LastModified, ETag: object.ETag}
+		}
+
+		if !result.IsTruncated {
+			d.cache.store(listed)
+			return files, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Object stores have no symlinks that could redirect a write.
+func (d *s3Backend) Contained(relPath string, followFinal bool) error {
+	return nil
+}
+
+// Modification times are kept exactly in the object metadata.
+func (d *s3Backend) ModTimePrecision() time.Duration {
+	return 0
+}
+
+func (d *s3Backend) Close() error {
+	return nil
+}
+
+// Sends a signed request for key, or for the bucket itself when key is empty, and returns
+// the response if it was successful. A missing key is reported as fs.ErrNotExist.
+func (d *s3Backend) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *d.endpoint
+	objectPath := "/" + key
+	if d.pathStyle {
+		objectPath = "/" + d.bucket + objectPath
+	} else {
+		u.Host = d.bucket + "." + u.Host
+	}
+	u.Path = objectPath
+	u.RawPath = s3Escape(objectPath, false)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	d.sign(req, time.Now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound && key != "" {
+			return nil, fs.ErrNotExist
+		}
+		return nil, s3ResponseError(resp)
+	}
+
+	return resp, nil
+}
+
+// Adds an AWS Signature Version 4 Authorization header to req. The payload isn't hashed
+// unless req carries its hash already, which S3 allows, so uploads don't have to be read twice.
+func (d *s3Backend) sign(req *http.Request, now time.Time) {
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if d.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.sessionToken)
+	}
+
+	// S3 wants the host and every x-amz-* header signed
+	names := []string{"host"}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + d.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+d.secretKey), date)
+	signingKey = hmacSHA256(signingKey, d.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Percent-encodes everything but the unreserved characters, and slashes unless encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Encodes query parameters sorted by name, the way they are signed.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Builds an error from an S3 error response body.
+func s3ResponseError(resp *http.Response) error {
+	var body struct {
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code == "" {
+		return fmt.Errorf("s3: %s", resp.Status)
+	}
+	return fmt.Errorf("s3: %s: %s", body.Code, body.Message)
+}
+
+// Some requests, like copies and completing uploads, report errors in a successful response.
+func checkS3Body(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return s3ResponseError(&http.Response{Status: resp.Status, Body: io.NopCloser(bytes.NewReader(data))})
+	}
+	return nil
+}
+
+// An object being written. Small ones are sent in a single request when closed, large
+// ones switch to a multipart upload once the first part is full.
+type s3Upload struct {
+	dest     *s3Backend
+	relPath  string
+	key      string
+	metadata http.Header
+	buffer   bytes.Buffer
+	uploadID string   // Set once the upload is multipart
+	etags    []string // Of the uploaded parts, in order
+	size     int64    // Written so far
+	kept     bool
+	closed   bool
+	err      error
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+
+	u.buffer.Write(p)
+	u.size += int64(len(p))
+	for u.buffer.Len() >= s3PartSize {
+		if u.err = u.uploadPart(u.buffer.Next(s3PartSize)); u.err != nil {
+			u.abort()
+			return 0, u.err
+		}
+	}
+	return len(p), nil
+}
+
+// Uploads the object as it is stored server side, there is nothing to flush before Close.
+func (u *s3Upload) Sync() error {
+	return nil
+}
+
+// Metadata can only be changed until the upload has started.
+func (u *s3Upload) Chmod(mode fs.FileMode) error {
+	u.metadata.Set(s3ModeHeader, strconv.FormatUint(uint64(mode.Perm()), 8))
+	return nil
+}
+
+func (u *s3Upload) SetModTime(modTime time.Time) error {
+	u.metadata.Set(s3MtimeHeader, strconv.FormatInt(modTime.UnixNano(), 10))
+	return nil
+}
+
+// Marks the upload as complete, Close stores the object instead of abandoning it.
+func (u *s3Upload) keep() {
+	u.kept = true
+}
+
+// Sends what is left and finishes the upload. Only then is the object visible. An upload
+// that wasn't kept, like a failed copy, is abandoned and the object stays as it was.
+func (u *s3Upload) Close() error {
+	if u.closed {
+		return u.err
+	}
+	u.closed = true
+
+	if u.err != nil {
+		return u.err
+	}
+	if !u.kept {
+		u.abort()
+		return nil
+	}
+
+	if u.uploadID == "" {
+		resp, err := u.dest.do(http.MethodPut, u.key, nil, u.metadata, u.buffer.Bytes())
+		if err != nil {
+			u.err = err
+			return err
+		}
+		resp.Body.Close()
+		u.dest.cache.update(u.relPath, &listedFile{Size: u.size, ModTime: time.Now(), ETag: resp.Header.Get("ETag")})
+		return nil
+	}
+
+	if u.buffer.Len() > 0 {
+		if u.err = u.uploadPart(u.buffer.Bytes()); u.err != nil {
+			u.abort()
+			return u.err
+		}
+	}
+
+	if u.err = u.complete(); u.err != nil {
+		u.abort()
+		return u.err
+	}
+	u.dest.cache.update(u.relPath, &listedFile{Size: u.size, ModTime: time.Now()})
+	return nil
+}
+
+// Uploads data as the next part, starting the multipart upload first if needed.
+func (u *s3Upload) uploadPart(data []byte) error {
+	if u.uploadID == "" {
+		resp, err := u.dest.do(http.MethodPost, u.key, url.Values{"uploads": {""}}, u.metadata, nil)
+		if err != nil {
+			return err
+		}
+
+		var result struct{ UploadId string }
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil || result.UploadId == "" {
+			return fmt.Errorf("s3: could not start multipart upload of %s", u.key)
+		}
+		u.uploadID = result.UploadId
+	}
+
+	query := url.Values{"partNumber": {strconv.Itoa(len(u.etags) + 1)}, "uploadId": {u.uploadID}}
+	resp, err := u.dest.do(http.MethodPut, u.key, query, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	u.etags = append(u.etags, resp.Header.Get("ETag"))
+	return nil
+}
+
+func (u *s3Upload) complete() error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	request := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range u.etags {
+		request.Parts = append(request.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.dest.do(http.MethodPost, u.key, url.Values{"uploadId": {u.uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	return checkS3Body(resp)
+}
+
+// Discards the parts uploaded so far, they would otherwise be stored and billed.
+func (u *s3Upload) abort() {
+	if u.uploadID == "" {
+		return
+	}
+	if resp, err := u.dest.do(http.MethodDelete, u.key, url.Values{"uploadId": {u.uploadID}}, nil, nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Describes an object, or a directory implied by the keys below it.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+func (i *s3FileInfo) Name() string       { return i.name }
+func (i *s3FileInfo) Size() int64        { return i.size }
+func (i *s3FileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *s3FileInfo) ModTime() time.Time { return i.modTime }
+func (i *s3FileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *s3FileInfo) Sys() any           { return nil }
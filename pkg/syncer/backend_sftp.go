@@ -0,0 +1,260 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Where an SFTP backend lives, parsed from user@host:/path or sftp://user@host:port/path.
+type sftpLocation struct {
+	user string
+	host string // host:port
+	root string // Slash separated path on the remote machine
+}
+
+func init() {
+	RegisterBackend("sftp", openSFTPBackend)
+}
+
+func openSFTPBackend(location string) (Backend, error) {
+	parsed, ok := parseSFTPLocation(location)
+	if !ok {
+		return nil, fmt.Errorf("invalid SFTP location %q, expected sftp://user@host:port/path.", location)
+	}
+
+	backend, err := dialSFTP(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", location, err)
+	}
+	return backend, nil
+}
+
+// Recognizes an SFTP location. Anything else, including Windows drive letters like
+// C:\backup, is a local path.
+func parseSFTPLocation(dest string) (sftpLocation, bool) {
+	var location sftpLocation
+
+	if strings.HasPrefix(dest, "sftp://") {
+		u, err := url.Parse(dest)
+		if err != nil || u.Hostname() == "" {
+			return location, false
+		}
+		location.user = u.User.Username()
+		location.host = u.Host
+		location.root = u.Path
+	} else {
+		// scp style, the host part ends at the first colon and can't contain a slash
+		hostPart, root, found := strings.Cut(dest, ":")
+		if !found || len(hostPart) < 2 || strings.ContainsAny(hostPart, `/\`) {
+			return location, false
+		}
+		if userPart, host, ok := strings.Cut(hostPart, "@"); ok {
+			location.user, hostPart = userPart, host
+		}
+		location.host = hostPart
+		location.root = root
+	}
+
+	if location.user == "" {
+		if current, err := user.Current(); err == nil {
+			location.user = current.Username
+		}
+	}
+	if _, _, err := net.SplitHostPort(location.host); err != nil {
+		location.host = net.JoinHostPort(location.host, "22")
+	}
+	if location.root == "" {
+		location.root = "." // The login directory
+	}
+
+	return location, true
+}
+
+// A directory on a remote machine, reached over SSH.
+type sftpBackend struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	root   string
+}
+
+// Connects with the keys from ssh-agent and the default identity files, checking the
+// server against ~/.ssh/known_hosts like ssh does.
+func dialSFTP(location sftpLocation) (*sftpBackend, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read known hosts, connect with ssh once to add the server: %w", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User:            location.user,
+		Auth:            sshAuthMethods(home),
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}
+
+	conn, err := ssh.Dial("tcp", location.host, config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &sftpBackend{conn: conn, client: client, root: location.root}, nil
+}
+
+// Collects the keys offered by ssh-agent and the unencrypted default identity files.
+func sshAuthMethods(home string) []ssh.AuthMethod {
+	var signers []ssh.Signer
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if agentConn, err := net.Dial("unix", socket); err == nil {
+			if agentSigners, err := agent.NewClient(agentConn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		key, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(key); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}
+}
+
+func (d *sftpBackend) path(relPath string) string {
+	return path.Join(d.root, filepath.ToSlash(relPath))
+}
+
+func (d *sftpBackend) Stat(relPath string) (fs.FileInfo, error) {
+	return d.client.Stat(d.path(relPath))
+}
+
+func (d *sftpBackend) MkdirAll(relPath string) error {
+	return d.client.MkdirAll(d.path(relPath))
+}
+
+func (d *sftpBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	file, err := d.client.OpenFile(d.path(relPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{File: file, client: d.client}, nil
+}
+
+func (d *sftpBackend) Open(relPath string) (io.ReadCloser, error) {
+	return d.client.Open(d.path(relPath))
+}
+
+func (d *sftpBackend) Chmod(relPath string, mode fs.FileMode) error {
+	return d.client.Chmod(d.path(relPath), mode)
+}
+
+func (d *sftpBackend) Chtimes(relPath string, modTime time.Time) error {
+	return d.client.Chtimes(d.path(relPath), time.Now(), modTime)
+}
+
+func (d *sftpBackend) Remove(relPath string) error {
+	return d.client.Remove(d.path(relPath))
+}
+
+func (d *sftpBackend) Rename(oldRelPath, newRelPath string) error {
+	return d.client.Rename(d.path(oldRelPath), d.path(newRelPath))
+}
+
+// Reads one directory at a time and visits entries in lexical order like
+// filepath.WalkDir, which the server's listings don't guarantee.
+func (d *sftpBackend) Walk(fn fs.WalkDirFunc) error {
+	info, err := d.Stat(".")
+	if err != nil {
+		return fn(".", nil, err)
+	}
+	return ignoreSkipDir(d.walk(".", fs.FileInfoToDirEntry(info), fn))
+}
+
+func (d *sftpBackend) walk(relPath string, entry fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(relPath, entry, nil); err != nil || !entry.IsDir() {
+		return err
+	}
+
+	infos, err := d.client.ReadDir(d.path(relPath))
+	if err != nil {
+		// Give the callback a chance to skip the directory like WalkDir does
+		return fn(relPath, entry, err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	for _, info := range infos {
+		err := d.walk(filepath.Join(relPath, info.Name()), fs.FileInfoToDirEntry(info), fn)
+		if errors.Is(err, filepath.SkipDir) {
+			if info.IsDir() {
+				continue
+			}
+			return nil // Skips the rest of this directory
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The server confines writes to what the login may touch, symlinks included.
+func (d *sftpBackend) Contained(relPath string, followFinal bool) error {
+	return nil
+}
+
+// SFTP version 3 transfers times in whole seconds.
+func (d *sftpBackend) ModTimePrecision() time.Duration {
+	return time.Second
+}
+
+func (d *sftpBackend) Close() error {
+	d.client.Close()
+	return d.conn.Close()
+}
+
+// A file being written on the server. Its modification time is set by path once written.
+type sftpFile struct {
+	*sftp.File
+	client *sftp.Client
+}
+
+func (f *sftpFile) Chmod(mode fs.FileMode) error {
+	return f.File.Chmod(mode)
+}
+
+func (f *sftpFile) SetModTime(modTime time.Time) error {
+	return f.client.Chtimes(f.Name(), time.Now(), modTime)
+}
@@ -0,0 +1,44 @@
+package syncer
+
+import (
+	"os/user"
+	"testing"
+)
+
+func TestParseSFTPLocation(t *testing.T) {
+	current := ""
+	if u, err := user.Current(); err == nil {
+		current = u.Username
+	}
+
+	tests := []struct {
+		location string
+		want     sftpLocation
+		ok       bool
+	}{
+		{"me@host:/backup", sftpLocation{user: "me", host: "host:22", root: "/backup"}, true},
+		{"me@host:backup", sftpLocation{user: "me", host: "host:22", root: "backup"}, true},
+		{"me@host:", sftpLocation{user: "me", host: "host:22", root: "."}, true},
+		{"host:/backup", sftpLocation{user: current, host: "host:22", root: "/backup"}, true},
+		{"sftp://me@host/backup", sftpLocation{user: "me", host: "host:22", root: "/backup"}, true},
+		{"sftp://me@host:2222/backup", sftpLocation{user: "me", host: "host:2222", root: "/backup"}, true},
+		{"sftp://host", sftpLocation{user: current, host: "host:22", root: "."}, true},
+		{"sftp://me@[::1]:2222/backup", sftpLocation{user: "me", host: "[::1]:2222", root: "/backup"}, true},
+		{"sftp://", sftpLocation{}, false},
+		{"sftp:///backup", sftpLocation{}, false},
+
+		// Local paths
+		{"/backup", sftpLocation{}, false},
+		{"backup", sftpLocation{}, false},
+		{`C:\backup`, sftpLocation{}, false},
+		{"C:/backup", sftpLocation{}, false},
+		{"./a:b", sftpLocation{}, false},
+		{`dir\a:b`, sftpLocation{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSFTPLocation(tt.location)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseSFTPLocation(%q) = %+v, %v, want %+v, %v", tt.location, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package syncer
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where a WebDAV backend lives, parsed from webdav://user@host/path or webdavs:// for HTTPS.
+type webdavLocation struct {
+	root     *url.URL // Collection synced to, without credentials
+	user     string
+	password string
+}
+
+func init() {
+	RegisterBackend("webdav", openWebDAVBackend)
+	RegisterBackend("webdavs", openWebDAVBackend)
+}
+
+func openWebDAVBackend(location string) (Backend, error) {
+	parsed, ok := parseWebDAVLocation(location)
+	if !ok {
+		return nil, fmt.Errorf("invalid WebDAV location, expected webdav://user@host/path.")
+	}
+
+	backend, err := newWebDAVBackend(parsed)
+	if err != nil {
+		// The URL without a password it may contain
+		return nil, fmt.Errorf("could not open %s: %w", parsed.root, err)
+	}
+	return backend, nil
+}
+
+// Recognizes a WebDAV location. The password may be given in the URL, but is better
+// kept out of the shell history in GOSYNC_WEBDAV_PASSWORD.
+func parseWebDAVLocation(dest string) (webdavLocation, bool) {
+	var location webdavLocation
+
+	scheme, rest, found := strings.Cut(dest, "://")
+	if !found || (scheme != "webdav" && scheme != "webdavs") {
+		return location, false
+	}
+
+	u, err := url.Parse("http://" + rest)
+	if err != nil || u.Hostname() == "" {
+		return location, false
+	}
+	if scheme == "webdavs" {
+		u.Scheme = "https"
+	}
+
+	location.user = u.User.Username()
+	location.password, _ = u.User.Password()
+	if location.password == "" {
+		location.password = os.Getenv("GOSYNC_WEBDAV_PASSWORD")
+	}
+
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawPath = ""
+	location.root = u
+
+	return location, true
+}
+
+// A collection on a WebDAV server such as a Nextcloud or ownCloud share. Modification
+// times are set the way those servers accept them, other servers keep the upload time.
+type webdavBackend struct {
+	client   *http.Client
+	root     *url.URL
+	user     string
+	password string
+
+	created sync.Map // Collections known to exist, so MkdirAll doesn't repeat itself
+}
+
+func newWebDAVBackend(location webdavLocation) (*webdavBackend, error) {
+	d := &webdavBackend{
+		client:   &http.Client{},
+		root:     location.root,
+		user:     location.user,
+		password: location.password,
+	}
+
+	// Fail early on a wrong URL or credentials rather than on every file. Like a local
+	// destination directory, the collection itself is created if its parent exists.
+	info, err := d.Stat(".")
+	if errors.Is(err, fs.ErrNotExist) {
+		var resp *http.Response
+		if resp, err = d.do("MKCOL", ".", nil, nil, http.StatusCreated); err == nil {
+			resp.Body.Close()
+			info, err = d.Stat(".")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a collection", location.root.Redacted())
+	}
+
+	return d, nil
+}
+
+func (d *webdavBackend) url(relPath string) *url.URL {
+	u := *d.root
+	u.Path = path.Join(d.root.Path, filepath.ToSlash(relPath))
+	if relPath == "." || strings.HasSuffix(relPath, "/") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	}
+	return &u
+}
+
+func (d *webdavBackend) newRequest(method, relPath string, header http.Header, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, d.url(relPath).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if d.user != "" {
+		req.SetBasicAuth(d.user, d.password)
+	}
+	return req, nil
+}
+
+// Sends an authenticated request for relPath and returns the response if its status is
+// one of ok. A missing resource is reported as fs.ErrNotExist.
+func (d *webdavBackend) do(method, relPath string, header http.Header, body io.Reader, ok ...int) (*http.Response, error) {
+	req, err := d.newRequest(method, relPath, header, body)
+	if err != nil {
+		return nil, err
+	}
+	return d.send(req, relPath, ok...)
+}
+
+func (d *webdavBackend) send(req *http.Request, relPath string, ok ...int) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+
+	resp.Body.Close()
+	op := strings.ToLower(req.Method)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: op, Path: relPath, Err: fs.ErrNotExist}
+	}
+	return nil, &fs.PathError{Op: op, Path: relPath, Err: errors.New(resp.Status)}
+}
+
+// The properties gosync reads, requested explicitly as servers may not return them all otherwise.
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength int64  `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// Lists relPath and, with depth "1", its direct children. Results are keyed by the
+// unescaped path of their href without trailing slashes.
+func (d *webdavBackend) propfind(relPath, depth string) (map[string]*webdavFileInfo, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml"}}
+	resp, err := d.do("PROPFIND", relPath, header, strings.NewReader(webdavPropfind), http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid PROPFIND response for %s: %w", relPath, err)
+	}
+
+	infos := make(map[string]*webdavFileInfo, len(result.Responses))
+	for _, response := range result.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			continue
+		}
+
+		hrefPath := strings.TrimSuffix(href.Path, "/")
+		info := &webdavFileInfo{name: path.Base(hrefPath), mode: 0o644}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			if prop.ResourceType.Collection != nil {
+				info.mode = fs.ModeDir | 0o755
+			}
+			info.size = prop.ContentLength
+			info.modTime, _ = http.ParseTime(prop.LastModified)
+		}
+		infos[hrefPath] = info
+	}
+
+	return infos, nil
+}
+
+func (d *webdavBackend) Stat(relPath string) (fs.FileInfo, error) {
+	infos, err := d.propfind(relPath, "0")
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		return info, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: relPath, Err: fs.ErrNotExist}
+}
+
+// Creates the missing collections from the root down, WebDAV has no recursive MKCOL.
+func (d *webdavBackend) MkdirAll(relPath string) error {
+	if relPath == "." {
+		return nil
+	}
+	if _, ok := d.created.Load(relPath); ok {
+		return nil
+	}
+
+	if err := d.MkdirAll(filepath.Dir(relPath)); err != nil {
+		return err
+	}
+
+	// An existing collection is reported as 405 Method Not Allowed
+	resp, err := d.do("MKCOL", relPath+"/", nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	d.created.Store(relPath, struct{}{})
+	return nil
+}
+
+// The contents are streamed to the server as they are written. Nextcloud and ownCloud
+// take the modification time along with the upload.
+func (d *webdavBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	reader, writer := io.Pipe()
+
+	header := http.Header{"X-Oc-Mtime": {strconv.FormatInt(srcInfo.ModTime().Unix(), 10)}}
+	req, err := d.newRequest(http.MethodPut, relPath, header, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &webdavFile{PipeWriter: writer, dest: d, relPath: relPath, modTime: srcInfo.ModTime(), done: make(chan error, 1)}
+	go func() {
+		resp, err := d.send(req, relPath, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+		if err == nil {
+			resp.Body.Close()
+		}
+		reader.CloseWithError(err) // Unblocks writes when the request failed early
+		file.done <- err
+	}()
+
+	return file, nil
+}
+
+func (d *webdavBackend) Open(relPath string) (io.ReadCloser, error) {
+	resp, err := d.do(http.MethodGet, relPath, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// WebDAV has no permissions to set.
+func (d *webdavBackend) Chmod(relPath string, mode fs.FileMode) error {
+	return nil
+}
+
+// Sets the lastmodified property, which Nextcloud and ownCloud accept as a Unix timestamp.
+func (d *webdavBackend) Chtimes(relPath string, modTime time.Time) error {
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<d:propertyupdate xmlns:d="DAV:"><d:set><d:prop><d:lastmodified>%d</d:lastmodified></d:prop></d:set></d:propertyupdate>`, modTime.Unix())
+
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := d.do("PROPPATCH", relPath, header, strings.NewReader(body), http.StatusMultiStatus)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The property can be refused within a successful response
+	var result webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid PROPPATCH response for %s: %w", relPath, err)
+	}
+	for _, response := range result.Responses {
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				return &fs.PathError{Op: "chtimes", Path: relPath, Err: fmt.Errorf("server refused modification time: %s", propstat.Status)}
+			}
+		}
+	}
+	return nil
+}
+
+func (d *webdavBackend) Remove(relPath string) error {
+	resp, err := d.do(http.MethodDelete, relPath, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	d.created.Delete(relPath)
+	return nil
+}
+
+// MOVE keeps the properties of the resource, the modification time included.
+func (d *webdavBackend) Rename(oldRelPath, newRelPath string) error {
+	header := http.Header{"Destination": {d.url(newRelPath).String()}, "Overwrite": {"F"}}
+	resp, err := d.do("MOVE", oldRelPath, header, nil, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Lists one collection at a time, as many servers refuse Depth: infinity, and visits
+// entries in lexical order like filepath.WalkDir.
+func (d *webdavBackend) Walk(fn fs.WalkDirFunc) error {
+	info, err := d.Stat(".")
+	if err != nil {
+		return fn(".", nil, err)
+	}
+	return ignoreSkipDir(d.walk(".", fs.FileInfoToDirEntry(info), fn))
+}
+
+func (d *webdavBackend) walk(relPath string, entry fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(relPath, entry, nil); err != nil || !entry.IsDir() {
+		return err
+	}
+
+	infos, err := d.propfind(relPath+"/", "1")
+	if err != nil {
+		// Give the callback a chance to skip the collection like WalkDir does
+		return fn(relPath, entry, err)
+	}
+
+	self := strings.TrimSuffix(d.url(relPath).Path, "/")
+	names := make([]string, 0, len(infos))
+	children := make(map[string]*webdavFileInfo, len(infos))
+	for hrefPath, info := range infos {
+		if hrefPath == self {
+			continue
+		}
+		names = append(names, info.name)
+		children[info.name] = info
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := filepath.Join(relPath, name)
+		err := d.walk(childPath, fs.FileInfoToDirEntry(children[name]), fn)
+		if errors.Is(err, filepath.SkipDir) {
+			if children[name].IsDir() {
+				continue
+			}
+			return nil // Skips the rest of this collection
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The server confines requests to the share, there are no symlinks to follow.
+func (d *webdavBackend) Contained(relPath string, followFinal bool) error {
+	return nil
+}
+
+// getlastmodified is an HTTP date, which has whole seconds.
+func (d *webdavBackend) ModTimePrecision() time.Duration {
+	return time.Second
+}
+
+func (d *webdavBackend) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// Ends the body of an upload that isn't kept, so the request fails instead of completing.
+var errUploadAbandoned = errors.New("upload abandoned")
+
+// A file being uploaded. The upload completes when it is kept and closed.
+type webdavFile struct {
+	*io.PipeWriter
+	dest    *webdavBackend
+	relPath string
+	modTime time.Time // Sent along with the upload
+	retime  bool      // Set when modTime changed afterwards and has to be applied separately
+	done    chan error
+	kept    bool
+	closed  bool
+	err     error
+}
+
+// Nothing is buffered on our side.
+func (f *webdavFile) Sync() error {
+	return nil
+}
+
+func (f *webdavFile) Chmod(mode fs.FileMode) error {
+	return nil
+}
+
+func (f *webdavFile) SetModTime(modTime time.Time) error {
+	if !modTime.Equal(f.modTime) {
+		f.modTime, f.retime = modTime, true
+	}
+	return nil
+}
+
+// Marks the upload as complete, Close finishes it instead of aborting it.
+func (f *webdavFile) keep() {
+	f.kept = true
+}
+
+// Finishes the upload, or aborts it when it wasn't kept, like a failed copy, so the
+// resource stays as it was.
+func (f *webdavFile) Close() error {
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+
+	if !f.kept {
+		f.PipeWriter.CloseWithError(errUploadAbandoned)
+		<-f.done
+		return nil
+	}
+	f.PipeWriter.Close()
+	if f.err = <-f.done; f.err == nil && f.retime {
+		f.err = f.dest.Chtimes(f.relPath, f.modTime)
+	}
+	return f.err
+}
+
+// Describes a resource listed by PROPFIND.
+type webdavFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+func (i *webdavFileInfo) Name() string       { return i.name }
+func (i *webdavFileInfo) Size() int64        { return i.size }
+func (i *webdavFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *webdavFileInfo) ModTime() time.Time { return i.modTime }
+func (i *webdavFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *webdavFileInfo) Sys() any           { return nil }
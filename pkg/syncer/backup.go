@@ -0,0 +1,155 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of the directory below BackupDir each run keeps its backups in.
+const backupTimeLayout = "2006-01-02T15-04-05"
+
+// Suffix of backups kept next to the files they were taken of when none is given.
+const defaultBackupSuffix = "~"
+
+// Checks the backup options and picks where this run's backups go.
+func (s *Syncer) prepareBackups(start time.Time) error {
+	if !s.Options.Backup && s.Options.BackupDir == "" {
+		return nil
+	}
+	if s.Options.BackupDir != "" && !filepath.IsLocal(s.Options.BackupDir) {
+		return fmt.Errorf("the backup directory has to be a relative path inside of the destination.")
+	}
+	if strings.ContainsRune(s.Options.BackupSuffix, filepath.Separator) || strings.ContainsRune(s.Options.BackupSuffix, '/') {
+		return fmt.Errorf("the backup suffix can't contain a path separator.")
+	}
+	if s.Options.BackupKeep > 0 && !strings.Contains(s.Options.BackupSuffix, "N") {
+		return fmt.Errorf("keeping a number of backups needs a numbered suffix like ~N~.")
+	}
+	if _, ok := s.dest.(renamer); !ok {
+		return fmt.Errorf("backups need a destination files can be moved in.")
+	}
+
+	s.backups = true
+	s.backupSuffix = s.Options.BackupSuffix
+	if s.Options.BackupDir != "" {
+		s.backupRoot = filepath.Join(s.Options.BackupDir, start.Format(backupTimeLayout))
+	} else if s.backupSuffix == "" {
+		s.backupSuffix = defaultBackupSuffix // A backup next to the file can't have its name
+	}
+	return nil
+}
+
+// Reports whether relPath is the backup directory, which is never deleted or synced over.
+func (s *Syncer) isBackupDir(relPath string) bool {
+	return s.Options.BackupDir != "" && relPath == filepath.Clean(s.Options.BackupDir)
+}
+
+// Reports whether relPath is named like a backup kept next to the file it was taken of.
+// Those are kept by deletions the same way a backup directory is.
+func (s *Syncer) isBackupFile(relPath string) bool {
+	if !s.backups || s.backupRoot != "" {
+		return false
+	}
+
+	name := filepath.Base(relPath)
+	before, after, numbered := strings.Cut(s.backupSuffix, "N")
+	if !numbered {
+		return len(name) > len(before) && strings.HasSuffix(name, before)
+	}
+
+	stem, ok := strings.CutSuffix(name, after)
+	if !ok {
+		return false
+	}
+	unnumbered := strings.TrimRight(stem, "0123456789")
+	return len(unnumbered) < len(stem) && len(unnumbered) > len(before) && strings.HasSuffix(unnumbered, before)
+}
+
+// Returns the path of the backup of relPath, the version-th one with a numbered suffix.
+func (s *Syncer) backupPath(relPath string, version int) string {
+	suffix := strings.Replace(s.backupSuffix, "N", strconv.Itoa(version), 1)
+	return filepath.Join(s.backupRoot, relPath) + suffix
+}
+
+// Moves the destination file at relPath to its backup before it is deleted or
+// overwritten. With keep set a local file stays in place as well, hard linked to the
+// backup, so it can still be replaced in one step. Reports whether the file is safe to
+// lose, false when it couldn't be backed up.
+func (s *Syncer) backup(relPath string, keep bool) bool {
+	if !s.backups {
+		return true
+	}
+
+	backupRelPath := s.backupPath(relPath, 1)
+	logEvent := s.logger.Info().Str("action", "BACKUP").Str("path", relPath).Str("backup", backupRelPath)
+	if s.Options.DryRun {
+		s.recordPlanned("BACKUP", relPath, backupRelPath)
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would back up file")
+		return true
+	}
+
+	if err := s.dest.MkdirAll(filepath.Dir(backupRelPath)); err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Could not create backup directory")
+		s.stats.recordError(relPath, err)
+		return false
+	}
+	if err := s.rotateBackups(relPath); err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Could not rotate backups")
+		s.stats.recordError(relPath, err)
+		return false
+	}
+
+	var err error
+	if keep && s.local != nil {
+		err = os.Link(s.local.path(relPath), s.local.path(backupRelPath))
+	} else {
+		err = s.dest.(renamer).Rename(relPath, backupRelPath)
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Could not back up file")
+		s.stats.recordError(relPath, err)
+		return false
+	}
+
+	logEvent.Msg("File backed up")
+	return true
+}
+
+// Makes room for a new first backup of relPath by renumbering the existing ones, the
+// most recent being 1. Those beyond BackupKeep are removed, and so is the only backup
+// an unnumbered suffix keeps.
+func (s *Syncer) rotateBackups(relPath string) error {
+	if !strings.Contains(s.backupSuffix, "N") {
+		if err := s.dest.Remove(s.backupPath(relPath, 1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	count := 0
+	for {
+		if _, err := s.dest.Stat(s.backupPath(relPath, count+1)); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		count++
+	}
+
+	for version := count; version > 0; version-- {
+		var err error
+		if s.Options.BackupKeep > 0 && version >= s.Options.BackupKeep {
+			err = s.dest.Remove(s.backupPath(relPath, version))
+		} else {
+			err = s.dest.(renamer).Rename(s.backupPath(relPath, version), s.backupPath(relPath, version+1))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package syncer
+
+import (
+	"io"
+	"sync"
+)
+
+// Size of the buffers file contents are copied through when BufferSize isn't set.
+const defaultBufferSize = 128 << 10
+
+// Copy buffers are reused across files and Syncers rather than allocated for every
+// copy, in a pool per buffer size.
+var bufferPools sync.Map // int to *sync.Pool
+
+// Copies src to dst like io.Copy, through a pooled buffer of size bytes, or of
+// defaultBufferSize when 0.
+func copyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{New: func() any {
+			buf := make([]byte, size)
+			return &buf
+		}})
+	}
+
+	buf := pool.(*sync.Pool).Get().(*[]byte)
+	defer pool.(*sync.Pool).Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+// Copies src to dst through a buffer of BufferSize bytes.
+func (s *Syncer) copy(dst io.Writer, src io.Reader) (int64, error) {
+	return copyBuffer(dst, src, s.Options.BufferSize)
+}
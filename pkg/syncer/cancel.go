@@ -0,0 +1,25 @@
+package syncer
+
+import (
+	"context"
+	"io"
+)
+
+// Reads from r until ctx is done, then fails with the error of ctx, so copies stop
+// midway when a sync is stopped.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Returns r read within budget, failing once the sync is stopped.
+func (s *Syncer) limitReader(r io.Reader, budget *ioBudget) io.Reader {
+	return contextReader{s.ctx, budget.reader(r)}
+}
@@ -0,0 +1,163 @@
+package syncer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CasePolicy decides what is done with source paths that differ only in case, of which a
+// case-insensitive destination can only hold one.
+type CasePolicy string
+
+const (
+	CaseFail   CasePolicy = "fail"   // Sync the first path found, report the others as failed
+	CaseSkip   CasePolicy = "skip"   // Sync the first path found, skip the others with a warning
+	CaseRename CasePolicy = "rename" // Sync the others too, under names with .case-N before the extension
+	CaseIgnore CasePolicy = "ignore" // Don't look for collisions, later paths overwrite earlier ones
+)
+
+// ValidCasePolicy reports whether policy is one of the known ones.
+func ValidCasePolicy(policy CasePolicy) bool {
+	switch policy {
+	case "", CaseFail, CaseSkip, CaseRename, CaseIgnore:
+		return true
+	}
+	return false
+}
+
+// The paths synced to a case-insensitive destination so far, by the walker.
+type caseCollisions struct {
+	policy  CasePolicy
+	paths   map[string]string // Destination paths in lower case, to the path as synced
+	renamed map[string]string // Source paths synced under another name, to that name
+}
+
+// Looks for case collisions when the destination is a local directory that doesn't tell
+// names apart by case, unless CaseCollisions is CaseIgnore.
+func (s *Syncer) prepareCaseCollisions() {
+	policy := s.Options.CaseCollisions
+	if policy == "" {
+		policy = CaseFail
+	}
+	if policy == CaseIgnore || s.local == nil || !caseInsensitiveDir(s.local.root, !s.Options.DryRun) {
+		return
+	}
+
+	s.logger.Debug().Str("action", "CASE_INSENSITIVE").Str("path", s.Options.DestinationPath).Msg("Destination is case-insensitive, checking for collisions")
+	s.caseFold = &caseCollisions{policy: policy, paths: make(map[string]string), renamed: make(map[string]string)}
+}
+
+// Returns the path the source entry at relPath is synced to, which differs from relPath
+// when it or a directory above it was renamed for colliding with another path. Returns
+// false when it isn't synced for colliding, having reported it.
+func (s *Syncer) checkCaseCollision(relPath string, d fs.DirEntry) (string, bool) {
+	c := s.caseFold
+	if renamed, ok := c.renamed[relPath]; ok {
+		return renamed, true // Seen before, by an earlier sync of Watch
+	}
+	synced := relPath
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		if renamed, ok := c.renamed[dir]; ok {
+			synced = renamed + relPath[len(dir):]
+			break
+		}
+	}
+
+	folded := strings.ToLower(synced)
+	first, seen := c.paths[folded]
+	if !seen || first == synced {
+		c.paths[folded] = synced
+		return synced, true
+	}
+
+	switch c.policy {
+	case CaseRename:
+		renamed := c.freeName(synced, d.IsDir())
+		c.paths[strings.ToLower(renamed)] = renamed
+		c.renamed[relPath] = renamed
+		s.logger.Warn().Str("action", "CASE_RENAME").Str("path", relPath).Str("collides", first).Str("target", renamed).Msg("Path collides with another on the case-insensitive destination, syncing it renamed")
+		return renamed, true
+	case CaseSkip:
+		s.stats.recordSkipped(relPath, "SKIP_CASE")
+		s.logger.Warn().Str("action", "SKIP_CASE").Str("path", relPath).Str("collides", first).Msg("Path collides with another on the case-insensitive destination, skipping")
+	default:
+		err := fmt.Errorf("%s collides with %s on the case-insensitive destination", relPath, first)
+		s.stats.recordError(relPath, err)
+		s.logger.Error().Err(err).Str("action", "CASE_COLLISION").Str("path", relPath).Msg("Path collides with another on the case-insensitive destination, not syncing it")
+	}
+	return "", false
+}
+
+// Returns the first name of the form name.case-N.ext that collides with nothing synced yet.
+// Directories have the suffix appended.
+func (c *caseCollisions) freeName(relPath string, dir bool) string {
+	ext := ""
+	if !dir {
+		ext = filepath.Ext(relPath)
+		if ext == filepath.Base(relPath) {
+			ext = "" // Hidden files like .profile have no extension
+		}
+	}
+	base := strings.TrimSuffix(relPath, ext)
+	for n := 2; ; n++ {
+		candidate := base + ".case-" + strconv.Itoa(n) + ext
+		if _, taken := c.paths[strings.ToLower(candidate)]; !taken {
+			return candidate
+		}
+	}
+}
+
+// Reports whether the directory at path, or the nearest of its parents that exists, is
+// on a file system that doesn't tell names apart by case. When probe is set a temporary
+// file is created to tell, otherwise the directory's own name is looked up with its case
+// swapped, and without letters in it the platform's usual file system decides.
+func caseInsensitiveDir(path string, probe bool) bool {
+	dir := path
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+		}
+		dir = parent
+	}
+
+	if probe {
+		if file, err := os.CreateTemp(dir, ".gosync-case-"); err == nil {
+			file.Close()
+			defer os.Remove(file.Name())
+			_, err := os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(file.Name()))))
+			return err == nil
+		}
+	}
+
+	if abs, err := filepath.Abs(dir); err == nil {
+		name := filepath.Base(abs)
+		if swapped := swapCase(name); swapped != name {
+			original, err := os.Stat(abs)
+			if err != nil {
+				return false
+			}
+			other, err := os.Stat(filepath.Join(filepath.Dir(abs), swapped))
+			return err == nil && os.SameFile(original, other)
+		}
+	}
+	return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+}
+
+func swapCase(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
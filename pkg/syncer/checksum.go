@@ -0,0 +1,61 @@
+package syncer
+
+import (
+	"encoding/hex"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bipinmdr07/gosync/internal/xattr"
+)
+
+// Extended attributes used to remember the content hash of a destination file. Every
+// hash algorithm has a pair of its own, named after it.
+const (
+	checksumXattrPrefix = "user.gosync."
+	checksumMtimeXattr  = "user.gosync.mtime" // Modification time the SHA-256 was recorded for
+)
+
+// Returns the attributes holding the checksum and the modification time it was recorded
+// for. SHA-256 keeps the names it had before other algorithms could be chosen.
+func checksumXattrs(algorithm HashAlgorithm) (string, string) {
+	name := checksumXattrPrefix + string(algorithm)
+	if algorithm == HashSHA256 {
+		return name, checksumMtimeXattr
+	}
+	return name, name + ".mtime"
+}
+
+// Stores sum as the checksum of the open destination file, valid as long as its modification time stays modTime.
+func (s *Syncer) recordChecksum(file *os.File, sum []byte, modTime time.Time) {
+	sumXattr, mtimeXattr := checksumXattrs(s.Options.Hash)
+	if err := xattr.FSet(file, sumXattr, []byte(hex.EncodeToString(sum))); err != nil {
+		s.logger.Warn().Err(err).Str("path", file.Name()).Msg("Error storing checksum")
+		return
+	}
+
+	if err := xattr.FSet(file, mtimeXattr, []byte(strconv.FormatInt(modTime.UnixNano(), 10))); err != nil {
+		s.logger.Warn().Err(err).Str("path", file.Name()).Msg("Error storing checksum")
+	}
+}
+
+// Returns the checksum stored on the destination file, if it was recorded for its current contents.
+func (s *Syncer) storedChecksum(destinationPath string, destInfo os.FileInfo) ([]byte, bool) {
+	sumXattr, mtimeXattr := checksumXattrs(s.Options.Hash)
+	mtime, err := xattr.Get(destinationPath, mtimeXattr)
+	if err != nil || string(mtime) != strconv.FormatInt(destInfo.ModTime().UnixNano(), 10) {
+		return nil, false
+	}
+
+	value, err := xattr.Get(destinationPath, sumXattr)
+	if err != nil {
+		return nil, false
+	}
+
+	sum, err := hex.DecodeString(string(value))
+	if err != nil {
+		return nil, false
+	}
+
+	return sum, true
+}
@@ -0,0 +1,38 @@
+//go:build darwin
+
+package syncer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// Replaces the empty dest with a copy-on-write clone of src where the file system
+// supports it (APFS). clonefile only creates new files, so the clone is made next to
+// dest, renamed over it and opened in its place. Reports false when cloning isn't
+// supported and nothing was copied.
+func copyKernel(ctx context.Context, dest *localFile, src *os.File, size int64, sparse bool, progress *atomic.Int64) (int64, bool, error) {
+	path := dest.Name()
+	tempPath := filepath.Join(filepath.Dir(path), ".gosync-clone-"+filepath.Base(path))
+	if err := unix.Fclonefileat(int(src.Fd()), unix.AT_FDCWD, tempPath, unix.CLONE_NOFOLLOW); err != nil {
+		return 0, false, nil
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return 0, false, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|noFollowFlag, 0)
+	if err != nil {
+		return 0, true, err
+	}
+	dest.File.Close()
+	dest.File = file
+
+	progress.Add(size)
+	return size, true, nil
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package syncer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// Size of the ranges copy_file_range is asked to copy at a time, so progress keeps moving.
+const kernelCopyChunk = 8 << 20
+
+// Copies src into the empty dest without the data passing through user space: as a
+// copy-on-write clone sharing the blocks of src where the file system can (FICLONE on
+// btrfs and XFS), otherwise with copy_file_range. Reports false when neither is
+// supported and nothing was copied. copy_file_range may fill in holes, so it isn't used
+// for sparse copies.
+func copyKernel(ctx context.Context, dest *localFile, src *os.File, size int64, sparse bool, progress *atomic.Int64) (int64, bool, error) {
+	if err := unix.IoctlFileClone(int(dest.Fd()), int(src.Fd())); err == nil {
+		progress.Add(size)
+		return size, true, nil
+	}
+	if sparse {
+		return 0, false, nil
+	}
+
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, true, err
+		}
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dest.Fd()), nil, kernelCopyChunk, 0)
+		if err != nil {
+			unsupported := errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) ||
+				errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EPERM)
+			if written == 0 && unsupported {
+				return 0, false, nil
+			}
+			return written, true, &os.PathError{Op: "copy_file_range", Path: dest.Name(), Err: err}
+		}
+		if n == 0 {
+			return written, true, nil
+		}
+		written += int64(n)
+		progress.Add(int64(n))
+	}
+}
@@ -0,0 +1,180 @@
+package syncer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// CompareMode selects how an existing destination file is judged up-to-date.
+type CompareMode string
+
+const (
+	CompareSizeMtime CompareMode = "size-mtime" // Trust size and modification time
+	CompareAdaptive  CompareMode = "adaptive"   // Trust size and modification time unless they are ambiguous, then hash
+	CompareChecksum  CompareMode = "checksum"   // Hash every file whose size matches, whatever its modification time
+)
+
+// Reports whether the source file has to be copied over the existing destination file,
+// and when not, whether the destination is known to match it: by its contents, or by
+// its size and modification time. An older source alone is no such evidence.
+func (s *Syncer) needsCopy(job fileJob, srcInfo, destInfo os.FileInfo) (needed, matched bool) {
+	relPath := job.relPath
+
+	// Compare at the precision the destination keeps, or a coarser one would never match
+	srcModTime := srcInfo.ModTime().Truncate(s.dest.ModTimePrecision())
+
+	// A transformed copy has its own size and contents, only its time tells it apart
+	if s.transformerFor(relPath) != nil {
+		return srcModTime.After(destInfo.ModTime()), false
+	}
+
+	if srcInfo.Size() != destInfo.Size() {
+		return true, false
+	}
+
+	// Modification times are no evidence either way, whether they match or not
+	if s.Options.Compare == CompareChecksum {
+		s.logger.Debug().Str("action", "HASH").Str("path", relPath).Msg("Comparing contents")
+		differ := s.contentsDiffer(job, srcInfo, destInfo, !srcModTime.Equal(destInfo.ModTime()))
+		return differ, !differ
+	}
+
+	delta := srcModTime.Sub(destInfo.ModTime())
+	if delta < 0 {
+		delta = -delta
+	}
+
+	// Only a source newer by more than the modify window is copied
+	if s.Options.Compare != CompareAdaptive {
+		return srcModTime.Sub(destInfo.ModTime()) > s.Options.ModifyWindow, delta <= s.Options.ModifyWindow
+	}
+
+	// Same size and close enough modification times clearly match
+	if delta <= s.Options.ModifyWindow {
+		return false, true
+	}
+
+	// Far apart modification times are clear enough to decide on
+	if delta > s.Options.AmbiguityWindow {
+		return srcModTime.After(destInfo.ModTime()), false
+	}
+
+	// Only the ambiguous cases pay for reading both files
+	s.logger.Debug().Str("action", "HASH").Str("path", relPath).Dur("delta", delta).Msg("Modification times are ambiguous, comparing contents")
+	differ := s.contentsDiffer(job, srcInfo, destInfo, true)
+	return differ, !differ
+}
+
+// Reports whether the destination file was modified after the source file, by more
+// than the modify window.
+func (s *Syncer) destinationNewer(srcInfo, destInfo os.FileInfo) bool {
+	srcModTime := srcInfo.ModTime().Truncate(s.dest.ModTimePrecision())
+	return destInfo.ModTime().Sub(srcModTime) > s.Options.ModifyWindow
+}
+
+// Hashes the source and destination files of job and reports whether they differ. When
+// they match and align is set, the destination takes the modification time of the source
+// so the next run can tell they match without hashing. Unreadable files count as different.
+func (s *Syncer) contentsDiffer(job fileJob, srcInfo, destInfo os.FileInfo, align bool) bool {
+	relPath := job.relPath
+	srcSum, err := s.hashSource(job)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not compare file contents, copying")
+		return true
+	}
+
+	// A checksum stored by a previous run saves reading the destination
+	var destSum []byte
+	ok := false
+	if s.local != nil {
+		destSum, ok = s.storedChecksum(s.local.path(relPath), destInfo)
+	}
+	if !ok {
+		destSum, ok = s.manifestChecksum(relPath, destInfo)
+	}
+	if !ok {
+		if destSum, err = s.hashDestination(relPath); err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not compare file contents, copying")
+			return true
+		}
+	}
+
+	if !bytes.Equal(srcSum, destSum) {
+		return true
+	}
+	s.rememberHash(relPath, destSum)
+
+	if align && !s.Options.DryRun {
+		s.alignModTime(relPath, srcSum, srcInfo.ModTime())
+	}
+
+	return false
+}
+
+// Sets the modification time of an existing destination file whose contents are known to be current.
+func (s *Syncer) alignModTime(relPath string, sum []byte, modTime time.Time) {
+	if s.local == nil {
+		if err := s.dest.Chtimes(relPath, modTime); err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Error preserving modification time")
+		}
+		return
+	}
+
+	destinationPath := s.local.path(relPath)
+	file, err := openForMetadata(destinationPath)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error preserving modification time")
+		return
+	}
+	defer file.Close()
+
+	if err := setFileTimes(file, time.Now(), modTime); err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error preserving modification time")
+		return
+	}
+
+	if s.Options.StoreChecksums {
+		s.recordChecksum(file, sum, modTime)
+	}
+}
+
+// Returns the digest of the source file of job.
+func (s *Syncer) hashSource(job fileJob) ([]byte, error) {
+	if s.sourceSums != nil {
+		return s.sourceSums.get(job.relPath, func() ([]byte, error) { return s.hashSourceFile(job) })
+	}
+	return s.hashSourceFile(job)
+}
+
+func (s *Syncer) hashSourceFile(job fileJob) ([]byte, error) {
+	file, err := job.src.Open(job.srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return s.hashReader(file)
+}
+
+// Returns the digest of the destination file at relPath.
+func (s *Syncer) hashDestination(relPath string) ([]byte, error) {
+	file, err := s.dest.Open(relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return s.hashReader(file)
+}
+
+// Hashes everything read from r with the configured algorithm.
+func (s *Syncer) hashReader(r io.Reader) ([]byte, error) {
+	hash := s.Options.Hash.newHash()
+	if _, err := s.copy(hash, s.limitReader(r, s.ioBudget(r))); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
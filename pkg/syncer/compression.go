@@ -0,0 +1,122 @@
+package syncer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression picks how file contents are compressed on their way to and from gosync://
+// servers, which tell the codecs they support when a client connects.
+type Compression string
+
+const (
+	CompressionNone Compression = "none" // Send contents as they are
+	CompressionZstd Compression = "zstd" // Smaller, for slow links
+	CompressionS2   Compression = "s2"   // Faster, in the class of LZ4, for links that are merely slow-ish
+)
+
+// Codecs a server offers, in the order it prefers them.
+var remoteCodecs = []string{string(CompressionZstd), string(CompressionS2)}
+
+// Largest a chunk may grow to when decompressed, so a peer can't make us allocate without
+// bound. Chunks are at most remoteChunkSize before they are compressed.
+const maxDecompressedChunk = 4 * remoteChunkSize
+
+// ValidCompression reports whether c is one of the known codecs or none.
+func ValidCompression(c Compression) bool {
+	switch c {
+	case "", CompressionNone, CompressionZstd, CompressionS2:
+		return true
+	}
+	return false
+}
+
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return encoder
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecompressedChunk))
+		return decoder
+	})
+)
+
+// Returns data compressed with codec and the codec, or data as it is and no codec when
+// compressing it saves nothing.
+func compressChunk(codec Compression, data []byte) ([]byte, Compression) {
+	if codec != CompressionZstd && codec != CompressionS2 {
+		return data, ""
+	}
+	if compressed := encodeChunk(codec, data); len(compressed) < len(data) {
+		return compressed, codec
+	}
+	return data, ""
+}
+
+// Compresses data with codec, which must be zstd or s2.
+func encodeChunk(codec Compression, data []byte) []byte {
+	if codec == CompressionZstd {
+		return zstdEncoder().EncodeAll(data, nil)
+	}
+	return s2.Encode(nil, data)
+}
+
+// Undoes compressChunk.
+func decompressChunk(codec Compression, data []byte) ([]byte, error) {
+	switch codec {
+	case "":
+		return data, nil
+	case CompressionZstd:
+		return zstdDecoder().DecodeAll(data, nil)
+	case CompressionS2:
+		if n, err := s2.DecodedLen(data); err != nil {
+			return nil, err
+		} else if n > maxDecompressedChunk {
+			return nil, fmt.Errorf("compressed chunk of %d bytes is too large", n)
+		}
+		return s2.Decode(nil, data)
+	}
+	return nil, fmt.Errorf("unsupported compression %q", codec)
+}
+
+// Extensions of files whose contents are compressed already, squeezing them again only
+// costs time.
+var compressedExtensions = map[string]bool{
+	".7z": true, ".avif": true, ".br": true, ".bz2": true, ".deb": true, ".docx": true,
+	".flac": true, ".gif": true, ".gz": true, ".heic": true, ".jar": true, ".jpeg": true,
+	".jpg": true, ".lz4": true, ".lzma": true, ".m4a": true, ".mkv": true, ".mov": true,
+	".mp3": true, ".mp4": true, ".ogg": true, ".opus": true, ".pdf": true, ".png": true,
+	".pptx": true, ".rar": true, ".rpm": true, ".tgz": true, ".webm": true, ".webp": true,
+	".xlsx": true, ".xz": true, ".zip": true, ".zst": true,
+}
+
+// Returns the codec the contents of the file at relPath are sent with, none for files
+// that are compressed already.
+func chunkCodec(codec Compression, relPath string) Compression {
+	if codec == CompressionNone || compressedExtensions[strings.ToLower(filepath.Ext(relPath))] {
+		return ""
+	}
+	return codec
+}
+
+// Backends that can compress the contents they transfer.
+type compressingBackend interface {
+	setCompression(codec Compression) error
+}
+
+// Has the backend compress transfers with the Compression of the options, if it can.
+func (s *Syncer) applyCompression(backend Backend) error {
+	if s.Options.Compression == "" || s.Options.Compression == CompressionNone {
+		return nil
+	}
+	if c, ok := backend.(compressingBackend); ok {
+		return c.setCompression(s.Options.Compression)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package syncer
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// ConflictPolicy decides what a two-way sync does with a file changed on both sides.
+type ConflictPolicy string
+
+const (
+	ConflictFail     ConflictPolicy = "fail"      // Leave both versions alone and report the conflict
+	ConflictNewest   ConflictPolicy = "newest"    // Keep the version modified last
+	ConflictSource   ConflictPolicy = "source"    // Keep the version of the source
+	ConflictDest     ConflictPolicy = "dest"      // Keep the version of the destination
+	ConflictKeepBoth ConflictPolicy = "keep-both" // Keep the source's version under the name, the destination's with ConflictSuffix appended
+)
+
+// Appended to the name the destination's version of a file is kept under by keep-both,
+// unless SyncOptions.ConflictSuffix says otherwise.
+const defaultConflictSuffix = ".conflict"
+
+// ConflictRule applies Policy to conflicts on paths matching Pattern, an --exclude style glob.
+type ConflictRule struct {
+	Pattern string
+	Policy  ConflictPolicy
+}
+
+// A ConflictRule ready to be matched.
+type conflictRule struct {
+	match  *filter.PathRules // Reports matching paths as excluded
+	policy ConflictPolicy
+}
+
+// ValidConflictPolicy reports whether policy is one of the known ones.
+func ValidConflictPolicy(policy ConflictPolicy) bool {
+	switch policy {
+	case ConflictFail, ConflictNewest, ConflictSource, ConflictDest, ConflictKeepBoth:
+		return true
+	}
+	return false
+}
+
+// Compiles the ConflictRules of the options.
+func compileConflictRules(rules []ConflictRule) ([]conflictRule, error) {
+	compiled := make([]conflictRule, 0, len(rules))
+	for _, rule := range rules {
+		if !ValidConflictPolicy(rule.Policy) {
+			return nil, fmt.Errorf("unknown conflict policy %q for %s.", rule.Policy, rule.Pattern)
+		}
+		match, err := filter.CompilePathRules([]filter.PathRule{{Pattern: rule.Pattern}})
+		if err != nil {
+			return nil, fmt.Errorf("%v.", err)
+		}
+		compiled = append(compiled, conflictRule{match: match, policy: rule.Policy})
+	}
+	return compiled, nil
+}
+
+// Returns the policy for a conflict on relPath, that of the first rule matching it.
+func (s *Syncer) conflictPolicy(relPath string) ConflictPolicy {
+	for _, rule := range s.conflictRules {
+		if rule.match.Excludes(relPath, false) {
+			return rule.policy
+		}
+	}
+	if s.Options.Conflicts == "" {
+		return ConflictFail
+	}
+	return s.Options.Conflicts
+}
+
+// Resolves a conflict on relPath by its policy, returning what to do with it instead.
+// Only files can be resolved, a file on one side and a directory on the other is
+// always reported. With keep-both, the destination's version is set aside and returned
+// as keptPath, to be copied to the source.
+func (s *Syncer) resolveConflict(relPath string, srcInfo, destInfo fs.FileInfo) (action twoWayAction, keptPath string) {
+	policy := s.conflictPolicy(relPath)
+	if srcInfo.IsDir() || destInfo.IsDir() {
+		policy = ConflictFail
+	}
+
+	logEvent := s.logger.Warn().Str("action", "CONFLICT").Str("path", relPath).Str("policy", string(policy))
+	switch policy {
+	case ConflictSource:
+		logEvent.Msg("Changed on both sides since the last sync, keeping the source's version")
+		return twoWayToDest, ""
+	case ConflictDest:
+		logEvent.Msg("Changed on both sides since the last sync, keeping the destination's version")
+		return twoWayToSrc, ""
+	case ConflictNewest:
+		if destInfo.ModTime().After(srcInfo.ModTime()) {
+			logEvent.Msg("Changed on both sides since the last sync, keeping the newer destination version")
+			return twoWayToSrc, ""
+		}
+		logEvent.Msg("Changed on both sides since the last sync, keeping the newer source version")
+		return twoWayToDest, ""
+	case ConflictKeepBoth:
+		if keptPath, ok := s.setAsideConflict(relPath); ok {
+			logEvent.Str("kept", keptPath).Msg("Changed on both sides since the last sync, keeping both versions")
+			return twoWayToDest, keptPath
+		}
+	default:
+		logEvent.Msg("Changed on both sides since the last sync, leaving both alone")
+	}
+
+	s.stats.recordError(relPath, errTwoWayConflict)
+	return twoWayConflict, ""
+}
+
+// Moves the destination's version of relPath to a free name with the conflict suffix.
+func (s *Syncer) setAsideConflict(relPath string) (string, bool) {
+	rename, ok := s.dest.(renamer)
+	if !ok {
+		s.logger.Warn().Str("path", relPath).Msg("The destination can't rename files, leaving both alone")
+		return "", false
+	}
+
+	suffix := s.Options.ConflictSuffix
+	if suffix == "" {
+		suffix = defaultConflictSuffix
+	}
+	keptPath := relPath + suffix
+	for n := 2; ; n++ {
+		if _, err := s.src.Stat(keptPath); os.IsNotExist(err) {
+			if _, err := s.dest.Stat(keptPath); os.IsNotExist(err) {
+				break
+			}
+		}
+		keptPath = relPath + suffix + "-" + strconv.Itoa(n)
+	}
+
+	if s.Options.DryRun {
+		return keptPath, true
+	}
+	if err := rename.Rename(relPath, keptPath); err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Could not set aside the destination's version")
+		return "", false
+	}
+	return keptPath, true
+}
@@ -0,0 +1,53 @@
+package syncer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var errEscapesDestination = errors.New("path resolves outside of the destination directory")
+
+// Returns an error unless writing to relPath in the destination stays inside of it after
+// following symlinks. With followFinal unset, relPath itself may be a symlink pointing
+// anywhere, which is what removing it needs.
+func (s *Syncer) checkContained(relPath string, followFinal bool) error {
+	return s.dest.Contained(relPath, followFinal)
+}
+
+// Checks that relPath below root resolves inside of root, by resolving the deepest part
+// of it that exists. Used wherever the kernel can't do the resolution for us.
+func resolveBeneathFallback(root, relPath string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if os.IsNotExist(err) {
+		return nil // Nothing exists yet, so nothing can redirect the write
+	} else if err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, relPath)
+	for path != root && path != filepath.Dir(path) {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			path = filepath.Dir(path)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// A dangling symlink fails to resolve, but writing through it would create its target
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return errEscapesDestination
+			}
+			return err
+		}
+
+		if !isWithin(realRoot, realPath) {
+			return errEscapesDestination
+		}
+		return nil
+	}
+
+	return nil
+}
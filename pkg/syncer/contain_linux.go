@@ -0,0 +1,60 @@
+//go:build linux
+
+package syncer
+
+import (
+	"errors"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Checks that relPath below root resolves inside of root using openat2 with RESOLVE_BENEATH,
+// which lets the kernel reject any symlink or ".." that leads out of root.
+func resolveBeneath(root, relPath string) error {
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil // Nothing exists yet, so nothing can redirect the write
+	} else if err != nil {
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	how := &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+
+	// Resolve the deepest part of the path that exists
+	for path := relPath; path != "."; path = filepath.Dir(path) {
+		fd, err := unix.Openat2(rootFd, path, how)
+		switch {
+		case err == nil:
+			unix.Close(fd)
+			return nil
+		case errors.Is(err, unix.EXDEV), errors.Is(err, unix.ELOOP):
+			return errEscapesDestination
+		case errors.Is(err, unix.ENOENT):
+			// A dangling symlink doesn't exist either, but writing through it would create its target
+			if isSymlinkAt(rootFd, path) {
+				return errEscapesDestination
+			}
+			continue
+		case errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EPERM):
+			return resolveBeneathFallback(root, relPath) // openat2 needs Linux 5.6 and may be filtered
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reports whether the last element of path below dirFd is a symlink.
+func isSymlinkAt(dirFd int, path string) bool {
+	var stat unix.Stat_t
+	if err := unix.Fstatat(dirFd, path, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return false
+	}
+	return stat.Mode&unix.S_IFMT == unix.S_IFLNK
+}
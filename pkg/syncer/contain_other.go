@@ -0,0 +1,8 @@
+//go:build !linux
+
+package syncer
+
+// Checks that relPath below root resolves inside of root.
+func resolveBeneath(root, relPath string) error {
+	return resolveBeneathFallback(root, relPath)
+}
@@ -0,0 +1,433 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Written to the root of a destination with Dedup, so syncing back from it restores
+	// the files.
+	dedupMarker = ".gosync-dedup"
+
+	// Directory at the root the chunks are kept in, by the first two digits of their hash.
+	dedupChunks = ".gosync-chunks"
+
+	// Chunks end where the rolling hash of the contents has dedupMaskBits zero bits, but are
+	// at least dedupMinChunk and at most dedupMaxChunk long. Boundaries depend on the
+	// contents around them only, so an insertion only changes the chunks it falls in.
+	dedupMinChunk  = 256 << 10
+	dedupMaxChunk  = 4 << 20
+	dedupMaskBits  = 20
+	dedupChunkMask = 1<<dedupMaskBits - 1
+)
+
+// Random values the rolling hash adds per byte, the same on every run so equal contents
+// are cut into equal chunks.
+var gearTable = func() (table [256]uint64) {
+	state := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		// splitmix64
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+		z = (z ^ z>>27) * 0x94D049BB133111EB
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
+
+// What a file of a deduplicating destination holds instead of its contents.
+type dedupManifest struct {
+	Size   int64        `json:"size"`
+	Chunks []dedupChunk `json:"chunks"`
+}
+
+type dedupChunk struct {
+	ID   string `json:"id"` // SHA-256 of the contents
+	Size int64  `json:"size"`
+}
+
+// A backend storing files as manifests listing chunks of their contents, which are kept
+// once by their hash in dedupChunks however many files hold them. Its files read back
+// as they were. Chunks no file refers to anymore are only removed by prune.
+type dedupBackend struct {
+	Backend
+	orphaned atomic.Bool // Files were replaced or removed, chunks may be left unused
+}
+
+// Returns the path the chunk with the given hash is kept at.
+func chunkPath(id string) string {
+	return filepath.Join(dedupChunks, id[:2], id)
+}
+
+// Reports whether relPath is kept by the backend itself rather than synced.
+func isDedupInternal(relPath string) bool {
+	return relPath == dedupMarker || relPath == dedupChunks ||
+		strings.HasPrefix(relPath, dedupChunks+string(filepath.Separator))
+}
+
+// Reads the manifest stored at relPath.
+func (b *dedupBackend) manifest(relPath string) (*dedupManifest, error) {
+	file, err := b.Backend.Open(relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest dedupManifest
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%s is no manifest of deduplicated contents: %w", relPath, err)
+	}
+	return &manifest, nil
+}
+
+func (b *dedupBackend) Stat(relPath string) (fs.FileInfo, error) {
+	if isDedupInternal(relPath) {
+		return nil, &fs.PathError{Op: "stat", Path: relPath, Err: fs.ErrNotExist}
+	}
+	info, err := b.Backend.Stat(relPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return info, err
+	}
+
+	// Files that aren't manifests don't match any source file, so they are replaced
+	size := int64(-1)
+	if manifest, err := b.manifest(relPath); err == nil {
+		size = manifest.Size
+	}
+	return storedFileInfo{FileInfo: info, name: info.Name(), size: size}, nil
+}
+
+func (b *dedupBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	if info, err := b.Backend.Stat(relPath); err == nil && info.Mode().IsRegular() {
+		b.orphaned.Store(true)
+	}
+	// What is stored is the manifest, whose size isn't known yet
+	if srcInfo != nil {
+		srcInfo = storedFileInfo{FileInfo: srcInfo, name: srcInfo.Name(), size: -1}
+	}
+	file, err := b.Backend.Create(relPath, srcInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &dedupFile{BackendFile: file, backend: b}, nil
+}
+
+func (b *dedupBackend) Open(relPath string) (io.ReadCloser, error) {
+	manifest, err := b.manifest(relPath)
+	if err != nil {
+		return nil, err
+	}
+	return &dedupReader{backend: b, chunks: manifest.Chunks, path: relPath}, nil
+}
+
+func (b *dedupBackend) Remove(relPath string) error {
+	if err := b.Backend.Remove(relPath); err != nil {
+		return err
+	}
+	b.orphaned.Store(true)
+	return nil
+}
+
+func (b *dedupBackend) Rename(oldRelPath, newRelPath string) error {
+	r, ok := b.Backend.(renamer)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldRelPath, New: newRelPath, Err: errors.ErrUnsupported}
+	}
+	if _, err := b.Backend.Stat(newRelPath); err == nil {
+		b.orphaned.Store(true)
+	}
+	return r.Rename(oldRelPath, newRelPath)
+}
+
+func (b *dedupBackend) Walk(fn fs.WalkDirFunc) error {
+	return b.Backend.Walk(func(relPath string, d fs.DirEntry, err error) error {
+		if isDedupInternal(relPath) {
+			if d != nil {
+				return skipEntry(d)
+			}
+			return nil
+		}
+		if err == nil && d.Type().IsRegular() {
+			d = dedupDirEntry{DirEntry: d, backend: b, relPath: relPath}
+		}
+		return fn(relPath, d, err)
+	})
+}
+
+// Stores the chunk data under its hash, unless it is stored already.
+func (b *dedupBackend) storeChunk(data []byte) (dedupChunk, error) {
+	sum := sha256.Sum256(data)
+	chunk := dedupChunk{ID: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+	if _, err := b.Backend.Stat(chunkPath(chunk.ID)); err == nil {
+		return chunk, nil
+	}
+	return chunk, writeBackendFile(b.Backend, chunkPath(chunk.ID), data)
+}
+
+// Removes the chunks no manifest refers to, returning how many there were and their size.
+func (b *dedupBackend) prune() (int, int64, error) {
+	used := make(map[string]struct{})
+	err := b.Backend.Walk(func(relPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if relPath == dedupChunks {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || relPath == dedupMarker {
+			return nil
+		}
+		manifest, err := b.manifest(relPath)
+		if err != nil {
+			return nil // Not ours
+		}
+		for _, chunk := range manifest.Chunks {
+			used[chunk.ID] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not list the chunks in use: %w", err)
+	}
+
+	var unused []string
+	var size int64
+	err = b.Backend.Walk(func(relPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		if !isDedupInternal(relPath) {
+			return skipEntry(d)
+		}
+		if !d.Type().IsRegular() || relPath == dedupMarker {
+			return nil
+		}
+		if _, ok := used[filepath.Base(relPath)]; !ok {
+			unused = append(unused, relPath)
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not list the chunks: %w", err)
+	}
+
+	for _, relPath := range unused {
+		if err := b.Backend.Remove(relPath); err != nil {
+			return 0, 0, err
+		}
+	}
+	b.orphaned.Store(false)
+	return len(unused), size, nil
+}
+
+// Has the destination store files deduplicated, marking it so syncs from it restore them.
+func (s *Syncer) storeDeduplicated() error {
+	dest := &dedupBackend{Backend: s.dest}
+	if !s.Options.DryRun {
+		if _, err := s.dest.Stat(dedupMarker); errors.Is(err, fs.ErrNotExist) {
+			err := writeBackendFile(s.dest, dedupMarker, []byte("Files here are stored deduplicated by gosync, sync from this directory to get them back.\n"))
+			if err != nil {
+				return fmt.Errorf("could not mark destination as deduplicated: %w.", err)
+			}
+		}
+	}
+	s.dest = dest
+	return nil
+}
+
+// Removes the chunks of a deduplicating destination that files no longer refer to.
+func (s *Syncer) pruneChunks() {
+	dest, ok := s.dest.(*dedupBackend)
+	if !ok || !dest.orphaned.Load() {
+		return
+	}
+	s.stats.setPhase("pruning")
+	removed, size, err := dest.prune()
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("Could not remove unused chunks, they are tried again next time")
+		return
+	}
+	logEvent := s.logger.Debug()
+	if removed > 0 {
+		logEvent = s.logger.Info()
+	}
+	logEvent.Str("action", "PRUNE_CHUNKS").Int("chunks", removed).Int64("bytes", size).Msg("Unused chunks removed")
+}
+
+// Reports whether backend holds files stored deduplicated, by its marker.
+func holdsDedupFiles(backend Backend) bool {
+	info, err := backend.Stat(dedupMarker)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// A file being stored deduplicated. Its contents are cut into chunks as they are written,
+// the manifest is written on Sync or Close, whichever comes first.
+type dedupFile struct {
+	BackendFile
+	backend  *dedupBackend
+	buf      []byte
+	hash     uint64
+	manifest dedupManifest
+	finished bool
+	err      error
+}
+
+func (f *dedupFile) Write(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// No chunk ends before the minimum length
+		if need := dedupMinChunk - len(f.buf); need > 0 {
+			n := min(need, len(p))
+			f.buf = append(f.buf, p[:n]...)
+			p, written = p[n:], written+n
+			continue
+		}
+
+		end := -1
+		for i, c := range p {
+			f.hash = f.hash<<1 + gearTable[c]
+			if f.hash&dedupChunkMask == 0 || len(f.buf)+i+1 >= dedupMaxChunk {
+				end = i + 1
+				break
+			}
+		}
+		if end < 0 {
+			f.buf = append(f.buf, p...)
+			return written + len(p), nil
+		}
+		f.buf = append(f.buf, p[:end]...)
+		p, written = p[end:], written+end
+		if f.err = f.cut(); f.err != nil {
+			return written, f.err
+		}
+	}
+	return written, nil
+}
+
+// Stores what was written since the last chunk as a chunk.
+func (f *dedupFile) cut() error {
+	chunk, err := f.backend.storeChunk(f.buf)
+	if err != nil {
+		return err
+	}
+	f.manifest.Chunks = append(f.manifest.Chunks, chunk)
+	f.manifest.Size += chunk.Size
+	f.buf = f.buf[:0]
+	f.hash = 0
+	return nil
+}
+
+func (f *dedupFile) finish() error {
+	if f.finished {
+		return f.err
+	}
+	f.finished = true
+	if f.err == nil && len(f.buf) > 0 {
+		f.err = f.cut()
+	}
+	if f.err == nil {
+		if f.manifest.Chunks == nil {
+			f.manifest.Chunks = []dedupChunk{}
+		}
+		f.err = json.NewEncoder(f.BackendFile).Encode(f.manifest)
+	}
+	return f.err
+}
+
+func (f *dedupFile) keep() {
+	if k, ok := f.BackendFile.(keeper); ok {
+		k.keep()
+	}
+}
+
+func (f *dedupFile) Sync() error {
+	if err := f.finish(); err != nil {
+		return err
+	}
+	return f.BackendFile.Sync()
+}
+
+func (f *dedupFile) Close() error {
+	if err := f.finish(); err != nil {
+		f.BackendFile.Close()
+		return err
+	}
+	return f.BackendFile.Close()
+}
+
+// Reads a file stored deduplicated, a chunk at a time, checking each against its hash.
+type dedupReader struct {
+	backend *dedupBackend
+	chunks  []dedupChunk
+	path    string
+	data    *bytes.Reader // Of the current chunk
+}
+
+func (r *dedupReader) Read(p []byte) (int, error) {
+	for r.data == nil || r.data.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	return r.data.Read(p)
+}
+
+func (r *dedupReader) next() error {
+	chunk := r.chunks[0]
+	r.chunks = r.chunks[1:]
+
+	file, err := r.backend.Backend.Open(chunkPath(chunk.ID))
+	if err != nil {
+		return fmt.Errorf("chunk %s of %s is missing: %w", chunk.ID, r.path, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, dedupMaxChunk+1))
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != chunk.ID {
+		return fmt.Errorf("chunk %s of %s is damaged", chunk.ID, r.path)
+	}
+	r.data = bytes.NewReader(data)
+	return nil
+}
+
+func (r *dedupReader) Close() error {
+	return nil
+}
+
+// A manifest listed with the size of the file it stands for.
+type dedupDirEntry struct {
+	fs.DirEntry
+	backend *dedupBackend
+	relPath string
+}
+
+// Returns the info of the file, which takes reading its manifest.
+func (e dedupDirEntry) Info() (fs.FileInfo, error) {
+	return e.backend.Stat(e.relPath)
+}
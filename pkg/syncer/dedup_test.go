@@ -0,0 +1,162 @@
+package syncer
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"maps"
+	mathrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Returns the manifest a deduplicating destination holds for file.
+func readManifest(t *testing.T, dest, file string) dedupManifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest dedupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("%s holds no manifest: %v", file, err)
+	}
+	return manifest
+}
+
+// Returns the size of the chunks a deduplicating destination keeps.
+func storedChunks(t *testing.T, dest string) int64 {
+	t.Helper()
+	var size int64
+	for _, file := range treeFiles(t, filepath.Join(dest, dedupChunks)) {
+		info, err := os.Stat(filepath.Join(dest, dedupChunks, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
+
+func TestDedupChunks(t *testing.T) {
+	// The same contents every time, chunks cut at the maximum would shift the others
+	data := make([]byte, 12<<20)
+	mathrand.NewChaCha8([32]byte{}).Read(data)
+	shifted := append([]byte("inserted at the start"), data...)
+
+	src, dest := t.TempDir(), t.TempDir()
+	writeContents(t, src, map[string]string{"data": string(data), "shifted": string(shifted)})
+	if _, err := NewSyncer(src, dest, WithOptions(&SyncOptions{Dedup: true})).Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks are cut within their limits, only the last one may be shorter
+	original := readManifest(t, dest, "data")
+	var total int64
+	for i, chunk := range original.Chunks {
+		if chunk.Size > dedupMaxChunk || chunk.Size < dedupMinChunk && i < len(original.Chunks)-1 {
+			t.Errorf("chunk %d holds %d bytes, want %d to %d", i, chunk.Size, dedupMinChunk, dedupMaxChunk)
+		}
+		total += chunk.Size
+	}
+	if total != original.Size || original.Size != int64(len(data)) {
+		t.Errorf("chunks hold %d bytes of %d, want %d", total, original.Size, len(data))
+	}
+	if len(original.Chunks) < 3 {
+		t.Fatalf("%d bytes were cut into %d chunks only", len(data), len(original.Chunks))
+	}
+
+	// Where chunks end depends on the contents around, so an insertion changes only the
+	// chunk it falls in
+	ids := make(map[string]bool)
+	for _, chunk := range original.Chunks {
+		ids[chunk.ID] = true
+	}
+	changed := 0
+	for _, chunk := range readManifest(t, dest, "shifted").Chunks {
+		if !ids[chunk.ID] {
+			changed++
+		}
+	}
+	if changed != 1 {
+		t.Errorf("the insertion changed %d chunks, want 1", changed)
+	}
+}
+
+func TestDedupRoundtrip(t *testing.T) {
+	big := make([]byte, 6<<20)
+	rand.Read(big)
+	files := map[string]string{
+		"empty":      "",
+		"small.txt":  "small file",
+		"big":        string(big),
+		"copy/big":   string(big),
+		"copy/small": "small file",
+	}
+
+	src, dest, restored := t.TempDir(), t.TempDir(), t.TempDir()
+	writeContents(t, src, files)
+	options := SyncOptions{Dedup: true, Delete: true}
+	if _, err := NewSyncer(src, dest, WithOptions(&options)).Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contents held by several files are stored once
+	if size := storedChunks(t, dest); size != int64(len(big)+len("small file")) {
+		t.Errorf("chunks hold %d bytes, want the %d of the distinct contents", size, len(big)+len("small file"))
+	}
+
+	// Syncing from the destination gives the files back
+	if _, err := NewSyncer(dest, restored, WithOptions(&SyncOptions{})).Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got := treeContents(t, restored); !maps.Equal(got, files) {
+		t.Errorf("restored %q, want %q", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(files)))
+	}
+
+	tests := []struct {
+		name      string
+		change    func(t *testing.T, src string)
+		wantFiles map[string]string
+		wantSize  int64 // Of the chunks kept
+	}{
+		{
+			"a copy removed keeps its chunks",
+			func(t *testing.T, src string) { os.RemoveAll(filepath.Join(src, "copy")) },
+			map[string]string{"empty": "", "small.txt": "small file", "big": string(big)},
+			int64(len(big) + len("small file")),
+		},
+		{
+			"the last file holding chunks removed prunes them",
+			func(t *testing.T, src string) { os.Remove(filepath.Join(src, "big")) },
+			map[string]string{"empty": "", "small.txt": "small file"},
+			int64(len("small file")),
+		},
+		{
+			"a changed file replaces its chunks",
+			func(t *testing.T, src string) { writeContents(t, src, map[string]string{"small.txt": "changed"}) },
+			map[string]string{"empty": "", "small.txt": "changed"},
+			int64(len("changed")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change(t, src)
+			if _, err := NewSyncer(src, dest, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+			if size := storedChunks(t, dest); size != tt.wantSize {
+				t.Errorf("chunks hold %d bytes, want %d", size, tt.wantSize)
+			}
+
+			restored := t.TempDir()
+			if _, err := NewSyncer(dest, restored, WithOptions(&SyncOptions{})).Start(); err != nil {
+				t.Fatal(err)
+			}
+			if got := treeContents(t, restored); !maps.Equal(got, tt.wantFiles) {
+				t.Errorf("restored %q, want %q", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(tt.wantFiles)))
+			}
+		})
+	}
+}
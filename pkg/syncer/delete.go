@@ -0,0 +1,295 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bipinmdr07/gosync/internal/junction"
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// Function to find and remove extra files in destination.
+// Files are deleted by a pool of workers while the destination is walked. Directories are
+// removed afterwards, deepest first, once everything inside of them is gone.
+func (s *Syncer) propagateDeletions(sourceFiles pathIndex) error {
+	s.logger.Info().Msg("START: Propagating deletions in destination")
+	s.stats.setPhase("deleting")
+	startTime := time.Now()
+
+	var directories []string
+	keptDirectories := make(map[string]struct{}) // Holding protected paths or backups, so never empty
+	keepParents := func(relPath string) {
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			keptDirectories[dir] = struct{}{}
+		}
+	}
+	files, wait := s.startDeleters(false)
+
+	// With a deletion limit or confirmations nothing is deleted before the walk shows how much would go
+	confirm := s.Options.ConfirmDeletions != nil && !s.Options.DryRun
+	limited := s.Options.MaxDelete != nil || s.Options.MaxDeletePercent != nil || confirm
+	var pending []string
+	total := 0 // Files in the destination
+
+	err := s.dest.Walk(func(relPath string, d os.DirEntry, err error) error {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			s.logger.Error().Err(err).Str("path", relPath).Msg("Error walking destination directory")
+			s.stats.recordError(relPath, err)
+			return nil
+		}
+
+		if relPath == "." {
+			return nil // Skip root
+		}
+		if !d.IsDir() {
+			total++
+		}
+
+		// Directories excluded by a marker are left alone with everything in them
+		if d.IsDir() && s.protectedByMarker(relPath) {
+			s.logger.Debug().Str("action", "KEEP_MARKER").Str("path", relPath).Msg("Directory is excluded by a marker, not deleting")
+			keepParents(relPath)
+			return filepath.SkipDir
+		}
+		// So are those whose source couldn't be listed, their contents are unknown
+		if _, ok := s.unreadableDirectories[relPath]; ok && d.IsDir() {
+			s.logger.Debug().Str("action", "KEEP_UNREADABLE").Str("path", relPath).Msg("Source directory can't be read, not deleting")
+			keepParents(relPath)
+			return filepath.SkipDir
+		}
+
+		// Backups are what deletions leave behind
+		if d.IsDir() && s.isBackupDir(relPath) {
+			keepParents(relPath)
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && s.isBackupFile(relPath) {
+			keepParents(relPath)
+			return nil
+		}
+
+		// A manifest kept in the destination describes it, it isn't part of it
+		if !d.IsDir() && s.isManifest(relPath) {
+			keepParents(relPath)
+			return nil
+		}
+
+		// Partial files are kept for the next run to resume
+		if d.IsDir() && s.isPartialDir(relPath) {
+			s.logger.Debug().Str("action", "KEEP_PARTIAL").Str("path", relPath).Msg("Directory holds partial files, not deleting")
+			keepParents(relPath)
+			return filepath.SkipDir
+		}
+
+		// Protected paths are kept with everything in them, and so are the directories they are in
+		if s.protectPaths.Excludes(relPath, d.IsDir()) {
+			s.logger.Debug().Str("action", "KEEP_PROTECTED").Str("path", relPath).Msg("Path is protected from deletion, not deleting")
+			keepParents(relPath)
+			return skipEntry(d)
+		}
+
+		junction := s.local != nil && junction.Is(s.local.path(relPath), d)
+
+		// If the file is not in the source index, mark it for deletion
+		if !sourceFiles.contains(relPath) {
+			if d.IsDir() && !junction {
+				directories = append(directories, relPath) // Removed once its contents are gone
+				return nil
+			}
+
+			if s.backups && s.backupRoot == "" {
+				keepParents(relPath) // Its backup takes its place
+			}
+			if limited {
+				pending = append(pending, relPath)
+			} else {
+				files <- relPath
+			}
+		}
+
+		// Never descend into a junction at the destination, it may point outside of it
+		if junction {
+			return skipEntry(d)
+		}
+
+		return nil
+	})
+
+	if limited && s.ctx.Err() == nil {
+		if limitErr := s.checkDeleteLimit(len(pending), total); limitErr != nil {
+			close(files)
+			wait()
+			return limitErr
+		}
+		if confirm && len(pending) > 0 {
+			pending = s.confirmDeletions(pending, keepParents)
+		}
+		for _, relPath := range pending {
+			files <- relPath
+		}
+	}
+
+	close(files)
+	wait()
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	directories = slices.DeleteFunc(directories, func(relPath string) bool {
+		_, kept := keptDirectories[relPath]
+		return kept
+	})
+
+	// Remove directories one depth level at a time, children before their parents
+	for _, level := range byDepthDescending(directories) {
+		queue, wait := s.startDeleters(true)
+		for _, relPath := range level {
+			queue <- relPath
+		}
+		close(queue)
+		wait()
+	}
+
+	elapsed := time.Since(startTime)
+	s.stats.recordDeletePhase(elapsed)
+	s.logger.Info().Dur("elapsed", elapsed).Msg("END: Propagating deletions in destination")
+
+	return err
+}
+
+// Refuses to delete count of the total files in the destination when that is more than
+// MaxDelete or MaxDeletePercent allow, unless forced. A mistyped source would otherwise
+// empty the destination. Dry runs only warn, so the plan can still be looked at.
+func (s *Syncer) checkDeleteLimit(count, total int) error {
+	exceeded := s.Options.MaxDelete != nil && count > *s.Options.MaxDelete ||
+		s.Options.MaxDeletePercent != nil && total > 0 && float64(count)*100/float64(total) > *s.Options.MaxDeletePercent
+	if !exceeded {
+		return nil
+	}
+
+	if s.Options.Force || s.Options.DryRun {
+		s.logger.Warn().Str("action", "MAX_DELETE").Int("count", count).Int("total", total).Msg("Deletions exceed the limit, deleting anyway")
+		return nil
+	}
+	return fmt.Errorf("deleting %d of %d destination files exceeds the deletion limit, nothing was deleted. Check the source or force the deletions.", count, total)
+}
+
+// Asks ConfirmDeletions about the files pending deletion and returns those it approved.
+// The others are kept, along with the directories they are in.
+func (s *Syncer) confirmDeletions(pending []string, keepParents func(string)) []string {
+	approved := make(map[string]struct{}, len(pending))
+	for _, relPath := range s.Options.ConfirmDeletions(s.Options.DestinationPath, slices.Clone(pending)) {
+		approved[relPath] = struct{}{}
+	}
+
+	confirmed := pending[:0]
+	for _, relPath := range pending {
+		if _, ok := approved[relPath]; ok {
+			confirmed = append(confirmed, relPath)
+			continue
+		}
+		keepParents(relPath)
+		s.logger.Info().Str("action", "KEEP_DECLINED").Str("path", relPath).Msg("Deletion was declined, keeping")
+	}
+	return confirmed
+}
+
+// Starts Workers goroutines deleting the relative paths sent on the returned channel,
+// all of them directories or none. The returned function waits for them after the
+// channel has been closed.
+func (s *Syncer) startDeleters(directories bool) (chan<- string, func()) {
+	queue := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.Options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for relPath := range queue {
+				s.deletePath(relPath, directories)
+			}
+		}()
+	}
+
+	return queue, wg.Wait
+}
+
+// Removes a single file or empty directory from the destination. Files are moved to the
+// backup directory instead when there is one.
+func (s *Syncer) deletePath(relPath string, directory bool) {
+	path := filepath.Join(s.Options.DestinationPath, relPath)
+	logEvent := s.logger.Info().Str("action", "DELETE").Str("path", relPath)
+
+	// The path itself is removed rather than followed, but its parents must not lead elsewhere
+	if err := s.checkContained(relPath, false); err != nil {
+		s.logger.Error().Err(err).Str("path", path).Msg("Refusing to delete outside of destination")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if s.Options.DryRun {
+		s.stats.recordDelete(relPath)
+		s.recordPlanned("DELETE", relPath, "")
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would delete file")
+		return
+	}
+
+	if !directory && s.backups {
+		if s.backup(relPath, false) {
+			s.stats.recordDelete(relPath)
+			logEvent.Msg("Successfully deleted file")
+		}
+		return
+	}
+
+	if err := s.dest.Remove(relPath); err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.Error().Err(err).Str("path", path).Msg("Error deleting file")
+			s.stats.recordError(relPath, err)
+		}
+		return
+	}
+
+	s.stats.recordDelete(relPath)
+	logEvent.Msg("Successfully deleted file")
+}
+
+// Groups relative paths by their depth, deepest group first.
+func byDepthDescending(relPaths []string) [][]string {
+	levels := make(map[int][]string)
+	for _, relPath := range relPaths {
+		depth := strings.Count(relPath, string(filepath.Separator))
+		levels[depth] = append(levels[depth], relPath)
+	}
+
+	depths := make([]int, 0, len(levels))
+	for depth := range levels {
+		depths = append(depths, depth)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(depths)))
+
+	grouped := make([][]string, 0, len(depths))
+	for _, depth := range depths {
+		grouped = append(grouped, levels[depth])
+	}
+	return grouped
+}
+
+// Compiles the patterns of paths protected from deletion as exclude rules, so a path
+// the rules exclude is protected.
+func compileProtectPatterns(patterns []string) (*filter.PathRules, error) {
+	rules := make([]filter.PathRule, len(patterns))
+	for i, pattern := range patterns {
+		rules[i] = filter.PathRule{Pattern: pattern}
+	}
+	return filter.CompilePathRules(rules)
+}
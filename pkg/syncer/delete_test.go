@@ -0,0 +1,90 @@
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Creates the files at the slash separated paths below root, each holding its own path.
+func writeTree(t *testing.T, root string, files ...string) {
+	t.Helper()
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the slash separated paths of the regular files below root, sorted.
+func treeFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			relPath, _ := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+func TestDeleteLimits(t *testing.T) {
+	zero, one, two, tenPercent := 0, 1, 2, 10.0
+
+	tests := []struct {
+		name    string
+		options SyncOptions
+		wantErr bool
+		want    []string
+	}{
+		{"no limit by default", SyncOptions{}, false, []string{"a", "b"}},
+		{"within the limit", SyncOptions{MaxDelete: &two}, false, []string{"a", "b"}},
+		{"0 deletes none", SyncOptions{MaxDelete: &zero}, true, []string{"a", "b", "old1", "old2"}},
+		{"above the percentage", SyncOptions{MaxDeletePercent: &tenPercent}, true, []string{"a", "b", "old1", "old2"}},
+		{"forced", SyncOptions{MaxDelete: &zero, Force: true}, false, []string{"a", "b"}},
+		{"protected", SyncOptions{ProtectPatterns: []string{"old1"}}, false, []string{"a", "b", "old1"}},
+		{"protected paths don't count", SyncOptions{MaxDelete: &one, ProtectPatterns: []string{"old2"}}, false, []string{"a", "b", "old2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dest := t.TempDir(), t.TempDir()
+			writeTree(t, src, "a", "b")
+			writeTree(t, dest, "a", "b", "old1", "old2")
+
+			options := tt.options
+			options.Delete = true
+			_, err := NewSyncer(src, dest, WithOptions(&options)).Start()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := treeFiles(t, dest); !slices.Equal(got, tt.want) {
+				t.Errorf("destination holds %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The options of the constructors alone don't limit deletions either
+	src, dest := t.TempDir(), t.TempDir()
+	writeTree(t, src, "a")
+	writeTree(t, dest, "a", "old")
+	if _, err := NewSyncer(src, dest, WithDelete(true)).Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got := treeFiles(t, dest); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("destination holds %q, want [a]", got)
+	}
+}
@@ -0,0 +1,347 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Delta transfer, the way rsync does it. The side holding the old version of a file
+// splits it into blocks and hands over a weak rolling checksum and a SHA-256 of each.
+// The sender slides a window over the new version a byte at a time, and wherever the
+// window matches a block of the old version only a reference to it is sent instead of
+// the data. The receiver rebuilds the file from those references and the data in
+// between, and checks the result against the SHA-256 of the whole new version.
+
+const (
+	deltaMinBlockSize = 2 << 10
+	deltaMaxBlockSize = 128 << 10
+
+	// Existing files smaller than this are sent whole, the checksums would save little
+	deltaMinFileSize = 64 << 10
+
+	// Data not found in the old version is sent in pieces of at most this size
+	deltaMaxLiteral = 1 << 20
+)
+
+// deltaBackend is implemented by backends that can rebuild a file from the version
+// they already hold, so only the parts that changed have to be sent to them.
+type deltaBackend interface {
+	// Returns the checksums of the blocks the file at relPath is split into.
+	BlockSums(relPath string, blockSize int) ([]blockSum, error)
+
+	// Starts replacing the file at relPath with one rebuilt from its blocks. The old
+	// version stays in place until the new one is closed.
+	CreateDelta(relPath string, blockSize int) (deltaFile, error)
+}
+
+// deltaFile is a file being rebuilt by a deltaBackend. Data written to it is stored as
+// is. Unless Expect is called before it is closed, the file is discarded.
+type deltaFile interface {
+	BackendFile
+	CopyBlocks(first, count int64) error // Appends blocks of the old version
+	Expect(sum []byte)                   // SHA-256 the rebuilt file has to match to replace the old version
+}
+
+// Checksums of one block of a file.
+type blockSum struct {
+	Weak   uint32
+	Strong [sha256.Size]byte
+}
+
+// An instruction for rebuilding a file: Count blocks of the old version starting at
+// Block, or Data when Count is zero.
+type deltaOp struct {
+	Block int64
+	Count int64
+	Data  []byte
+}
+
+// Picks the block size for an old version of size bytes. Around its square root like
+// rsync, so the number of checksums and the data per block grow at the same rate.
+func deltaBlockSize(size int64) int {
+	blockSize := int(math.Sqrt(float64(size))) &^ (1<<10 - 1)
+	return min(max(blockSize, deltaMinBlockSize), deltaMaxBlockSize)
+}
+
+// Adler-32 like checksum of a window that can be moved by a byte in constant time.
+type rollingSum struct {
+	a, b   uint32
+	length uint32
+}
+
+func newRollingSum(window []byte) rollingSum {
+	sum := rollingSum{length: uint32(len(window))}
+	for i, c := range window {
+		sum.a += uint32(c)
+		sum.b += uint32(len(window)-i) * uint32(c)
+	}
+	return sum
+}
+
+// Moves the window one byte further, out leaving it and in entering it.
+func (r *rollingSum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.length*uint32(out)
+}
+
+func (r *rollingSum) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+// Splits the contents of r into blocks and returns their checksums.
+func computeBlockSums(r io.Reader, blockSize int) ([]blockSum, error) {
+	var sums []blockSum
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			rolling := newRollingSum(block[:n])
+			sums = append(sums, blockSum{Weak: rolling.sum(), Strong: sha256.Sum256(block[:n])})
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return sums, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Reads the new version of a file from r and sends what the receiver needs to rebuild
+// it from the old version sums were computed for: data not found in the old version to
+// literal and runs of matching blocks to copyBlocks. Returns the bytes read and the
+// bytes sent as literal data.
+func sendDelta(r io.Reader, sums []blockSum, blockSize int, literal io.Writer, copyBlocks func(first, count int64) error) (read, sent int64, err error) {
+	index := make(map[uint32][]int64, len(sums))
+	for i, sum := range sums {
+		index[sum.Weak] = append(index[sum.Weak], int64(i))
+	}
+
+	// Matching blocks are collected into a run until one doesn't follow the last
+	var runFirst, runCount int64
+	flushRun := func() error {
+		if runCount == 0 {
+			return nil
+		}
+		err := copyBlocks(runFirst, runCount)
+		runCount = 0
+		return err
+	}
+	flushLiteral := func(data []byte) error {
+		if len(data) == 0 {
+			return nil
+		}
+		if err := flushRun(); err != nil {
+			return err
+		}
+		n, err := literal.Write(data)
+		sent += int64(n)
+		return err
+	}
+
+	// The buffer holds the pending literal data from start and the window from pos
+	buffer := make([]byte, 0, deltaMaxLiteral+2*blockSize)
+	start, pos := 0, 0
+	eof := false
+	var rolling rollingSum
+	rolled := false // Whether rolling is the checksum of the window at pos
+
+	for {
+		if len(buffer)-pos < blockSize && !eof {
+			if pos-start >= deltaMaxLiteral {
+				if err := flushLiteral(buffer[start:pos]); err != nil {
+					return read, sent, err
+				}
+				start = pos
+			}
+
+			// Keep what is still needed and fill up the rest
+			kept := copy(buffer, buffer[start:])
+			buffer, pos, start = buffer[:kept], pos-start, 0
+
+			n, err := io.ReadFull(r, buffer[kept:cap(buffer)])
+			buffer = buffer[:kept+n]
+			read += int64(n)
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				eof = true
+			} else if err != nil {
+				return read, sent, err
+			}
+			continue
+		}
+
+		// The tail shorter than a block is sent as is
+		if len(buffer)-pos < blockSize {
+			break
+		}
+
+		window := buffer[pos : pos+blockSize]
+		if !rolled {
+			rolling, rolled = newRollingSum(window), true
+		}
+
+		if block, ok := matchBlock(index, sums, rolling.sum(), window, runFirst+runCount); ok {
+			if err := flushLiteral(buffer[start:pos]); err != nil {
+				return read, sent, err
+			}
+			if runCount > 0 && block != runFirst+runCount {
+				if err := flushRun(); err != nil {
+					return read, sent, err
+				}
+			}
+			if runCount == 0 {
+				runFirst = block
+			}
+			runCount++
+
+			pos += blockSize
+			start = pos
+			rolled = false
+			continue
+		}
+
+		if pos+blockSize < len(buffer) {
+			rolling.roll(buffer[pos], buffer[pos+blockSize])
+		} else {
+			rolled = false // The next byte isn't read yet
+		}
+		pos++
+	}
+
+	if err := flushLiteral(buffer[start:]); err != nil {
+		return read, sent, err
+	}
+	return read, sent, flushRun()
+}
+
+// Returns the block of the old version window matches, preferring next so runs of
+// blocks aren't broken up by duplicates.
+func matchBlock(index map[uint32][]int64, sums []blockSum, weak uint32, window []byte, next int64) (int64, bool) {
+	candidates := index[weak]
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	strong := sha256.Sum256(window)
+	if next < int64(len(sums)) && sums[next].Weak == weak && sums[next].Strong == strong {
+		return next, true
+	}
+	for _, block := range candidates {
+		if sums[block].Strong == strong {
+			return block, true
+		}
+	}
+	return 0, false
+}
+
+// Rebuilding from blocks only saves anything when the destination is across a network.
+// Local directories implement it for gosync serve, which rebuilds files for its clients.
+
+func (d *localBackend) BlockSums(relPath string, blockSize int) ([]blockSum, error) {
+	file, err := os.Open(d.path(relPath))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return computeBlockSums(file, blockSize)
+}
+
+// The new version is written next to the old one and renamed over it once it matches.
+func (d *localBackend) CreateDelta(relPath string, blockSize int) (deltaFile, error) {
+	basis, err := os.Open(d.path(relPath))
+	if err != nil {
+		return nil, err
+	}
+
+	tempRelPath := filepath.Join(filepath.Dir(relPath), ".gosync-delta-"+filepath.Base(relPath))
+	file, err := createDestination(d.root, tempRelPath, 0o600)
+	if err != nil {
+		basis.Close()
+		return nil, err
+	}
+
+	return &localDeltaFile{
+		localFile: localFile{File: file},
+		basis:     basis,
+		blockSize: int64(blockSize),
+		hash:      sha256.New(),
+		path:      d.path(relPath),
+	}, nil
+}
+
+// A file in a local directory being rebuilt from the version it replaces.
+type localDeltaFile struct {
+	localFile
+	basis     *os.File
+	blockSize int64
+	hash      hash.Hash // Of everything written so far
+	expected  []byte
+	path      string // Of the old version, replaced on success
+	closed    bool
+	err       error
+}
+
+func (f *localDeltaFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.hash.Write(p[:n])
+	return n, err
+}
+
+func (f *localDeltaFile) CopyBlocks(first, count int64) error {
+	blocks := io.NewSectionReader(f.basis, first*f.blockSize, count*f.blockSize)
+	_, err := copyBuffer(io.MultiWriter(f.File, f.hash), blocks, 0)
+	return err
+}
+
+func (f *localDeltaFile) Expect(sum []byte) {
+	f.expected = sum
+}
+
+func (f *localDeltaFile) Close() error {
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+
+	f.basis.Close()
+	f.err = f.File.Close()
+	switch {
+	case f.err != nil:
+	case f.expected == nil:
+		f.err = fmt.Errorf("update of %s was abandoned", f.path)
+	case !bytes.Equal(f.hash.Sum(nil), f.expected):
+		f.err = fmt.Errorf("%s changed while it was being updated", f.path)
+	}
+
+	if f.err != nil {
+		os.Remove(f.File.Name())
+		return f.err
+	}
+
+	f.err = os.Rename(f.File.Name(), f.path)
+	return f.err
+}
+
+// Returns the checksums of the existing destination file at relPath if only the parts
+// of it that changed should be sent, along with the block size they were computed for.
+func (s *Syncer) deltaBasis(relPath string, destInfo os.FileInfo) ([]blockSum, int, bool) {
+	delta, ok := s.dest.(deltaBackend)
+	if !ok || s.local != nil || s.Options.WholeFile || destInfo == nil || destInfo.Size() < deltaMinFileSize {
+		return nil, 0, false
+	}
+
+	blockSize := deltaBlockSize(destInfo.Size())
+	sums, err := delta.BlockSums(relPath, blockSize)
+	if err != nil {
+		s.logger.Debug().Err(err).Str("path", relPath).Msg("Could not checksum destination file, sending it whole")
+		return nil, 0, false
+	}
+	return sums, blockSize, true
+}
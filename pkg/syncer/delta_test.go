@@ -0,0 +1,158 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	mathrand "math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRollingSum(t *testing.T) {
+	data := make([]byte, 4096)
+	mathrand.NewChaCha8([32]byte{1}).Read(data)
+
+	for _, window := range []int{1, 16, 1000} {
+		rolling := newRollingSum(data[:window])
+		for pos := 1; pos+window <= len(data); pos++ {
+			rolling.roll(data[pos-1], data[pos+window-1])
+			if want := newRollingSum(data[pos : pos+window]); rolling.sum() != want.sum() {
+				t.Fatalf("window of %d rolled to %d sums to %x, want %x", window, pos, rolling.sum(), want.sum())
+			}
+		}
+	}
+}
+
+func TestSendDelta(t *testing.T) {
+	const blockSize = 2 << 10
+	old := make([]byte, 100*blockSize+123)
+	mathrand.NewChaCha8([32]byte{2}).Read(old)
+	other := make([]byte, len(old))
+	mathrand.NewChaCha8([32]byte{3}).Read(other)
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name     string
+		new      []byte
+		wantSent int64 // At most
+	}{
+		{"unchanged", old, 123}, // The tail shorter than a block is always sent
+		{"appended", join(old, []byte("more")), 127},
+		{"prepended", join([]byte("before"), old), 6 + 123},
+		{"inserted", join(old[:50*blockSize+7], []byte("inserted"), old[50*blockSize+7:]), 2*blockSize + 8 + 123},
+		{"removed", join(old[:30*blockSize], old[31*blockSize+1:]), 2 * blockSize},
+		{"byte changed", join(old[:70*blockSize], []byte{^old[70*blockSize]}, old[70*blockSize+1:]), blockSize + 123},
+		{"blocks moved", join(old[60*blockSize:], old[:60*blockSize]), 2*blockSize + 123},
+		{"truncated", old[:40*blockSize+5], 5},
+		{"different", other, int64(len(other))},
+		{"empty", nil, 0},
+		{"longer than a literal piece", join(other, other, other, other, other, other), int64(6 * len(other))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sums, err := computeBlockSums(bytes.NewReader(old), blockSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Rebuilt the way receivers do, from the literal data and the old blocks in order
+			var rebuilt bytes.Buffer
+			read, sent, err := sendDelta(bytes.NewReader(tt.new), sums, blockSize, &rebuilt, func(first, count int64) error {
+				end := min((first+count)*blockSize, int64(len(old)))
+				rebuilt.Write(old[first*blockSize : end])
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(rebuilt.Bytes(), tt.new) {
+				t.Errorf("rebuilt %d bytes that differ from the %d of the new version", rebuilt.Len(), len(tt.new))
+			}
+			if read != int64(len(tt.new)) {
+				t.Errorf("read %d bytes, want %d", read, len(tt.new))
+			}
+			if sent > tt.wantSent {
+				t.Errorf("sent %d bytes, want at most %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestDeltaBlockSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{0, deltaMinBlockSize},
+		{deltaMinFileSize, deltaMinBlockSize},
+		{100 << 20, 10 << 10},
+		{1 << 40, deltaMaxBlockSize},
+	}
+	for _, tt := range tests {
+		if got := deltaBlockSize(tt.size); got != tt.want {
+			t.Errorf("deltaBlockSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+// Changed files synced to gosync serve only send what changed.
+func TestDeltaTransfer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	served := t.TempDir()
+	go Serve(listener, ServeOptions{Root: served, Token: "secret", LogWriter: io.Discard})
+	t.Setenv("GOSYNC_TOKEN", "secret")
+	dest := "gosync://" + listener.Addr().String() + "/dest"
+
+	data := make([]byte, 4<<20)
+	mathrand.NewChaCha8([32]byte{4}).Read(data)
+	src := t.TempDir()
+	writeContents(t, src, map[string]string{"image": string(data)})
+	if _, err := NewSyncer(src, dest, WithOptions(&SyncOptions{})).Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changed in place and grown
+	copy(data[1<<20:], "changed in the middle")
+	data = append(data, "and appended"...)
+	writeContents(t, src, map[string]string{"image": string(data)})
+
+	var log bytes.Buffer
+	logger := zerolog.New(&log).Level(zerolog.InfoLevel)
+	if _, err := NewSyncer(src, dest, WithOptions(&SyncOptions{}), WithLogger(logger)).Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(served, "dest", "image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatal("the served file differs from the source")
+	}
+
+	// What was sent is logged with the copy
+	sent := int64(-1)
+	for scanner := bufio.NewScanner(&log); scanner.Scan(); {
+		var entry struct {
+			Path string
+			Sent *int64
+		}
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Path == "image" && entry.Sent != nil {
+			sent = *entry.Sent
+		}
+	}
+	if blockSize := int64(deltaBlockSize(4 << 20)); sent < 0 || sent > 3*blockSize {
+		t.Errorf("sent %d bytes of the %d of the file, want at most %d", sent, len(data), 3*blockSize)
+	}
+}
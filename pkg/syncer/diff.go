@@ -0,0 +1,249 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// DiffKind tells how a path differs between the source and the destination.
+type DiffKind string
+
+const (
+	DiffOnlyInSource DiffKind = "only-in-source"
+	DiffOnlyInDest   DiffKind = "only-in-dest"
+	DiffContent      DiffKind = "content-differs"  // Size or contents, or a file on one side and a directory on the other
+	DiffMetadata     DiffKind = "metadata-differs" // Same contents, but the mode or the modification time differs
+)
+
+// A path that differs between the source and the destination.
+type Difference struct {
+	Path    string
+	Kind    DiffKind
+	Details []string // What differs with DiffMetadata: "mode" and "mtime"
+}
+
+// Diff compares the source and the destination without changing either and returns
+// their differences sorted by path. Of a directory only on one side, only the directory
+// is listed. Filters apply as in a sync, and files of the same size are compared as
+// Compare says, hashing them with CompareChecksum or when their times are ambiguous.
+// Modes are only compared between local directories.
+func (s *Syncer) Diff(ctx context.Context) ([]Difference, error) {
+	differences, _, err := s.diff(ctx)
+	return differences, err
+}
+
+// Check returns the differences of Diff that leave the destination out of sync, so none
+// means it is current: files the source has that are missing or differ, or only differ
+// in their modification time unless ignoreMtimes is set, and with Delete what only the
+// destination has. Directories without files are left out, syncs don't create them, and
+// so are modes, syncs don't change them on files up to date. ignoreMtimes also has files
+// of the same size hashed instead of judged by their times.
+func (s *Syncer) Check(ctx context.Context, ignoreMtimes bool) ([]Difference, error) {
+	if ignoreMtimes {
+		s.Options.Compare = CompareChecksum
+	}
+	differences, srcEntries, err := s.diff(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var outOfSync []Difference
+	for _, difference := range differences {
+		switch difference.Kind {
+		case DiffOnlyInSource:
+			if !holdsFiles(difference.Path, srcEntries) {
+				continue
+			}
+		case DiffOnlyInDest:
+			if !s.Options.Delete {
+				continue
+			}
+		case DiffMetadata:
+			if ignoreMtimes || !slices.Contains(difference.Details, "mtime") {
+				continue
+			}
+			difference.Details = []string{"mtime"}
+		}
+		outOfSync = append(outOfSync, difference)
+	}
+	return outOfSync, nil
+}
+
+// Compares the trees for Diff, returning the source's entries along with the differences.
+func (s *Syncer) diff(ctx context.Context) ([]Difference, map[string]fs.FileInfo, error) {
+	s.ctx = ctx
+	s.Options.DryRun = true // Comparing may align modification times otherwise
+
+	var err error
+	if s.pathRules, err = filter.CompilePathRules(s.Options.PathRules); err != nil {
+		return nil, nil, err
+	}
+
+	if s.src, err = s.openReadable(s.Options.SourcePath, "the source", false); err != nil {
+		return nil, nil, err
+	}
+	defer s.src.Close()
+	if local, ok := s.src.(*localBackend); ok {
+		s.localSource = local
+	}
+	if s.dest, err = s.openReadable(s.Options.DestinationPath, "the destination", s.Options.Decrypt); err != nil {
+		return nil, nil, err
+	}
+	defer s.dest.Close()
+	if local, ok := s.dest.(*localBackend); ok {
+		s.local = local
+	}
+
+	srcEntries, err := s.listTree(s.src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list source: %w.", err)
+	}
+	destEntries, err := s.listTree(s.dest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list destination: %w.", err)
+	}
+
+	var differences []Difference
+	var mu sync.Mutex
+	add := func(difference Difference) {
+		mu.Lock()
+		defer mu.Unlock()
+		differences = append(differences, difference)
+	}
+
+	// Paths on both sides are compared by the workers, they may have to be hashed
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(s.Options.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for relPath := range paths {
+				if difference, ok := s.diffEntry(relPath, srcEntries[relPath], destEntries[relPath]); ok {
+					add(difference)
+				}
+			}
+		}()
+	}
+	for _, relPath := range sortedPaths(srcEntries) {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, ok := destEntries[relPath]; ok {
+			paths <- relPath
+		} else if !withinListed(relPath, srcEntries, destEntries) {
+			add(Difference{Path: relPath, Kind: DiffOnlyInSource})
+		}
+	}
+	close(paths)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, relPath := range sortedPaths(destEntries) {
+		if _, ok := srcEntries[relPath]; !ok && !withinListed(relPath, destEntries, srcEntries) {
+			differences = append(differences, Difference{Path: relPath, Kind: DiffOnlyInDest})
+		}
+	}
+
+	sort.Slice(differences, func(i, j int) bool { return differences[i].Path < differences[j].Path })
+	return differences, srcEntries, nil
+}
+
+// Compares a path both sides have and returns how they differ, if they do.
+func (s *Syncer) diffEntry(relPath string, srcInfo, destInfo fs.FileInfo) (Difference, bool) {
+	if srcInfo.IsDir() != destInfo.IsDir() {
+		return Difference{Path: relPath, Kind: DiffContent}, true
+	}
+
+	var details []string
+	if s.localSource != nil && s.local != nil && srcInfo.Mode().Perm() != destInfo.Mode().Perm() {
+		details = append(details, "mode")
+	}
+	if !srcInfo.IsDir() {
+		differs, sameTime := s.filesDiffer(relPath, srcInfo, destInfo)
+		if differs {
+			return Difference{Path: relPath, Kind: DiffContent}, true
+		}
+		if !sameTime {
+			details = append(details, "mtime")
+		}
+	}
+
+	if len(details) == 0 {
+		return Difference{}, false
+	}
+	return Difference{Path: relPath, Kind: DiffMetadata, Details: details}, true
+}
+
+// Reports whether the contents of two files differ as Compare judges them, and whether
+// their modification times match within the modify window.
+func (s *Syncer) filesDiffer(relPath string, srcInfo, destInfo fs.FileInfo) (differs, sameTime bool) {
+	srcModTime := srcInfo.ModTime().Truncate(s.dest.ModTimePrecision())
+	delta := srcModTime.Sub(destInfo.ModTime())
+	if delta < 0 {
+		delta = -delta
+	}
+	sameTime = delta <= s.Options.ModifyWindow
+
+	if srcInfo.Size() != destInfo.Size() {
+		return true, sameTime
+	}
+
+	switch {
+	case s.Options.Compare == CompareChecksum:
+	case sameTime:
+		return false, true
+	case s.Options.Compare != CompareAdaptive || delta > s.Options.AmbiguityWindow:
+		return true, false // Taken to differ, as a sync would copy it
+	}
+
+	s.logger.Debug().Str("action", "HASH").Str("path", relPath).Msg("Comparing contents")
+	job := fileJob{src: s.src, srcPath: relPath, relPath: relPath}
+	return s.contentsDiffer(job, srcInfo, destInfo, false), sameTime
+}
+
+// Reports whether a parent directory of relPath is listed in entries but not in other,
+// so relPath is part of a difference already listed.
+func withinListed(relPath string, entries, other map[string]fs.FileInfo) bool {
+	for dir := filepath.Dir(relPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if _, ok := other[dir]; !ok {
+			if _, ok := entries[dir]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether the directory at relPath holds any regular file, as listed in entries.
+func holdsFiles(relPath string, entries map[string]fs.FileInfo) bool {
+	if info, ok := entries[relPath]; ok && !info.IsDir() {
+		return true
+	}
+	prefix := relPath + string(filepath.Separator)
+	for path, info := range entries {
+		if strings.HasPrefix(path, prefix) && info.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedPaths(entries map[string]fs.FileInfo) []string {
+	paths := make([]string, 0, len(entries))
+	for relPath := range entries {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+	return paths
+}
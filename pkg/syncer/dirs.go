@@ -0,0 +1,82 @@
+package syncer
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// A directory created in dirs-only mode, whose modification time is applied once the walk is done.
+type createdDirectory struct {
+	relPath string
+	modTime time.Time
+}
+
+// Recreates a source directory at the destination with the same permissions. Its
+// modification time is set by applyDirectoryTimes, since creating the directories
+// below it would change it again.
+func (s *Syncer) syncDirectory(src Backend, srcPath, relPath string) {
+	destinationPath := filepath.Join(s.Options.DestinationPath, relPath)
+
+	srcInfo, err := src.Stat(srcPath)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not stat source directory")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if err := s.checkContained(relPath, true); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Refusing to write outside of destination")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	destInfo, err := s.dest.Stat(relPath)
+	if err == nil && destInfo.IsDir() && destInfo.Mode().Perm() == srcInfo.Mode().Perm() && destInfo.ModTime().Equal(srcInfo.ModTime().Truncate(s.dest.ModTimePrecision())) {
+		s.preserveOwner(relPath, srcInfo, destInfo, s.lchown(relPath))
+		s.logger.Debug().Str("action", "SKIP_DIR").Str("path", relPath).Msg("Directory is up-to-date, skipping")
+		return
+	}
+
+	logEvent := s.logger.Info().Str("action", "MKDIR").Str("path", relPath)
+
+	if s.Options.DryRun {
+		s.stats.recordDirectory()
+		s.recordPlanned("MKDIR", relPath, "")
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would create directory")
+		return
+	}
+
+	if err := s.dest.MkdirAll(relPath); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Failed to create directories")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if err != nil || !destInfo.IsDir() {
+		destInfo = nil // Created just now, owned by whoever runs the sync
+	}
+	s.preserveOwner(relPath, srcInfo, destInfo, s.lchown(relPath))
+
+	if err := s.dest.Chmod(relPath, srcInfo.Mode().Perm()); err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error setting directory permissions")
+	}
+	if s.local != nil {
+		if err := copyDirAttributes(srcInfo, s.local.path(relPath)); err != nil {
+			s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error preserving directory attributes")
+		}
+	}
+
+	s.createdDirectories = append(s.createdDirectories, createdDirectory{relPath: relPath, modTime: srcInfo.ModTime()})
+	s.stats.recordDirectory()
+	logEvent.Msg("Directory created successfully")
+}
+
+// Sets the modification times of the directories created by syncDirectory.
+func (s *Syncer) applyDirectoryTimes() {
+	for _, dir := range s.createdDirectories {
+		if err := s.dest.Chtimes(dir.relPath, dir.modTime); err != nil {
+			s.logger.Warn().Err(err).Str("path", dir.relPath).Msg("Error preserving modification time")
+		}
+	}
+	s.createdDirectories = nil
+}
@@ -0,0 +1,21 @@
+// Package syncer mirrors a source directory tree into a destination directory.
+//
+// A Syncer is created by NewSyncer from a source, a destination and Options like
+// WithWorkers, or WithOptions for all of SyncOptions, and run with Start, or with
+// StartContext to be able to stop it midway. Files are compared and copied by a pool of
+// workers while the source is walked, and extra destination entries are removed
+// afterwards when Delete is set. Summary and Progress report on the run, and the On
+// callbacks of SyncOptions and the channel of Events on each path as it is handled. Pool
+// lets several Syncers in one process share their workers.
+//
+// Source and destination are opened as a Backend from their location: a local
+// directory, user@host:/path or sftp:// for SFTP, s3://, webdav:// and webdavs://, or
+// gosync:// for a server run by Serve. RegisterBackend adds further schemes. Files a
+// gosync:// destination already holds are updated by sending only the blocks that
+// changed, unless WholeFile is set.
+//
+//	s := syncer.NewSyncer("/data", "/backup", syncer.WithDelete(true))
+//	if _, err := s.Start(); err != nil {
+//		log.Fatal(err)
+//	}
+package syncer
@@ -0,0 +1,686 @@
+package syncer
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// EncryptionKey is what encrypted destinations are encrypted with, and encrypted
+// sources opened with: a passphrase, or a file whose contents are the key.
+type EncryptionKey struct {
+	Passphrase string `json:"-"` // Secrets are left out of saved options, like plans
+	KeyFile    string
+	Salt       string `json:"-"` // Second passphrase of the rclone format, rclone's password2
+}
+
+func (k EncryptionKey) empty() bool {
+	return k.Passphrase == "" && k.KeyFile == ""
+}
+
+const (
+	// Written to the root of an encrypted destination, holding what the key is derived with.
+	encryptionMarker = ".gosync-encrypted"
+
+	// Files are sealed in chunks of this many bytes, each with its own tag, so they can be
+	// streamed and a changed file is noticed.
+	encryptedChunk = 64 << 10
+	encryptedTag   = 16
+
+	// Longest encrypted name stored, the usual limit of file systems.
+	maxEncryptedName = 255
+)
+
+// EncryptFormat picks how encrypted destinations store their files.
+type EncryptFormat string
+
+const (
+	EncryptGosync EncryptFormat = "gosync" // AES-256-GCM, every file under a key of its own
+	EncryptRclone EncryptFormat = "rclone" // That of rclone crypt remotes, for reading them with rclone
+)
+
+// ValidEncryptFormat reports whether f is one of the known formats.
+func ValidEncryptFormat(f EncryptFormat) bool {
+	switch f {
+	case "", EncryptGosync, EncryptRclone:
+		return true
+	}
+	return false
+}
+
+// How an encrypted backend seals the contents of its files.
+type contentCipher interface {
+	headerSize() int
+	minChunks() int64 // Chunks even an empty file has
+
+	// Returns the header of a new file and what seals its chunks.
+	newFile() ([]byte, chunkCipher, error)
+	// Returns what opens the chunks of the file starting with header, or false when it
+	// isn't in the format.
+	openFile(header []byte) (chunkCipher, bool)
+}
+
+// Seals and opens the chunks of one file in order, the last one marked.
+type chunkCipher interface {
+	seal(plain []byte, last bool) []byte // Nothing is written for nil
+	open(sealed []byte, last bool) ([]byte, error)
+}
+
+// How an encrypted backend encrypts names, the same name always the same way so paths
+// can be looked up.
+type nameCipher interface {
+	encrypt(name string) string
+	decrypt(stored string) (string, bool)
+}
+
+// The contents of the marker.
+type encryptionParams struct {
+	Version int           `json:"version"`
+	Format  EncryptFormat `json:"format,omitempty"` // gosync when empty
+	KDF     string        `json:"kdf,omitempty"`    // scrypt for passphrases, hmac-sha256 for key files
+	Salt    []byte        `json:"salt,omitempty"`
+	Names   bool          `json:"names"` // File and directory names are encrypted too
+	Check   []byte        `json:"check"` // Derived from the key, so a wrong one is told apart
+}
+
+// Returns the ciphers of the destination or source that params describe, under key.
+func (params *encryptionParams) ciphers(key EncryptionKey) (contentCipher, nameCipher, error) {
+	if params.Format == EncryptRclone {
+		return params.rcloneCiphers(key)
+	}
+
+	master, err := params.deriveKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	content := gcmContent{key: hmacSHA256(master, "content")}
+	if !params.Names {
+		return content, nil, nil
+	}
+	names, err := newGCMNames(hmacSHA256(master, "names"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, names, nil
+}
+
+// Returns the key of a gosync format destination or source, derived from key.
+func (params *encryptionParams) deriveKey(key EncryptionKey) ([]byte, error) {
+	var master []byte
+	switch params.KDF {
+	case "scrypt":
+		if key.Passphrase == "" {
+			return nil, fmt.Errorf("it is encrypted with a passphrase")
+		}
+		var err error
+		if master, err = scrypt.Key([]byte(key.Passphrase), params.Salt, 1<<15, 8, 1, 32); err != nil {
+			return nil, err
+		}
+	case "hmac-sha256":
+		if key.KeyFile == "" {
+			return nil, fmt.Errorf("it is encrypted with a key file")
+		}
+		contents, err := os.ReadFile(key.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read key file: %w", err)
+		}
+		master = hmacSHA256(params.Salt, string(contents))
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", params.KDF)
+	}
+
+	return master, params.check(master)
+}
+
+// Checks that master is the key of the destination or source, or records it for a new one.
+func (params *encryptionParams) check(master []byte) error {
+	check := hmacSHA256(master, "check")
+	if params.Check == nil {
+		params.Check = check
+	} else if !hmac.Equal(check, params.Check) {
+		return fmt.Errorf("the key doesn't match")
+	}
+	return nil
+}
+
+// A backend whose files are stored encrypted, optionally with their names encrypted too.
+// Its files read back decrypted, and Walk passes them in the order of their stored names.
+type encryptedBackend struct {
+	Backend
+	content contentCipher
+	names   nameCipher // Nil when names are stored as they are
+}
+
+// Returns a backend encrypting the files of backend under key, taking the parameters
+// from its marker. One without a marker is marked first when create is set, unless dryRun
+// is set too, and otherwise taken to be in format, with names encrypted when names is set.
+func openEncrypted(backend Backend, key EncryptionKey, format EncryptFormat, create, names, dryRun bool) (*encryptedBackend, error) {
+	params, err := readEncryptionMarker(backend)
+	isNew := errors.Is(err, fs.ErrNotExist)
+	switch {
+	case isNew && format == EncryptRclone:
+		params = &encryptionParams{Version: 1, Format: EncryptRclone, Names: names}
+	case isNew && !create:
+		return nil, fmt.Errorf("it has no %s, so it wasn't encrypted by gosync", encryptionMarker)
+	case isNew:
+		params = &encryptionParams{Version: 1, KDF: "scrypt", Salt: make([]byte, 16), Names: names}
+		if key.Passphrase == "" {
+			params.KDF = "hmac-sha256"
+		}
+		rand.Read(params.Salt)
+	case err != nil:
+		return nil, err
+	}
+
+	b := &encryptedBackend{Backend: backend}
+	if b.content, b.names, err = params.ciphers(key); err != nil {
+		return nil, err
+	}
+
+	if isNew && create && !dryRun {
+		contents, _ := json.MarshalIndent(params, "", "  ")
+		if err := writeBackendFile(backend, encryptionMarker, contents); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Reads the marker of an encrypted backend, failing with fs.ErrNotExist for others.
+func readEncryptionMarker(backend Backend) (*encryptionParams, error) {
+	file, err := backend.Open(encryptionMarker)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var params encryptionParams
+	if err := json.NewDecoder(io.LimitReader(file, 4096)).Decode(&params); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", encryptionMarker, err)
+	}
+	if params.Version != 1 {
+		return nil, fmt.Errorf("unsupported version %d of %s", params.Version, encryptionMarker)
+	}
+	return &params, nil
+}
+
+// Has the destination store files encrypted, marking it with what the key is derived with.
+func (s *Syncer) encryptDestination() error {
+	if s.Options.EncryptionKey.empty() {
+		return fmt.Errorf("encrypting needs a key file or passphrase.")
+	}
+	dest, err := openEncrypted(s.dest, s.Options.EncryptionKey, s.Options.EncryptFormat, true, s.Options.EncryptNames, s.Options.DryRun)
+	if err != nil {
+		return fmt.Errorf("could not encrypt destination: %w.", err)
+	}
+	s.dest = dest
+	return nil
+}
+
+// Returns backend decrypting what is read from it if it is encrypted, or it as it is
+// unless force is set. what names it in errors, like "the source".
+func (s *Syncer) decrypting(backend Backend, what string, force bool) (Backend, error) {
+	if _, err := backend.Stat(encryptionMarker); err != nil && !force {
+		return backend, nil
+	}
+	if s.Options.EncryptionKey.empty() {
+		return nil, fmt.Errorf("%s is encrypted, a key file or passphrase is needed to read it.", what)
+	}
+	format := s.Options.EncryptFormat
+	if format != EncryptRclone {
+		format = EncryptGosync
+	}
+	decrypted, err := openEncrypted(backend, s.Options.EncryptionKey, format, false, s.Options.EncryptNames, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt %s: %w.", what, err)
+	}
+	return decrypted, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the name the path relPath is stored under.
+func (b *encryptedBackend) path(relPath string) (string, error) {
+	if b.names == nil || relPath == "." {
+		return relPath, nil
+	}
+	parts := strings.Split(relPath, string(filepath.Separator))
+	for i, name := range parts {
+		if parts[i] = b.names.encrypt(name); len(parts[i]) > maxEncryptedName {
+			return "", fmt.Errorf("name %q is too long to be encrypted", name)
+		}
+	}
+	return filepath.Join(parts...), nil
+}
+
+// Undoes path, reporting false for names that weren't encrypted with the key.
+func (b *encryptedBackend) plainPath(storedPath string) (string, bool) {
+	if b.names == nil || storedPath == "." {
+		return storedPath, true
+	}
+	parts := strings.Split(storedPath, string(filepath.Separator))
+	for i, part := range parts {
+		name, ok := b.names.decrypt(part)
+		if !ok {
+			return "", false
+		}
+		parts[i] = name
+	}
+	return filepath.Join(parts...), true
+}
+
+// Returns the size of a file holding size bytes once encrypted, and the other way round.
+func (b *encryptedBackend) encryptedSize(size int64) int64 {
+	chunks := max((size+encryptedChunk-1)/encryptedChunk, b.content.minChunks())
+	return int64(b.content.headerSize()) + size + chunks*encryptedTag
+}
+
+func (b *encryptedBackend) decryptedSize(size int64) int64 {
+	body := size - int64(b.content.headerSize())
+	chunks := (body + encryptedChunk + encryptedTag - 1) / (encryptedChunk + encryptedTag)
+	return max(body-chunks*encryptedTag, 0)
+}
+
+func (b *encryptedBackend) Stat(relPath string) (fs.FileInfo, error) {
+	if relPath == encryptionMarker {
+		return nil, &fs.PathError{Op: "stat", Path: relPath, Err: fs.ErrNotExist}
+	}
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.Backend.Stat(storedPath)
+	if err != nil {
+		return nil, err
+	}
+	return b.decryptedInfo(info, filepath.Base(relPath)), nil
+}
+
+// Returns info of a stored file as it looks decrypted, named name.
+func (b *encryptedBackend) decryptedInfo(info fs.FileInfo, name string) fs.FileInfo {
+	size := info.Size()
+	if info.Mode().IsRegular() {
+		size = b.decryptedSize(size)
+	}
+	return storedFileInfo{FileInfo: info, name: name, size: size}
+}
+
+func (b *encryptedBackend) MkdirAll(relPath string) error {
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return err
+	}
+	return b.Backend.MkdirAll(storedPath)
+}
+
+func (b *encryptedBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return nil, err
+	}
+	if srcInfo != nil && srcInfo.Size() >= 0 {
+		srcInfo = storedFileInfo{FileInfo: srcInfo, name: filepath.Base(storedPath), size: b.encryptedSize(srcInfo.Size())}
+	}
+	header, chunks, err := b.content.newFile()
+	if err != nil {
+		return nil, err
+	}
+	file, err := b.Backend.Create(storedPath, srcInfo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &encryptedFile{BackendFile: file, chunks: chunks, buf: make([]byte, 0, encryptedChunk)}, nil
+}
+
+func (b *encryptedBackend) Open(relPath string) (io.ReadCloser, error) {
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := b.Backend.Open(storedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, b.content.headerSize())
+	if _, err := io.ReadFull(file, header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s is not encrypted", relPath)
+	}
+	chunks, ok := b.content.openFile(header)
+	if !ok {
+		file.Close()
+		return nil, fmt.Errorf("%s is not encrypted", relPath)
+	}
+	return &encryptedReader{file: file, r: bufio.NewReaderSize(file, encryptedChunk+encryptedTag), chunks: chunks, path: relPath}, nil
+}
+
+func (b *encryptedBackend) Chmod(relPath string, mode fs.FileMode) error {
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return err
+	}
+	return b.Backend.Chmod(storedPath, mode)
+}
+
+func (b *encryptedBackend) Chtimes(relPath string, modTime time.Time) error {
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return err
+	}
+	return b.Backend.Chtimes(storedPath, modTime)
+}
+
+func (b *encryptedBackend) Remove(relPath string) error {
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return err
+	}
+	return b.Backend.Remove(storedPath)
+}
+
+func (b *encryptedBackend) Rename(oldRelPath, newRelPath string) error {
+	r, ok := b.Backend.(renamer)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldRelPath, New: newRelPath, Err: errors.ErrUnsupported}
+	}
+	oldPath, err := b.path(oldRelPath)
+	if err != nil {
+		return err
+	}
+	newPath, err := b.path(newRelPath)
+	if err != nil {
+		return err
+	}
+	return r.Rename(oldPath, newPath)
+}
+
+func (b *encryptedBackend) Contained(relPath string, followFinal bool) error {
+	storedPath, err := b.path(relPath)
+	if err != nil {
+		return err
+	}
+	return b.Backend.Contained(storedPath, followFinal)
+}
+
+// Walks the tree passing decrypted paths. What wasn't encrypted with the key is left out.
+func (b *encryptedBackend) Walk(fn fs.WalkDirFunc) error {
+	return b.Backend.Walk(func(storedPath string, d fs.DirEntry, err error) error {
+		if storedPath == encryptionMarker {
+			return nil
+		}
+		relPath, ok := b.plainPath(storedPath)
+		if !ok {
+			if d != nil {
+				return skipEntry(d)
+			}
+			return nil
+		}
+		if err == nil {
+			d = decryptedDirEntry{DirEntry: d, backend: b, name: filepath.Base(relPath)}
+		}
+		return fn(relPath, d, err)
+	})
+}
+
+// A stored entry as it looks decrypted.
+type decryptedDirEntry struct {
+	fs.DirEntry
+	backend *encryptedBackend
+	name    string
+}
+
+func (e decryptedDirEntry) Name() string { return e.name }
+
+func (e decryptedDirEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return e.backend.decryptedInfo(info, e.name), nil
+}
+
+// A file being stored encrypted. A chunk is only sealed once it is known whether more
+// follow, the last one on Sync or Close, whichever comes first.
+type encryptedFile struct {
+	BackendFile
+	chunks   chunkCipher
+	buf      []byte
+	finished bool
+	err      error
+}
+
+func (f *encryptedFile) Write(p []byte) (int, error) {
+	if f.finished {
+		return 0, os.ErrClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(f.buf) == encryptedChunk {
+			if err := f.seal(false); err != nil {
+				return n, err
+			}
+		}
+		written := copy(f.buf[len(f.buf):encryptedChunk], p)
+		f.buf = f.buf[:len(f.buf)+written]
+		p = p[written:]
+		n += written
+	}
+	return n, nil
+}
+
+func (f *encryptedFile) seal(last bool) error {
+	sealed := f.chunks.seal(f.buf, last)
+	f.buf = f.buf[:0]
+	if sealed == nil {
+		return nil
+	}
+	_, err := f.BackendFile.Write(sealed)
+	return err
+}
+
+func (f *encryptedFile) finish() error {
+	if !f.finished {
+		f.finished = true
+		f.err = f.seal(true)
+	}
+	return f.err
+}
+
+func (f *encryptedFile) keep() {
+	if k, ok := f.BackendFile.(keeper); ok {
+		k.keep()
+	}
+}
+
+func (f *encryptedFile) Sync() error {
+	if err := f.finish(); err != nil {
+		return err
+	}
+	return f.BackendFile.Sync()
+}
+
+func (f *encryptedFile) Close() error {
+	if err := f.finish(); err != nil {
+		f.BackendFile.Close()
+		return err
+	}
+	return f.BackendFile.Close()
+}
+
+// Reads a stored file decrypted, failing when it was changed or cut short.
+type encryptedReader struct {
+	file   io.Closer
+	r      *bufio.Reader
+	chunks chunkCipher
+	path   string
+	plain  []byte // Decrypted and not read yet
+	done   bool
+}
+
+func (r *encryptedReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// Decrypts the next chunk.
+func (r *encryptedReader) next() error {
+	sealed := make([]byte, encryptedChunk+encryptedTag)
+	n, err := io.ReadFull(r.r, sealed)
+	last := false
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		_, err := r.r.Peek(1)
+		last = errors.Is(err, io.EOF)
+	}
+
+	if r.plain, err = r.chunks.open(sealed[:n], last); err != nil {
+		return fmt.Errorf("%s is damaged or was changed, it doesn't decrypt", r.path)
+	}
+	r.done = last
+	return nil
+}
+
+func (r *encryptedReader) Close() error {
+	return r.file.Close()
+}
+
+// The gosync format: files start with a magic and a random salt their key is derived
+// from, and are sealed with AES-256-GCM in chunks whose nonce is their index and whether
+// they are the last one, so a file cut short is noticed.
+type gcmContent struct {
+	key []byte
+}
+
+const (
+	gcmMagic = "gse1"
+	gcmSalt  = 16
+)
+
+func (c gcmContent) headerSize() int  { return len(gcmMagic) + gcmSalt }
+func (c gcmContent) minChunks() int64 { return 1 }
+
+func (c gcmContent) newFile() ([]byte, chunkCipher, error) {
+	header := make([]byte, c.headerSize())
+	copy(header, gcmMagic)
+	rand.Read(header[len(gcmMagic):])
+	chunks, _ := c.openFile(header)
+	return header, chunks, nil
+}
+
+func (c gcmContent) openFile(header []byte) (chunkCipher, bool) {
+	if string(header[:len(gcmMagic)]) != gcmMagic {
+		return nil, false
+	}
+	aead, err := newGCM(hmacSHA256(c.key, string(header[len(gcmMagic):])))
+	if err != nil {
+		return nil, false
+	}
+	return &gcmChunks{aead: aead}, true
+}
+
+type gcmChunks struct {
+	aead  cipher.AEAD
+	index uint64
+}
+
+func (c *gcmChunks) nonce(last bool) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], c.index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	c.index++
+	return nonce
+}
+
+func (c *gcmChunks) seal(plain []byte, last bool) []byte {
+	return c.aead.Seal(nil, c.nonce(last), plain, nil)
+}
+
+func (c *gcmChunks) open(sealed []byte, last bool) ([]byte, error) {
+	return c.aead.Open(sealed[:0], c.nonce(last), sealed, nil)
+}
+
+// Names of the gosync format are sealed with AES-256-GCM under a nonce derived from them.
+type gcmNames struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+func newGCMNames(key []byte) (*gcmNames, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcmNames{key: key, aead: aead}, nil
+}
+
+var nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+func (n *gcmNames) encrypt(name string) string {
+	nonce := hmacSHA256(n.key, name)[:n.aead.NonceSize()]
+	return strings.ToLower(nameEncoding.EncodeToString(n.aead.Seal(nonce, nonce, []byte(name), nil)))
+}
+
+func (n *gcmNames) decrypt(stored string) (string, bool) {
+	sealed, err := nameEncoding.DecodeString(strings.ToUpper(stored))
+	if err != nil || len(sealed) < n.aead.NonceSize() {
+		return "", false
+	}
+	name, err := n.aead.Open(nil, sealed[:n.aead.NonceSize()], sealed[n.aead.NonceSize():], nil)
+	if err != nil {
+		return "", false
+	}
+	return string(name), true
+}
+
+// Encrypted reports whether the tree at location was encrypted by a sync with Encrypt.
+func Encrypted(location string) (bool, error) {
+	backend, err := OpenBackend(location)
+	if err != nil {
+		return false, err
+	}
+	defer backend.Close()
+
+	_, err = backend.Stat(encryptionMarker)
+	return err == nil, nil
+}
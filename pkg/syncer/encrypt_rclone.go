@@ -0,0 +1,219 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The format of rclone crypt remotes, with a password and optionally password2, and
+// standard or no name encryption: files are sealed with NaCl secretbox in chunks under
+// an incrementing nonce, names with AES-EME and base32.
+
+const rcloneMagic = "RCLONE\x00\x00"
+
+// Salt rclone derives keys with when password2 isn't set.
+var rcloneSalt = []byte{0xA8, 0x0D, 0xF4, 0x3A, 0x8F, 0xBD, 0x03, 0x08, 0xA7, 0xCA, 0xB8, 0x3E, 0x58, 0x1F, 0x86, 0xB1}
+
+// Returns the ciphers of an rclone format destination or source, under key.
+func (params *encryptionParams) rcloneCiphers(key EncryptionKey) (contentCipher, nameCipher, error) {
+	if key.Passphrase == "" {
+		return nil, nil, fmt.Errorf("the rclone format takes a passphrase, not a key file")
+	}
+	salt := rcloneSalt
+	if key.Salt != "" {
+		salt = []byte(key.Salt)
+	}
+	derived, err := scrypt.Key([]byte(key.Passphrase), salt, 16384, 8, 1, 32+32+16)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := params.check(derived); err != nil {
+		return nil, nil, err
+	}
+
+	var content rcloneContent
+	copy(content.key[:], derived[:32])
+	if !params.Names {
+		return content, nil, nil
+	}
+	block, err := aes.NewCipher(derived[32:64])
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, &emeNames{block: block, tweak: derived[64:]}, nil
+}
+
+type rcloneContent struct {
+	key [32]byte
+}
+
+func (c rcloneContent) headerSize() int  { return len(rcloneMagic) + 24 }
+func (c rcloneContent) minChunks() int64 { return 0 }
+
+func (c rcloneContent) newFile() ([]byte, chunkCipher, error) {
+	header := make([]byte, c.headerSize())
+	copy(header, rcloneMagic)
+	if _, err := rand.Read(header[len(rcloneMagic):]); err != nil {
+		return nil, nil, err
+	}
+	chunks, _ := c.openFile(header)
+	return header, chunks, nil
+}
+
+func (c rcloneContent) openFile(header []byte) (chunkCipher, bool) {
+	if string(header[:len(rcloneMagic)]) != rcloneMagic {
+		return nil, false
+	}
+	chunks := &secretboxChunks{key: &c.key}
+	copy(chunks.nonce[:], header[len(rcloneMagic):])
+	return chunks, true
+}
+
+// Chunks of the rclone format. Files cut short at a chunk boundary aren't noticed, the
+// format doesn't mark the last one.
+type secretboxChunks struct {
+	key   *[32]byte
+	nonce [24]byte
+}
+
+// Adds one to the nonce, read as a little-endian number.
+func (c *secretboxChunks) increment() {
+	for i := range c.nonce {
+		c.nonce[i]++
+		if c.nonce[i] != 0 {
+			break
+		}
+	}
+}
+
+func (c *secretboxChunks) seal(plain []byte, last bool) []byte {
+	if len(plain) == 0 {
+		return nil // Empty files are only the header
+	}
+	sealed := secretbox.Seal(nil, plain, &c.nonce, c.key)
+	c.increment()
+	return sealed
+}
+
+func (c *secretboxChunks) open(sealed []byte, last bool) ([]byte, error) {
+	if len(sealed) == 0 && last {
+		return nil, nil
+	}
+	plain, ok := secretbox.Open(nil, sealed, &c.nonce, c.key)
+	if !ok {
+		return nil, fmt.Errorf("chunk doesn't decrypt")
+	}
+	c.increment()
+	return plain, nil
+}
+
+// Names of the rclone format, padded with PKCS#7 and encrypted with EME, which is wide
+// block AES, so equal names encrypt the same without leaking common prefixes.
+type emeNames struct {
+	block cipher.Block
+	tweak []byte
+}
+
+func (n *emeNames) encrypt(name string) string {
+	padding := aes.BlockSize - len(name)%aes.BlockSize
+	padded := append([]byte(name), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	return strings.ToLower(nameEncoding.EncodeToString(emeTransform(n.block, n.tweak, padded, true)))
+}
+
+func (n *emeNames) decrypt(stored string) (string, bool) {
+	sealed, err := nameEncoding.DecodeString(strings.ToUpper(stored))
+	if err != nil || len(sealed) == 0 || len(sealed)%aes.BlockSize != 0 || len(sealed) > 128*aes.BlockSize {
+		return "", false
+	}
+	padded := emeTransform(n.block, n.tweak, sealed, false)
+	padding := int(padded[len(padded)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(padded[len(padded)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return "", false
+	}
+	return string(padded[:len(padded)-padding]), true
+}
+
+// Encrypts or decrypts data, a multiple of the block size and at most 128 blocks, with
+// EME (ECB-Mix-ECB) as rclone does.
+func emeTransform(block cipher.Block, tweak, data []byte, encrypt bool) []byte {
+	transform := block.Decrypt
+	if encrypt {
+		transform = block.Encrypt
+	}
+	m := len(data) / aes.BlockSize
+
+	// L is AES(0) doubled once for every block
+	l := make([][]byte, m)
+	li := make([]byte, aes.BlockSize)
+	block.Encrypt(li, li)
+	for j := range l {
+		li = double(li)
+		l[j] = li
+	}
+
+	out := make([]byte, len(data))
+	for j := 0; j < m; j++ {
+		chunk := out[j*aes.BlockSize : (j+1)*aes.BlockSize]
+		xorBlock(chunk, data[j*aes.BlockSize:(j+1)*aes.BlockSize], l[j])
+		transform(chunk, chunk)
+	}
+
+	mp := make([]byte, aes.BlockSize)
+	xorBlock(mp, out[:aes.BlockSize], tweak)
+	for j := 1; j < m; j++ {
+		xorBlock(mp, mp, out[j*aes.BlockSize:(j+1)*aes.BlockSize])
+	}
+	mc := make([]byte, aes.BlockSize)
+	transform(mc, mp)
+	mask := make([]byte, aes.BlockSize)
+	xorBlock(mask, mp, mc)
+
+	for j := 1; j < m; j++ {
+		mask = double(mask)
+		chunk := out[j*aes.BlockSize : (j+1)*aes.BlockSize]
+		xorBlock(chunk, chunk, mask)
+	}
+
+	first := make([]byte, aes.BlockSize)
+	xorBlock(first, mc, tweak)
+	for j := 1; j < m; j++ {
+		xorBlock(first, first, out[j*aes.BlockSize:(j+1)*aes.BlockSize])
+	}
+	copy(out, first)
+
+	for j := 0; j < m; j++ {
+		chunk := out[j*aes.BlockSize : (j+1)*aes.BlockSize]
+		transform(chunk, chunk)
+		xorBlock(chunk, chunk, l[j])
+	}
+	return out
+}
+
+// Returns b multiplied by two in GF(2^128), little-endian as EME has it.
+func double(b []byte) []byte {
+	out := make([]byte, len(b))
+	out[0] = b[0] << 1
+	if b[len(b)-1] >= 0x80 {
+		out[0] ^= 0x87
+	}
+	for j := 1; j < len(b); j++ {
+		out[j] = b[j] << 1
+		if b[j-1] >= 0x80 {
+			out[j]++
+		}
+	}
+	return out
+}
+
+func xorBlock(out, a, b []byte) {
+	for i := range out {
+		out[i] = a[i] ^ b[i]
+	}
+}
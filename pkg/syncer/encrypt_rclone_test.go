@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestRcloneNames(t *testing.T) {
+	// Those of rclone's own tests, under the all zero keys of an empty password
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := &emeNames{block: block, tweak: make([]byte, 16)}
+
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{"1", "p0e52nreeaj0a5ea7s64m4j72s"},
+		{"12", "l42g6771hnv3an9cgc8cr2n1ng"},
+		{"123", "qgm4avr35m5loi1th53ato71v0"},
+	}
+	for _, tt := range tests {
+		if got := names.encrypt(tt.name); got != tt.stored {
+			t.Errorf("encrypt(%q) = %q, want %q", tt.name, got, tt.stored)
+		}
+		if got, ok := names.decrypt(tt.stored); !ok || got != tt.name {
+			t.Errorf("decrypt(%q) = %q, %v, want %q", tt.stored, got, ok, tt.name)
+		}
+	}
+
+	for _, stored := range []string{"", "not base32!", "p0e52nreeaj0a5ea", "q0e52nreeaj0a5ea7s64m4j72s"} {
+		if got, ok := names.decrypt(stored); ok {
+			t.Errorf("decrypt(%q) = %q, want it refused", stored, got)
+		}
+	}
+}
+
+func TestRcloneRoundtrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   EncryptionKey
+		names bool
+	}{
+		{"password", EncryptionKey{Passphrase: "secret"}, false},
+		{"password2", EncryptionKey{Passphrase: "secret", Salt: "pepper"}, false},
+		{"standard names", EncryptionKey{Passphrase: "secret"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, encrypted, restored := t.TempDir(), t.TempDir(), t.TempDir()
+			files := encryptedTestFiles()
+			writeContents(t, src, files)
+
+			options := SyncOptions{Encrypt: true, EncryptFormat: EncryptRclone, EncryptNames: tt.names, EncryptionKey: tt.key}
+			if _, err := NewSyncer(src, encrypted, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Stored as rclone stores it: a magic and a nonce, then the contents sealed
+			// with secretbox in chunks of 64 KiB, the nonce incremented for each
+			salt := rcloneSalt
+			if tt.key.Salt != "" {
+				salt = []byte(tt.key.Salt)
+			}
+			derived, err := scrypt.Key([]byte(tt.key.Passphrase), salt, 16384, 8, 1, 80)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var key [32]byte
+			copy(key[:], derived)
+
+			data, err := os.ReadFile(largestFile(t, encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte(rcloneMagic)) {
+				t.Fatalf("stored file starts with %q, want %q", data[:8], rcloneMagic)
+			}
+			var nonce [24]byte
+			copy(nonce[:], data[len(rcloneMagic):])
+			var plain []byte
+			for sealed := data[len(rcloneMagic)+24:]; len(sealed) > 0; {
+				chunk := sealed[:min(len(sealed), 64<<10+secretbox.Overhead)]
+				opened, ok := secretbox.Open(nil, chunk, &nonce, &key)
+				if !ok {
+					t.Fatalf("chunk %d doesn't open", len(plain)/(64<<10))
+				}
+				plain = append(plain, opened...)
+				sealed = sealed[len(chunk):]
+				for i := range nonce {
+					if nonce[i]++; nonce[i] != 0 {
+						break
+					}
+				}
+			}
+			if string(plain) != files["dir/sub/big.bin"] {
+				t.Errorf("stored file opens to %d bytes, not the %d of the source", len(plain), len(files["dir/sub/big.bin"]))
+			}
+
+			stored := treeFiles(t, encrypted)
+			if got := slices.Contains(stored, "plain.txt"); got == tt.names {
+				t.Errorf("destination holds %q, plain names %v, want %v", stored, got, !tt.names)
+			}
+
+			// rclone crypt remotes have no marker, they are decrypted when asked to
+			if err := os.Remove(filepath.Join(encrypted, encryptionMarker)); err != nil {
+				t.Fatal(err)
+			}
+			options = SyncOptions{Decrypt: true, EncryptFormat: EncryptRclone, EncryptNames: tt.names, EncryptionKey: tt.key}
+			if _, err := NewSyncer(encrypted, restored, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+			if got := treeContents(t, restored); !maps.Equal(got, files) {
+				t.Errorf("restored %q, want %q", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(files)))
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Writes the files of contents below root, by their slash separated paths.
+func writeContents(t *testing.T, root string, contents map[string]string) {
+	t.Helper()
+	for file, data := range contents {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the path of the largest regular file below root.
+func largestFile(t *testing.T, root string) string {
+	t.Helper()
+	var largest string
+	var size int64 = -1
+	for _, file := range treeFiles(t, root) {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > size {
+			largest, size = path, info.Size()
+		}
+	}
+	return largest
+}
+
+// Files spanning no, one and several chunks.
+func encryptedTestFiles() map[string]string {
+	big := make([]byte, 3*encryptedChunk+100)
+	rand.Read(big)
+	return map[string]string{
+		"empty":           "",
+		"plain.txt":       "readable text",
+		"dir/sub/big.bin": string(big),
+		"dir/exact":       strings.Repeat("x", encryptedChunk),
+	}
+}
+
+func TestEncryptRoundtrip(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   EncryptionKey
+		names bool
+		wrong EncryptionKey
+	}{
+		{"passphrase", EncryptionKey{Passphrase: "secret"}, false, EncryptionKey{Passphrase: "guess"}},
+		{"key file", EncryptionKey{KeyFile: keyFile}, false, EncryptionKey{Passphrase: "secret"}},
+		{"encrypted names", EncryptionKey{Passphrase: "secret"}, true, EncryptionKey{Passphrase: "guess"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, encrypted, restored := t.TempDir(), t.TempDir(), t.TempDir()
+			files := encryptedTestFiles()
+			writeContents(t, src, files)
+
+			options := SyncOptions{Encrypt: true, EncryptNames: tt.names, EncryptionKey: tt.key}
+			if _, err := NewSyncer(src, encrypted, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Neither contents nor, when encrypted, names are stored as they are
+			stored := treeFiles(t, encrypted)
+			if !slices.Contains(stored, encryptionMarker) {
+				t.Errorf("destination holds %q, no %s", stored, encryptionMarker)
+			}
+			if got := slices.Contains(stored, "plain.txt"); got == tt.names {
+				t.Errorf("destination holds %q, plain names %v, want %v", stored, got, !tt.names)
+			}
+			for file, data := range treeContents(t, encrypted) {
+				if strings.Contains(data, "readable text") {
+					t.Errorf("%s holds the plaintext", file)
+				}
+			}
+
+			// Syncing from an encrypted source decrypts it
+			options = SyncOptions{EncryptionKey: tt.key}
+			if _, err := NewSyncer(encrypted, restored, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+			if got := treeContents(t, restored); !maps.Equal(got, files) {
+				t.Errorf("restored %d files, want the %d synced", len(got), len(files))
+			}
+
+			// Changes are synced like to any destination
+			files["plain.txt"] = "changed text"
+			delete(files, "dir/exact")
+			writeContents(t, src, map[string]string{"plain.txt": "changed text"})
+			os.Remove(filepath.Join(src, "dir", "exact"))
+			options = SyncOptions{Encrypt: true, EncryptNames: tt.names, EncryptionKey: tt.key, Delete: true}
+			if _, err := NewSyncer(src, encrypted, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+			options = SyncOptions{EncryptionKey: tt.key, Delete: true}
+			if _, err := NewSyncer(encrypted, restored, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+			if got := treeContents(t, restored); !maps.Equal(got, files) {
+				t.Errorf("restored %q after the changes, want %q", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(files)))
+			}
+
+			// Another key opens nothing
+			options = SyncOptions{EncryptionKey: tt.wrong}
+			if _, err := NewSyncer(encrypted, t.TempDir(), WithOptions(&options)).Start(); err == nil {
+				t.Error("decrypting with another key succeeded")
+			}
+			options = SyncOptions{Encrypt: true, EncryptionKey: tt.wrong}
+			if _, err := NewSyncer(src, encrypted, WithOptions(&options)).Start(); err == nil {
+				t.Error("encrypting with another key succeeded")
+			}
+		})
+	}
+}
+
+func TestEncryptDetectsChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(data []byte) []byte
+	}{
+		{"flipped bit", func(data []byte) []byte { data[len(data)/2] ^= 1; return data }},
+		{"last chunk dropped", func(data []byte) []byte { return data[:len(data)-100-encryptedTag] }},
+		{"cut short", func(data []byte) []byte { return data[:len(data)-1] }},
+		{"chunks swapped", func(data []byte) []byte {
+			header, chunk := len(gcmMagic)+gcmSalt, encryptedChunk+encryptedTag
+			first := bytes.Clone(data[header : header+chunk])
+			copy(data[header:], data[header+chunk:header+2*chunk])
+			copy(data[header+chunk:], first)
+			return data
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, encrypted := t.TempDir(), t.TempDir()
+			writeContents(t, src, encryptedTestFiles())
+			options := SyncOptions{Encrypt: true, EncryptionKey: EncryptionKey{Passphrase: "secret"}}
+			if _, err := NewSyncer(src, encrypted, WithOptions(&options)).Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			path := largestFile(t, encrypted)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.change(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			restored := t.TempDir()
+			options = SyncOptions{EncryptionKey: EncryptionKey{Passphrase: "secret"}}
+			result, _ := NewSyncer(encrypted, restored, WithOptions(&options)).Start()
+			if len(result.Errors) != 1 {
+				t.Errorf("restoring failed for %d files, want the changed one", len(result.Errors))
+			}
+			if slices.Contains(treeFiles(t, restored), "dir/sub/big.bin") {
+				t.Error("the changed file was restored")
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package syncer
+
+import (
+	"io/fs"
+	"time"
+)
+
+// EventKind tells what a SyncEvent reports.
+type EventKind int
+
+const (
+	EventScanned EventKind = iota // The walker found a source path that is synced
+	EventQueued                   // A file was handed to the workers
+	EventCopied                   // A file was copied, or would be in a dry run
+	EventDeleted                  // A destination path was deleted, or would be in a dry run
+	EventErrored                  // A path failed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventScanned:
+		return "scanned"
+	case EventQueued:
+		return "queued"
+	case EventCopied:
+		return "copied"
+	case EventDeleted:
+		return "deleted"
+	case EventErrored:
+		return "errored"
+	}
+	return "unknown"
+}
+
+// SyncEvent is something a sync did to one path.
+type SyncEvent struct {
+	Kind     EventKind
+	Path     string        // Relative to the source and destination
+	Size     int64         // Of the file, or the bytes copied, when known
+	Duration time.Duration // How long a copy took, zero in a dry run
+	Err      error         // Why the path failed
+	Time     time.Time
+}
+
+// Files events can get ahead of their receiver before a sync waits for it.
+const eventBuffer = 256
+
+// Events returns a channel receiving an event for every path the next sync scans,
+// queues, copies, deletes or fails on, closed once Start, StartContext or Watch returns.
+// It must be called before the sync starts, and the channel received from until it is
+// closed, the sync waits for its receiver. Syncs that never call it send no events.
+func (s *Syncer) Events() <-chan SyncEvent {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	if s.stats.events == nil {
+		s.stats.events = make(chan SyncEvent, eventBuffer)
+	}
+	return s.stats.events
+}
+
+// Sends event to the receiver of Events, if there is one.
+func (c *statsCollector) emit(event SyncEvent) {
+	if c.events == nil {
+		return
+	}
+	event.Time = time.Now()
+	c.events <- event
+}
+
+// Sends an EventScanned for the source entry d at relPath.
+func (c *statsCollector) recordScanned(relPath string, d fs.DirEntry) {
+	if c.events == nil {
+		return
+	}
+	event := SyncEvent{Kind: EventScanned, Path: relPath}
+	if !d.IsDir() {
+		if info, err := d.Info(); err == nil {
+			event.Size = info.Size()
+		}
+	}
+	c.emit(event)
+}
+
+// Closes the channel of Events at the end of a sync, later syncs send no events.
+func (c *statsCollector) closeEvents() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.events != nil {
+		close(c.events)
+		c.events = nil
+	}
+}
@@ -0,0 +1,195 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Fan-out syncs one source to several destinations in a run. Every destination gets a
+// Syncer of its own and they all run at once, but the source is walked only once: the
+// first of them to start walking does so for real and the others go through the entries
+// it finds as it finds them. Source files hashed for one destination aren't hashed again
+// for the others.
+
+// Entries of a source walk, shared by the Syncers of a fan-out.
+type sourceListing struct {
+	mu      sync.Mutex
+	added   *sync.Cond
+	entries []listedEntry
+	started bool // Whether a Syncer is walking the source
+	done    bool
+	err     error // Of the walk, once done
+}
+
+type listedEntry struct {
+	relPath string
+	d       fs.DirEntry
+	err     error
+}
+
+func newSourceListing() *sourceListing {
+	l := &sourceListing{}
+	l.added = sync.NewCond(&l.mu)
+	return l
+}
+
+// Walks src like its Walk method, the first caller really and the others through what
+// it found. Directories the first caller skipped aren't listed, which is fine as long as
+// every caller skips the same ones.
+func (l *sourceListing) walk(src Backend, fn fs.WalkDirFunc) error {
+	l.mu.Lock()
+	if l.started {
+		l.mu.Unlock()
+		return l.replay(fn)
+	}
+	l.started = true
+	l.mu.Unlock()
+
+	err := src.Walk(func(relPath string, d fs.DirEntry, err error) error {
+		l.mu.Lock()
+		l.entries = append(l.entries, listedEntry{relPath, d, err})
+		l.added.Broadcast()
+		l.mu.Unlock()
+		return fn(relPath, d, err)
+	})
+
+	l.mu.Lock()
+	l.done, l.err = true, err
+	l.added.Broadcast()
+	l.mu.Unlock()
+	return err
+}
+
+// Hands the listed entries to fn as they come in, honouring filepath.SkipDir and
+// filepath.SkipAll the way fs.WalkDir does.
+func (l *sourceListing) replay(fn fs.WalkDirFunc) error {
+	skipped := "" // Entries below this prefix are left out
+	for i := 0; ; i++ {
+		l.mu.Lock()
+		for i >= len(l.entries) && !l.done {
+			l.added.Wait()
+		}
+		if i >= len(l.entries) {
+			err := l.err
+			l.mu.Unlock()
+			return err
+		}
+		entry := l.entries[i]
+		l.mu.Unlock()
+
+		if skipped != "" && strings.HasPrefix(entry.relPath, skipped) {
+			continue
+		}
+
+		err := fn(entry.relPath, entry.d, entry.err)
+		switch {
+		case errors.Is(err, filepath.SkipAll):
+			return nil
+		case errors.Is(err, filepath.SkipDir):
+			// A file skips the rest of its directory
+			dir := entry.relPath
+			if entry.d == nil || !entry.d.IsDir() {
+				dir = filepath.Dir(dir)
+			}
+			if dir == "." {
+				return nil
+			}
+			skipped = dir + string(filepath.Separator)
+		case err != nil:
+			return err
+		}
+	}
+}
+
+// Digests of source files by path, shared by the Syncers of a fan-out.
+type sourceSums struct {
+	mu   sync.Mutex
+	sums map[string]*sourceSum
+}
+
+type sourceSum struct {
+	once sync.Once
+	sum  []byte
+	err  error
+}
+
+// Returns the digest of the source file at relPath, calling hash for it only the first
+// time it is asked for.
+func (c *sourceSums) get(relPath string, hash func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	entry, ok := c.sums[relPath]
+	if !ok {
+		entry = &sourceSum{}
+		c.sums[relPath] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() { entry.sum, entry.err = hash() })
+	return entry.sum, entry.err
+}
+
+// Syncs the source to DestinationPath and every one of ExtraDestinations at once, with a
+// Syncer for each of the extra ones sharing the walk, the hashes, the logger and the
+// statistics of s.
+func (s *Syncer) syncFanOut(ctx context.Context) error {
+	switch {
+	case s.Options.TwoWay:
+		return fmt.Errorf("two-way syncs can only have one destination.")
+	case s.watchCtx != nil:
+		return fmt.Errorf("only syncs to one destination can be watched.")
+	case s.Options.RecordPlan:
+		return fmt.Errorf("only syncs to one destination can be planned ahead.")
+	}
+
+	s.listing = newSourceListing()
+	s.sourceSums = &sourceSums{sums: make(map[string]*sourceSum)}
+
+	errs := make([]error, len(s.Options.ExtraDestinations))
+	var wg sync.WaitGroup
+	for i, destination := range s.Options.ExtraDestinations {
+		peer := s.fanOutPeer(destination, ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := peer.run(ctx); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", destination, err)
+			}
+		}()
+	}
+
+	err := s.run(ctx)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", s.Options.DestinationPath, err)
+	}
+	wg.Wait()
+	return errors.Join(append([]error{err}, errs...)...)
+}
+
+// Returns a Syncer to the destination at path that takes part in the fan-out of s, running
+// until ctx is done.
+func (s *Syncer) fanOutPeer(path string, ctx context.Context) *Syncer {
+	options := *s.Options
+	options.DestinationPath = path
+	options.ExtraDestinations = nil
+
+	return &Syncer{
+		Options:    &options,
+		fileOps:    make(chan fileJob, options.QueueSize),
+		logger:     s.logger,
+		matcher:    s.matcher,
+		stats:      s.stats,
+		ctx:        ctx,
+		listing:    s.listing,
+		sourceSums: s.sourceSums,
+
+		shadowSource: s.shadowSource,
+
+		markedDirectories:     make(map[string]struct{}),
+		unreadableDirectories: make(map[string]struct{}),
+	}
+}
@@ -0,0 +1,37 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Creates a new file at path for writing without following a symlink in its place.
+// A regular file or symlink already there is removed first, so nothing that was planted
+// at path between the checks and the open can redirect the write.
+func createExclusive(path string, perm os.FileMode) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL | noFollowFlag
+
+	file, err := os.OpenFile(path, flags, perm)
+	if !errors.Is(err, os.ErrExist) {
+		return file, err
+	}
+
+	if err := removeReplaceable(path); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, flags, perm)
+}
+
+// Removes the file or symlink at path so it can be recreated. Anything else is left alone.
+func removeReplaceable(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is not a regular file", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package syncer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const noFollowFlag = syscall.O_NOFOLLOW
+
+// Creates a new destination file at relPath below root. The parent directory is resolved
+// with RESOLVE_BENEATH and the file is created relative to it with O_EXCL|O_NOFOLLOW, so
+// neither a swapped parent nor a planted symlink can redirect the write.
+func createDestination(root, relPath string, perm os.FileMode) (*os.File, error) {
+	path := filepath.Join(root, relPath)
+
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(rootFd)
+
+	parentFd, err := unix.Openat2(rootFd, filepath.Dir(relPath), &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	switch {
+	case errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EPERM):
+		return createExclusive(path, perm) // openat2 needs Linux 5.6 and may be filtered
+	case errors.Is(err, unix.EXDEV), errors.Is(err, unix.ELOOP):
+		return nil, errEscapesDestination
+	case err != nil:
+		return nil, &os.PathError{Op: "openat2", Path: filepath.Dir(path), Err: err}
+	}
+	defer unix.Close(parentFd)
+
+	name := filepath.Base(relPath)
+	flags := unix.O_WRONLY | unix.O_CREAT | unix.O_EXCL | unix.O_NOFOLLOW | unix.O_CLOEXEC
+
+	fd, err := unix.Openat(parentFd, name, flags, uint32(perm.Perm()))
+	if errors.Is(err, unix.EEXIST) {
+		var stat unix.Stat_t
+		if err := unix.Fstatat(parentFd, name, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return nil, &os.PathError{Op: "fstatat", Path: path, Err: err}
+		}
+
+		fileType := stat.Mode & unix.S_IFMT
+		if fileType != unix.S_IFREG && fileType != unix.S_IFLNK {
+			return nil, &os.PathError{Op: "create", Path: path, Err: errors.New("exists and is not a regular file")}
+		}
+		if err := unix.Unlinkat(parentFd, name, 0); err != nil {
+			return nil, &os.PathError{Op: "unlinkat", Path: path, Err: err}
+		}
+
+		fd, err = unix.Openat(parentFd, name, flags, uint32(perm.Perm()))
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: path, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// Opens an existing file to change its metadata, without following a symlink in its place.
+func openForMetadata(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY|noFollowFlag, 0)
+}
+
+// Sets the access and modification times of the open file (futimens).
+func setFileTimes(file *os.File, atime, mtime time.Time) error {
+	times := [2]unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(mtime.UnixNano()),
+	}
+
+	// utimensat with a NULL path operates on the descriptor itself
+	_, _, errno := unix.Syscall6(unix.SYS_UTIMENSAT, file.Fd(), 0, uintptr(unsafe.Pointer(&times[0])), 0, 0, 0)
+	if errno != 0 {
+		return &os.PathError{Op: "futimens", Path: file.Name(), Err: errno}
+	}
+	return nil
+}
+
+// Drops the cached pages of the open file, so it is read back from the media. The file
+// has to be synced first, dirty pages aren't dropped.
+func dropCache(file *os.File) {
+	unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,41 @@
+//go:build unix && !linux
+
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const noFollowFlag = syscall.O_NOFOLLOW
+
+// Creates a new destination file at relPath below root without following a symlink in its place.
+func createDestination(root, relPath string, perm os.FileMode) (*os.File, error) {
+	return createExclusive(filepath.Join(root, relPath), perm)
+}
+
+// Opens an existing file to change its metadata, without following a symlink in its place.
+func openForMetadata(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY|noFollowFlag, 0)
+}
+
+// Sets the access and modification times of the open file. There is no futimens wrapper
+// here, so the times are set by name without following a symlink that replaced it.
+func setFileTimes(file *os.File, atime, mtime time.Time) error {
+	times := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(mtime.UnixNano()),
+	}
+
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, file.Name(), times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "utimensat", Path: file.Name(), Err: err}
+	}
+	return nil
+}
+
+// There is no portable way to drop cached pages of a single file here.
+func dropCache(file *os.File) {}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// CREATE_NEW never follows an existing reparse point, so O_EXCL is enough here.
+const noFollowFlag = 0
+
+// Creates a new destination file at relPath below root without following a symlink in its place.
+func createDestination(root, relPath string, perm os.FileMode) (*os.File, error) {
+	return createExclusive(filepath.Join(root, relPath), perm)
+}
+
+// Opens an existing file to change its metadata. Setting times needs a writable handle.
+func openForMetadata(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// Sets the access and modification times of the open file through its handle.
+func setFileTimes(file *os.File, atime, mtime time.Time) error {
+	accessTime := windows.NsecToFiletime(atime.UnixNano())
+	writeTime := windows.NsecToFiletime(mtime.UnixNano())
+
+	if err := windows.SetFileTime(windows.Handle(file.Fd()), nil, &accessTime, &writeTime); err != nil {
+		return &os.PathError{Op: "SetFileTime", Path: file.Name(), Err: err}
+	}
+	return nil
+}
+
+// Windows has no way to drop cached pages of a single file.
+func dropCache(file *os.File) {}
@@ -0,0 +1,78 @@
+package syncer
+
+import (
+	"io/fs"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// Reports whether the file is excluded by the content type filters. Only called from
+// the workers, since sniffing means reading the start of every file.
+func (s *Syncer) filteredByContentType(job fileJob) bool {
+	if len(s.Options.IncludeTypes) == 0 && len(s.Options.ExcludeTypes) == 0 {
+		return false
+	}
+
+	relPath := job.relPath
+	contentType, err := sniffContentType(job)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not detect content type")
+		s.stats.recordError(relPath, err)
+		return true
+	}
+
+	if len(s.Options.IncludeTypes) > 0 && !filter.MatchContentType(contentType, s.Options.IncludeTypes) {
+		s.stats.recordSkipped(relPath, "SKIP_TYPE")
+		s.logger.Debug().Str("action", "SKIP_TYPE").Str("path", relPath).Str("type", contentType).Msg("Content type is not included, skipping")
+		return true
+	}
+
+	if filter.MatchContentType(contentType, s.Options.ExcludeTypes) {
+		s.stats.recordSkipped(relPath, "SKIP_TYPE")
+		s.logger.Debug().Str("action", "SKIP_TYPE").Str("path", relPath).Str("type", contentType).Msg("Content type is excluded, skipping")
+		return true
+	}
+
+	return false
+}
+
+func sniffContentType(job fileJob) (string, error) {
+	file, err := job.src.Open(job.srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return filter.SniffContentTypeReader(file)
+}
+
+// Reports whether the walked file is excluded by the owner filters. Directories are
+// always traversed so that matching files below them are still found.
+func (s *Syncer) filteredByOwner(relPath string, d fs.DirEntry) bool {
+	if len(s.includeOwners) == 0 && len(s.excludeOwners) == 0 {
+		return false
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not stat source file")
+		return false
+	}
+
+	uid, gid, ok := filter.FileOwner(info)
+	if !ok {
+		return false // Ownership isn't available on this platform
+	}
+
+	if len(s.includeOwners) > 0 && !filter.MatchAnyOwner(s.includeOwners, uid, gid) {
+		s.logger.Debug().Str("action", "SKIP_OWNER").Str("path", relPath).Uint32("uid", uid).Uint32("gid", gid).Msg("Owner is not included, skipping")
+		return true
+	}
+
+	if filter.MatchAnyOwner(s.excludeOwners, uid, gid) {
+		s.logger.Debug().Str("action", "SKIP_OWNER").Str("path", relPath).Uint32("uid", uid).Uint32("gid", gid).Msg("Owner is excluded, skipping")
+		return true
+	}
+
+	return false
+}
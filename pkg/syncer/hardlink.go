@@ -0,0 +1,104 @@
+package syncer
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Identifies a file on a local filesystem independently of its names.
+type inode struct {
+	dev, ino uint64
+}
+
+// The names a file with several hard links was found under. The first one to be
+// processed is copied, the others wait for it and are linked to the copy.
+type linkGroup struct {
+	first  string        // Relative path the file is copied to
+	done   chan struct{} // Closed once first is handled
+	copied bool          // Whether first holds the file at the destination once done
+}
+
+// Hard linked files of the source seen so far, by inode.
+type linkGroups struct {
+	mu     sync.Mutex
+	groups map[inode]*linkGroup
+}
+
+// Returns the group of the file srcInfo describes, and whether relPath is the first name
+// it was seen under. The group is nil if the file has no other names.
+func (l *linkGroups) join(srcInfo os.FileInfo, relPath string) (*linkGroup, bool) {
+	id, ok := fileInode(srcInfo)
+	if !ok {
+		return nil, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if group, ok := l.groups[id]; ok {
+		return group, false
+	}
+	group := &linkGroup{first: relPath, done: make(chan struct{})}
+	l.groups[id] = group
+	return group, true
+}
+
+// Reports whether the first name of the file was copied, or was up-to-date, at the destination.
+func (s *Syncer) holdsCopy(relPath string, srcInfo os.FileInfo) bool {
+	if s.Options.DryRun {
+		return true
+	}
+	destInfo, err := s.local.Stat(relPath)
+	return err == nil && destInfo.Size() == srcInfo.Size()
+}
+
+// Waits for the first name of group to be copied and links relPath to it. Reports false
+// if relPath has to be copied on its own after all.
+func (s *Syncer) linkToGroup(group *linkGroup, relPath string) bool {
+	<-group.done
+	if !group.copied {
+		return false
+	}
+
+	destinationPath := s.local.path(relPath)
+	firstPath := s.local.path(group.first)
+
+	// Linked by an earlier run already
+	firstInfo, firstErr := os.Lstat(firstPath)
+	destInfo, destErr := os.Lstat(destinationPath)
+	if firstErr == nil && destErr == nil && os.SameFile(firstInfo, destInfo) {
+		s.stats.recordSkipped(relPath, "SKIP_FILE")
+		s.logger.Debug().Str("action", "SKIP_FILE").Str("path", relPath).Msg("Hard link is up-to-date, skipping")
+		return true
+	}
+
+	logEvent := s.logger.Info().Str("action", "LINK").Str("path", relPath).Str("target", group.first)
+	if s.Options.DryRun {
+		s.stats.recordLink()
+		s.recordPlanned("LINK", relPath, group.first)
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would create hard link")
+		return true
+	}
+
+	if err := s.local.MkdirAll(filepath.Dir(relPath)); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Failed to create directories")
+		s.stats.recordError(relPath, err)
+		return true
+	}
+	if destErr == nil {
+		if err := removeReplaceable(destinationPath); err != nil {
+			s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error replacing file with hard link")
+			s.stats.recordError(relPath, err)
+			return true
+		}
+	}
+	if err := os.Link(firstPath, destinationPath); err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Could not create hard link, copying")
+		return false
+	}
+
+	s.stats.recordLink()
+	logEvent.Msg("Hard link created")
+	return true
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package syncer
+
+import "os"
+
+// Returns the inode of the file described by info if it has more than one name. The
+// FileInfo of os.Stat carries no link count here, so every file is copied on its own.
+func fileInode(info os.FileInfo) (inode, bool) {
+	return inode{}, false
+}
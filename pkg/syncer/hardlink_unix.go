@@ -0,0 +1,17 @@
+//go:build unix
+
+package syncer
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the inode of the file described by info if it has more than one name.
+func fileInode(info os.FileInfo) (inode, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 || !info.Mode().IsRegular() {
+		return inode{}, false
+	}
+	return inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,34 @@
+package syncer
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// HashAlgorithm selects the hash file contents are compared and checksums stored with.
+type HashAlgorithm string
+
+const (
+	HashSHA256   HashAlgorithm = "sha256"
+	HashBLAKE3   HashAlgorithm = "blake3"   // As strong as SHA-256 and several times faster
+	HashXXHash64 HashAlgorithm = "xxhash64" // Fastest, but files can be crafted to collide
+	HashMD5      HashAlgorithm = "md5"      // Not collision resistant either, for matching checksums made by other tools
+)
+
+// Returns a new hash of the algorithm, SHA-256 for an unknown one.
+func (a HashAlgorithm) newHash() hash.Hash {
+	switch a {
+	case HashBLAKE3:
+		return blake3.New()
+	case HashXXHash64:
+		return xxhash.New()
+	case HashMD5:
+		return md5.New()
+	default:
+		return sha256.New()
+	}
+}
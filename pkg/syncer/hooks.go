@@ -0,0 +1,53 @@
+package syncer
+
+import "time"
+
+// The callbacks of SyncOptions, called by the statsCollector once it recorded an event
+// and let go of its lock, so they may look at Progress. Unset ones are skipped.
+type hooks struct {
+	onFileStart func(relPath string, size int64)
+	onFileDone  func(relPath string, bytes int64, duration time.Duration)
+	onSkip      func(relPath, reason string)
+	onDelete    func(relPath string)
+	onError     func(FileError)
+}
+
+func newHooks(opts *SyncOptions) hooks {
+	return hooks{
+		onFileStart: opts.OnFileStart,
+		onFileDone:  opts.OnFileDone,
+		onSkip:      opts.OnSkip,
+		onDelete:    opts.OnDelete,
+		onError:     opts.OnError,
+	}
+}
+
+func (h hooks) fileStart(relPath string, size int64) {
+	if h.onFileStart != nil {
+		h.onFileStart(relPath, size)
+	}
+}
+
+func (h hooks) fileDone(relPath string, bytes int64, duration time.Duration) {
+	if h.onFileDone != nil {
+		h.onFileDone(relPath, bytes, duration)
+	}
+}
+
+func (h hooks) skip(relPath, reason string) {
+	if h.onSkip != nil {
+		h.onSkip(relPath, reason)
+	}
+}
+
+func (h hooks) delete(relPath string) {
+	if h.onDelete != nil {
+		h.onDelete(relPath)
+	}
+}
+
+func (h hooks) failed(err FileError) {
+	if h.onError != nil {
+		h.onError(err)
+	}
+}
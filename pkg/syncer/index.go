@@ -0,0 +1,113 @@
+package syncer
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Set of relative paths seen in the source, used to decide what to delete at the destination.
+type pathIndex interface {
+	add(relPath string) error
+	// Lookups must be made in the order filepath.WalkDir visits paths.
+	contains(relPath string) bool
+	close() error
+}
+
+// Index kept entirely in memory.
+type memoryIndex map[string]bool
+
+func (m memoryIndex) add(relPath string) error {
+	m[relPath] = true
+	return nil
+}
+
+func (m memoryIndex) contains(relPath string) bool {
+	return m[relPath]
+}
+
+func (m memoryIndex) close() error {
+	return nil
+}
+
+// Index spilled to a temporary file so memory use doesn't grow with the size of the tree.
+// Paths are written in walk order, which lets lookups made in the same order stream through
+// the file once instead of loading it.
+type diskIndex struct {
+	file    *os.File
+	writer  *bufio.Writer
+	reader  *bufio.Reader
+	current string
+	done    bool
+}
+
+func newDiskIndex() (*diskIndex, error) {
+	file, err := os.CreateTemp("", "gosync-index-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &diskIndex{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (d *diskIndex) add(relPath string) error {
+	// File names can't contain NUL, so it is a safe separator
+	if _, err := d.writer.WriteString(relPath); err != nil {
+		return err
+	}
+	return d.writer.WriteByte(0)
+}
+
+func (d *diskIndex) contains(relPath string) bool {
+	if d.reader == nil {
+		if err := d.writer.Flush(); err != nil {
+			d.done = true
+			return false
+		}
+		if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+			d.done = true
+			return false
+		}
+		d.reader = bufio.NewReader(d.file)
+		d.next()
+	}
+
+	// Skip over indexed paths that sort before the one we are looking for
+	for !d.done && walkOrderLess(d.current, relPath) {
+		d.next()
+	}
+
+	return !d.done && d.current == relPath
+}
+
+// Advances to the next indexed path.
+func (d *diskIndex) next() {
+	entry, err := d.reader.ReadString(0)
+	if err != nil {
+		d.done = true
+		return
+	}
+	d.current = strings.TrimSuffix(entry, "\x00")
+}
+
+func (d *diskIndex) close() error {
+	d.file.Close()
+	return os.Remove(d.file.Name())
+}
+
+// Reports whether a is visited before b by filepath.WalkDir, which walks each directory
+// in lexical order and finishes a subdirectory before moving on to its next sibling.
+func walkOrderLess(a, b string) bool {
+	aParts := strings.Split(a, string(filepath.Separator))
+	bParts := strings.Split(b, string(filepath.Separator))
+
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if aParts[i] != bParts[i] {
+			return aParts[i] < bParts[i]
+		}
+	}
+
+	return len(aParts) < len(bParts)
+}
@@ -0,0 +1,130 @@
+package syncer
+
+import (
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestWalkOrderLess(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"a", "b", true},
+		{"b", "a", false},
+		{"a", "a", false},
+		{"a", "a" + sep + "b", true},
+		{"a" + sep + "b", "a", false},
+		// A directory's contents come before its later siblings, whatever they sort like
+		{"a" + sep + "z", "a.b", true},
+		{"a" + sep + "z", "a-b", true},
+		{"a-b", "a" + sep + "z", false},
+		{"a" + sep + "b" + sep + "c", "a" + sep + "c", true},
+		{"B", "a", true},
+		{"a b", "a" + sep + "b", false},
+	}
+	for _, tt := range tests {
+		if got := walkOrderLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("walkOrderLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// Builds a tree whose names sort differently as whole paths than directory by directory.
+func makeWalkTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, dir := range []string{"a/b/c", "a.b", "a-b/x", "ab", "B", "a b", "z/a"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"a/b/c/f", "a/b.txt", "a/b-", "a.b/f", "a-b/x/f", "a0", "B/f", "a b/f", "z/a/f", "z/a.f"} {
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// Returns the paths filepath.WalkDir visits below root, in its order.
+func walkPaths(t *testing.T, root string) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root {
+			relPath, _ := filepath.Rel(root, path)
+			paths = append(paths, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestWalkOrderLessMatchesWalkDir(t *testing.T) {
+	walked := walkPaths(t, makeWalkTree(t))
+
+	sorted := slices.Clone(walked)
+	rand.New(rand.NewSource(1)).Shuffle(len(sorted), func(i, j int) { sorted[i], sorted[j] = sorted[j], sorted[i] })
+	sort.Slice(sorted, func(i, j int) bool { return walkOrderLess(sorted[i], sorted[j]) })
+
+	if !slices.Equal(sorted, walked) {
+		t.Errorf("sorted by walkOrderLess:\n%q\nwalked by filepath.WalkDir:\n%q", sorted, walked)
+	}
+}
+
+func TestDiskIndex(t *testing.T) {
+	walked := walkPaths(t, makeWalkTree(t))
+	sep := string(filepath.Separator)
+
+	tests := []struct {
+		name    string
+		indexed func(path string) bool
+	}{
+		{"all", func(string) bool { return true }},
+		{"none", func(string) bool { return false }},
+		{"every other", func(path string) bool { return slices.Index(walked, path)%2 == 0 }},
+		{"outside a", func(path string) bool { return path != "a" && !isWithin("a", path) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := newDiskIndex()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer index.close()
+
+			for _, path := range walked {
+				if tt.indexed(path) {
+					if err := index.add(path); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			// Looked up in walk order like deletions do, with paths that were never there in
+			// between, named to come first among the contents of each path
+			for _, path := range walked {
+				if got := index.contains(path); got != tt.indexed(path) {
+					t.Errorf("contains(%q) = %v, want %v", path, got, tt.indexed(path))
+				}
+				missing := path + sep + "!missing"
+				if index.contains(missing) {
+					t.Errorf("contains(%q) = true for a path never added", missing)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Opens the existing regular file at relPath for updating in place, without following a
+// symlink in its place.
+func (d *localBackend) openInPlace(relPath string) (*localFile, error) {
+	file, err := os.OpenFile(d.path(relPath), os.O_RDWR|noFollowFlag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a regular file", file.Name())
+	}
+	return &localFile{File: file}, nil
+}
+
+// Reports whether the existing destination file at relPath is updated block by block
+// rather than replaced.
+func (s *Syncer) updatesInPlace(destInfo os.FileInfo) bool {
+	return s.Options.BlockSize > 0 && s.local != nil && destInfo != nil && destInfo.Mode().IsRegular()
+}
+
+// Reads src and the file a block at a time and writes only the blocks that differ to
+// out, a writer over file, then cuts the file to the length of src. Unchanged blocks
+// cost a read but no write, which is what makes growing files cheap to update. Returns
+// the bytes read from src and the bytes written.
+func rewriteBlocks(file *os.File, out io.Writer, seek io.Seeker, src io.Reader, blockSize int, progress *atomic.Int64) (read, written int64, err error) {
+	srcBlock := make([]byte, blockSize)
+	destBlock := make([]byte, blockSize)
+
+	for {
+		n, err := io.ReadFull(src, srcBlock)
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return read, written, err
+		}
+
+		if n > 0 {
+			m, err := file.ReadAt(destBlock[:n], read)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return read, written, err
+			}
+
+			if m != n || !bytes.Equal(srcBlock[:n], destBlock[:n]) {
+				if _, err := seek.Seek(read, io.SeekStart); err != nil {
+					return read, written, err
+				}
+				if _, err := out.Write(srcBlock[:n]); err != nil {
+					return read, written, err
+				}
+				written += int64(n)
+			} else {
+				progress.Add(int64(n)) // Written blocks are counted by out
+			}
+			read += int64(n)
+		}
+
+		if eof {
+			return read, written, file.Truncate(read)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package syncer
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The disk traffic of local files is limited per device, with a budget shared by every
+// Syncer in the process that has the same limits.
+var ioBudgets sync.Map // ioBudgetKey to *ioBudget
+
+type ioBudgetKey struct {
+	device uint64
+	bytes  int64
+	ops    int
+	nice   bool
+}
+
+// Limits reads and writes of files on one device to IOLimit bytes and IOOps operations
+// per second, and with NiceIO pauses after every read.
+type ioBudget struct {
+	bytes *rateLimiter // nil when the bytes aren't limited
+	ops   *rateLimiter // nil when the operations aren't limited
+	nice  bool
+}
+
+// Reports whether the disk traffic of local files is limited at all.
+func (s *Syncer) ioLimited() bool {
+	return s.Options.IOLimit > 0 || s.Options.IOOps > 0 || s.Options.NiceIO
+}
+
+// Returns the budget of the device the local file f is on, nil when the traffic isn't
+// limited or f is no local file.
+func (s *Syncer) ioBudget(f any) *ioBudget {
+	if !s.ioLimited() {
+		return nil
+	}
+
+	var file *os.File
+	switch f := f.(type) {
+	case *os.File:
+		file = f
+	case *localFile:
+		file = f.File
+	default:
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+
+	key := ioBudgetKey{device: fileDevice(info), bytes: s.Options.IOLimit, ops: s.Options.IOOps, nice: s.Options.NiceIO}
+	if budget, ok := ioBudgets.Load(key); ok {
+		return budget.(*ioBudget)
+	}
+	budget := &ioBudget{nice: key.nice}
+	if key.bytes > 0 {
+		budget.bytes = &rateLimiter{rate: float64(key.bytes)}
+	}
+	if key.ops > 0 {
+		budget.ops = &rateLimiter{rate: float64(key.ops)}
+	}
+	actual, _ := ioBudgets.LoadOrStore(key, budget)
+	return actual.(*ioBudget)
+}
+
+// Wraps r so reads through it count against the budget, returns r itself for a nil budget.
+func (b *ioBudget) reader(r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &budgetReader{r: r, budget: b}
+}
+
+// Wraps w so writes through it count against the budget, returns w itself for a nil budget.
+func (b *ioBudget) writer(w io.Writer) io.Writer {
+	if b == nil || b.bytes == nil && b.ops == nil {
+		return w
+	}
+	return &budgetWriter{w: w, budget: b}
+}
+
+// Blocks until an operation of n bytes fits in the budget.
+func (b *ioBudget) wait(n int) {
+	if b.ops != nil {
+		b.ops.wait(1)
+	}
+	if b.bytes != nil {
+		b.bytes.wait(n)
+	}
+}
+
+type budgetReader struct {
+	r      io.Reader
+	budget *ioBudget
+}
+
+// Reads are paid for up front by the size of the buffer, as the disk may well fill it.
+func (r *budgetReader) Read(p []byte) (int, error) {
+	r.budget.wait(len(p))
+	started := time.Now()
+	n, err := r.r.Read(p)
+	// Leave the disk idle for as long as it was kept busy, at least half its time is
+	// left to others
+	if r.budget.nice {
+		time.Sleep(time.Since(started))
+	}
+	return n, err
+}
+
+type budgetWriter struct {
+	w      io.Writer
+	budget *ioBudget
+}
+
+func (w *budgetWriter) Write(p []byte) (int, error) {
+	w.budget.wait(len(p))
+	return w.w.Write(p)
+}
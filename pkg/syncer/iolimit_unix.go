@@ -0,0 +1,16 @@
+//go:build unix
+
+package syncer
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the device the file described by info is on.
+func fileDevice(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev)
+	}
+	return 0
+}
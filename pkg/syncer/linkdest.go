@@ -0,0 +1,106 @@
+package syncer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// Resolves the LinkDest directories, relative ones against the destination like rsync.
+func (s *Syncer) prepareLinkDests() error {
+	for _, dir := range s.Options.LinkDest {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(s.Options.DestinationPath, dir)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("could not read link destination: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("link destination %s is not a directory.", dir)
+		}
+		s.linkDests = append(s.linkDests, dir)
+	}
+	return nil
+}
+
+// Looks for the source file of job unchanged in one of the LinkDest directories, and
+// hard links it from there instead of copying it. Only files missing at the destination
+// are linked, a new snapshot being filled. Reports whether it was linked.
+func (s *Syncer) linkFromPrevious(job fileJob, srcInfo os.FileInfo) bool {
+	relPath := job.relPath
+	for _, dir := range s.linkDests {
+		previousPath := filepath.Join(dir, relPath)
+		previousInfo, err := os.Lstat(previousPath)
+		if err != nil || !previousInfo.Mode().IsRegular() || !s.unchangedIn(job, srcInfo, previousPath, previousInfo) {
+			continue
+		}
+
+		logEvent := s.logger.Info().Str("action", "LINK_DEST").Str("path", relPath).Str("target", previousPath)
+		if s.Options.DryRun {
+			s.stats.recordLink()
+			s.recordPlanned("LINK_DEST", relPath, previousPath)
+			s.logPlanned(relPath, logEvent, "DRY_RUN: Would hard link unchanged file")
+			return true
+		}
+
+		if err := s.local.MkdirAll(filepath.Dir(relPath)); err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not create directories to link into, copying")
+			return false
+		}
+		if err := s.checkContained(relPath, false); err != nil {
+			s.logger.Error().Err(err).Str("path", relPath).Msg("Refusing to link outside of destination")
+			s.stats.recordError(relPath, err)
+			return true
+		}
+		if err := os.Link(previousPath, s.local.path(relPath)); err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not hard link unchanged file, copying")
+			return false
+		}
+
+		s.stats.recordLink()
+		logEvent.Msg("Unchanged file hard linked")
+		return true
+	}
+	return false
+}
+
+// Reports whether the file at previousPath is the same as the source file of job, down to
+// the metadata that would be preserved. Linked files share it, it can't differ.
+func (s *Syncer) unchangedIn(job fileJob, srcInfo os.FileInfo, previousPath string, previousInfo os.FileInfo) bool {
+	if srcInfo.Size() != previousInfo.Size() || srcInfo.Mode().Perm() != previousInfo.Mode().Perm() {
+		return false
+	}
+
+	if s.Options.Owner || s.Options.Group {
+		srcUID, srcGID, srcOK := filter.FileOwner(srcInfo)
+		uid, gid, ok := filter.FileOwner(previousInfo)
+		if !srcOK || !ok || s.Options.Owner && srcUID != uid || s.Options.Group && srcGID != gid {
+			return false
+		}
+	}
+
+	if s.Options.Compare != CompareChecksum {
+		return srcInfo.ModTime().Equal(previousInfo.ModTime())
+	}
+
+	srcSum, err := s.hashSource(job)
+	if err != nil {
+		return false
+	}
+	previousSum, ok := s.storedChecksum(previousPath, previousInfo)
+	if !ok {
+		file, err := os.Open(previousPath)
+		if err != nil {
+			return false
+		}
+		defer file.Close()
+		if previousSum, err = s.hashReader(file); err != nil {
+			return false
+		}
+	}
+	return bytes.Equal(srcSum, previousSum)
+}
@@ -0,0 +1,181 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The objects of an S3 destination as a listing found them, kept between runs with
+// ListingCache so listing a large prefix isn't paid for on every run. Objects this
+// process writes or removes are updated in it as they are. Every object looked at
+// during a sync is checked against it by its ETag, and a mismatch, i.e. a change made
+// by other means, drops the listing so the next walk lists again.
+type listingCache struct {
+	path string
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	state   listingState
+	changed bool // Since it was loaded, so it is saved
+}
+
+type listingState struct {
+	Listed time.Time // When the listing was taken, zero when there is none to trust
+	Files  map[string]listedFile
+}
+
+type listedFile struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string // Empty when not known yet, e.g. for objects just copied
+}
+
+// Loads the listing of the destination a run left, if ListingCache is set. Unlike
+// other state it is kept per destination, whatever was synced to it.
+func (s *Syncer) loadListingCache() (*listingCache, error) {
+	dir, err := s.stateDir()
+	if err != nil {
+		return nil, err
+	}
+	dest := sha256.Sum256([]byte(s.Options.DestinationPath))
+	cache := &listingCache{
+		path: filepath.Join(dir, "listing-"+hex.EncodeToString(dest[:8])+".state"),
+		ttl:  s.Options.ListingCache,
+	}
+	if err := loadState(cache.path, &cache.state); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// Has an S3 destination list its objects through the listing an earlier run left, with
+// ListingCache. Other destinations are listed on every run.
+func (s *Syncer) cacheListing() error {
+	dest, ok := s.dest.(*s3Backend)
+	if !ok || s.Options.ListingCache <= 0 {
+		return nil
+	}
+	cache, err := s.loadListingCache()
+	if err != nil {
+		return err
+	}
+	dest.cache = cache
+	s.listingCache = cache
+	return nil
+}
+
+// Saves the listing for the next run if this one changed it. Dry runs change nothing.
+func (s *Syncer) saveListingCache() {
+	if s.listingCache == nil || s.Options.DryRun {
+		return
+	}
+	if err := s.listingCache.save(); err != nil {
+		s.logger.Warn().Err(err).Msg("Could not save the destination listing, the next run lists it again")
+	}
+}
+
+func (c *listingCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.changed {
+		return nil
+	}
+	return saveState(c.path, c.state)
+}
+
+// Returns the files of the listing if it is recent enough to be trusted.
+func (c *listingCache) files() (map[string]listedFile, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fresh() {
+		return nil, false
+	}
+	return maps.Clone(c.state.Files), true
+}
+
+func (c *listingCache) fresh() bool {
+	return !c.state.Listed.IsZero() && time.Since(c.state.Listed) < c.ttl
+}
+
+// Replaces the listing with one just taken.
+func (c *listingCache) store(files map[string]listedFile) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state = listingState{Listed: time.Now(), Files: files}
+	c.changed = true
+}
+
+// Records the file at relPath as written, or as removed when file is nil.
+func (c *listingCache) update(relPath string, file *listedFile) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.Files == nil {
+		return // Nothing listed to keep up to date
+	}
+	if file == nil {
+		delete(c.state.Files, relPath)
+	} else {
+		c.state.Files[relPath] = *file
+	}
+	c.changed = true
+}
+
+// Records the file at oldRelPath as copied to newRelPath, which may be the same, and
+// removed from oldRelPath otherwise. Copies may have another ETag.
+func (c *listingCache) moved(oldRelPath, newRelPath string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, ok := c.state.Files[oldRelPath]
+	if !ok {
+		return
+	}
+	delete(c.state.Files, oldRelPath)
+	c.state.Files[newRelPath] = listedFile{Size: file.Size, ModTime: time.Now()}
+	c.changed = true
+}
+
+// Compares what was found at relPath, its ETag if it exists, with the listing and drops
+// the listing if they differ.
+func (c *listingCache) check(relPath string, exists bool, etag string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fresh() {
+		return
+	}
+	file, listed := c.state.Files[relPath]
+	switch {
+	case listed != exists, exists && file.ETag != "" && file.ETag != etag:
+		c.state = listingState{}
+	case exists && file.ETag == "":
+		file.ETag = etag
+		c.state.Files[relPath] = file
+	default:
+		return
+	}
+	c.changed = true
+}
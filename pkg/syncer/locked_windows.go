@@ -0,0 +1,15 @@
+//go:build windows
+
+package syncer
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reports whether err comes from a file another program holds open without sharing it,
+// or holds a byte range lock on.
+func locked(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+}
@@ -0,0 +1,224 @@
+package syncer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Version of the manifest format, raised when it changes in a way readers must know of.
+const manifestVersion = 1
+
+// A manifest of the destination as a run left it, for audits and so the next run can
+// take hashes from it instead of reading files again.
+type manifestFile struct {
+	Version     int             `json:"version"`
+	Created     time.Time       `json:"created"`
+	Destination string          `json:"destination"`
+	Hash        HashAlgorithm   `json:"hash"` // Algorithm of the hashes of the files
+	Files       []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	Path    string    `json:"path"` // Relative to the destination, with forward slashes
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash"` // Hex encoded
+}
+
+// The hashes known of destination files, from the last manifest and from this run.
+type destManifest struct {
+	previous map[string]manifestEntry // Loaded at the start, read only
+
+	mu     sync.Mutex
+	hashed map[string][]byte // Files copied or compared by this run, with their contents' hash
+}
+
+// Loads the manifest the last run left, if Manifest is set. One that can't be read only
+// means every file is hashed again.
+func (s *Syncer) loadManifest() {
+	if s.Options.Manifest == "" {
+		return
+	}
+	s.manifest = &destManifest{previous: make(map[string]manifestEntry), hashed: make(map[string][]byte)}
+
+	data, err := os.ReadFile(s.Options.Manifest)
+	if os.IsNotExist(err) {
+		return
+	}
+	var previous manifestFile
+	if err == nil {
+		err = json.Unmarshal(data, &previous)
+	}
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", s.Options.Manifest).Msg("Could not read the manifest, hashing every file")
+		return
+	}
+	if previous.Version != manifestVersion || previous.Hash != s.Options.Hash {
+		return
+	}
+	for _, entry := range previous.Files {
+		s.manifest.previous[filepath.FromSlash(entry.Path)] = entry
+	}
+}
+
+// Returns the hash the last manifest has of the destination file at relPath, if the file
+// hasn't changed since by its size and modification time.
+func (s *Syncer) manifestChecksum(relPath string, destInfo os.FileInfo) ([]byte, bool) {
+	if s.manifest == nil {
+		return nil, false
+	}
+	entry, ok := s.manifest.previous[relPath]
+	if !ok || entry.Size != destInfo.Size() || !entry.ModTime.Equal(destInfo.ModTime()) {
+		return nil, false
+	}
+	sum, err := hex.DecodeString(entry.Hash)
+	if err != nil {
+		return nil, false
+	}
+	return sum, true
+}
+
+// Records sum as the hash of the contents this run left at relPath.
+func (s *Syncer) rememberHash(relPath string, sum []byte) {
+	if s.manifest == nil || sum == nil {
+		return
+	}
+	s.manifest.mu.Lock()
+	defer s.manifest.mu.Unlock()
+	s.manifest.hashed[relPath] = sum
+}
+
+// Writes the manifest of the destination's files, hashing those whose hash isn't known
+// from this run or the last manifest. Backups and partial files are left out, and so is
+// the manifest itself when it is kept in the destination. Dry runs change nothing.
+func (s *Syncer) writeManifest() error {
+	if s.manifest == nil || s.Options.DryRun {
+		return nil
+	}
+
+	manifest := manifestFile{Version: manifestVersion, Created: time.Now().UTC(), Destination: s.Options.DestinationPath, Hash: s.Options.Hash, Files: []manifestEntry{}}
+	hashed := 0
+	err := s.dest.Walk(func(relPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if relPath == "." || relPath == "" {
+			return nil
+		}
+		if d.IsDir() {
+			if s.isBackupDir(relPath) || s.isPartialDir(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || s.isBackupFile(relPath) {
+			return nil
+		}
+		if s.isManifest(relPath) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not add file to the manifest")
+			return nil
+		}
+		sum, fresh, err := s.manifestHash(relPath, info)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not add file to the manifest")
+			return nil
+		}
+		if fresh {
+			hashed++
+		}
+		manifest.Files = append(manifest.Files, manifestEntry{
+			Path:    filepath.ToSlash(relPath),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Hash:    hex.EncodeToString(sum),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeManifestFile(s.Options.Manifest, &manifest); err != nil {
+		return err
+	}
+	s.logger.Info().Str("action", "MANIFEST").Str("path", s.Options.Manifest).Int("files", len(manifest.Files)).Int("hashed", hashed).Msg("Manifest written")
+	return nil
+}
+
+// Reports whether relPath is the manifest, kept in a local destination.
+func (s *Syncer) isManifest(relPath string) bool {
+	if s.Options.Manifest == "" || s.local == nil {
+		return false
+	}
+	manifestPath, err := filepath.Abs(s.Options.Manifest)
+	if err != nil {
+		return false
+	}
+	destinationPath, err := filepath.Abs(s.local.path(relPath))
+	return err == nil && destinationPath == manifestPath
+}
+
+// Returns the hash of the destination file at relPath, reading it only when neither this
+// run nor the last manifest knows it. fresh tells that the file was read.
+func (s *Syncer) manifestHash(relPath string, info os.FileInfo) (sum []byte, fresh bool, err error) {
+	s.manifest.mu.Lock()
+	sum, ok := s.manifest.hashed[relPath]
+	s.manifest.mu.Unlock()
+	if ok {
+		return sum, false, nil
+	}
+	if sum, ok := s.manifestChecksum(relPath, info); ok {
+		return sum, false, nil
+	}
+	if s.local != nil {
+		if sum, ok := s.storedChecksum(s.local.path(relPath), info); ok {
+			return sum, false, nil
+		}
+	}
+
+	s.logger.Debug().Str("action", "HASH").Str("path", relPath).Msg("Hashing file for the manifest")
+	sum, err = s.hashDestination(relPath)
+	return sum, true, err
+}
+
+// Replaces the manifest at path in one step, so readers never see half of it.
+func writeManifestFile(path string, manifest *manifestFile) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".gosync-manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("could not replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package syncer
+
+import (
+	"path/filepath"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// Returns the marker that excludes the directory at relPath in tree, CACHEDIR.TAG or one
+// of the ExcludeMarkers, or false when it isn't excluded.
+func (s *Syncer) excludingMarker(tree Backend, relPath string) (string, bool) {
+	if local, ok := tree.(*localBackend); ok {
+		path := local.path(relPath)
+		if s.Options.ExcludeCaches && filter.IsCacheDir(path) {
+			return filter.CacheDirTag, true
+		}
+		return filter.HasMarker(path, s.Options.ExcludeMarkers)
+	}
+
+	if s.Options.ExcludeCaches {
+		if file, err := tree.Open(filepath.Join(relPath, filter.CacheDirTag)); err == nil {
+			isCacheDir := filter.IsCacheDirTag(file)
+			file.Close()
+			if isCacheDir {
+				return filter.CacheDirTag, true
+			}
+		}
+	}
+	for _, marker := range s.Options.ExcludeMarkers {
+		if _, err := tree.Stat(filepath.Join(relPath, marker)); err == nil {
+			return marker, true
+		}
+	}
+	return "", false
+}
+
+// Reports whether the destination directory must be kept by --delete, because the source
+// directory was excluded by a marker or the destination one holds a marker itself.
+func (s *Syncer) protectedByMarker(relPath string) bool {
+	if _, ok := s.markedDirectories[relPath]; ok {
+		return true
+	}
+	_, ok := s.excludingMarker(s.dest, relPath)
+	return ok
+}
@@ -0,0 +1,56 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+)
+
+// Rejects options that would make removing source files unsafe.
+func (s *Syncer) checkRemoveSourceOptions() error {
+	if !s.Options.RemoveSourceFiles {
+		return nil
+	}
+	switch {
+	case s.Options.Delete:
+		return fmt.Errorf("source files can't be removed with deletions enabled, the next run would delete what was moved.")
+	case s.Options.TwoWay:
+		return fmt.Errorf("source files can't be removed in a two-way sync.")
+	case len(s.Options.ExtraDestinations) > 0:
+		return fmt.Errorf("source files can only be removed when syncing to one destination.")
+	}
+	return nil
+}
+
+// Removes the source file of job once the destination holds it, unless it changed since
+// srcInfo was taken, so nothing written to it meanwhile is lost.
+func (s *Syncer) removeSource(job fileJob, srcInfo os.FileInfo) {
+	if !s.Options.RemoveSourceFiles {
+		return
+	}
+	relPath := job.relPath
+	logEvent := s.logger.Info().Str("action", "REMOVE_SOURCE").Str("path", relPath)
+
+	if s.Options.DryRun {
+		s.recordPlanned("REMOVE_SOURCE", relPath, "")
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would remove source file")
+		return
+	}
+
+	info, err := job.src.Stat(job.srcPath)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not stat source file again, keeping it")
+		return
+	}
+	if info.Size() != srcInfo.Size() || !info.ModTime().Equal(srcInfo.ModTime()) {
+		s.logger.Warn().Str("action", "KEEP_SOURCE").Str("path", relPath).Msg("Source file changed while it was synced, keeping it")
+		return
+	}
+
+	if err := job.src.Remove(job.srcPath); err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Error removing source file")
+		s.stats.recordError(relPath, err)
+		return
+	}
+	s.stats.recordSourceRemoved()
+	logEvent.Msg("Source file removed")
+}
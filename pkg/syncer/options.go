@@ -0,0 +1,64 @@
+package syncer
+
+import (
+	"github.com/bipinmdr07/gosync/pkg/filter"
+
+	"github.com/rs/zerolog"
+)
+
+// Option sets up a Syncer made by NewSyncer.
+type Option func(*config)
+
+// What the Options passed to NewSyncer set up.
+type config struct {
+	options   SyncOptions
+	logger    *zerolog.Logger
+	filter    *filter.Ignore
+	filterSet bool
+}
+
+// WithOptions starts from a copy of opts, for the settings without an Option of their
+// own. The source and destination passed to NewSyncer win over the ones of opts, and
+// Options after this one over its other fields. opts itself is left as it is.
+func WithOptions(opts *SyncOptions) Option {
+	return func(c *config) {
+		c.options = *opts
+	}
+}
+
+// WithWorkers copies n files at once, one per CPU by default.
+func WithWorkers(n int) Option {
+	return func(c *config) {
+		c.options.Workers = n
+	}
+}
+
+// WithDelete removes destination paths the source doesn't have.
+func WithDelete(delete bool) Option {
+	return func(c *config) {
+		c.options.Delete = delete
+	}
+}
+
+// WithDryRun logs what the sync would do without changing the destination.
+func WithDryRun(dryRun bool) Option {
+	return func(c *config) {
+		c.options.DryRun = dryRun
+	}
+}
+
+// WithLogger logs to l, in place of the console logger set up from Verbose, LogWriter
+// and LogFile.
+func WithLogger(l zerolog.Logger) Option {
+	return func(c *config) {
+		c.logger = &l
+	}
+}
+
+// WithFilter skips the source paths f matches, in place of the rules of the .gosyncignore
+// file of the source. A nil f skips nothing.
+func WithFilter(f *filter.Ignore) Option {
+	return func(c *config) {
+		c.filter, c.filterSet = f, true
+	}
+}
@@ -0,0 +1,83 @@
+package syncer
+
+import (
+	"os"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// Gives the destination entry at relPath the owner and group of the source entry
+// described by srcInfo, as far as they are preserved and differ from destInfo, which may
+// be nil for a new entry. chown changes the entry without following a symlink. Failing
+// is only warned about, changing the owner usually needs root.
+func (s *Syncer) preserveOwner(relPath string, srcInfo, destInfo os.FileInfo, chown func(uid, gid int) error) {
+	if !s.Options.Owner && !s.Options.Group {
+		return
+	}
+	srcUID, srcGID, ok := filter.FileOwner(srcInfo)
+	if !ok {
+		return
+	}
+
+	// -1 leaves the id alone
+	uid, gid := -1, -1
+	var destUID, destGID uint32
+	known := false
+	if destInfo != nil {
+		destUID, destGID, known = filter.FileOwner(destInfo)
+	}
+	if s.Options.Owner && (!known || destUID != srcUID) {
+		uid = int(srcUID)
+	}
+	if s.Options.Group && (!known || destGID != srcGID) {
+		gid = int(srcGID)
+	}
+	if uid == -1 && gid == -1 {
+		return
+	}
+
+	if s.Options.DryRun {
+		return
+	}
+	if err := chown(uid, gid); err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Uint32("uid", srcUID).Uint32("gid", srcGID).Msg("Error preserving ownership")
+		return
+	}
+	s.logger.Debug().Str("action", "CHOWN").Str("path", relPath).Uint32("uid", srcUID).Uint32("gid", srcGID).Msg("Ownership preserved")
+}
+
+// Changes the owner of the destination entry at relPath by path, without following a symlink.
+func (s *Syncer) lchown(relPath string) func(uid, gid int) error {
+	return func(uid, gid int) error {
+		return os.Lchown(s.local.path(relPath), uid, gid)
+	}
+}
+
+// A source directory whose owner is given to its destination directory once the workers
+// created it.
+type ownedDirectory struct {
+	relPath string
+	info    os.FileInfo
+}
+
+func (s *Syncer) rememberDirectoryOwner(relPath string, d os.DirEntry) {
+	if info, err := d.Info(); err == nil {
+		s.ownedDirectories = append(s.ownedDirectories, ownedDirectory{relPath, info})
+	}
+}
+
+// Preserves the owners of the directories remembered by the walker. Directories
+// nothing was copied into don't exist and are left out.
+func (s *Syncer) applyDirectoryOwners() {
+	for _, dir := range s.ownedDirectories {
+		if s.checkContained(dir.relPath, true) != nil {
+			continue
+		}
+		destInfo, err := os.Lstat(s.local.path(dir.relPath))
+		if err != nil || !destInfo.IsDir() {
+			continue
+		}
+		s.preserveOwner(dir.relPath, dir.info, destInfo, s.lchown(dir.relPath))
+	}
+	s.ownedDirectories = nil
+}
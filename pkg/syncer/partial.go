@@ -0,0 +1,119 @@
+package syncer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Length of the end of a partial file compared with the source before it is continued,
+// so a partial file of another version of the source isn't resumed.
+const partialVerifySize = 1 << 20
+
+// Returns the path relative to the root a new file for relPath is written to before it
+// is moved into place. Without a partial directory it sits next to the file it replaces.
+func (d *localBackend) tempPath(relPath string) string {
+	if d.partialDir != "" {
+		return filepath.Join(filepath.Dir(relPath), d.partialDir, filepath.Base(relPath))
+	}
+	return filepath.Join(filepath.Dir(relPath), ".gosync-tmp-"+filepath.Base(relPath))
+}
+
+// Creates the partial directory new files for relPath are written to.
+func (d *localBackend) makePartialDir(relPath string) error {
+	dir := filepath.Dir(d.path(d.tempPath(relPath)))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	d.partialDirs.Store(dir, struct{}{})
+	return nil
+}
+
+// Removes the partial directories that were used and are empty again, once no more
+// files are written to them.
+func (d *localBackend) removePartialDirs() {
+	d.partialDirs.Range(func(dir, _ any) bool {
+		os.Remove(dir.(string))
+		d.partialDirs.Delete(dir)
+		return true
+	})
+}
+
+// Opens the partial file an interrupted copy to relPath left behind.
+func (d *localBackend) openPartial(relPath string) (*localFile, os.FileInfo, error) {
+	tempRelPath := d.tempPath(relPath)
+	if err := d.Contained(tempRelPath, false); err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(d.path(tempRelPath), os.O_RDWR|noFollowFlag, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, os.ErrNotExist
+	}
+	d.partialDirs.Store(filepath.Dir(file.Name()), struct{}{})
+	return &localFile{File: file, target: d.path(relPath), partial: true}, info, nil
+}
+
+// Reports whether the destination directory at relPath holds partial files, which are
+// kept for the next run rather than deleted or taken for moved files.
+func (s *Syncer) isPartialDir(relPath string) bool {
+	return s.Options.PartialDir != "" && filepath.Base(relPath) == s.Options.PartialDir
+}
+
+// Reopens the partial file an interrupted copy of src to relPath left behind, and moves
+// src and the file to where it stopped. The source must not have been modified after
+// the partial file was last written, and both have to end the same. Returns nil when
+// there is nothing to resume.
+func (s *Syncer) resumePartial(relPath string, src io.Reader, srcInfo os.FileInfo) (*localFile, int64) {
+	if s.Options.PartialDir == "" || s.local == nil {
+		return nil, 0
+	}
+	srcAt, ok := src.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil, 0
+	}
+
+	file, info, err := s.local.openPartial(relPath)
+	if err != nil {
+		return nil, 0
+	}
+
+	offset := info.Size()
+	if offset == 0 || offset > srcInfo.Size() || srcInfo.ModTime().After(info.ModTime()) || !sameTail(file.File, srcAt, offset) {
+		s.logger.Debug().Str("path", relPath).Msg("Partial file doesn't match source, starting over")
+		file.File.Close()
+		return nil, 0
+	}
+
+	if _, err := srcAt.Seek(offset, io.SeekStart); err != nil {
+		file.File.Close()
+		return nil, 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.File.Close()
+		return nil, 0
+	}
+	return file, offset
+}
+
+// Reports whether the last bytes before size are the same in file and src.
+func sameTail(file *os.File, src io.ReaderAt, size int64) bool {
+	n := min(size, partialVerifySize)
+	ours, theirs := make([]byte, n), make([]byte, n)
+	if _, err := file.ReadAt(ours, size-n); err != nil {
+		return false
+	}
+	if _, err := src.ReadAt(theirs, size-n); err != nil {
+		return false
+	}
+	return bytes.Equal(ours, theirs)
+}
@@ -0,0 +1,118 @@
+package syncer
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// PlannedOperation is a change a dry run found to be needed, along with the state it
+// found the path in on both sides, nil where missing. A later dry run planning the same
+// operations against the same states tells that nothing changed in between.
+type PlannedOperation struct {
+	Action      string     `json:"action"`           // As logged, like COPY, MKDIR or DELETE
+	Path        string     `json:"path"`             // Relative to the source and destination
+	Target      string     `json:"target,omitempty"` // Where a link points, a file is moved from or backed up to
+	Source      *PathState `json:"source"`
+	Destination *PathState `json:"destination"`
+}
+
+// PathState describes a file or directory as an operation was planned.
+type PathState struct {
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// A log line describing a planned operation, held back so dry-run output can be sorted.
+type plannedLog struct {
+	relPath string
+	event   *zerolog.Event
+	msg     string
+}
+
+// Collects planned operations from the walker and workers during a sorted dry run.
+type planBuffer struct {
+	mu         sync.Mutex
+	entries    []plannedLog
+	operations []PlannedOperation // With RecordPlan
+}
+
+// Logs an operation of the plan. In a sorted dry run it is held back until flushPlan so the
+// output doesn't depend on which worker got to it first.
+func (s *Syncer) logPlanned(relPath string, event *zerolog.Event, msg string) {
+	if !s.Options.DryRun || !s.Options.SortPlan || event == nil {
+		event.Msg(msg)
+		return
+	}
+
+	s.plan.mu.Lock()
+	defer s.plan.mu.Unlock()
+	s.plan.entries = append(s.plan.entries, plannedLog{relPath: relPath, event: event, msg: msg})
+}
+
+// Writes out the held back operations ordered by path.
+func (s *Syncer) flushPlan() {
+	s.plan.mu.Lock()
+	defer s.plan.mu.Unlock()
+
+	// Stable, so lines logged for the same path keep the order they were logged in
+	sort.SliceStable(s.plan.entries, func(i, j int) bool {
+		return s.plan.entries[i].relPath < s.plan.entries[j].relPath
+	})
+
+	for _, entry := range s.plan.entries {
+		entry.event.Msg(entry.msg)
+	}
+	s.plan.entries = nil
+}
+
+// Records an operation of a dry run for Plan, with RecordPlan. target is where a link
+// points, a file is moved from or backed up to, if anywhere.
+func (s *Syncer) recordPlanned(action, relPath, target string) {
+	if !s.Options.RecordPlan {
+		return
+	}
+	operation := PlannedOperation{
+		Action:      action,
+		Path:        relPath,
+		Target:      target,
+		Source:      pathState(s.src, relPath),
+		Destination: pathState(s.dest, relPath),
+	}
+
+	s.plan.mu.Lock()
+	defer s.plan.mu.Unlock()
+	s.plan.operations = append(s.plan.operations, operation)
+}
+
+func pathState(tree Backend, relPath string) *PathState {
+	info, err := tree.Stat(relPath)
+	if err != nil {
+		return nil
+	}
+	return &PathState{Mode: info.Mode(), Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// Plan returns the operations a dry run with RecordPlan found to be needed, ordered by
+// path, so two plans for the same trees are equal.
+func (s *Syncer) Plan() []PlannedOperation {
+	s.plan.mu.Lock()
+	defer s.plan.mu.Unlock()
+
+	operations := append([]PlannedOperation(nil), s.plan.operations...)
+	sort.Slice(operations, func(i, j int) bool {
+		a, b := operations[i], operations[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Target < b.Target
+	})
+	return operations
+}
@@ -0,0 +1,156 @@
+package syncer
+
+import (
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Finds changes by scanning the source every PollInterval and comparing what it finds
+// with the scan before, for sources whose changes the system doesn't report. Only
+// metadata is read, contents are compared by the syncs of what changed.
+type pollWatcher struct {
+	s       *Syncer
+	entries map[string]polledEntry // Found by the last scan
+	events  chan string
+	errors  chan error
+	done    chan struct{}
+}
+
+// What a scan found at a path. Directories only count by their type, their times change
+// with every entry added or removed, which is reported by itself.
+type polledEntry struct {
+	size    int64
+	modTime time.Time
+	typ     fs.FileMode
+}
+
+func (s *Syncer) newPollWatcher() *pollWatcher {
+	w := &pollWatcher{
+		s:      s,
+		events: make(chan string),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+	}
+	w.entries, _, _ = w.scan()
+	go w.run()
+	return w
+}
+
+func (w *pollWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *pollWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *pollWatcher) run() {
+	defer close(w.events)
+
+	ticker := time.NewTicker(w.s.Options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		// A source that can't be scanned at all is no sign everything was removed
+		entries, unreadable, err := w.scan()
+		if err != nil {
+			select {
+			case w.errors <- err:
+			case <-w.done:
+				return
+			}
+			continue
+		}
+
+		// Nor is a directory that can't be read, what it held is taken as unchanged
+		for relPath, entry := range w.entries {
+			for _, dir := range unreadable {
+				if strings.HasPrefix(relPath, dir+string(filepath.Separator)) {
+					entries[relPath] = entry
+				}
+			}
+		}
+
+		for _, relPath := range changedEntries(w.entries, entries) {
+			select {
+			case w.events <- relPath:
+			case <-w.done:
+				return
+			}
+		}
+		w.entries = entries
+	}
+}
+
+// Lists what the source holds that is synced, with the directories that couldn't be read.
+func (w *pollWatcher) scan() (map[string]polledEntry, []string, error) {
+	s := w.s
+	entries := make(map[string]polledEntry, len(w.entries))
+	var unreadable []string
+
+	err := filepath.WalkDir(s.localSource.root, func(path string, d fs.DirEntry, err error) error {
+		entryPath, _ := filepath.Rel(s.localSource.root, path)
+		if err != nil {
+			if entryPath == "." {
+				return err
+			}
+			if d != nil && d.IsDir() {
+				unreadable = append(unreadable, entryPath)
+			}
+			return nil
+		}
+		if entryPath == "." {
+			return nil
+		}
+
+		if d.IsDir() {
+			if s.skipWatched(path, entryPath, d) {
+				return filepath.SkipDir
+			}
+			entries[entryPath] = polledEntry{typ: fs.ModeDir}
+			return nil
+		}
+		if s.matcher.Matches(entryPath) || s.pathRules.Excludes(entryPath, false) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed while scanning, the next scan tells
+		}
+		entries[entryPath] = polledEntry{size: info.Size(), modTime: info.ModTime(), typ: info.Mode().Type()}
+		return nil
+	})
+	return entries, unreadable, err
+}
+
+// Returns the paths added, changed or removed from one scan to the next, sorted.
+func changedEntries(before, after map[string]polledEntry) []string {
+	var changed []string
+	for relPath, entry := range after {
+		if previous, ok := before[relPath]; !ok || previous.size != entry.size || previous.typ != entry.typ || !previous.modTime.Equal(entry.modTime) {
+			changed = append(changed, relPath)
+		}
+	}
+	for relPath := range before {
+		if _, ok := after[relPath]; !ok {
+			changed = append(changed, relPath)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
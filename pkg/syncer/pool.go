@@ -0,0 +1,160 @@
+package syncer
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Pool is a set of copy workers and a bandwidth budget shared by any number of Syncers.
+// Attach it through SyncOptions.Pool to bound the total work of concurrent syncs in one
+// process. Files of all attached Syncers are processed in the order they are handed over.
+type Pool struct {
+	jobs    chan func()
+	limiter *rateLimiter
+	wg      sync.WaitGroup
+	once    sync.Once
+
+	mu      sync.Mutex
+	workers int           // Running, or soon to be after a Resize
+	retire  chan struct{} // Each value sent ends an idle worker
+	closing chan struct{}
+}
+
+// NewPool starts a pool of workers copy workers, NumCPU when 0. The combined rate at which
+// they write file contents is limited to bytesPerSecond, or unlimited when 0.
+func NewPool(workers int, bytesPerSecond int64) *Pool {
+	p := &Pool{
+		jobs:    make(chan func()),
+		limiter: &rateLimiter{},
+		retire:  make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	p.SetBandwidth(bytesPerSecond)
+	p.Resize(workers)
+	return p
+}
+
+// Resize grows or shrinks the pool to workers copy workers, NumCPU when 0. Workers that
+// go away finish the file they are copying first.
+func (p *Pool) Resize(workers int) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for ; p.workers < workers; p.workers++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	if surplus := p.workers - workers; surplus > 0 {
+		p.workers = workers
+		go func() {
+			for range surplus {
+				select {
+				case p.retire <- struct{}{}:
+				case <-p.closing:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// SetBandwidth limits the combined rate at which the workers write file contents to
+// bytesPerSecond, or lifts the limit when 0. Files being copied keep their limit.
+func (p *Pool) SetBandwidth(bytesPerSecond int64) {
+	p.limiter.setRate(float64(max(bytesPerSecond, 0)))
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case job, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+			job()
+		case <-p.retire:
+			return
+		}
+	}
+}
+
+// Close stops the workers once they are done. No Syncer may use the pool afterwards.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		close(p.closing)
+		close(p.jobs)
+	})
+	p.wg.Wait()
+}
+
+// Runs job on the next free worker, blocking until one picks it up.
+func (p *Pool) submit(job func()) {
+	p.jobs <- job
+}
+
+// Reports whether writes through the pool are rate limited.
+func (p *Pool) limited() bool {
+	return p != nil && p.limiter.limited()
+}
+
+// Wraps w so writes through it count against the bandwidth budget of the pool.
+func (p *Pool) limitWriter(w io.Writer) io.Writer {
+	if !p.limited() {
+		return w
+	}
+	return &limitedWriter{w: w, limiter: p.limiter}
+}
+
+// Spaces out writes so that on average no more than rate bytes per second go through.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64   // Bytes per second, unlimited when 0
+	next time.Time // When the bytes allowed through so far are paid off
+}
+
+func (l *rateLimiter) setRate(rate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+}
+
+func (l *rateLimiter) limited() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate > 0
+}
+
+// Blocks until n more bytes fit in the budget.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return // Lifted since the writer was made
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now // Unused budget doesn't carry over
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+type limitedWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	l.limiter.wait(len(p))
+	return l.w.Write(p)
+}
@@ -0,0 +1,230 @@
+package syncer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Number of recent copies and errors kept for Progress.
+const recentLimit = 10
+
+// ActiveTransfer describes a file copy that is currently in progress.
+type ActiveTransfer struct {
+	Path    string
+	Size    int64
+	Copied  int64
+	Started time.Time
+}
+
+// FileError is a failed operation on a single path.
+type FileError struct {
+	Path string
+	Err  error
+	Time time.Time
+}
+
+func (e FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// FilesFailedError is returned by Start when the sync went through but some paths failed,
+// to be copied, deleted or even looked at. They are left for the next run.
+type FilesFailedError struct {
+	Errors []FileError // Every failure, in the order they happened
+}
+
+func (e *FilesFailedError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("%v.", e.Errors[0])
+	}
+	return fmt.Sprintf("%d paths failed, the first %v.", len(e.Errors), e.Errors[0])
+}
+
+// Unwrap returns the failures, so errors.Is and errors.As look into each of them.
+func (e *FilesFailedError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Progress is a point in time view of a running sync, meant for live displays.
+type Progress struct {
+	Phase          string // "copying", "deleting" or "done"
+	FilesQueued    int64  // Files the walker has handed to the workers so far
+	FilesProcessed int64  // Files the workers are done with, copied or not
+	FilesCopied    int64
+	BytesCopied    int64 // Bytes of finished and in-progress copies
+	Errors         int64
+	BytesQueued    int64            // Size of the files handed to the workers so far, with Totals
+	BytesProcessed int64            // Size of those the workers are done with, in-progress copies by what they copied
+	Walked         bool             // Whether the walk is done, so FilesQueued and BytesQueued are totals
+	QueueDepth     int64            // Files found and waiting for a worker, the walker is held up once QueueSize are
+	Active         []ActiveTransfer // Copies in progress, oldest first
+	RecentCopies   []Transfer       // Most recently finished copies, newest first
+	RecentErrors   []FileError      // Most recent errors, newest first
+}
+
+// A copy in progress. copied is updated by the copying worker without holding the stats lock.
+type activeTransfer struct {
+	path    string
+	size    int64
+	started time.Time
+	copied  atomic.Int64
+}
+
+// Counts the bytes written through it into count.
+type countingWriter struct {
+	w     io.Writer
+	count *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// Moves the run on to phase, adding the time spent in the previous one to its total.
+func (c *statsCollector) setPhase(phase string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.phase != "" {
+		c.addPhaseTime(c.phase, now.Sub(c.phaseStarted))
+	} else {
+		c.started = now
+	}
+	c.phase, c.phaseStarted = phase, now
+}
+
+// Callers must hold mu.
+func (c *statsCollector) addPhaseTime(phase string, elapsed time.Duration) {
+	for i := range c.phases {
+		if c.phases[i].Phase == phase {
+			c.phases[i].Duration += elapsed
+			return
+		}
+	}
+	c.phases = append(c.phases, PhaseDuration{Phase: phase, Duration: elapsed})
+}
+
+func (c *statsCollector) recordQueued(relPath string, size int64) {
+	defer c.emit(SyncEvent{Kind: EventQueued, Path: relPath, Size: size})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queued++
+	c.queuedBytes += size
+	c.waiting++
+}
+
+// Records that a worker picked up a queued file.
+func (c *statsCollector) recordTaken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waiting--
+}
+
+// Records that depth files were in the queue right after one was added, and how long the
+// walker waited for the workers to make room for it.
+func (c *statsCollector) recordEnqueued(depth int, wait time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxWaiting = max(c.maxWaiting, int64(depth))
+	c.queueFull += wait
+}
+
+func (c *statsCollector) recordProcessed(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.processed++
+	c.doneBytes += size
+}
+
+// Records that every file was found at now.
+func (c *statsCollector) setWalked(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.walked = true
+	c.scanTime = now.Sub(c.started)
+}
+
+// Registers a copy as in progress. The caller must call endTransfer once it is finished.
+func (c *statsCollector) beginTransfer(relPath string, size int64) *activeTransfer {
+	defer c.hooks.fileStart(relPath, size)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	transfer := &activeTransfer{path: relPath, size: size, started: time.Now()}
+	c.active[transfer] = struct{}{}
+	return transfer
+}
+
+func (c *statsCollector) endTransfer(transfer *activeTransfer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.active, transfer)
+}
+
+// Adds entry to the front of a list of recent entries, dropping the oldest past recentLimit.
+func pushRecent[T any](list []T, entry T) []T {
+	list = append([]T{entry}, list...)
+	if len(list) > recentLimit {
+		list = list[:recentLimit]
+	}
+	return list
+}
+
+// Returns a snapshot of the progress of the run.
+func (c *statsCollector) progress() Progress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	progress := Progress{
+		Phase:          c.phase,
+		FilesQueued:    c.queued,
+		FilesProcessed: c.processed,
+		FilesCopied:    c.total.FilesCopied,
+		BytesCopied:    c.total.BytesCopied,
+		Errors:         c.total.Errors,
+		BytesQueued:    c.queuedBytes,
+		BytesProcessed: c.doneBytes,
+		Walked:         c.walked,
+		QueueDepth:     c.waiting,
+		Active:         make([]ActiveTransfer, 0, len(c.active)),
+		RecentCopies:   append([]Transfer(nil), c.recentCopies...),
+		RecentErrors:   append([]FileError(nil), c.recentErrors...),
+	}
+
+	for transfer := range c.active {
+		copied := transfer.copied.Load()
+		progress.BytesCopied += copied
+		progress.BytesProcessed += copied
+		progress.Active = append(progress.Active, ActiveTransfer{
+			Path:    transfer.path,
+			Size:    transfer.size,
+			Copied:  copied,
+			Started: transfer.started,
+		})
+	}
+	sort.Slice(progress.Active, func(i, j int) bool {
+		return progress.Active[i].Started.Before(progress.Active[j].Started)
+	})
+
+	return progress
+}
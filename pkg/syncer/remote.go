@@ -0,0 +1,264 @@
+package syncer
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"path"
+	"sync"
+	"time"
+)
+
+// The native protocol spoken between gosync serve and gosync:// destinations. The server
+// opens with a random challenge the client proves knowledge of the shared token with,
+// sending a challenge of its own the server proves it with in turn, so neither side talks
+// to an impostor. Then both sides exchange gob encoded messages. Requests carry an ID their responses
+// repeat, so any number of them can be in flight on one connection.
+
+// Bumped on incompatible changes, both ends must agree.
+const remoteProtocolVersion = 2
+
+// DefaultServePort is the port gosync serve listens on unless told otherwise.
+const DefaultServePort = 7873
+
+// Size of the chunks file contents are transferred in.
+const remoteChunkSize = 1 << 20
+
+// Number of entries sent per response when listing a tree.
+const remoteListBatch = 1000
+
+// Time the client has to complete the handshake.
+const remoteHandshakeTimeout = 30 * time.Second
+
+// Operations a client can request.
+const (
+	remoteStat    = "stat"
+	remoteList    = "list" // Every entry of the tree in walk order, answered in batches
+	remoteMkdir   = "mkdir"
+	remoteCreate  = "create"
+	remoteWrite   = "write"
+	remoteCommit  = "commit" // Applies the metadata of a created file and closes it
+	remoteOpen    = "open"
+	remoteRead    = "read"
+	remoteRelease = "release" // Closes a file without committing it
+	remoteChmod   = "chmod"
+	remoteChtimes = "chtimes"
+	remoteRemove  = "remove"
+	remoteRename  = "rename" // Moves Path to Target
+
+	// Delta transfer, see delta.go
+	remoteSums        = "sums"         // Block checksums of an existing file
+	remoteCreateDelta = "create-delta" // Like create, rebuilding the file from its old version
+	remotePatch       = "patch"        // Applies deltaOps to a file opened by create-delta
+)
+
+// Sent by the server when a client connects.
+type remoteHello struct {
+	Version   int
+	Challenge []byte
+	Codecs    []string // Compression the server understands, none from older servers
+}
+
+// The client's answer to remoteHello.
+type remoteLogin struct {
+	Version   int
+	Proof     []byte // HMAC-SHA256 of the challenge keyed with the token
+	Path      string // Directory below the served root to sync to, slash separated
+	Challenge []byte // The client's, for the server to prove knowledge of the token with
+}
+
+// The server's answer to remoteLogin.
+type remoteWelcome struct {
+	Err   string
+	Proof []byte // Of the client's challenge, only once the client proved itself
+}
+
+type remoteRequest struct {
+	ID      uint64
+	Op      string
+	Path    string // Slash separated and relative to the directory logged in to
+	Handle  uint64 // Of a file opened by create or open
+	Data    []byte // Contents for write, the expected SHA-256 when committing a delta
+	Mode    fs.FileMode
+	ModTime time.Time
+
+	BlockSize int
+	Ops       []deltaOp
+	Target    string // Where rename moves Path to, slash separated like it
+
+	// Data and the data of Ops are compressed with this codec, unless empty. For read, the
+	// codec the response is to use
+	Codec Compression
+}
+
+type remoteResponse struct {
+	ID       uint64
+	Err      string
+	NotExist bool // Err is about a missing file, reported as fs.ErrNotExist
+	More     bool // Further responses to the same request follow
+	Handle   uint64
+	Info     remoteEntry
+	Entries  []remoteEntry
+	Data     []byte
+	EOF      bool
+	Sums     []blockSum
+	Codec    Compression // Data is compressed with this codec, unless empty
+}
+
+// A file or directory as transferred over the wire.
+type remoteEntry struct {
+	Path    string // Slash separated, "." for the root
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+func newRemoteEntry(relPath string, info fs.FileInfo) remoteEntry {
+	return remoteEntry{Path: relPath, Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime()}
+}
+
+// Describes a remote entry to code expecting an fs.FileInfo.
+type remoteFileInfo struct {
+	entry remoteEntry
+}
+
+func (i remoteFileInfo) Name() string       { return path.Base(i.entry.Path) }
+func (i remoteFileInfo) Size() int64        { return i.entry.Size }
+func (i remoteFileInfo) Mode() fs.FileMode  { return i.entry.Mode }
+func (i remoteFileInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i remoteFileInfo) IsDir() bool        { return i.entry.Mode.IsDir() }
+func (i remoteFileInfo) Sys() any           { return nil }
+
+// One end of a connection. Messages may be sent from several goroutines at once.
+type remoteConn struct {
+	conn   net.Conn
+	writer *bufio.Writer
+	enc    *gob.Encoder
+	dec    *gob.Decoder
+	sendMu sync.Mutex
+}
+
+func newRemoteConn(conn net.Conn) *remoteConn {
+	writer := bufio.NewWriter(conn)
+	return &remoteConn{
+		conn:   conn,
+		writer: writer,
+		enc:    gob.NewEncoder(writer),
+		dec:    gob.NewDecoder(bufio.NewReader(conn)),
+	}
+}
+
+func (c *remoteConn) send(message any) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if err := c.enc.Encode(message); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+func (c *remoteConn) receive(message any) error {
+	return c.dec.Decode(message)
+}
+
+// Proves knowledge of token without sending it. The side proving it is part of the proof,
+// so neither end can have the other answer its own challenge.
+func remoteProof(token, side string, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(side))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// Random challenge for the other end of the handshake to answer.
+func remoteChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+// Runs the server side of the handshake. The path the client logs in to is handed to
+// open, which may refuse it.
+func (c *remoteConn) accept(token string, open func(path string) error) error {
+	c.conn.SetDeadline(time.Now().Add(remoteHandshakeTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	challenge, err := remoteChallenge()
+	if err != nil {
+		return err
+	}
+	if err := c.send(remoteHello{Version: remoteProtocolVersion, Challenge: challenge, Codecs: remoteCodecs}); err != nil {
+		return err
+	}
+
+	var login remoteLogin
+	if err := c.receive(&login); err != nil {
+		return err
+	}
+
+	// Only a client that proved itself gets the server's proof
+	welcome := remoteWelcome{}
+	switch {
+	case login.Version != remoteProtocolVersion:
+		err = fmt.Errorf("protocol version %d is not supported, the server speaks %d", login.Version, remoteProtocolVersion)
+	case !hmac.Equal(login.Proof, remoteProof(token, "client", challenge)):
+		err = errors.New("authentication failed")
+	default:
+		welcome.Proof = remoteProof(token, "server", login.Challenge)
+		err = open(login.Path)
+	}
+
+	if err != nil {
+		welcome.Err = err.Error()
+	}
+	if sendErr := c.send(welcome); sendErr != nil && err == nil {
+		err = sendErr
+	}
+	return err
+}
+
+// Runs the client side of the handshake, logging in to dir below the served root. Returns
+// the compression codecs the server supports.
+func (c *remoteConn) login(token, dir string) ([]string, error) {
+	c.conn.SetDeadline(time.Now().Add(remoteHandshakeTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	var hello remoteHello
+	if err := c.receive(&hello); err != nil {
+		return nil, fmt.Errorf("not a gosync server: %w", err)
+	}
+	if hello.Version != remoteProtocolVersion {
+		return nil, fmt.Errorf("server speaks protocol version %d, expected %d", hello.Version, remoteProtocolVersion)
+	}
+
+	challenge, err := remoteChallenge()
+	if err != nil {
+		return nil, err
+	}
+	login := remoteLogin{Version: remoteProtocolVersion, Proof: remoteProof(token, "client", hello.Challenge), Path: dir, Challenge: challenge}
+	if err := c.send(login); err != nil {
+		return nil, err
+	}
+
+	var welcome remoteWelcome
+	if err := c.receive(&welcome); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(welcome.Proof, remoteProof(token, "server", challenge)) {
+		if welcome.Err != "" {
+			return nil, errors.New(welcome.Err)
+		}
+		return nil, errors.New("the server doesn't know the token, refusing to sync to it")
+	}
+	if welcome.Err != "" {
+		return nil, errors.New(welcome.Err)
+	}
+	return hello.Codecs, nil
+}
@@ -0,0 +1,51 @@
+package syncer
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRemoteHandshake(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverToken string
+		clientToken string
+		impostor    bool // The server answers as if the client was let in, without knowing the token
+		wantServer  bool // Whether the server lets the client in
+		wantClient  bool // Whether the client trusts the server
+	}{
+		{"same token", "secret", "secret", false, true, true},
+		{"client without the token", "secret", "guess", false, false, false},
+		{"server without the token", "guess", "secret", false, false, false},
+		{"impostor server", "guess", "secret", true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			defer clientConn.Close()
+
+			serverErr := make(chan error, 1)
+			go func() {
+				server := newRemoteConn(serverConn)
+				if !tt.impostor {
+					serverErr <- server.accept(tt.serverToken, func(string) error { return nil })
+					return
+				}
+				server.send(remoteHello{Version: remoteProtocolVersion, Challenge: make([]byte, 32)})
+				var login remoteLogin
+				server.receive(&login)
+				serverErr <- server.send(remoteWelcome{})
+			}()
+
+			_, clientErr := newRemoteConn(clientConn).login(tt.clientToken, "dir")
+			clientConn.Close()
+			if err := <-serverErr; (err == nil) != tt.wantServer {
+				t.Errorf("server error = %v, want it to let the client in %v", err, tt.wantServer)
+			}
+			if (clientErr == nil) != tt.wantClient {
+				t.Errorf("client error = %v, want it to trust the server %v", clientErr, tt.wantClient)
+			}
+		})
+	}
+}
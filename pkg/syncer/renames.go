@@ -0,0 +1,188 @@
+package syncer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bipinmdr07/gosync/internal/junction"
+)
+
+// renamer is implemented by backends that can move a file without it being transferred
+// again. The new path doesn't exist yet.
+type renamer interface {
+	Rename(oldRelPath, newRelPath string) error
+}
+
+// Destination files that may be source files moved elsewhere since the last run, by size.
+type renameCandidates struct {
+	mu     sync.Mutex
+	bySize map[int64][]renameCandidate
+}
+
+type renameCandidate struct {
+	relPath string
+	modTime time.Time
+	mode    os.FileMode
+}
+
+// Walks the destination for files that moved files of the source could be found among.
+// Empty files are left out, copying them costs nothing.
+func (s *Syncer) findRenameCandidates() error {
+	candidates := &renameCandidates{bySize: make(map[int64][]renameCandidate)}
+
+	err := s.dest.Walk(func(relPath string, d os.DirEntry, err error) error {
+		if err != nil {
+			if relPath == "." {
+				return nil // Nothing was synced yet
+			}
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Error walking destination directory")
+			return nil
+		}
+
+		if relPath == "." {
+			return nil
+		}
+
+		// The same directories are kept by deletions, nothing in them counts as gone
+		if d.IsDir() && (s.protectedByMarker(relPath) || s.isPartialDir(relPath) || s.isBackupDir(relPath)) {
+			return filepath.SkipDir
+		}
+		if s.protectPaths.Excludes(relPath, d.IsDir()) {
+			return skipEntry(d)
+		}
+		if s.local != nil && junction.Is(s.local.path(relPath), d) {
+			return skipEntry(d)
+		}
+		if !d.Type().IsRegular() || s.isBackupFile(relPath) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.Size() == 0 {
+			return nil
+		}
+		candidates.bySize[info.Size()] = append(candidates.bySize[info.Size()], renameCandidate{relPath, info.ModTime(), info.Mode()})
+		return nil
+	})
+
+	s.renames = candidates
+	return err
+}
+
+// Returns the candidates of the given size.
+func (c *renameCandidates) ofSize(size int64) []renameCandidate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.bySize[size])
+}
+
+// Removes the candidate at relPath so no other file can be moved from there, reporting
+// whether it was still available.
+func (c *renameCandidates) claim(size int64, relPath string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	candidates := c.bySize[size]
+	for i, candidate := range candidates {
+		if candidate.relPath == relPath {
+			c.bySize[size] = slices.Delete(candidates, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Looks for a destination file that is gone from the source and has the contents of the
+// source file of job, and moves it to where that file belongs instead of copying it.
+// Local destinations are compared by hash. Remote ones would have to be downloaded for
+// that, so a matching modification time is taken as proof there.
+func (s *Syncer) moveRenamed(job fileJob, srcInfo os.FileInfo) bool {
+	relPath := job.relPath
+	var srcSum []byte
+
+	for _, candidate := range s.renames.ofSize(srcInfo.Size()) {
+		// Files still in the source stay where they are, unless they are ignored and deleted anyway
+		if !s.matcher.Matches(candidate.relPath) && !s.pathRules.Excludes(candidate.relPath, false) {
+			if _, err := s.src.Stat(candidate.relPath); !os.IsNotExist(err) {
+				continue
+			}
+		}
+
+		if s.local == nil {
+			srcModTime := srcInfo.ModTime().Truncate(s.dest.ModTimePrecision())
+			if !srcModTime.Equal(candidate.modTime) {
+				continue
+			}
+		} else {
+			if srcSum == nil {
+				var err error
+				if srcSum, err = s.hashSource(job); err != nil {
+					s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not hash file to find it at the destination, copying")
+					return false
+				}
+			}
+
+			candidateInfo, err := s.dest.Stat(candidate.relPath)
+			if err != nil {
+				continue
+			}
+			destSum, ok := s.storedChecksum(s.local.path(candidate.relPath), candidateInfo)
+			if !ok {
+				if destSum, err = s.hashDestination(candidate.relPath); err != nil {
+					continue
+				}
+			}
+			if !bytes.Equal(srcSum, destSum) {
+				continue
+			}
+		}
+
+		if !s.renames.claim(srcInfo.Size(), candidate.relPath) {
+			continue // Taken by a copy of the same file
+		}
+		return s.renameFile(candidate, relPath, srcInfo)
+	}
+
+	return false
+}
+
+// Moves the destination file of candidate to relPath and gives it the metadata of the
+// source file where it differs. Reports whether it was moved.
+func (s *Syncer) renameFile(candidate renameCandidate, relPath string, srcInfo os.FileInfo) bool {
+	oldRelPath := candidate.relPath
+	logEvent := s.logger.Info().Str("action", "RENAME").Str("path", relPath).Str("from", oldRelPath)
+	if s.Options.DryRun {
+		s.stats.recordRename()
+		s.recordPlanned("RENAME", relPath, oldRelPath)
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would move file at destination")
+		return true
+	}
+
+	if err := s.dest.MkdirAll(filepath.Dir(relPath)); err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not create directories to move file into, copying")
+		return false
+	}
+	if err := s.dest.(renamer).Rename(oldRelPath, relPath); err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Str("from", oldRelPath).Msg("Could not move file at destination, copying")
+		return false
+	}
+
+	if !srcInfo.ModTime().Truncate(s.dest.ModTimePrecision()).Equal(candidate.modTime) {
+		if err := s.dest.Chtimes(relPath, srcInfo.ModTime()); err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Error preserving modification time")
+		}
+	}
+	if srcInfo.Mode().Perm() != candidate.mode.Perm() {
+		if err := s.dest.Chmod(relPath, srcInfo.Mode()); err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Error setting file permissions")
+		}
+	}
+
+	s.stats.recordRename()
+	logEvent.Msg("File moved at destination")
+	return true
+}
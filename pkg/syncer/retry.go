@@ -0,0 +1,66 @@
+package syncer
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Longest wait between two attempts, however often they were retried.
+const maxRetryDelay = time.Minute
+
+// ErrSourceChanged is recorded for files whose size or modification time changed while
+// they were copied, on every attempt. No torn copy of them is kept.
+var ErrSourceChanged = errors.New("source file changed while being copied")
+
+// Reports whether err may go away when the operation is tried again, like a dropped
+// connection to a network share. Missing files and refused access stay that way.
+func transient(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, errEscapesDestination) &&
+		!errors.Is(err, ErrSourceChanged) // Copied again with its new size and time instead
+}
+
+// Returns ErrSourceChanged when the source file of job no longer has the size and
+// modification time of srcInfo, having been written to while it was read. A source that
+// can't be looked at again is taken to be unchanged.
+func (s *Syncer) checkSourceUnchanged(job fileJob, srcInfo os.FileInfo) error {
+	info, err := job.src.Stat(job.srcPath)
+	if err != nil || (info.Size() == srcInfo.Size() && info.ModTime().Equal(srcInfo.ModTime())) {
+		return nil
+	}
+	s.logger.Warn().Str("action", "SOURCE_CHANGED").Str("path", job.relPath).Int64("size", info.Size()).Time("mtime", info.ModTime()).Msg("Source file changed while being copied, dropping the copy")
+	return ErrSourceChanged
+}
+
+// Runs op on relPath until it succeeds, fails for good or the retries run out, waiting
+// twice as long before each retry as before the last. Returns the last error.
+func (s *Syncer) withRetries(relPath string, op func() error) error {
+	delay := s.Options.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt > s.Options.Retries || !transient(err) || s.ctx.Err() != nil {
+			return err
+		}
+
+		s.logger.Warn().Err(err).Str("action", "RETRY").Str("path", relPath).Int("attempt", attempt+1).Dur("delay", delay).Msg("Operation failed, retrying")
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			return err
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+// Waits RetryDelay for whatever writes to the source file of job, which changed while it
+// was copied, and returns how the file is now for the next copy.
+func (s *Syncer) awaitSourceChange(job fileJob) (os.FileInfo, error) {
+	s.logger.Warn().Str("action", "RETRY").Str("path", job.relPath).Dur("delay", s.Options.RetryDelay).Msg("Copying changed source file again")
+	select {
+	case <-time.After(s.Options.RetryDelay):
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+	return job.src.Stat(job.srcPath)
+}
@@ -0,0 +1,1051 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Speaks version 29 of rsync's protocol, the last one before the compatibility flags and
+// variable length integers of rsync 3, which every rsync since 2.6.4 still accepts. Only
+// whole files are sent and received, the server is never asked to match blocks.
+const (
+	rsyncProtocol   = 29
+	rsyncDaemonPort = 873
+	rsyncChunk      = 32 << 10 // Most literal data sent in one token, rsync's CHUNK_SIZE
+	rsyncDone       = -1       // Ends a phase of requests
+)
+
+// Tags of what the server multiplexes on its output.
+const (
+	rsyncMplexBase    = 7
+	rsyncMsgData      = 0
+	rsyncMsgErrorXfer = 1
+	rsyncMsgError     = 3
+	rsyncMsgWarning   = 4
+)
+
+// Flags of file list entries.
+const (
+	rsyncXmitTopDir        = 1 << 0
+	rsyncXmitSameMode      = 1 << 1
+	rsyncXmitExtendedFlags = 1 << 2
+	rsyncXmitSameName      = 1 << 5
+	rsyncXmitLongName      = 1 << 6
+	rsyncXmitSameTime      = 1 << 7
+)
+
+// Flags of transfer requests.
+const (
+	rsyncItemBasisTypeFollows = 1 << 11
+	rsyncItemXnameFollows     = 1 << 12
+	rsyncItemTransfer         = 1 << 15
+)
+
+// File types as rsync sends them in modes.
+const (
+	rsyncTypeMask    = 0o170000
+	rsyncTypeDir     = 0o040000
+	rsyncTypeRegular = 0o100000
+	rsyncTypeSymlink = 0o120000
+)
+
+// Where an rsync backend lives, parsed from rsync://user@host:port/module/path for an rsync
+// daemon or rsync+ssh://user@host:port/path for rsync run over ssh.
+type rsyncLocation struct {
+	shell bool
+	user  string
+	host  string // host:port for daemons, the host alone for ssh
+	port  string // For ssh, unless its default
+	path  string // module/path for daemons, slash separated
+}
+
+func parseRsyncLocation(location string) (rsyncLocation, bool) {
+	var parsed rsyncLocation
+
+	u, err := url.Parse(location)
+	if err != nil || u.Hostname() == "" {
+		return parsed, false
+	}
+	parsed.user = u.User.Username()
+
+	switch u.Scheme {
+	case "rsync":
+		parsed.path = strings.Trim(path.Clean("/"+u.Path), "/")
+		if parsed.path == "" {
+			return parsed, false // Daemons serve modules, not their whole machine
+		}
+		parsed.host = u.Host
+		if u.Port() == "" {
+			parsed.host = net.JoinHostPort(u.Hostname(), strconv.Itoa(rsyncDaemonPort))
+		}
+	case "rsync+ssh":
+		parsed.shell = true
+		parsed.host, parsed.port = u.Hostname(), u.Port()
+		parsed.path = u.Path
+		if parsed.path == "" {
+			parsed.path = "." // The login directory
+		}
+	default:
+		return parsed, false
+	}
+	return parsed, true
+}
+
+// Starts rsync as a server for args and returns the connection to it once the
+// protocol is agreed on.
+func (l rsyncLocation) connect(args []string) (*rsyncConn, error) {
+	if l.shell {
+		return l.startShell(args)
+	}
+	return l.dialDaemon(args)
+}
+
+// Runs rsync on the host through ssh, or the command in RSYNC_RSH like rsync does.
+func (l rsyncLocation) startShell(args []string) (*rsyncConn, error) {
+	command := strings.Fields(os.Getenv("RSYNC_RSH"))
+	if len(command) == 0 {
+		command = []string{"ssh"}
+	}
+	if l.port != "" {
+		command = append(command, "-p", l.port)
+	}
+	if l.user != "" {
+		command = append(command, "-l", l.user)
+	}
+	command = append(command, l.host, "rsync")
+	for _, arg := range args {
+		command = append(command, shellQuote(arg))
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	conn := newRsyncConn(stdout, stdin)
+	conn.close = func() error {
+		stdin.Close()
+		if err := cmd.Wait(); err != nil {
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				return fmt.Errorf("%w: %s", err, message)
+			}
+			return err
+		}
+		return nil
+	}
+
+	// Both sides send their version, the lower one is spoken
+	conn.writeInt(rsyncProtocol)
+	err = conn.w.Flush()
+	var version uint32
+	if err == nil {
+		version, err = conn.readRawInt()
+	}
+	if err == nil {
+		err = checkRsyncVersion(int(version))
+	}
+	if err == nil {
+		err = conn.start()
+	}
+	if err != nil {
+		if closeErr := conn.close(); closeErr != nil {
+			return nil, fmt.Errorf("%w (%v)", err, closeErr)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Connects to an rsync daemon, logs in to the module when it asks for a password and
+// passes args like the command line of the server.
+func (l rsyncLocation) dialDaemon(args []string) (*rsyncConn, error) {
+	netConn, err := net.DialTimeout("tcp", l.host, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn := newRsyncConn(netConn, netConn)
+	conn.close = netConn.Close
+
+	if err := l.daemonHandshake(conn, args); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := conn.start(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (l rsyncLocation) daemonHandshake(conn *rsyncConn, args []string) error {
+	module, _, _ := strings.Cut(l.path, "/")
+
+	fmt.Fprintf(conn.w, "@RSYNCD: %d.0\n", rsyncProtocol)
+	if err := conn.w.Flush(); err != nil {
+		return err
+	}
+	greeting, err := conn.readLine()
+	if err != nil {
+		return err
+	}
+	version, ok := strings.CutPrefix(greeting, "@RSYNCD: ")
+	if !ok {
+		return fmt.Errorf("not an rsync daemon, it greeted with %q", greeting)
+	}
+	version, _, _ = strings.Cut(version, ".")
+	major, err := strconv.Atoi(version)
+	if err != nil {
+		return fmt.Errorf("not an rsync daemon, it greeted with %q", greeting)
+	}
+	if err := checkRsyncVersion(major); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(conn.w, "%s\n", module)
+	if err := conn.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := conn.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "@RSYNCD: OK" {
+			break
+		}
+		if challenge, ok := strings.CutPrefix(line, "@RSYNCD: AUTHREQD "); ok {
+			password, ok := os.LookupEnv("RSYNC_PASSWORD")
+			if !ok {
+				return fmt.Errorf("module %s needs a password, set it in RSYNC_PASSWORD", module)
+			}
+			user := l.user
+			if user == "" {
+				user = currentUsername()
+			}
+			fmt.Fprintf(conn.w, "%s %s\n", user, rsyncAuthResponse(password, challenge))
+			if err := conn.w.Flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		if message, ok := strings.CutPrefix(line, "@ERROR"); ok {
+			return errors.New(strings.TrimLeft(message, ": "))
+		}
+		if line == "@RSYNCD: EXIT" {
+			return fmt.Errorf("the daemon closed the connection")
+		}
+		// Anything else is the message of the day
+	}
+
+	for _, arg := range args {
+		fmt.Fprintf(conn.w, "%s\n", arg)
+	}
+	fmt.Fprint(conn.w, "\n")
+	return conn.w.Flush()
+}
+
+// The answer to a daemon's challenge for protocols before 30: the MD4 of the password and
+// the challenge, seeded with zero, in base64 without padding.
+func rsyncAuthResponse(password, challenge string) string {
+	sum := md4.New()
+	sum.Write(make([]byte, 4))
+	sum.Write([]byte(password))
+	sum.Write([]byte(challenge))
+	return base64.RawStdEncoding.EncodeToString(sum.Sum(nil))
+}
+
+func checkRsyncVersion(version int) error {
+	if version < rsyncProtocol {
+		return fmt.Errorf("the server speaks rsync protocol %d, at least %d is needed", version, rsyncProtocol)
+	}
+	return nil
+}
+
+// Quotes arg for the shell ssh runs the command with.
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// A connection to rsync running as a server. What we send is plain, what it sends is
+// multiplexed with its messages.
+type rsyncConn struct {
+	raw   *bufio.Reader
+	in    *rsyncDemux
+	w     *bufio.Writer
+	seed  uint32 // Starts the checksums of whole files
+	close func() error
+}
+
+func newRsyncConn(r io.Reader, w io.Writer) *rsyncConn {
+	raw := bufio.NewReaderSize(r, 64<<10)
+	return &rsyncConn{raw: raw, in: &rsyncDemux{r: raw}, w: bufio.NewWriterSize(w, 64<<10)}
+}
+
+// Reads the checksum seed the server sends once it has started, before its output is
+// multiplexed.
+func (c *rsyncConn) start() error {
+	seed, err := c.readRawInt()
+	if err != nil {
+		return err
+	}
+	// Daemons refusing the arguments say so in plain text
+	if start := binary.LittleEndian.AppendUint32(nil, seed); string(start) == "@ERR" {
+		line, _ := c.readLine()
+		return errors.New(strings.TrimLeft(strings.TrimPrefix(line, "OR"), ": "))
+	}
+	c.seed = seed
+	return nil
+}
+
+func (c *rsyncConn) readLine() (string, error) {
+	line, err := c.raw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *rsyncConn) readRawInt() (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(c.raw, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func (c *rsyncConn) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(c.in, b)
+	return b, err
+}
+
+func (c *rsyncConn) readByte() (byte, error) {
+	b, err := c.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (c *rsyncConn) readShort() (int, error) {
+	b, err := c.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.LittleEndian.Uint16(b)), nil
+}
+
+func (c *rsyncConn) readInt() (int32, error) {
+	b, err := c.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+// Reads a 64 bit integer, sent as 32 bits unless it doesn't fit.
+func (c *rsyncConn) readLong() (int64, error) {
+	value, err := c.readInt()
+	if err != nil || value != -1 {
+		return int64(value), err
+	}
+	b, err := c.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+// Reads a string of up to 32767 bytes, its length sent in one byte when it fits in 7 bits.
+func (c *rsyncConn) readVstring() ([]byte, error) {
+	length, err := c.readByte()
+	if err != nil {
+		return nil, err
+	}
+	n := int(length)
+	if length&0x80 != 0 {
+		low, err := c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		n = int(length&0x7f)<<8 | int(low)
+	}
+	return c.read(n)
+}
+
+// Writes are buffered and only fail once flushed.
+func (c *rsyncConn) writeByte(value byte) {
+	c.w.WriteByte(value)
+}
+
+func (c *rsyncConn) writeShort(value int) {
+	c.w.Write(binary.LittleEndian.AppendUint16(nil, uint16(value)))
+}
+
+func (c *rsyncConn) writeInt(value int32) {
+	c.w.Write(binary.LittleEndian.AppendUint32(nil, uint32(value)))
+}
+
+func (c *rsyncConn) writeLong(value int64) {
+	if value >= 0 && value <= 0x7fffffff {
+		c.writeInt(int32(value))
+		return
+	}
+	c.writeInt(-1)
+	c.w.Write(binary.LittleEndian.AppendUint64(nil, uint64(value)))
+}
+
+func (c *rsyncConn) writeVstring(value []byte) {
+	if len(value) > 0x7f {
+		c.writeByte(byte(len(value)>>8) | 0x80)
+	}
+	c.writeByte(byte(len(value)))
+	c.w.Write(value)
+}
+
+// Returns the error the server reported, or else err.
+func (c *rsyncConn) failure(err error) error {
+	if reported := c.in.reported(); reported != nil {
+		return reported
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("the server closed the connection")
+	}
+	return err
+}
+
+// Waits for the server to finish and returns the errors it reported on the way.
+func (c *rsyncConn) finish() error {
+	if err := c.w.Flush(); err != nil {
+		c.close()
+		return c.failure(err)
+	}
+	io.Copy(io.Discard, c.in)
+	closeErr := c.close()
+	if reported := c.in.reported(); reported != nil {
+		return reported
+	}
+	return closeErr
+}
+
+// Reads the data the server multiplexes with its messages, collecting its errors and
+// warnings.
+type rsyncDemux struct {
+	r         io.Reader
+	remaining int // Of the data frame being read
+	messages  []string
+
+	// Fails reads on errors and warnings instead of waiting for more data, which the
+	// server doesn't send for files it fails to read
+	stopOnError bool
+}
+
+func (d *rsyncDemux) Read(p []byte) (int, error) {
+	for d.remaining == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(d.r, header[:]); err != nil {
+			return 0, err
+		}
+		value := binary.LittleEndian.Uint32(header[:])
+		tag, length := int(value>>24)-rsyncMplexBase, int(value&0xffffff)
+		if tag < 0 {
+			return 0, fmt.Errorf("unexpected data from the server, is it rsync?")
+		}
+		if tag == rsyncMsgData {
+			d.remaining = length
+			continue
+		}
+
+		message := make([]byte, length)
+		if _, err := io.ReadFull(d.r, message); err != nil {
+			return 0, err
+		}
+		switch tag {
+		case rsyncMsgErrorXfer, rsyncMsgError, rsyncMsgWarning:
+			d.messages = append(d.messages, strings.TrimSpace(string(message)))
+			if d.stopOnError {
+				return 0, d.reported()
+			}
+		}
+		// Anything else is logging and keep-alives
+	}
+
+	n, err := d.r.Read(p[:min(len(p), d.remaining)])
+	d.remaining -= n
+	return n, err
+}
+
+// Returns the errors and warnings the server sent, nil when there are none.
+func (d *rsyncDemux) reported() error {
+	if len(d.messages) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(d.messages, "; "))
+}
+
+// An entry of a file list.
+type rsyncFile struct {
+	path    string // Slash separated, "." for the top directory
+	mode    uint32 // With rsync's file type bits
+	size    int64
+	modTime int64 // Unix seconds
+	target  string
+}
+
+func (f rsyncFile) isDir() bool {
+	return f.mode&rsyncTypeMask == rsyncTypeDir
+}
+
+func (f rsyncFile) isRegular() bool {
+	return f.mode&rsyncTypeMask == rsyncTypeRegular
+}
+
+// Reads a file list up to its end and the I/O error flag after it.
+func (c *rsyncConn) readFileList() ([]rsyncFile, error) {
+	var files []rsyncFile
+	var last rsyncFile
+	lastName := ""
+
+	for {
+		flags, err := c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if flags == 0 {
+			break
+		}
+		xflags := int(flags)
+		if xflags&rsyncXmitExtendedFlags != 0 {
+			high, err := c.readByte()
+			if err != nil {
+				return nil, err
+			}
+			xflags |= int(high) << 8
+		}
+
+		var file rsyncFile
+		shared := 0
+		if xflags&rsyncXmitSameName != 0 {
+			b, err := c.readByte()
+			if err != nil {
+				return nil, err
+			}
+			shared = int(b)
+		}
+		var length int
+		if xflags&rsyncXmitLongName != 0 {
+			n, err := c.readInt()
+			if err != nil {
+				return nil, err
+			}
+			length = int(n)
+		} else {
+			b, err := c.readByte()
+			if err != nil {
+				return nil, err
+			}
+			length = int(b)
+		}
+		if shared > len(lastName) || length < 0 {
+			return nil, fmt.Errorf("invalid file list entry")
+		}
+		name, err := c.read(length)
+		if err != nil {
+			return nil, err
+		}
+		lastName = lastName[:shared] + string(name)
+		file.path = lastName
+
+		if file.size, err = c.readLong(); err != nil {
+			return nil, err
+		}
+		file.modTime = last.modTime
+		if xflags&rsyncXmitSameTime == 0 {
+			modTime, err := c.readInt()
+			if err != nil {
+				return nil, err
+			}
+			file.modTime = int64(modTime)
+		}
+		file.mode = last.mode
+		if xflags&rsyncXmitSameMode == 0 {
+			mode, err := c.readInt()
+			if err != nil {
+				return nil, err
+			}
+			file.mode = uint32(mode)
+		}
+		if file.mode&rsyncTypeMask == rsyncTypeSymlink {
+			n, err := c.readInt()
+			if err != nil {
+				return nil, err
+			}
+			target, err := c.read(int(n))
+			if err != nil {
+				return nil, err
+			}
+			file.target = string(target)
+		}
+
+		files = append(files, file)
+		last = file
+	}
+
+	// Whether the server failed to read some of the tree, its messages tell what
+	if _, err := c.readInt(); err != nil {
+		return nil, err
+	}
+
+	sortRsyncFiles(files)
+	return files, nil
+}
+
+// Writes files, which must be sorted, as a file list followed by a clear I/O error flag.
+// The first entry is the top directory of the transfer.
+func (c *rsyncConn) writeFileList(files []rsyncFile) {
+	for i, file := range files {
+		xflags := 0
+		if i == 0 && file.isDir() {
+			xflags |= rsyncXmitTopDir
+		}
+		if len(file.path) > 255 {
+			xflags |= rsyncXmitLongName
+		}
+		if xflags == 0 {
+			xflags = rsyncXmitExtendedFlags // A zero byte would end the list
+		}
+
+		if xflags&rsyncXmitExtendedFlags != 0 {
+			c.writeShort(xflags)
+		} else {
+			c.writeByte(byte(xflags))
+		}
+		if xflags&rsyncXmitLongName != 0 {
+			c.writeInt(int32(len(file.path)))
+		} else {
+			c.writeByte(byte(len(file.path)))
+		}
+		c.w.WriteString(file.path)
+		c.writeLong(file.size)
+		c.writeInt(int32(file.modTime))
+		c.writeInt(int32(file.mode))
+	}
+	c.writeByte(0)
+	c.writeInt(0)
+}
+
+// Sorts files the way rsync numbers them: within a directory its files come first, by
+// name, then its subdirectories, each followed by its contents.
+func sortRsyncFiles(files []rsyncFile) {
+	slices.SortFunc(files, func(a, b rsyncFile) int {
+		return compareRsyncPaths(a.path, a.isDir(), b.path, b.isDir())
+	})
+}
+
+func compareRsyncPaths(a string, aDir bool, b string, bDir bool) int {
+	// The top directory comes before everything
+	if a == "." || b == "." {
+		return boolCompare(b == ".", a == ".")
+	}
+
+	aParts, bParts := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; ; i++ {
+		// A path goes on below this part when it has more or is a directory
+		aPath := i < len(aParts)-1 || aDir
+		bPath := i < len(bParts)-1 || bDir
+		if aPath != bPath {
+			return boolCompare(aPath, bPath)
+		}
+		if aParts[i] == bParts[i] {
+			if i == len(aParts)-1 || i == len(bParts)-1 {
+				return len(aParts) - len(bParts)
+			}
+			continue
+		}
+		// Directories compare as if their names ended with a slash
+		aName, bName := aParts[i], bParts[i]
+		if aPath {
+			aName, bName = aName+"/", bName+"/"
+		}
+		return strings.Compare(aName, bName)
+	}
+}
+
+// Orders false before true.
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// The checksum of a whole file, the MD4 of the seed and the contents.
+func newRsyncFileSum(seed uint32) hash.Hash {
+	sum := md4.New()
+	sum.Write(binary.LittleEndian.AppendUint32(nil, seed))
+	return sum
+}
+
+// A session reading from the server, which sends the whole file list first and then
+// each file asked for.
+type rsyncPull struct {
+	conn  *rsyncConn
+	files []rsyncFile // In the order the server numbers them
+	index map[string]int
+}
+
+// Starts a session reading the tree at the location. A tree that doesn't exist has no
+// files and the session is already over.
+func (l rsyncLocation) pull() (*rsyncPull, error) {
+	source := l.path
+	if !strings.HasSuffix(source, "/") {
+		source += "/" // Its contents rather than itself
+	}
+	conn, err := l.connect([]string{"--server", "--sender", "-lr", ".", source})
+	if err != nil {
+		return nil, err
+	}
+
+	conn.writeInt(0) // No filter rules
+	if err := conn.w.Flush(); err != nil {
+		conn.close()
+		return nil, err
+	}
+	files, err := conn.readFileList()
+	if err != nil {
+		err = conn.failure(err)
+		conn.close()
+		return nil, err
+	}
+
+	// With nothing to send the server quits
+	if len(files) == 0 {
+		reported := conn.in.reported()
+		conn.finish()
+		if reported != nil && !strings.Contains(reported.Error(), "No such file or directory") {
+			return nil, reported
+		}
+		return &rsyncPull{}, nil
+	}
+
+	pull := &rsyncPull{conn: conn, files: files, index: make(map[string]int, len(files))}
+	for i, file := range files {
+		pull.index[file.path] = i
+	}
+	return pull, nil
+}
+
+// Copies the contents of the regular file at slashPath to w.
+func (p *rsyncPull) fetch(slashPath string, w io.Writer) error {
+	ndx, ok := p.index[slashPath]
+	if !ok || p.conn == nil {
+		return &fs.PathError{Op: "open", Path: slashPath, Err: fs.ErrNotExist}
+	}
+	c := p.conn
+
+	// Whole, without checksums of an older version to match against
+	c.writeInt(int32(ndx))
+	c.writeShort(rsyncItemTransfer)
+	for range 4 {
+		c.writeInt(0)
+	}
+	if err := c.w.Flush(); err != nil {
+		return c.failure(err)
+	}
+
+	c.in.stopOnError = true
+	got, err := c.readInt()
+	c.in.stopOnError = false
+	if err != nil {
+		return c.failure(err)
+	}
+	if got != int32(ndx) {
+		return fmt.Errorf("the server sent file %d when asked for %d", got, ndx)
+	}
+	iflags, err := c.readShort()
+	if err != nil {
+		return c.failure(err)
+	}
+	if iflags&rsyncItemBasisTypeFollows != 0 {
+		if _, err := c.readByte(); err != nil {
+			return c.failure(err)
+		}
+	}
+	if iflags&rsyncItemXnameFollows != 0 {
+		if _, err := c.readVstring(); err != nil {
+			return c.failure(err)
+		}
+	}
+	if _, err := c.read(16); err != nil { // The checksum header sent back
+		return c.failure(err)
+	}
+
+	sum := newRsyncFileSum(c.seed)
+	out := io.MultiWriter(w, sum)
+	for {
+		token, err := c.readInt()
+		if err != nil {
+			return c.failure(err)
+		}
+		if token == 0 {
+			break
+		}
+		if token < 0 {
+			return fmt.Errorf("the server referred to a block of %s it has no checksums for", slashPath)
+		}
+		if _, err := io.CopyN(out, c.in, int64(token)); err != nil {
+			return c.failure(err)
+		}
+	}
+
+	want, err := c.read(md4.Size)
+	if err != nil {
+		return c.failure(err)
+	}
+	if !bytes.Equal(sum.Sum(nil), want) {
+		return fmt.Errorf("%s arrived corrupted, its checksum doesn't match", slashPath)
+	}
+	return nil
+}
+
+// Ends the session. The server goes through its phases on a request each, sends its
+// statistics and then waits for a last one, so all four are sent at once.
+func (p *rsyncPull) close() error {
+	if p.conn == nil {
+		return nil
+	}
+	c := p.conn
+	p.conn = nil
+
+	for range 4 {
+		c.writeInt(rsyncDone)
+	}
+	return c.finish()
+}
+
+// An entry sent to the server, with its contents for regular files. Regular files without
+// contents only have their metadata updated.
+type rsyncUpload struct {
+	rsyncFile
+	contents *os.File
+}
+
+// How a push treats entries the server already has.
+const (
+	rsyncMetadataOnly = "--size-only" // Updates the metadata, unless the size differs
+	rsyncReplace      = "-I"          // Sends the contents whatever the server has
+)
+
+// Sends uploads to the location in one session, which must hold the parents of every
+// entry. With replace the contents of regular files are sent even where the server's look
+// the same, else only their metadata is updated. Directory times are only set with dirTimes. When
+// deletions are given, the server removes those, and only those, of the directories sent.
+func (l rsyncLocation) push(uploads []rsyncUpload, replace, dirTimes bool, deletions []string) error {
+	args := []string{"--server", "-lptr"}
+	if replace {
+		args = append(args, rsyncReplace)
+	} else {
+		args = append(args, rsyncMetadataOnly)
+	}
+	if !dirTimes {
+		args = append(args, "-O")
+	}
+	if len(deletions) > 0 {
+		args = append(args, "--delete")
+	}
+	args = append(args, ".", l.path)
+
+	conn, err := l.connect(args)
+	if err != nil {
+		return err
+	}
+
+	if len(deletions) > 0 {
+		for _, relPath := range deletions {
+			rule := "R /" + rsyncPattern(relPath)
+			conn.writeInt(int32(len(rule)))
+			conn.w.WriteString(rule)
+		}
+		conn.writeInt(int32(len("P *")))
+		conn.w.WriteString("P *")
+		conn.writeInt(0)
+	}
+
+	slices.SortFunc(uploads, func(a, b rsyncUpload) int {
+		return compareRsyncPaths(a.path, a.isDir(), b.path, b.isDir())
+	})
+	files := make([]rsyncFile, len(uploads))
+	for i, upload := range uploads {
+		files[i] = upload.rsyncFile
+	}
+	conn.writeFileList(files)
+
+	if err := l.sendFiles(conn, uploads); err != nil {
+		err = conn.failure(err)
+		conn.close()
+		return err
+	}
+	return conn.finish()
+}
+
+// Answers the server's requests for files until it is done with them.
+func (l rsyncLocation) sendFiles(c *rsyncConn, uploads []rsyncUpload) error {
+	phase := 0
+	for {
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		ndx, err := c.readInt()
+		if err != nil {
+			return err
+		}
+		if ndx == rsyncDone {
+			if phase++; phase > 2 {
+				break
+			}
+			c.writeInt(rsyncDone)
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(uploads) {
+			return fmt.Errorf("the server asked for file %d of %d", ndx, len(uploads))
+		}
+
+		iflags, err := c.readShort()
+		if err != nil {
+			return err
+		}
+		var basisType []byte
+		if iflags&rsyncItemBasisTypeFollows != 0 {
+			if basisType, err = c.read(1); err != nil {
+				return err
+			}
+		}
+		var xname []byte
+		if iflags&rsyncItemXnameFollows != 0 {
+			if xname, err = c.readVstring(); err != nil {
+				return err
+			}
+		}
+		writeHeader := func() {
+			c.writeInt(ndx)
+			c.writeShort(iflags)
+			c.w.Write(basisType)
+			if iflags&rsyncItemXnameFollows != 0 {
+				c.writeVstring(xname)
+			}
+		}
+
+		// Changes without contents are only passed back for the server to log
+		if iflags&rsyncItemTransfer == 0 {
+			writeHeader()
+			continue
+		}
+
+		// The checksums of the server's version, which are of no use for whole files
+		header, err := c.read(16)
+		if err != nil {
+			return err
+		}
+		count := int64(int32(binary.LittleEndian.Uint32(header)))
+		sumLength := int64(int32(binary.LittleEndian.Uint32(header[8:])))
+		if count < 0 || sumLength < 0 || sumLength > 16 {
+			return fmt.Errorf("invalid checksum header from the server")
+		}
+		if _, err := io.CopyN(io.Discard, c.in, count*(4+sumLength)); err != nil {
+			return err
+		}
+
+		// Files whose contents we don't have are left out, the server expects that of
+		// files a sender fails to read
+		upload := uploads[ndx]
+		if upload.contents == nil {
+			continue
+		}
+		writeHeader()
+		c.w.Write(header)
+		if err := c.sendContents(upload.contents); err != nil {
+			return err
+		}
+	}
+
+	// The server says goodbye once it has everything, which finish reads
+	c.writeInt(rsyncDone)
+	return nil
+}
+
+// Sends the contents of file as literal data followed by their checksum.
+func (c *rsyncConn) sendContents(file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := newRsyncFileSum(c.seed)
+	buf := make([]byte, rsyncChunk)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			c.writeInt(int32(n))
+			c.w.Write(buf[:n])
+			sum.Write(buf[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.writeInt(0)
+	c.w.Write(sum.Sum(nil))
+	return nil
+}
+
+// Returns a filter pattern matching only relPath, whose wildcards are escaped when it has
+// any, as rsync only treats backslashes specially then.
+func rsyncPattern(slashPath string) string {
+	if !strings.ContainsAny(slashPath, "*?[") {
+		return slashPath
+	}
+	var escaped strings.Builder
+	for _, r := range slashPath {
+		if strings.ContainsRune(`*?[\`, r) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
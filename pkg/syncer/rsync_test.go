@@ -0,0 +1,500 @@
+package syncer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"maps"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRsyncLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     rsyncLocation
+		wantOK   bool
+	}{
+		{"rsync://host/module/dir", rsyncLocation{host: "host:873", path: "module/dir"}, true},
+		{"rsync://user@host:8873/module/", rsyncLocation{user: "user", host: "host:8873", path: "module"}, true},
+		{"rsync://host/", rsyncLocation{}, false},
+		{"rsync+ssh://user@host/srv/backup", rsyncLocation{shell: true, user: "user", host: "host", path: "/srv/backup"}, true},
+		{"rsync+ssh://host:2222", rsyncLocation{shell: true, host: "host", port: "2222", path: "."}, true},
+		{"sftp://host/dir", rsyncLocation{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRsyncLocation(tt.location)
+		if ok != tt.wantOK || ok && got != tt.want {
+			t.Errorf("parseRsyncLocation(%q) = %+v, %v, want %+v, %v", tt.location, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRsyncFileOrder(t *testing.T) {
+	// Files before subdirectories, which sort as if their names ended with a slash
+	want := []string{".", "a", "a.b", "b", "a.d/", "a.d/x", "a/", "a/x", "a/sub/", "a/sub/y", "ab/"}
+	files := make([]rsyncFile, len(want))
+	for i, name := range want {
+		files[i] = rsyncFile{path: strings.TrimSuffix(name, "/"), mode: rsyncTypeRegular}
+		if name == "." || strings.HasSuffix(name, "/") {
+			files[i].mode = rsyncTypeDir
+		}
+	}
+	shuffled := slices.Clone(files)
+	slices.Reverse(shuffled)
+	sortRsyncFiles(shuffled)
+	if !slices.Equal(shuffled, files) {
+		t.Errorf("sorted to %v, want %v", shuffled, files)
+	}
+}
+
+// Writes what the server multiplexes, all of it as data.
+type rsyncMux struct {
+	w io.Writer
+}
+
+func (m rsyncMux) Write(p []byte) (int, error) {
+	return len(p), m.message(rsyncMsgData, p)
+}
+
+func (m rsyncMux) message(tag int, p []byte) error {
+	header := binary.LittleEndian.AppendUint32(nil, uint32(rsyncMplexBase+tag)<<24|uint32(len(p)))
+	_, err := m.w.Write(append(header, p...))
+	return err
+}
+
+func TestRsyncFileList(t *testing.T) {
+	var buf bytes.Buffer
+	writer := newRsyncConn(nil, rsyncMux{&buf})
+	files := []rsyncFile{
+		{path: ".", mode: rsyncTypeDir | 0o755, modTime: 1700000000},
+		{path: "file", mode: rsyncTypeRegular | 0o644, size: 5 << 30, modTime: 1700000001},
+		{path: "dir", mode: rsyncTypeDir | 0o700, modTime: 1700000002},
+		{path: "dir/" + strings.Repeat("long", 100), mode: rsyncTypeRegular | 0o600, size: 1, modTime: 1700000003},
+	}
+	writer.writeFileList(files)
+	writer.w.Flush()
+
+	got, err := newRsyncConn(&buf, io.Discard).readFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, files) {
+		t.Errorf("read %+v, want %+v", got, files)
+	}
+
+	// Entries the way rsync shortens them, sharing the start of the name, the time or the
+	// mode of the one before
+	list := []byte{rsyncXmitTopDir, 3, 'd', 'i', 'r', 0, 0, 0, 0}
+	list = binary.LittleEndian.AppendUint32(list, 1700000000)
+	list = binary.LittleEndian.AppendUint32(list, rsyncTypeDir|0o755)
+	list = append(list, rsyncXmitSameName|rsyncXmitSameTime, 3, 2, '/', 'b', 2, 0, 0, 0)
+	list = binary.LittleEndian.AppendUint32(list, rsyncTypeRegular|0o644)
+	list = append(list, rsyncXmitSameName|rsyncXmitSameTime|rsyncXmitSameMode, 4, 1, 'c', 3, 0, 0, 0)
+	list = append(list, rsyncXmitSameName|rsyncXmitSameTime, 4, 3, 'l', 'n', 'k', 0, 0, 0, 0)
+	list = binary.LittleEndian.AppendUint32(list, rsyncTypeSymlink|0o777)
+	list = append(list, 1, 0, 0, 0, 'b', 0, 0, 0, 0, 0)
+	buf.Reset()
+	rsyncMux{&buf}.Write(list)
+
+	got, err = newRsyncConn(&buf, io.Discard).readFileList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rsyncFile{
+		{path: "dir", mode: rsyncTypeDir | 0o755, modTime: 1700000000},
+		{path: "dir/b", mode: rsyncTypeRegular | 0o644, size: 2, modTime: 1700000000},
+		{path: "dir/c", mode: rsyncTypeRegular | 0o644, size: 3, modTime: 1700000000},
+		{path: "dir/lnk", mode: rsyncTypeSymlink | 0o777, modTime: 1700000000, target: "b"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+}
+
+func TestRsyncDemux(t *testing.T) {
+	var buf bytes.Buffer
+	mux := rsyncMux{&buf}
+	mux.Write([]byte("da"))
+	mux.message(2, []byte("info is dropped\n"))
+	mux.Write([]byte("ta"))
+	mux.message(rsyncMsgWarning, []byte("file has vanished: x\n"))
+	mux.message(rsyncMsgError, []byte("send_files failed to open y\n"))
+
+	demux := &rsyncDemux{r: &buf}
+	data, err := io.ReadAll(io.LimitReader(demux, 4))
+	if err != nil || string(data) != "data" {
+		t.Fatalf("read %q, %v, want data", data, err)
+	}
+	demux.stopOnError = true
+	if _, err := demux.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "vanished") {
+		t.Errorf("read failed with %v, want the warning", err)
+	}
+	demux.stopOnError = false
+	io.ReadAll(demux)
+	if err := demux.reported(); err == nil || err.Error() != "file has vanished: x; send_files failed to open y" {
+		t.Errorf("reported %v, want both messages", err)
+	}
+}
+
+// Serves root as module "mod" the way rsync's daemon does, for protocol 29 clients
+// only, asking for password unless it is empty.
+func serveRsync(t *testing.T, root, password string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer netConn.Close()
+				serveRsyncSession(netConn, root, password)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveRsyncSession(netConn net.Conn, root, password string) error {
+	in := bufio.NewReader(netConn)
+	io.WriteString(netConn, "@RSYNCD: 31.0\n")
+	greeting, _ := in.ReadString('\n')
+	if greeting != "@RSYNCD: 29.0\n" {
+		return io.ErrUnexpectedEOF
+	}
+	if module, _ := in.ReadString('\n'); module != "mod\n" {
+		io.WriteString(netConn, "@ERROR: Unknown module\n")
+		return nil
+	}
+	io.WriteString(netConn, "Welcome\n")
+	if password != "" {
+		io.WriteString(netConn, "@RSYNCD: AUTHREQD challenge\n")
+		if response, _ := in.ReadString('\n'); response != "user "+rsyncAuthResponse(password, "challenge")+"\n" {
+			io.WriteString(netConn, "@ERROR: auth failed on module mod\n")
+			return nil
+		}
+	}
+	io.WriteString(netConn, "@RSYNCD: OK\n")
+
+	var args []string
+	for {
+		arg, err := in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if arg == "\n" {
+			break
+		}
+		args = append(args, strings.TrimSuffix(arg, "\n"))
+	}
+	dir := filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(args[len(args)-1], "mod")))
+	netConn.Write(binary.LittleEndian.AppendUint32(nil, 1234))
+
+	// What the client sends is plain, the conn reads it as if multiplexed
+	pipeReader, pipeWriter := io.Pipe()
+	go func() { pipeWriter.CloseWithError(copyError(io.Copy(rsyncMux{pipeWriter}, in))) }()
+	conn := newRsyncConn(pipeReader, rsyncMux{netConn})
+	conn.seed = 1234
+	defer pipeReader.Close()
+
+	if slices.Contains(args, "--sender") {
+		return serveRsyncSender(conn, dir, netConn)
+	}
+	return serveRsyncReceiver(conn, dir, args)
+}
+
+func copyError(_ int64, err error) error {
+	return err
+}
+
+// Lists dir like rsync sends the tree from the top directory down.
+func listRsync(dir string) ([]rsyncFile, error) {
+	var files []rsyncFile
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(dir, file)
+		entry := rsyncFile{path: filepath.ToSlash(relPath), mode: uint32(info.Mode().Perm()), size: info.Size(), modTime: info.ModTime().Unix()}
+		if info.IsDir() {
+			entry.mode |= rsyncTypeDir
+			entry.size = 0
+		} else {
+			entry.mode |= rsyncTypeRegular
+		}
+		files = append(files, entry)
+		return nil
+	})
+	sortRsyncFiles(files)
+	return files, err
+}
+
+// Sends the files of dir that are asked for. Messages are written to out between the data.
+func serveRsyncSender(conn *rsyncConn, dir string, out io.Writer) error {
+	if rules, err := conn.readInt(); err != nil || rules != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	files, err := listRsync(dir)
+	if err != nil || len(files) == 0 {
+		conn.w.Flush()
+		rsyncMux{out}.message(rsyncMsgError, []byte("link_stat failed: No such file or directory (2)\n"))
+		conn.writeByte(0)
+		conn.writeInt(0)
+		return conn.w.Flush()
+	}
+	conn.writeFileList(files)
+
+	for phase := 0; ; {
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		ndx, err := conn.readInt()
+		if err != nil {
+			return err
+		}
+		if ndx == rsyncDone {
+			if phase++; phase > 2 {
+				break
+			}
+			conn.writeInt(rsyncDone)
+			continue
+		}
+		if iflags, _ := conn.readShort(); iflags != rsyncItemTransfer {
+			return io.ErrUnexpectedEOF
+		}
+		if _, err := conn.read(16); err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(dir, filepath.FromSlash(files[ndx].path)))
+		if err != nil {
+			// Left out, the client only learns why
+			conn.w.Flush()
+			rsyncMux{out}.message(rsyncMsgError, []byte("send_files failed to open "+files[ndx].path+"\n"))
+			continue
+		}
+		conn.writeInt(ndx)
+		conn.writeShort(rsyncItemTransfer)
+		conn.w.Write(make([]byte, 16))
+		err = conn.sendContents(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	conn.writeInt(rsyncDone)
+	for range 5 {
+		conn.writeLong(0)
+	}
+	if err := conn.w.Flush(); err != nil {
+		return err
+	}
+	if goodbye, err := conn.readInt(); err != nil || goodbye != rsyncDone {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func serveRsyncReceiver(conn *rsyncConn, dir string, args []string) error {
+	var rules []string
+	if slices.Contains(args, "--delete") {
+		for {
+			n, err := conn.readInt()
+			if err != nil || n == 0 {
+				break
+			}
+			rule, _ := conn.read(int(n))
+			rules = append(rules, string(rule))
+		}
+	}
+	files, err := conn.readFileList()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	apply := func(file rsyncFile, target string) {
+		os.Chmod(target, fs.FileMode(file.mode&0o777))
+		if !file.isDir() || !slices.Contains(args, "-O") {
+			modTime := time.Unix(file.modTime, 0)
+			os.Chtimes(target, modTime, modTime)
+		}
+	}
+	sent := make(map[string]bool)
+	for _, file := range files {
+		sent[file.path] = true
+	}
+	for ndx, file := range files {
+		target := filepath.Join(dir, filepath.FromSlash(file.path))
+		if file.isDir() {
+			os.Mkdir(target, 0o755)
+			apply(file, target)
+
+			// Deletes what wasn't sent and the rules leave at risk, rsync's R and P
+			entries, _ := os.ReadDir(target)
+			for _, entry := range entries {
+				entryPath := path.Join(file.path, entry.Name())
+				if file.path == "." {
+					entryPath = entry.Name()
+				}
+				if sent[entryPath] || len(rules) == 0 {
+					continue
+				}
+				for _, rule := range rules {
+					if rule == "P *" {
+						break
+					}
+					if rule == "R /"+entryPath {
+						os.Remove(filepath.Join(target, entry.Name()))
+						break
+					}
+				}
+			}
+			continue
+		}
+
+		if info, err := os.Stat(target); err == nil && info.Size() == file.size && slices.Contains(args, "--size-only") {
+			apply(file, target)
+			continue
+		}
+		conn.writeInt(int32(ndx))
+		conn.writeShort(rsyncItemTransfer)
+		conn.w.Write(make([]byte, 16))
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		if got, _ := conn.readInt(); got != int32(ndx) {
+			return io.ErrUnexpectedEOF
+		}
+		conn.read(2 + 16)
+		var data bytes.Buffer
+		for {
+			n, err := conn.readInt()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			chunk, _ := conn.read(int(n))
+			data.Write(chunk)
+		}
+		sum := newRsyncFileSum(conn.seed)
+		sum.Write(data.Bytes())
+		if want, _ := conn.read(16); !bytes.Equal(sum.Sum(nil), want) {
+			return io.ErrUnexpectedEOF
+		}
+		if err := os.WriteFile(target, data.Bytes(), 0o600); err != nil {
+			return err
+		}
+		apply(file, target)
+	}
+
+	for range 3 {
+		conn.writeInt(rsyncDone)
+		if err := conn.w.Flush(); err != nil {
+			return err
+		}
+		if done, err := conn.readInt(); err != nil || done != rsyncDone {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	conn.writeInt(rsyncDone)
+	return conn.w.Flush()
+}
+
+func TestRsyncDaemon(t *testing.T) {
+	served := t.TempDir()
+	address := serveRsync(t, served, "secret")
+	t.Setenv("RSYNC_PASSWORD", "secret")
+	dest := "rsync://user@" + address + "/mod/dest"
+	modTime := time.Date(2026, 1, 15, 10, 7, 30, 0, time.UTC)
+
+	src := t.TempDir()
+	files := map[string]string{"empty": "", "a.txt": "a", "dir/b": "b", "dir/sub/c": "c", "big": strings.Repeat("big", 30000)}
+	writeContents(t, src, files)
+	os.Chtimes(filepath.Join(src, "a.txt"), modTime, modTime)
+	options := SyncOptions{Delete: true}
+	if _, err := NewSyncer(src, dest, WithOptions(&options)).Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got := treeContents(t, filepath.Join(served, "dest")); !maps.Equal(got, files) {
+		t.Fatalf("served %q, want %q", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(files)))
+	}
+	if info, err := os.Stat(filepath.Join(served, "dest", "a.txt")); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("served a.txt modified at %v, want %v", info.ModTime(), modTime)
+	}
+
+	// Changes and deletions
+	files["a.txt"] = "changed"
+	delete(files, "dir/sub/c")
+	writeContents(t, src, map[string]string{"a.txt": "changed"})
+	os.RemoveAll(filepath.Join(src, "dir", "sub"))
+	if _, err := NewSyncer(src, dest, WithOptions(&options)).Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got := treeFiles(t, filepath.Join(served, "dest")); !slices.Equal(got, slices.Sorted(maps.Keys(files))) {
+		t.Errorf("served %q after the changes, want %q", got, slices.Sorted(maps.Keys(files)))
+	}
+	if _, err := os.Stat(filepath.Join(served, "dest", "dir", "sub")); err == nil {
+		t.Error("the removed directory is still served")
+	}
+
+	// And back from the daemon
+	restored := t.TempDir()
+	if _, err := NewSyncer(dest, restored, WithOptions(&SyncOptions{})).Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got := treeContents(t, restored); !maps.Equal(got, files) {
+		t.Errorf("restored %q, want %q", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(files)))
+	}
+
+	t.Setenv("RSYNC_PASSWORD", "guess")
+	if _, err := NewSyncer(src, dest, WithOptions(&options)).Start(); err == nil || !strings.Contains(err.Error(), "auth failed") {
+		t.Errorf("syncing with the wrong password failed with %v, want the daemon's refusal", err)
+	}
+}
+
+func TestRsyncUnreadableFile(t *testing.T) {
+	served := t.TempDir()
+	writeContents(t, served, map[string]string{"src/ok": "ok", "src/gone": "gone"})
+	backend, err := OpenBackend("rsync://" + serveRsync(t, served, "") + "/mod/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	// The server leaves out what it can't read, which fails the read without the session
+	os.Remove(filepath.Join(served, "src", "gone"))
+	if _, err := backend.Open("gone"); err == nil || !strings.Contains(err.Error(), "failed to open gone") {
+		t.Errorf("opening a file the server can't read failed with %v, want its error", err)
+	}
+	file, err := backend.Open("ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if data, err := io.ReadAll(file); err != nil || string(data) != "ok" {
+		t.Errorf("read %q, %v, want ok", data, err)
+	}
+}
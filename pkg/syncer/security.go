@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"errors"
+	"os"
+
+	"github.com/bipinmdr07/gosync/internal/xattr"
+)
+
+// Security extended attributes. They are left to the destination's defaults unless asked
+// for, since applying them takes privileges a normal user doesn't have.
+const (
+	selinuxXattr    = "security.selinux"
+	capabilityXattr = "security.capability"
+)
+
+// Returns the names of the security extended attributes carried over to the destination.
+func (s *Syncer) securityXattrs() []string {
+	var names []string
+	if s.Options.PreserveSELinux {
+		names = append(names, selinuxXattr)
+	}
+	if s.Options.PreserveCapabilities {
+		names = append(names, capabilityXattr)
+	}
+	return names
+}
+
+// Copies the requested security extended attributes from the source file to the destination
+// file. Must run once the contents are written, since writing to a file drops its capabilities.
+func (s *Syncer) copySecurityXattrs(srcFile, destinationFile *os.File, relPath string) {
+	for _, name := range s.securityXattrs() {
+		value, err := xattr.FGet(srcFile, name)
+		if errors.Is(err, xattr.ErrNotFound) {
+			continue
+		}
+		if err == nil {
+			err = xattr.FSet(destinationFile, name, value)
+		}
+
+		if err != nil {
+			s.logger.Warn().Err(err).Str("action", "SECURITY_XATTR").Str("path", relPath).Str("xattr", name).Msg("Could not preserve security attribute")
+			s.stats.recordSecurityNotApplied()
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ServeOptions configures Serve.
+type ServeOptions struct {
+	Root      string    // Directory clients sync to, nothing outside of it can be reached
+	Token     string    // Shared secret clients authenticate with
+	Verbose   bool      // Log every operation instead of only connections
+	LogWriter io.Writer // Where log output is written, os.Stderr when nil
+}
+
+// Serve exposes opts.Root to gosync://host:port/path destinations connecting through
+// listener, until the listener is closed. Clients have to prove they know opts.Token.
+// Paths are resolved beneath the root the same way local destinations are.
+func Serve(listener net.Listener, opts ServeOptions) error {
+	if opts.Token == "" {
+		return errors.New("a token is required, unauthenticated access is not supported.")
+	}
+	if info, err := os.Stat(opts.Root); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory.", opts.Root)
+	}
+
+	logWriter := opts.LogWriter
+	if logWriter == nil {
+		logWriter = os.Stderr
+	}
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: logWriter, TimeFormat: time.RFC3339}).With().Timestamp().Logger()
+	if opts.Verbose {
+		logger = logger.Level(zerolog.DebugLevel)
+	} else {
+		logger = logger.Level(zerolog.InfoLevel)
+	}
+
+	logger.Info().Str("address", listener.Addr().String()).Str("root", opts.Root).Msg("Serving")
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		session := &serveSession{
+			conn:    newRemoteConn(conn),
+			root:    &localBackend{root: opts.Root},
+			logger:  logger.With().Str("client", conn.RemoteAddr().String()).Logger(),
+			handles: make(map[uint64]any),
+		}
+		go session.run(opts.Token)
+	}
+}
+
+// A single client connection.
+type serveSession struct {
+	conn   *remoteConn
+	root   *localBackend
+	base   string // Directory below the root the client logged in to
+	logger zerolog.Logger
+
+	mu         sync.Mutex
+	handles    map[uint64]any // Files the client has open, BackendFile, deltaFile or io.ReadCloser
+	nextHandle uint64
+}
+
+func (s *serveSession) run(token string) {
+	defer s.conn.conn.Close()
+
+	err := s.conn.accept(token, func(base string) (err error) {
+		s.base, err = s.resolve(base)
+		return err
+	})
+	if err != nil {
+		s.logger.Warn().Err(err).Str("action", "REJECT").Msg("Client rejected")
+		return
+	}
+	s.logger.Info().Str("action", "CONNECT").Str("path", s.base).Msg("Client connected")
+
+	var wg sync.WaitGroup
+	for {
+		var request remoteRequest
+		if err := s.conn.receive(&request); err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logger.Warn().Err(err).Msg("Connection lost")
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(request)
+		}()
+	}
+
+	wg.Wait()
+	s.releaseAll()
+	s.logger.Info().Str("action", "DISCONNECT").Msg("Client disconnected")
+}
+
+// Turns a slash separated path from the client into one relative to the root, refusing
+// anything that could climb out of it.
+func (s *serveSession) resolve(clientPath string) (string, error) {
+	relPath := filepath.FromSlash(strings.TrimPrefix(clientPath, "/"))
+	if relPath == "" {
+		relPath = "."
+	}
+	if !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("%q is not a path below the served directory", clientPath)
+	}
+	return filepath.Join(s.base, relPath), nil
+}
+
+func (s *serveSession) handle(request remoteRequest) {
+	s.logger.Debug().Str("action", strings.ToUpper(request.Op)).Str("path", request.Path).Msg("Request")
+
+	response := remoteResponse{ID: request.ID}
+	if err := s.serve(request, &response); err != nil {
+		response.Err = err.Error()
+		response.NotExist = errors.Is(err, fs.ErrNotExist)
+		response.More = false
+	}
+
+	if err := s.conn.send(response); err != nil {
+		s.logger.Warn().Err(err).Msg("Could not send response")
+	}
+}
+
+// Carries out request and fills in response. Batched operations send all but their
+// final response themselves.
+func (s *serveSession) serve(request remoteRequest, response *remoteResponse) error {
+	switch request.Op {
+	case remoteWrite, remoteCommit, remoteRead, remoteRelease, remotePatch:
+		return s.serveHandle(request, response)
+	}
+
+	relPath, err := s.resolve(request.Path)
+	if err != nil {
+		return err
+	}
+
+	// Removing a symlink is fine wherever it points, everything else has to stay inside
+	if err := s.root.Contained(relPath, request.Op != remoteRemove); err != nil {
+		return err
+	}
+
+	switch request.Op {
+	case remoteStat:
+		info, err := s.root.Stat(relPath)
+		if err != nil {
+			return err
+		}
+		response.Info = newRemoteEntry(request.Path, info)
+		return nil
+
+	case remoteList:
+		return s.list(relPath, request.ID, response)
+
+	case remoteMkdir:
+		return s.root.MkdirAll(relPath)
+
+	case remoteCreate:
+		file, err := s.root.Create(relPath, nil)
+		if err != nil {
+			return err
+		}
+		response.Handle = s.register(file)
+		return nil
+
+	case remoteOpen:
+		file, err := s.root.Open(relPath)
+		if err != nil {
+			return err
+		}
+		response.Handle = s.register(file)
+		return nil
+
+	case remoteSums, remoteCreateDelta:
+		if request.BlockSize < deltaMinBlockSize || request.BlockSize > deltaMaxBlockSize {
+			return fmt.Errorf("block size %d is out of range", request.BlockSize)
+		}
+		if request.Op == remoteSums {
+			response.Sums, err = s.root.BlockSums(relPath, request.BlockSize)
+			return err
+		}
+		file, err := s.root.CreateDelta(relPath, request.BlockSize)
+		if err != nil {
+			return err
+		}
+		response.Handle = s.register(file)
+		return nil
+
+	case remoteChmod:
+		return s.root.Chmod(relPath, request.Mode)
+
+	case remoteChtimes:
+		return s.root.Chtimes(relPath, request.ModTime)
+
+	case remoteRemove:
+		return s.root.Remove(relPath)
+
+	case remoteRename:
+		target, err := s.resolve(request.Target)
+		if err != nil {
+			return err
+		}
+		return s.root.Rename(relPath, target)
+	}
+
+	return fmt.Errorf("unknown operation %q", request.Op)
+}
+
+// Carries out operations on an open file.
+func (s *serveSession) serveHandle(request remoteRequest, response *remoteResponse) error {
+	s.mu.Lock()
+	handle, ok := s.handles[request.Handle]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown file handle %d", request.Handle)
+	}
+
+	switch request.Op {
+	case remoteWrite:
+		file, ok := handle.(BackendFile)
+		if !ok {
+			return errors.New("file is not open for writing")
+		}
+		data, err := decompressChunk(request.Codec, request.Data)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		return err
+
+	case remoteCommit:
+		file, ok := handle.(BackendFile)
+		if !ok {
+			return errors.New("file is not open for writing")
+		}
+		s.unregister(request.Handle)
+
+		if delta, ok := file.(deltaFile); ok && request.Data != nil {
+			delta.Expect(request.Data)
+		}
+		if local, ok := file.(*localFile); ok {
+			local.keep() // Files released without a commit are discarded
+		}
+
+		// Metadata the client didn't set is left alone, like after a failed copy
+		file.Sync()
+		if !request.ModTime.IsZero() {
+			if err := file.SetModTime(request.ModTime); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		if request.Mode != 0 {
+			if err := file.Chmod(request.Mode); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		return file.Close()
+
+	case remotePatch:
+		file, ok := handle.(deltaFile)
+		if !ok {
+			return errors.New("file is not open for a delta")
+		}
+		for _, op := range request.Ops {
+			var err error
+			if op.Count > 0 {
+				err = file.CopyBlocks(op.Block, op.Count)
+			} else if data, decompressErr := decompressChunk(request.Codec, op.Data); decompressErr != nil {
+				err = decompressErr
+			} else {
+				_, err = file.Write(data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case remoteRead:
+		file, ok := handle.(io.Reader)
+		if !ok {
+			return errors.New("file is not open for reading")
+		}
+		if !ValidCompression(request.Codec) {
+			return fmt.Errorf("unsupported compression %q", request.Codec)
+		}
+		buffer := make([]byte, remoteChunkSize)
+		n, err := io.ReadFull(file, buffer)
+		response.Data, response.Codec = compressChunk(request.Codec, buffer[:n])
+		response.EOF = errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !response.EOF {
+			return err
+		}
+		return nil
+
+	case remoteRelease:
+		s.unregister(request.Handle)
+		return handle.(io.Closer).Close()
+	}
+
+	return fmt.Errorf("unknown operation %q", request.Op)
+}
+
+// Sends every entry below relPath in walk order, in batches. The last one is left in response.
+func (s *serveSession) list(relPath string, id uint64, response *remoteResponse) error {
+	tree := &localBackend{root: filepath.Join(s.root.root, relPath)}
+
+	var batch []remoteEntry
+	err := tree.Walk(func(entryPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if entryPath == "." {
+				return err // Usually not created yet, which the client handles
+			}
+			s.logger.Warn().Err(err).Str("path", entryPath).Msg("Error walking served directory")
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed while walking
+		}
+		batch = append(batch, newRemoteEntry(filepath.ToSlash(entryPath), info))
+
+		if len(batch) == remoteListBatch {
+			if err := s.conn.send(remoteResponse{ID: id, More: true, Entries: batch}); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		return nil
+	})
+
+	response.Entries = batch
+	return err
+}
+
+func (s *serveSession) register(file any) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextHandle++
+	s.handles[s.nextHandle] = file
+	return s.nextHandle
+}
+
+func (s *serveSession) unregister(handle uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handles, handle)
+}
+
+// Closes the files a client left open when it went away. Uploads stay incomplete.
+func (s *serveSession) releaseAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for handle, file := range s.handles {
+		file.(io.Closer).Close()
+		delete(s.handles, handle)
+	}
+}
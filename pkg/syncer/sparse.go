@@ -0,0 +1,63 @@
+package syncer
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Copies the size bytes of src to out, a writer over dest, leaving out the ranges the
+// file system reports as holes so they stay holes in dest instead of taking up space as
+// zeros. hash still sees the zeros the holes read as. Data is read through what read
+// wraps it in, and copied through buffers of bufferSize. Returns the bytes of src covered.
+func copySparse(dest *os.File, out io.Writer, seek io.Seeker, src *os.File, size int64, hash io.Writer, read func(io.Reader) io.Reader, bufferSize int, progress *atomic.Int64) (int64, error) {
+	var offset int64
+	for offset < size {
+		dataStart, err := seekData(src, offset, size)
+		if err != nil {
+			return offset, err
+		}
+
+		// A hole is skipped over, the destination is cut to length at the end
+		if dataStart > offset {
+			if hash != nil {
+				if _, err := io.CopyN(hash, zeroReader{}, dataStart-offset); err != nil {
+					return offset, err
+				}
+			}
+			progress.Add(dataStart - offset) // Written data is counted by out
+			offset = dataStart
+			continue
+		}
+
+		holeStart, err := seekHole(src, offset, size)
+		if err != nil {
+			return offset, err
+		}
+		if holeStart <= offset {
+			holeStart = size // Data ends at the end of the file, shorter than size by now
+		}
+
+		if _, err := seek.Seek(offset, io.SeekStart); err != nil {
+			return offset, err
+		}
+		data := read(io.NewSectionReader(src, offset, holeStart-offset))
+		if hash != nil {
+			data = io.TeeReader(data, hash)
+		}
+		n, err := copyBuffer(out, data, bufferSize)
+		offset += n
+		if err != nil {
+			return offset, err
+		}
+	}
+
+	return offset, dest.Truncate(size)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd
+
+package syncer
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns where the data following offset starts, size if only a hole follows.
+func seekData(file *os.File, offset, size int64) (int64, error) {
+	start, err := unix.Seek(int(file.Fd()), offset, unix.SEEK_DATA)
+	switch {
+	case errors.Is(err, unix.ENXIO):
+		return size, nil
+	case errors.Is(err, unix.EINVAL), errors.Is(err, unix.EOPNOTSUPP):
+		return offset, nil // Holes aren't reported by the file system, it is all data
+	case err != nil:
+		return 0, &os.PathError{Op: "lseek", Path: file.Name(), Err: err}
+	}
+	return min(start, size), nil
+}
+
+// Returns where the hole following offset starts, size if there is none before it.
+func seekHole(file *os.File, offset, size int64) (int64, error) {
+	start, err := unix.Seek(int(file.Fd()), offset, unix.SEEK_HOLE)
+	switch {
+	case errors.Is(err, unix.ENXIO), errors.Is(err, unix.EINVAL), errors.Is(err, unix.EOPNOTSUPP):
+		return size, nil
+	case err != nil:
+		return 0, &os.PathError{Op: "lseek", Path: file.Name(), Err: err}
+	}
+	return min(start, size), nil
+}
@@ -0,0 +1,86 @@
+package syncer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Reports whether mode is that of a device, named pipe or socket, files whose contents
+// can't be copied.
+func isSpecial(mode os.FileMode) bool {
+	return mode&(os.ModeDevice|os.ModeCharDevice|os.ModeNamedPipe|os.ModeSocket) != 0
+}
+
+// Recreates the special file of job at the destination with Devices, or leaves it out.
+// Reading one would block on a pipe or read from the device.
+func (s *Syncer) syncSpecial(job fileJob, srcInfo os.FileInfo) {
+	relPath := job.relPath
+	if !s.Options.Devices || s.local == nil {
+		s.stats.recordSkipped(relPath, "SKIP_SPECIAL")
+		s.logger.Debug().Str("action", "SKIP_SPECIAL").Str("path", relPath).Msg("File is a device, pipe or socket, skipping")
+		return
+	}
+
+	srcDevice, ok := deviceNumber(srcInfo)
+	if !ok {
+		s.logger.Warn().Str("path", relPath).Msg("Could not read the device number of the source file, skipping")
+		return
+	}
+
+	// Nothing to do if the same kind of file for the same device is there already
+	destInfo, err := os.Lstat(s.local.path(relPath))
+	if err == nil && destInfo.Mode().Type() == srcInfo.Mode().Type() {
+		if destDevice, ok := deviceNumber(destInfo); ok && destDevice == srcDevice {
+			s.stats.recordSkipped(relPath, "SKIP_SPECIAL")
+			s.logger.Debug().Str("action", "SKIP_SPECIAL").Str("path", relPath).Msg("Special file is up-to-date, skipping")
+			s.preserveOwner(relPath, srcInfo, destInfo, s.lchown(relPath))
+			return
+		}
+	}
+
+	logEvent := s.logger.Info().Str("action", "MKNOD").Str("path", relPath)
+	if s.Options.DryRun {
+		s.recordPlanned("MKNOD", relPath, "")
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would create special file")
+		return
+	}
+
+	if err == nil {
+		if destInfo.IsDir() {
+			err := errors.New("a directory is in the way")
+			s.logger.Error().Err(err).Str("path", relPath).Msg("Could not replace directory with special file")
+			s.stats.recordError(relPath, err)
+			return
+		}
+		if !s.backup(relPath, false) {
+			return
+		}
+		if err := os.Remove(s.local.path(relPath)); err != nil && !os.IsNotExist(err) {
+			s.logger.Error().Err(err).Str("path", relPath).Msg("Could not remove existing destination entry")
+			s.stats.recordError(relPath, err)
+			return
+		}
+	}
+
+	if err := s.dest.MkdirAll(filepath.Dir(relPath)); err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Failed to create directories")
+		s.stats.recordError(relPath, err)
+		return
+	}
+	if err := makeSpecial(s.local.path(relPath), srcInfo.Mode(), srcDevice); err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Error creating special file")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	// The umask applied to mknod, and devices are usually owned by root
+	if err := os.Chmod(s.local.path(relPath), srcInfo.Mode().Perm()); err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Error setting file permissions")
+	}
+	if err := os.Chtimes(s.local.path(relPath), srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Error preserving modification time")
+	}
+	s.preserveOwner(relPath, srcInfo, nil, s.lchown(relPath))
+	logEvent.Msg("Special file created")
+}
@@ -0,0 +1,8 @@
+package syncer
+
+import "golang.org/x/sys/unix"
+
+// Device numbers are 64 bits wide here.
+func mknod(path string, mode uint32, device uint64) error {
+	return unix.Mknod(path, mode, device)
+}
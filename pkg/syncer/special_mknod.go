@@ -0,0 +1,9 @@
+//go:build unix && !freebsd
+
+package syncer
+
+import "golang.org/x/sys/unix"
+
+func mknod(path string, mode uint32, device uint64) error {
+	return unix.Mknod(path, mode, int(device))
+}
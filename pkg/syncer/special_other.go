@@ -0,0 +1,17 @@
+//go:build !unix
+
+package syncer
+
+import (
+	"errors"
+	"os"
+)
+
+// Device files can't be told apart or created here.
+func deviceNumber(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+func makeSpecial(path string, mode os.FileMode, device uint64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package syncer
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the device a device file described by info stands for, 0 for pipes and sockets.
+func deviceNumber(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Rdev), true
+}
+
+// Creates a special file of the type of mode for device at path.
+func makeSpecial(path string, mode os.FileMode, device uint64) error {
+	fileType := uint32(unix.S_IFIFO)
+	switch {
+	case mode&os.ModeCharDevice != 0:
+		fileType = unix.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		fileType = unix.S_IFBLK
+	case mode&os.ModeSocket != 0:
+		fileType = unix.S_IFSOCK
+	}
+	return mknod(path, fileType|uint32(mode.Perm()), device)
+}
@@ -0,0 +1,78 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Returns the file state of the given kind about syncing the source with the destination
+// is kept in, named after both so every pair has its own.
+func (s *Syncer) statePath(kind string) (string, error) {
+	dir, err := s.stateDir()
+	if err != nil {
+		return "", err
+	}
+
+	location := func(path string, local bool) string {
+		if abs, err := filepath.Abs(path); err == nil && local {
+			return abs
+		}
+		return path
+	}
+	pair := sha256.Sum256([]byte(location(s.Options.SourcePath, s.localSource != nil) + "\x00" + location(s.Options.DestinationPath, s.local != nil)))
+	return filepath.Join(dir, kind+"-"+hex.EncodeToString(pair[:8])+".state"), nil
+}
+
+// Returns StateDir, or its default.
+func (s *Syncer) stateDir() (string, error) {
+	if s.Options.StateDir != "" {
+		return s.Options.StateDir, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find a directory to keep the sync state in, set one: %w", err)
+	}
+	return filepath.Join(cacheDir, "gosync"), nil
+}
+
+// Reads the state saved at path into state, leaving it alone if there is none yet.
+func loadState(path string, state any) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := gob.NewDecoder(file).Decode(state); err != nil {
+		return fmt.Errorf("could not read sync state %s: %w", path, err)
+	}
+	return nil
+}
+
+// Replaces the state at path with state, in one step so an interrupted run leaves the
+// old one.
+func saveState(path string, state any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".gosync-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := gob.NewEncoder(file).Encode(state); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
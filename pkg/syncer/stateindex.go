@@ -0,0 +1,99 @@
+package syncer
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"time"
+)
+
+// A file as it was left at the destination by an earlier run.
+type indexEntry struct {
+	Size    int64
+	ModTime time.Time // Of the source file it was copied from
+	Hash    []byte    // With the configured algorithm, nil when it wasn't computed
+}
+
+// The files of the destination known to be in sync with the source, kept between runs
+// with StateIndex. Files unchanged in the source since are taken to be unchanged at the
+// destination too, which saves a stat there for each of them.
+type stateIndex struct {
+	previous map[string]indexEntry // Loaded at the start, read only
+
+	mu   sync.Mutex
+	next map[string]indexEntry // Files found or made in sync by this run
+}
+
+// Loads the index the last run left, if StateIndex is set.
+func (s *Syncer) loadStateIndex() error {
+	if !s.Options.StateIndex {
+		return nil
+	}
+
+	path, err := s.statePath("index")
+	if err != nil {
+		return err
+	}
+	index := &stateIndex{previous: make(map[string]indexEntry), next: make(map[string]indexEntry)}
+	if err := loadState(path, &index.previous); err != nil {
+		return err
+	}
+	s.index = index
+	return nil
+}
+
+// Saves the files found in sync by this run for the next one. Dry runs change nothing.
+func (s *Syncer) saveStateIndex() error {
+	if s.index == nil || s.Options.DryRun {
+		return nil
+	}
+
+	path, err := s.statePath("index")
+	if err != nil {
+		return err
+	}
+	return saveState(path, s.index.next)
+}
+
+// Reports whether the source file of job was in sync when the last run left it and hasn't
+// changed since, remembering it again if so. Comparing by checksum, its hash is checked
+// against the one stored instead of against the destination.
+func (s *Syncer) unchangedSinceIndexed(job fileJob, srcInfo os.FileInfo) bool {
+	if s.index == nil {
+		return false
+	}
+	entry, ok := s.index.previous[job.relPath]
+	if !ok || entry.Size != srcInfo.Size() {
+		return false
+	}
+
+	if s.Options.Compare == CompareChecksum {
+		if entry.Hash == nil {
+			return false
+		}
+		srcSum, err := s.hashSource(job)
+		if err != nil || !bytes.Equal(srcSum, entry.Hash) {
+			return false
+		}
+	} else if !entry.ModTime.Equal(srcInfo.ModTime()) {
+		return false
+	}
+
+	s.index.remember(job.relPath, entry)
+	return true
+}
+
+// Records the destination file at relPath as in sync with the source file srcInfo
+// describes, with the hash of its contents if known.
+func (s *Syncer) rememberSynced(relPath string, srcInfo os.FileInfo, hash []byte) {
+	if s.index == nil || s.Options.DryRun {
+		return
+	}
+	s.index.remember(relPath, indexEntry{Size: srcInfo.Size(), ModTime: srcInfo.ModTime(), Hash: hash})
+}
+
+func (i *stateIndex) remember(relPath string, entry indexEntry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.next[relPath] = entry
+}
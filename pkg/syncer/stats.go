@@ -0,0 +1,383 @@
+package syncer
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirStats holds the transfer totals for one directory of the source tree.
+type DirStats struct {
+	Path        string `json:"path"`
+	FilesCopied int64  `json:"files_copied"`
+	BytesCopied int64  `json:"bytes_copied"`
+	Errors      int64  `json:"errors"`
+}
+
+// Transfer describes a single file copy.
+type Transfer struct {
+	Path     string        `json:"path"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+	Rate     float64       `json:"bytes_per_second"`
+}
+
+// PhaseDuration is the time a sync run spent in one of its phases, like "copying".
+type PhaseDuration struct {
+	Phase    string        `json:"phase"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Summary describes what a sync run did.
+type Summary struct {
+	FilesCopied int64      `json:"files_copied"`
+	BytesCopied int64      `json:"bytes_copied"`
+	Errors      int64      `json:"errors"`
+	Directories []DirStats `json:"directories"`
+
+	FilesDeleted   int64         `json:"files_deleted"`      // Files and directories removed from the destination
+	DeleteDuration time.Duration `json:"delete_duration_ns"` // Time spent in the deletion phase
+
+	SecurityNotApplied int64 `json:"security_not_applied"` // SELinux contexts and capabilities that couldn't be preserved
+	DirectoriesCreated int64 `json:"directories_created"`  // Directories created or updated in dirs-only mode
+	FilesDeferred      int64 `json:"files_deferred"`       // Files skipped for being modified within the minimum age
+	FilesRenamed       int64 `json:"files_renamed"`        // Files moved at the destination instead of copied, with DetectRenames
+	FilesLinked        int64 `json:"files_linked"`         // Hard links created instead of copies, with HardLinks
+	SourceFilesRemoved int64 `json:"source_files_removed"` // Source files removed once synced, with RemoveSourceFiles
+	FilesKeptNewer     int64 `json:"files_kept_newer"`     // Destination files left alone for being newer, with Update
+	FilesUnstable      int64 `json:"files_unstable"`       // Files that changed while being copied on every attempt, counted among the errors
+	FilesUnreadable    int64 `json:"files_unreadable"`     // Source files and directories that couldn't be read, skipped or counted among the errors
+
+	FilesScanned int64           `json:"files_scanned"`    // Source files looked at, copied or not
+	FilesSkipped int64           `json:"files_skipped"`    // Source files left alone for being up to date or filtered out
+	ScanDuration time.Duration   `json:"scan_duration_ns"` // Time until every source file was found, copies run alongside
+	Phases       []PhaseDuration `json:"phases"`           // Time spent in each phase, in the order they started
+
+	QueueSize         int           `json:"queue_size"`             // Files the walker may get ahead of the workers by
+	MaxQueueDepth     int64         `json:"max_queue_depth"`        // Most files waiting for a worker at once
+	QueueFullDuration time.Duration `json:"queue_full_duration_ns"` // Time the walker waited for the workers with the queue full
+
+	Largest []Transfer `json:"largest,omitempty"` // The largest transfers, biggest first
+	Slowest []Transfer `json:"slowest,omitempty"` // The transfers with the lowest throughput, slowest first
+}
+
+// Result is the outcome of a sync, returned by Start. The statistics of Summary go into
+// more detail.
+type Result struct {
+	FilesCopied  int64
+	FilesSkipped int64 // Left alone for being up to date or filtered out
+	FilesDeleted int64 // Files and directories removed from the destination
+	BytesCopied  int64
+	Errors       []FileError // Every path that failed, in the order it did
+	Duration     time.Duration
+}
+
+// Collects transfer statistics from the workers while a sync runs.
+type statsCollector struct {
+	mu         sync.Mutex
+	hooks      hooks
+	events     chan SyncEvent // Set by Events
+	depth      int
+	topN       int
+	total      DirStats
+	deleted    int64
+	deleteTime time.Duration
+	security   int64
+	mkdirs     int64
+	deferred   int64
+	renamed    int64
+	linked     int64
+	srcRemoved int64
+	keptNewer  int64
+	unstable   int64
+	unreadable map[string]struct{}
+	skipped    int64
+	scanTime   time.Duration
+	queueSize  int
+	maxWaiting int64
+	queueFull  time.Duration
+	dirs       map[string]*DirStats
+	largest    []Transfer
+	slowest    []Transfer
+
+	// Live progress, see progress.go
+	phase        string
+	started      time.Time
+	phaseStarted time.Time
+	phases       []PhaseDuration
+	queued       int64
+	processed    int64
+	queuedBytes  int64
+	doneBytes    int64
+	waiting      int64
+	walked       bool
+	active       map[*activeTransfer]struct{}
+	recentCopies []Transfer
+	recentErrors []FileError
+	errors       []FileError // All of them, for Result
+}
+
+func newStatsCollector(depth, topN, queueSize int, hooks hooks) *statsCollector {
+	return &statsCollector{
+		hooks:      hooks,
+		depth:      depth,
+		topN:       topN,
+		queueSize:  queueSize,
+		dirs:       make(map[string]*DirStats),
+		unreadable: make(map[string]struct{}),
+		active:     make(map[*activeTransfer]struct{}),
+	}
+}
+
+// Returns the directory relPath is accounted under, truncated to the configured depth.
+func (c *statsCollector) dirKey(relPath string) string {
+	dir := filepath.Dir(relPath)
+	if dir == "." {
+		return dir
+	}
+
+	parts := strings.Split(dir, string(filepath.Separator))
+	if len(parts) > c.depth {
+		parts = parts[:c.depth]
+	}
+	return filepath.Join(parts...)
+}
+
+// Returns the entry for relPath's directory, creating it if needed. Callers must hold mu.
+func (c *statsCollector) dirFor(relPath string) *DirStats {
+	key := c.dirKey(relPath)
+	dir, ok := c.dirs[key]
+	if !ok {
+		dir = &DirStats{Path: key}
+		c.dirs[key] = dir
+	}
+	return dir
+}
+
+// Records a copied file. A zero duration means the copy was only simulated.
+func (c *statsCollector) recordCopy(relPath string, bytes int64, duration time.Duration) {
+	defer c.emit(SyncEvent{Kind: EventCopied, Path: relPath, Size: bytes, Duration: duration})
+	defer c.hooks.fileDone(relPath, bytes, duration)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dir := c.dirFor(relPath)
+	dir.FilesCopied++
+	dir.BytesCopied += bytes
+	c.total.FilesCopied++
+	c.total.BytesCopied += bytes
+
+	transfer := Transfer{Path: relPath, Bytes: bytes, Duration: duration}
+	if duration > 0 {
+		transfer.Rate = float64(bytes) / duration.Seconds()
+	}
+	c.recentCopies = pushRecent(c.recentCopies, transfer)
+
+	if c.topN <= 0 || bytes == 0 {
+		return
+	}
+
+	c.largest = insertTop(c.largest, transfer, c.topN, func(a, b Transfer) bool {
+		return a.Bytes > b.Bytes
+	})
+
+	if duration > 0 {
+		c.slowest = insertTop(c.slowest, transfer, c.topN, func(a, b Transfer) bool {
+			return a.Rate < b.Rate
+		})
+	}
+}
+
+func (c *statsCollector) recordDelete(relPath string) {
+	defer c.emit(SyncEvent{Kind: EventDeleted, Path: relPath})
+	defer c.hooks.delete(relPath)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deleted++
+}
+
+func (c *statsCollector) recordDeferred() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deferred++
+}
+
+func (c *statsCollector) recordRename() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.renamed++
+}
+
+func (c *statsCollector) recordLink() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.linked++
+}
+
+func (c *statsCollector) recordSourceRemoved() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.srcRemoved++
+}
+
+func (c *statsCollector) recordKeptNewer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.keptNewer++
+}
+
+func (c *statsCollector) recordUnstable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unstable++
+}
+
+// Records a source entry that couldn't be read, once however many destinations miss it.
+func (c *statsCollector) recordUnreadable(relPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unreadable[relPath] = struct{}{}
+}
+
+// Returns the paths of the source entries that couldn't be read, sorted.
+func (c *statsCollector) unreadablePaths() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	paths := make([]string, 0, len(c.unreadable))
+	for relPath := range c.unreadable {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Records a file left alone, reason is the action logged for it.
+func (c *statsCollector) recordSkipped(relPath, reason string) {
+	defer c.hooks.skip(relPath, reason)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.skipped++
+}
+
+func (c *statsCollector) recordDirectory() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mkdirs++
+}
+
+func (c *statsCollector) recordSecurityNotApplied() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.security++
+}
+
+func (c *statsCollector) recordDeletePhase(elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deleteTime = elapsed
+}
+
+// Inserts t into the ordered list, keeping at most n entries ranked by better.
+func insertTop(list []Transfer, t Transfer, n int, better func(a, b Transfer) bool) []Transfer {
+	i := sort.Search(len(list), func(i int) bool { return better(t, list[i]) })
+	if i >= n {
+		return list
+	}
+
+	list = append(list, Transfer{})
+	copy(list[i+1:], list[i:])
+	list[i] = t
+
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func (c *statsCollector) recordError(relPath string, err error) {
+	fileErr := FileError{Path: relPath, Err: err, Time: time.Now()}
+	defer c.emit(SyncEvent{Kind: EventErrored, Path: relPath, Err: err})
+	defer c.hooks.failed(fileErr)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dirFor(relPath).Errors++
+	c.total.Errors++
+	c.recentErrors = pushRecent(c.recentErrors, fileErr)
+	c.errors = append(c.errors, fileErr)
+}
+
+// Returns the outcome of a sync that took duration.
+func (c *statsCollector) result(duration time.Duration) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Result{
+		FilesCopied:  c.total.FilesCopied,
+		FilesSkipped: c.skipped,
+		FilesDeleted: c.deleted,
+		BytesCopied:  c.total.BytesCopied,
+		Errors:       append([]FileError(nil), c.errors...),
+		Duration:     duration,
+	}
+}
+
+// Returns a snapshot of the collected statistics with directories sorted by path.
+func (c *statsCollector) summary() Summary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	summary := Summary{
+		FilesCopied: c.total.FilesCopied,
+		BytesCopied: c.total.BytesCopied,
+		Errors:      c.total.Errors,
+		Directories: make([]DirStats, 0, len(c.dirs)),
+		Largest:     append([]Transfer(nil), c.largest...),
+		Slowest:     append([]Transfer(nil), c.slowest...),
+
+		FilesDeleted:   c.deleted,
+		DeleteDuration: c.deleteTime,
+
+		SecurityNotApplied: c.security,
+		DirectoriesCreated: c.mkdirs,
+		FilesDeferred:      c.deferred,
+		FilesRenamed:       c.renamed,
+		FilesLinked:        c.linked,
+		SourceFilesRemoved: c.srcRemoved,
+		FilesKeptNewer:     c.keptNewer,
+		FilesUnstable:      c.unstable,
+		FilesUnreadable:    int64(len(c.unreadable)),
+
+		FilesScanned: c.queued,
+		FilesSkipped: c.skipped,
+		ScanDuration: c.scanTime,
+		Phases:       append([]PhaseDuration(nil), c.phases...),
+
+		QueueSize:         c.queueSize,
+		MaxQueueDepth:     c.maxWaiting,
+		QueueFullDuration: c.queueFull,
+	}
+	for _, dir := range c.dirs {
+		summary.Directories = append(summary.Directories, *dir)
+	}
+	sort.Slice(summary.Directories, func(i, j int) bool {
+		return summary.Directories[i].Path < summary.Directories[j].Path
+	})
+
+	return summary
+}
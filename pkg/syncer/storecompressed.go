@@ -0,0 +1,272 @@
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Appended to the names of files stored compressed.
+	storedSuffix = ".zst"
+
+	// Written to the root of a destination with StoreCompressed, so syncing back from it
+	// decompresses the files.
+	storedMarker = ".gosync-compressed"
+)
+
+// A backend whose files are stored compressed with zstd as name.zst, holding the original
+// size in the frame header. Its files read back as they were under their original names,
+// directories and what other tools left there are passed through as they are.
+type storedBackend struct {
+	Backend
+}
+
+// Returns the name the file at relPath is stored under, the original name when there is
+// no compressed file, e.g. for directories.
+func (b *storedBackend) stored(relPath string) string {
+	if info, err := b.Backend.Stat(relPath + storedSuffix); err == nil && info.Mode().IsRegular() {
+		return relPath + storedSuffix
+	}
+	return relPath
+}
+
+func (b *storedBackend) Stat(relPath string) (fs.FileInfo, error) {
+	if relPath == storedMarker {
+		return nil, &fs.PathError{Op: "stat", Path: relPath, Err: fs.ErrNotExist}
+	}
+	info, err := b.Backend.Stat(relPath + storedSuffix)
+	if err != nil || !info.Mode().IsRegular() {
+		return b.Backend.Stat(relPath)
+	}
+
+	size, err := b.contentSize(relPath + storedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return storedFileInfo{FileInfo: info, name: strings.TrimSuffix(info.Name(), storedSuffix), size: size}, nil
+}
+
+// Returns the original size of the compressed file at relPath from its frame header.
+// Small files are written without it, those are decompressed to count their size.
+func (b *storedBackend) contentSize(relPath string) (int64, error) {
+	file, err := b.Backend.Open(relPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, zstd.HeaderMaxSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	var header zstd.Header
+	if err := header.Decode(buf[:n]); err == nil && header.HasFCS {
+		return int64(header.FrameContentSize), nil
+	}
+
+	decoder, err := zstd.NewReader(io.MultiReader(bytes.NewReader(buf[:n]), file), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return 0, err
+	}
+	defer decoder.Close()
+	return io.Copy(io.Discard, decoder)
+}
+
+func (b *storedBackend) Create(relPath string, srcInfo fs.FileInfo) (BackendFile, error) {
+	// The original size goes in the frame header, the compressed one isn't known yet
+	size := int64(-1)
+	if srcInfo != nil {
+		size = srcInfo.Size()
+		srcInfo = storedFileInfo{FileInfo: srcInfo, name: srcInfo.Name() + storedSuffix, size: -1}
+	}
+	file, err := b.Backend.Create(relPath+storedSuffix, srcInfo)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithZeroFrames(true))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	encoder.ResetContentSize(file, size)
+	return &storedFile{BackendFile: file, encoder: encoder, backend: b.Backend, relPath: relPath}, nil
+}
+
+func (b *storedBackend) Open(relPath string) (io.ReadCloser, error) {
+	file, err := b.Backend.Open(relPath + storedSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b.Backend.Open(relPath)
+	} else if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &storedReader{Decoder: decoder, file: file}, nil
+}
+
+func (b *storedBackend) Chmod(relPath string, mode fs.FileMode) error {
+	return b.Backend.Chmod(b.stored(relPath), mode)
+}
+
+func (b *storedBackend) Chtimes(relPath string, modTime time.Time) error {
+	return b.Backend.Chtimes(b.stored(relPath), modTime)
+}
+
+func (b *storedBackend) Remove(relPath string) error {
+	return b.Backend.Remove(b.stored(relPath))
+}
+
+func (b *storedBackend) Rename(oldRelPath, newRelPath string) error {
+	r, ok := b.Backend.(renamer)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldRelPath, New: newRelPath, Err: errors.ErrUnsupported}
+	}
+	if stored := b.stored(oldRelPath); stored != oldRelPath {
+		return r.Rename(stored, newRelPath+storedSuffix)
+	}
+	return r.Rename(oldRelPath, newRelPath)
+}
+
+func (b *storedBackend) Walk(fn fs.WalkDirFunc) error {
+	return b.Backend.Walk(func(relPath string, d fs.DirEntry, err error) error {
+		if relPath == storedMarker {
+			return nil
+		}
+		if err == nil && d.Type().IsRegular() && strings.HasSuffix(relPath, storedSuffix) {
+			relPath = strings.TrimSuffix(relPath, storedSuffix)
+			d = storedDirEntry{DirEntry: d, backend: b, relPath: relPath}
+		}
+		return fn(relPath, d, err)
+	})
+}
+
+// Has the destination store files compressed, marking it so syncs from it decompress them.
+func (s *Syncer) storeCompressed() error {
+	if _, ok := s.dest.(*storedBackend); ok {
+		return nil
+	}
+	dest := &storedBackend{Backend: s.dest}
+	if !s.Options.DryRun {
+		if err := dest.writeMarker(); err != nil {
+			return fmt.Errorf("could not mark destination as compressed: %w.", err)
+		}
+	}
+	s.dest = dest
+	return nil
+}
+
+// Writes the marker telling that the files are stored compressed.
+func (b *storedBackend) writeMarker() error {
+	if _, err := b.Backend.Stat(storedMarker); err == nil {
+		return nil
+	}
+	return writeBackendFile(b.Backend, storedMarker, []byte("Files here are stored compressed by gosync, sync from this directory to get them back.\n"))
+}
+
+// Reports whether backend holds files stored compressed, by its marker.
+func holdsStoredFiles(backend Backend) bool {
+	info, err := backend.Stat(storedMarker)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// A file being stored compressed. The frame is finished on Sync or Close, whichever
+// comes first.
+type storedFile struct {
+	BackendFile
+	encoder  *zstd.Encoder
+	finished bool
+	err      error // Of finishing the frame
+
+	backend Backend
+	relPath string // Original name, where a plain file may be left
+}
+
+func (f *storedFile) Write(p []byte) (int, error) {
+	return f.encoder.Write(p)
+}
+
+func (f *storedFile) finish() error {
+	if !f.finished {
+		f.finished = true
+		f.err = f.encoder.Close()
+	}
+	return f.err
+}
+
+func (f *storedFile) keep() {
+	if k, ok := f.BackendFile.(keeper); ok {
+		k.keep()
+	}
+}
+
+func (f *storedFile) Sync() error {
+	if err := f.finish(); err != nil {
+		return err
+	}
+	return f.BackendFile.Sync()
+}
+
+func (f *storedFile) Close() error {
+	if err := f.finish(); err != nil {
+		f.BackendFile.Close()
+		return err
+	}
+	if err := f.BackendFile.Close(); err != nil {
+		return err
+	}
+
+	// A plain file left at the original name would be listed along with the compressed one
+	if info, err := f.backend.Stat(f.relPath); err == nil && info.Mode().IsRegular() {
+		f.backend.Remove(f.relPath)
+	}
+	return nil
+}
+
+type storedReader struct {
+	*zstd.Decoder
+	file io.Closer
+}
+
+func (r *storedReader) Close() error {
+	r.Decoder.Close()
+	return r.file.Close()
+}
+
+// What a compressed file looks like with its original name and size.
+type storedFileInfo struct {
+	fs.FileInfo
+	name string
+	size int64
+}
+
+func (i storedFileInfo) Name() string { return i.name }
+func (i storedFileInfo) Size() int64  { return i.size }
+
+// A compressed file listed under its original name.
+type storedDirEntry struct {
+	fs.DirEntry
+	backend *storedBackend
+	relPath string
+}
+
+func (e storedDirEntry) Name() string {
+	return strings.TrimSuffix(e.DirEntry.Name(), storedSuffix)
+}
+
+// Returns the info of the original file, which takes reading the frame header.
+func (e storedDirEntry) Info() (fs.FileInfo, error) {
+	return e.backend.Stat(e.relPath)
+}
@@ -0,0 +1,59 @@
+package syncer
+
+import (
+	"io"
+	"os"
+
+	"github.com/bipinmdr07/gosync/internal/ads"
+)
+
+// Turns ADS off with a warning unless both the source and the destination are on volumes
+// with alternate data streams, NTFS on Windows.
+func (s *Syncer) prepareStreams() {
+	if !s.Options.ADS || s.localSource == nil || s.local == nil {
+		return
+	}
+	for _, path := range []string{s.localSource.root, s.local.root} {
+		if !ads.Supported(path) {
+			s.logger.Warn().Str("action", "ADS").Str("path", path).Msg("No alternate data streams on this file system, not preserving them")
+			s.Options.ADS = false
+			return
+		}
+	}
+}
+
+// Copies the alternate data streams of the source file to the destination file, like the
+// Zone.Identifier marking downloads. Written through the path of the open destination file,
+// the streams move with it into place. Streams that can't be copied are warned about one
+// by one, the copy itself stands.
+func (s *Syncer) copyStreams(srcFile, destinationFile *os.File, relPath string) {
+	names, err := ads.List(srcFile.Name())
+	if err != nil {
+		s.logger.Warn().Err(err).Str("action", "ADS").Str("path", relPath).Msg("Could not list alternate data streams")
+		return
+	}
+
+	for _, name := range names {
+		if err := copyStream(ads.Path(srcFile.Name(), name), ads.Path(destinationFile.Name(), name)); err != nil {
+			s.logger.Warn().Err(err).Str("action", "ADS").Str("path", relPath).Str("stream", name).Msg("Could not preserve alternate data stream")
+		}
+	}
+}
+
+func copyStream(srcPath, destinationPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dest, src); err != nil {
+		dest.Close()
+		return err
+	}
+	return dest.Close()
+}
@@ -0,0 +1,150 @@
+package syncer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Resolves a local source given as a symlink to the directory it points to, so the tree
+// is synced from its real root and the links in it are judged against that. Left as given
+// with NoResolveSource, the source is walked through the link.
+func (s *Syncer) resolveSourceRoot() error {
+	root := s.localSource.root
+	info, err := os.Lstat(root)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return nil // A trailing separator already has the link followed
+	}
+	if s.Options.NoResolveSource {
+		s.logger.Info().Str("action", "SOURCE_LINK").Str("path", root).Msg("Source is a symlink, walking through it as given")
+		return nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("could not resolve source symlink %s: %w.", root, err)
+	}
+	s.logger.Info().Str("action", "RESOLVE_SOURCE").Str("path", root).Str("target", resolved).Msg("Source is a symlink, syncing the directory it points to")
+	s.localSource.root = resolved
+	return nil
+}
+
+// Handles a symbolic link found in the source at relPath, path being where it is on a
+// local source. Reports false when the link is left to be copied like a regular file.
+func (s *Syncer) handleSymlink(path, relPath string, chain []string, sourceFiles pathIndex) (bool, error) {
+	switch s.Options.Symlinks {
+	case SymlinkSkip:
+		s.logger.Debug().Str("action", "SKIP_SYMLINK").Str("path", relPath).Msg("Path is a symlink, skipping")
+		return true, nil
+
+	case SymlinkRecreate:
+		info, err := os.Lstat(path)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not stat symlink, skipping")
+			return true, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not read symlink target, skipping")
+			return true, nil
+		}
+		if err := sourceFiles.add(relPath); err != nil {
+			return true, err
+		}
+		s.recreateSymlink(relPath, target, info)
+		return true, nil
+	}
+
+	// Copying what the link points to is the same as for a regular file, unless it is a directory
+	info, err := os.Stat(path)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Symlink target can't be reached, skipping")
+		s.stats.recordError(relPath, err)
+		return true, nil
+	}
+	if !info.IsDir() {
+		return false, nil
+	}
+
+	realTarget, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not resolve symlink target, skipping")
+		return true, nil
+	}
+	if leadsToAncestor(path, realTarget, chain) {
+		s.logger.Warn().Str("action", "SYMLINK_LOOP").Str("path", relPath).Str("target", realTarget).Msg("Symlink points to an ancestor directory, skipping")
+		return true, nil
+	}
+
+	s.logger.Debug().Str("action", "FOLLOW_SYMLINK").Str("path", relPath).Str("target", realTarget).Msg("Following symlink")
+	if err := sourceFiles.add(relPath); err != nil {
+		return true, err
+	}
+	if err := s.walkSource(&localBackend{root: realTarget}, relPath, chain, sourceFiles); err != nil {
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Error walking symlink target")
+		return true, err
+	}
+	return true, nil
+}
+
+// Reports whether following a link at path to the directory realTarget would lead back
+// into a directory the walk is already inside of.
+func leadsToAncestor(path, realTarget string, chain []string) bool {
+	realParent, _ := filepath.EvalSymlinks(filepath.Dir(path))
+	for _, ancestor := range append(chain[:len(chain):len(chain)], realParent) {
+		if isWithin(realTarget, ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates a symlink at the destination with the same target as the one in the source.
+// The target is copied verbatim, relative or not.
+func (s *Syncer) recreateSymlink(relPath, target string, srcInfo os.FileInfo) {
+	destinationPath := s.local.path(relPath)
+	logEvent := s.logger.Info().Str("action", "SYMLINK").Str("path", relPath).Str("target", target)
+
+	if err := s.checkContained(relPath, false); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Refusing to write outside of destination")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	// Nothing to do if an identical link already exists
+	if existing, err := os.Readlink(destinationPath); err == nil && existing == target {
+		if destInfo, err := os.Lstat(destinationPath); err == nil {
+			s.preserveOwner(relPath, srcInfo, destInfo, s.lchown(relPath))
+		}
+		s.logger.Debug().Str("action", "SKIP_SYMLINK").Str("path", relPath).Msg("Symlink is up-to-date, skipping")
+		return
+	}
+
+	if s.Options.DryRun {
+		s.recordPlanned("SYMLINK", relPath, target)
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would create symlink")
+		return
+	}
+
+	// Replace a file or link in the way, never a directory
+	if err := removeReplaceable(destinationPath); err != nil && !os.IsNotExist(err) {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Could not remove existing destination entry")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Failed to create directories")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if err := os.Symlink(target, destinationPath); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error creating symlink")
+		s.stats.recordError(relPath, err)
+		return
+	}
+	s.preserveOwner(relPath, srcInfo, nil, s.lchown(relPath))
+
+	logEvent.Msg("Symlink created successfully")
+}
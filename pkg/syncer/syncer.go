@@ -1,264 +1,1547 @@
 package syncer
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/bipinmdr07/gosync/internal/junction"
+	"github.com/bipinmdr07/gosync/internal/placeholder"
+	"github.com/bipinmdr07/gosync/pkg/filter"
+
 	"github.com/rs/zerolog"
+)
+
+// JunctionMode controls how NTFS junctions (mount point reparse points) found in the source are handled.
+type JunctionMode string
+
+const (
+	JunctionSkip     JunctionMode = "skip"     // Leave junctions out of the sync entirely
+	JunctionFollow   JunctionMode = "follow"   // Walk into the junction target as if it were a regular directory
+	JunctionRecreate JunctionMode = "recreate" // Create an equivalent junction at the destination
+)
+
+// SymlinkMode controls how symbolic links found in the source are handled.
+type SymlinkMode string
+
+const (
+	SymlinkCopy     SymlinkMode = "copy"     // Copy what the link points to, walking into linked directories
+	SymlinkRecreate SymlinkMode = "recreate" // Create a link with the same target at the destination
+	SymlinkSkip     SymlinkMode = "skip"     // Leave links out of the sync entirely
+)
+
+// PlaceholderMode controls how cloud "online-only" placeholder files (OneDrive, iCloud, Dropbox) are handled.
+type PlaceholderMode string
 
-	ignore "github.com/sabhiram/go-gitignore"
+const (
+	PlaceholderSkip    PlaceholderMode = "skip"    // Leave placeholders alone so they are never downloaded
+	PlaceholderHydrate PlaceholderMode = "hydrate" // Read through the placeholder, letting the provider download it
+	PlaceholderStub    PlaceholderMode = "stub"    // Create an empty file with the same name, times and mode
 )
 
 type SyncOptions struct {
-	SourcePath      string
-	DestinationPath string
-	DryRun          bool
-	Delete          bool
-	Verbose         bool
-	Workers         int
+	SourcePath        string
+	DestinationPath   string
+	ExtraDestinations []string // Further destinations the source is synced to in the same run, walked and hashed once for all
+	DryRun            bool
+	Delete            bool
+	Verbose           bool
+	Workers           int
+	Junctions         JunctionMode
+	Symlinks          SymlinkMode
+	NoResolveSource   bool // Keep a local source given as a symlink as it is, walking through the link, instead of resolving it to its target
+	Placeholders      PlaceholderMode
+	StatsDepth        int   // Number of leading directories the per-directory statistics are grouped by
+	TopN              int   // Number of largest and slowest transfers to keep in the summary
+	MaxMemory         int64 // Approximate memory budget in bytes, 0 for unlimited. Trades speed for a smaller footprint
+	Compare           CompareMode
+	ModifyWindow      time.Duration // Modification times closer than this are treated as equal
+	AmbiguityWindow   time.Duration // In adaptive mode, modification times closer than this are verified by hashing
+	IncludeTypes      []string      // Only sync files whose sniffed content type matches one of these, e.g. "text/*"
+	ExcludeTypes      []string      // Never sync files whose sniffed content type matches one of these, e.g. "video/*"
+	IncludeOwners     []string      // Only sync files owned by one of these, as "user", ":group" or "user:group"
+	ExcludeOwners     []string      // Never sync files owned by one of these, as "user", ":group" or "user:group"
+	StoreChecksums    bool          // Record the hash of copied files in the user.gosync.<hash> extended attribute
+	SortPlan          bool          // In a dry run, log the planned operations sorted by path once the run is done
+	Pool              *Pool         // Process files on these shared workers instead of starting Workers of our own
+	LogWriter         io.Writer     // Where log output is written, os.Stderr when nil
+
+	PreserveSELinux      bool // Copy security.selinux contexts, needs the privilege to relabel files
+	PreserveCapabilities bool // Copy file capabilities (security.capability), needs CAP_SETFCAP
+	DirsOnly             bool // Only recreate the directory tree with its modes and modification times, copy no files
+
+	MinAge time.Duration // Files modified more recently than this are deferred to a later run, they may still be written to
+	VSS    bool          // Read a local source from a Volume Shadow Copy snapshot of its volume, taken at the start of the run. Windows only, needs administrator rights
+
+	ExcludeCaches  bool     // Skip directories holding a CACHEDIR.TAG, and keep them at the destination
+	ExcludeMarkers []string // Skip directories holding a file of one of these names, e.g. ".nosync", and keep them at the destination
+
+	PathRules []filter.PathRule // Include and exclude globs and regexes, the first one matching a path decides on it
+
+	ProtectPatterns  []string // Destination paths matching these --exclude style globs are never deleted
+	MaxDelete        *int     // Unless nil, refuse to delete more files than this unless Force is set, 0 deletes none
+	MaxDeletePercent *float64 // Unless nil, refuse to delete more than this percentage of the destination's files unless Force is set
+	Force            bool     // Delete even beyond MaxDelete and MaxDeletePercent
+
+	// What is done with source paths differing only in case when the destination is a
+	// local directory that doesn't tell them apart, CaseFail by default
+	CaseCollisions CasePolicy
+
+	// Called with the destination and the files the deletion pass would remove before any
+	// of them is, only those it returns are deleted. Not called in dry runs
+	ConfirmDeletions func(destination string, paths []string) []string `json:"-"`
+
+	WholeFile bool          // Always send whole files, never only the blocks of an existing destination file that changed
+	BlockSize int64         // When above 0, existing local destination files are compared in blocks of this size and only differing blocks rewritten in place
+	Hash      HashAlgorithm // Hash file contents are compared and checksums stored with, SHA-256 by default
+	Verify    bool          // Read every copied file back and compare its hash with the source, copying it again on a mismatch
+
+	DetectRenames bool // Move destination files the source no longer has to where the same contents turned up instead of copying them, needs Delete
+	HardLinks     bool // Copy files with several names in the source once and hard link the other names to the copy
+	Sparse        bool // Leave the holes of sparse source files out of new local copies so they stay sparse
+	Owner         bool // Give destination entries the user owning the source entry, usually needs root
+	Group         bool // Give destination entries the group owning the source entry
+	Xattrs        bool // Copy the extended attributes of source files, on Linux those of the user and security namespaces
+	ADS           bool // Copy the alternate data streams of source files, when both sides are on NTFS
+
+	PartialDir string        // Name of a directory next to them new local files are written to and interrupted copies kept in, to be resumed by the next run
+	Retries    int           // Times a failed file operation is tried again
+	RetryDelay time.Duration // Wait before the first retry, doubled for every further one
+
+	UnstableRetries int // Times a file that changed while being copied is copied again before it is reported as unstable
+
+	SkipUnreadable bool   // Skip source entries that can't be read for lack of permission or a lock, instead of failing them
+	UnreadableList string // Path of a file the source entries that couldn't be read are listed in after the run, one per line
+
+	Backup       bool   // Keep the previous version of deleted and overwritten files, next to them unless BackupDir is set
+	BackupDir    string // Directory inside the destination deleted and overwritten files are moved to, below a timestamped directory per run
+	BackupSuffix string // Appended to the names of backups, "~" by default next to the file. An N in it is replaced by a version number, 1 for the most recent
+	BackupKeep   int    // When above 0, the number of versions kept with a numbered BackupSuffix
+
+	LinkDest []string // Earlier snapshots files missing at the destination are hard linked from when unchanged, relative ones to the destination
+
+	TwoWay   bool   // Carry changes over in both directions, deletions included, using the state the last run left
+	StateDir string // Directory the state of two-way syncs is kept in, gosync in the user cache directory by default
+
+	Conflicts      ConflictPolicy // What a two-way sync does with files changed on both sides, ConflictFail by default
+	ConflictRules  []ConflictRule // Policies for the paths they match, the first matching one applies instead of Conflicts
+	ConflictSuffix string         // Appended to the name the destination's version is kept under by ConflictKeepBoth, ".conflict" by default
+
+	StateIndex   bool          // Remember the files in sync in StateDir and trust that over the destination for files unchanged in the source since
+	ListingCache time.Duration // Reuse the listing of an S3 destination, kept in StateDir, for this long between runs, never when 0
+
+	// After each sync, write a manifest of the destination's files with their sizes,
+	// modification times and hashes to this local path as JSON. The hashes of files
+	// unchanged since the last manifest are taken from it instead of reading them again
+	Manifest string
+
+	WatchDelay        time.Duration // With Watch, how long the source has to be quiet before its changes are synced, a second by default
+	ReconcileInterval time.Duration // With Watch, how often everything is compared again to catch changes the watcher missed, never when 0
+	Poll              bool          // With Watch, find changes by scanning the source instead of having the system report them
+	PollInterval      time.Duration // How often a polled source is scanned, also when watching falls back to it, 10 seconds by default
+
+	RemoveSourceFiles bool // Remove source files once the destination holds them, copied and verified or found identical
+
+	Update bool // Leave destination files alone that were modified after the source file, like rsync -u
+
+	Existing       bool // Only update files the destination already has, never create new ones
+	IgnoreExisting bool // Only create files the destination doesn't have, never touch existing ones
+
+	Devices bool // Recreate device files, named pipes and sockets at local destinations instead of leaving them out
+
+	// Preserve everything that can be kept: symlinks are recreated unless Symlinks is set,
+	// and between local directories Group, Devices and, when running as root, Owner are set
+	Archive bool
+
+	Totals bool // Add up the sizes of the files found for Progress, a stat per file of local sources
+
+	RecordPlan bool // In a dry run, keep the operations planned for Plan, along with the state of their paths
+
+	// Also where operations, warnings and errors are logged to as JSON lines, whatever the
+	// console shows, and debug entries too with Verbose
+	LogFile io.Writer
+
+	// Limits on the disk traffic of local files, per device and shared by the Syncers in
+	// the process with the same limits, so a busy host keeps bandwidth for its own work
+	IOLimit int64 // When above 0, bytes per second read or written
+	IOOps   int   // When above 0, reads and writes per second
+	NiceIO  bool  // After every read, leave the disk idle for as long as the read took
+
+	BufferSize int // Size of the buffers file contents are copied through, 128 KiB by default
+
+	// Files the walker may find ahead of the workers, so scanning goes on while large files
+	// are copied. Once the queue is full the walker waits. 1024 by default
+	QueueSize int
+
+	// Rewrite the contents of matching files on their way to the destination, the first
+	// matching rule applies
+	Transforms []TransformRule
+
+	// Codec file contents are compressed with to and from gosync:// servers, none by default.
+	// Files of types that are compressed already are sent as they are
+	Compression Compression
+
+	// Store destination files compressed with zstd as name.zst, for archiving large text
+	// trees. Syncing from such a destination gives the files back as they were
+	StoreCompressed bool
+
+	// Store destination files as manifests of content-addressed chunks, so contents held by
+	// several files, or left over in backups, take space once. Syncing from such a
+	// destination gives the files back as they were
+	Dedup bool
+
+	// Store destination files encrypted with AES-256-GCM under EncryptionKey, and their
+	// names too with EncryptNames, for storage that isn't trusted. Sources encrypted this
+	// way are decrypted with EncryptionKey. Whether names are encrypted is kept from the
+	// first sync to a destination
+	Encrypt       bool
+	EncryptNames  bool
+	EncryptionKey EncryptionKey
+
+	// Format new encrypted destinations are written in, gosync by default. With rclone they
+	// can be read as rclone crypt remotes
+	EncryptFormat EncryptFormat
+
+	// Decrypt the source also without the marker of an encrypted destination, e.g. an
+	// rclone crypt remote, taking it to be in EncryptFormat with names encrypted when
+	// EncryptNames is set
+	Decrypt bool
+
+	// Called as paths are handled, for applications to follow a sync without reading its
+	// log. They are called from the workers, so concurrently, and hold them up while they
+	// run. Functions can't be saved with the options, plans leave them out
+	OnFileStart func(relPath string, size int64)                          `json:"-"` // A copy starts
+	OnFileDone  func(relPath string, bytes int64, duration time.Duration) `json:"-"` // A file was copied, with a zero duration in a dry run
+	OnSkip      func(relPath, reason string)                              `json:"-"` // A file was left alone, reason is the logged action like SKIP_FILE
+	OnDelete    func(relPath string)                                      `json:"-"` // A destination path was deleted, or would be in a dry run
+	OnError     func(FileError)                                           `json:"-"` // A path failed, it is in the Result's Errors too
 }
 
+// Files found ahead of the workers when QueueSize isn't set.
+const defaultQueueSize = 1024
+
+// Rough upper bound of the memory a single copy worker needs, buffers and open files included.
+const workerMemoryEstimate = 4 << 20
+
 type Syncer struct {
 	Options *SyncOptions
 	wg      sync.WaitGroup
-	fileOps chan string
+	fileOps chan fileJob
 	logger  zerolog.Logger
-	matcher *ignore.GitIgnore
+	matcher *filter.Ignore
+	stats   *statsCollector
+	plan    planBuffer
+
+	pathRules     *filter.PathRules
+	protectPaths  *filter.PathRules // Matches the paths of ProtectPatterns as excluded
+	includeOwners []filter.OwnerRule
+	excludeOwners []filter.OwnerRule
+
+	src         Backend
+	localSource *localBackend // Same as src when it is a local directory, nil otherwise
+	dest        Backend
+	local       *localBackend // Same as dest when it is a local directory, nil otherwise
+
+	createdDirectories    []createdDirectory  // Only filled in dirs-only mode, by the walker
+	ownedDirectories      []ownedDirectory    // Only filled when ownership is preserved, by the walker
+	markedDirectories     map[string]struct{} // Source directories skipped for holding a marker file, by the walker
+	unreadableDirectories map[string]struct{} // Source directories that couldn't be listed, by the walker
+	backups               bool                // Whether deleted and overwritten files are backed up
+	backupRoot            string              // Directory below BackupDir this run's backups go to, empty when they are kept next to the files
+	backupSuffix          string              // BackupSuffix, or its default
+	linkDests             []string            // LinkDest resolved to paths
+	conflictRules         []conflictRule      // ConflictRules compiled, with TwoWay
+	transforms            []transformRule     // Transforms compiled
+	index                 *stateIndex         // Files in sync after the last run, with StateIndex
+	listingCache          *listingCache       // Objects of an S3 destination as listed by an earlier run, with ListingCache
+	manifest              *destManifest       // Hashes of destination files known so far, with Manifest
+	caseFold              *caseCollisions     // Paths synced so far, when the destination is case-insensitive
+	ctx                   context.Context     // Done when the sync is to stop, from StartContext
+	watchCtx              context.Context     // Set by Watch, which keeps syncing until it is done
+	deferred              *deferredFiles      // Files deferred for MinAge while watching, synced again once old enough
+	renames               *renameCandidates   // Destination files moved files may be found among, with DetectRenames
+	hardLinks             *linkGroups         // Source files with several names seen so far, with HardLinks
+	listing               *sourceListing      // Walk of the source shared with the other Syncers of a fan-out
+	sourceSums            *sourceSums         // Source digests shared with the other Syncers of a fan-out
+	shadowSource          string              // Where the source is read in its shadow copy, with VSS
+}
+
+// A single file handed from the walker to the worker pool.
+type fileJob struct {
+	src     Backend // Tree the file is read from, the source or a junction target in it
+	srcPath string  // Path of the file within src
+	relPath string  // Path relative to the source root, used for the destination
+	size    int64   // Of the file when it was found, with Totals
+}
+
+// NewSyncer returns a Syncer mirroring src into dst, set up by opts. Settings left unset
+// get their defaults on the Syncer's own copy of the options.
+func NewSyncer(src, dst string, opts ...Option) *Syncer {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.options.SourcePath, c.options.DestinationPath = src, dst
+	return newSyncer(&c)
 }
 
-func NewSyncer(opts *SyncOptions) *Syncer {
+func newSyncer(c *config) *Syncer {
+	opts := &c.options
 	if opts.Workers == 0 {
 		opts.Workers = runtime.NumCPU()
 	}
+	if opts.Junctions == "" {
+		opts.Junctions = JunctionSkip
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.WatchDelay == 0 {
+		opts.WatchDelay = time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 10 * time.Second
+	}
+	if opts.Symlinks == "" && !opts.Archive {
+		opts.Symlinks = SymlinkCopy // Archive picks it by destination
+	}
+	if opts.Placeholders == "" {
+		opts.Placeholders = PlaceholderSkip
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	// Stay within the memory budget by limiting how many copies are in flight at once
+	if opts.MaxMemory > 0 {
+		maxWorkers := int(opts.MaxMemory / 2 / (workerMemoryEstimate + int64(opts.BufferSize)))
+		if maxWorkers < 1 {
+			maxWorkers = 1
+		}
+		if opts.Workers > maxWorkers {
+			opts.Workers = maxWorkers
+		}
+	}
+	if opts.Compare == "" {
+		opts.Compare = CompareSizeMtime
+	}
+	if opts.Hash == "" {
+		opts.Hash = HashSHA256
+	}
+	if opts.StatsDepth <= 0 {
+		opts.StatsDepth = 1
+	}
+
+	logWriter := opts.LogWriter
+	if logWriter == nil {
+		logWriter = os.Stderr
+	}
 
 	// Initialize Zerolog Console Writer for better readability in terminal
-	output := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
-	logger := zerolog.New(output).With().Timestamp().Logger()
+	var output io.Writer = zerolog.ConsoleWriter{Out: logWriter, TimeFormat: time.RFC3339}
 
 	// Set log level based on flags
+	level := zerolog.InfoLevel
 	if !opts.Verbose && !opts.DryRun {
-		logger = logger.Level(zerolog.Disabled)
+		level = zerolog.Disabled
 	} else if opts.Verbose {
-		logger = logger.Level(zerolog.DebugLevel)
-	} else {
-		logger = logger.Level(zerolog.InfoLevel)
+		level = zerolog.DebugLevel
+	}
+
+	// The log file keeps the operations even when the console is quiet
+	if opts.LogFile != nil {
+		fileLevel := zerolog.InfoLevel
+		if opts.Verbose {
+			fileLevel = zerolog.DebugLevel
+		}
+		output = zerolog.MultiLevelWriter(levelFilter{output, level}, levelFilter{opts.LogFile, fileLevel})
+		level = min(level, fileLevel)
+	}
+	logger := zerolog.New(output).With().Timestamp().Logger().Level(level)
+	if c.logger != nil {
+		logger = *c.logger
 	}
 
 	// Load the ignore patterns
-	matcher := loadIgnorePatterns(opts.SourcePath, logger)
+	matcher := c.filter
+	if !c.filterSet {
+		matcher = loadIgnorePatterns(opts.SourcePath, logger)
+	}
 
 	return &Syncer{
 		Options: opts,
-		fileOps: make(chan string),
+		fileOps: make(chan fileJob, opts.QueueSize),
 		logger:  logger,
 		matcher: matcher,
+		stats:   newStatsCollector(opts.StatsDepth, opts.TopN, opts.QueueSize, newHooks(opts)),
+		ctx:     context.Background(),
+
+		markedDirectories:     make(map[string]struct{}),
+		unreadableDirectories: make(map[string]struct{}),
 	}
 }
 
-// Read .gosyncignore file from source directory and return a list of patterns to ignore.
-func loadIgnorePatterns(sourceDir string, logger zerolog.Logger) *ignore.GitIgnore {
-	ignoreFilePath := filepath.Join(sourceDir, ".gosyncignore")
+// Returns the statistics collected so far. After Start returns they cover the whole run.
+func (s *Syncer) Summary() Summary {
+	return s.stats.summary()
+}
+
+// Returns a snapshot of the running sync. Safe to call from any goroutine while Start runs.
+func (s *Syncer) Progress() Progress {
+	return s.stats.progress()
+}
 
-	// Check if the file exists
-	if _, err := os.Stat(ignoreFilePath); os.IsNotExist(err) {
-		return nil // Return nil if file don't exist
+// Passes log entries of at least min on to w, for writers logging at different levels.
+type levelFilter struct {
+	w   io.Writer
+	min zerolog.Level
+}
+
+func (f levelFilter) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f levelFilter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < f.min {
+		return len(p), nil
 	}
+	return f.w.Write(p)
+}
 
-	matcher, err := ignore.CompileIgnoreFile(ignoreFilePath)
+// Read .gosyncignore file from source directory and return a list of patterns to ignore.
+func loadIgnorePatterns(sourceDir string, logger zerolog.Logger) *filter.Ignore {
+	matcher, err := filter.LoadIgnore(sourceDir)
 	if err != nil {
-		logger.Error().Err(err).Str("path", ignoreFilePath).Msg("Error reading .gosyncignore file")
+		logger.Error().Err(err).Str("path", filepath.Join(sourceDir, filter.IgnoreFile)).Msg("Error reading .gosyncignore file")
 		return nil
 	}
 
 	return matcher
 }
 
+// Starts workers processing the files sent on fileOps until it is closed, unless files go
+// to a pool shared with other Syncers.
+func (s *Syncer) startWorkers() {
+	if s.Options.Pool != nil {
+		s.wg.Add(1)
+		go s.dispatch(s.Options.Pool)
+		return
+	}
+	for i := 0; i < s.Options.Workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+}
+
 func (s *Syncer) worker() {
 	defer s.wg.Done()
-	for srcPath := range s.fileOps {
-		s.processFile(srcPath)
+	for job := range s.fileOps {
+		s.stats.recordTaken()
+		s.processFile(job)
+		s.stats.recordProcessed(job.size)
+	}
+}
+
+// Hands files over to the shared pool instead of processing them on workers of our own.
+func (s *Syncer) dispatch(pool *Pool) {
+	defer s.wg.Done()
+	for job := range s.fileOps {
+		s.stats.recordTaken()
+		job := job
+		s.wg.Add(1)
+		pool.submit(func() {
+			defer s.wg.Done()
+			s.processFile(job)
+			s.stats.recordProcessed(job.size)
+		})
 	}
 }
 
 // Handles the comparison and copying of a single file.
-func (s *Syncer) processFile(srcPath string) {
-	relPath, _ := filepath.Rel(s.Options.SourcePath, srcPath)
+func (s *Syncer) processFile(job fileJob) {
+	if s.ctx.Err() != nil {
+		return // Files still queued when the sync is stopped are left alone
+	}
+	relPath := job.relPath
 	destinationPath := filepath.Join(s.Options.DestinationPath, relPath)
 
 	s.logger.Debug().Str("action", "CHECK_FILE").Str("path", relPath).Msg("File check started")
 
 	// Check if source path exists
-	srcInfo, err := os.Stat(srcPath)
+	var srcInfo os.FileInfo
+	err := s.withRetries(relPath, func() (err error) {
+		srcInfo, err = job.src.Stat(job.srcPath)
+		return err
+	})
+	if err != nil && unreadable(err) {
+		s.recordUnreadable(relPath, err)
+		return
+	}
 	if err != nil {
-		s.logger.Warn().Err(err).Str("path", srcPath).Msg("Could not stat source file")
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not stat source file")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	// A file that was just modified may be half written, leave it for the next run
+	if age := time.Since(srcInfo.ModTime()); s.Options.MinAge > 0 && age < s.Options.MinAge {
+		s.logger.Info().Str("action", "DEFER").Str("path", relPath).Dur("age", age).Msg("File was modified too recently, deferring")
+		s.stats.recordDeferred()
+		if s.deferred != nil {
+			s.deferred.add(relPath, srcInfo.ModTime().Add(s.Options.MinAge))
+		}
+		return
+	}
+
+	// Refuse to write through symlinks that lead out of the destination
+	if err := s.checkContained(relPath, true); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Refusing to write outside of destination")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if isSpecial(srcInfo.Mode()) {
+		s.syncSpecial(job, srcInfo)
+		return
+	}
+
+	// Opening a cloud placeholder makes the provider download it, so decide what to do first
+	if placeholder.Is(srcInfo) {
+		switch s.Options.Placeholders {
+		case PlaceholderHydrate:
+			s.logger.Debug().Str("action", "HYDRATE").Str("path", relPath).Msg("File is a cloud placeholder, hydrating")
+		case PlaceholderStub:
+			s.copyStub(destinationPath, relPath, srcInfo)
+			return
+		default:
+			s.stats.recordSkipped(relPath, "SKIP_PLACEHOLDER")
+			s.logPlanned(relPath, s.logger.Info().Str("action", "SKIP_PLACEHOLDER").Str("path", relPath), "File is a cloud placeholder, skipping")
+			return
+		}
+	}
+
+	if s.filteredByContentType(job) {
+		return
+	}
+
+	// A file with several names is copied under the first and linked under the others
+	if s.hardLinks != nil {
+		group, first := s.hardLinks.join(srcInfo, relPath)
+		switch {
+		case group == nil:
+		case first:
+			defer func() {
+				group.copied = s.holdsCopy(relPath, srcInfo)
+				close(group.done)
+			}()
+		case s.linkToGroup(group, relPath):
+			s.removeSource(job, srcInfo)
+			return
+		}
+	}
+
+	// Files unchanged since the last run left them in sync need no look at the destination
+	if s.unchangedSinceIndexed(job, srcInfo) {
+		s.stats.recordSkipped(relPath, "SKIP_FILE")
+		s.logger.Debug().Str("action", "SKIP_FILE").Str("path", relPath).Msg("File is unchanged since the last run, skipping")
+		return
 	}
 
 	// Check if destination exists and is up-to-date
-	destInfo, err := os.Stat(destinationPath)
-	if err == nil {
+	var destInfo os.FileInfo
+	err = s.withRetries(relPath, func() (err error) {
+		destInfo, err = s.dest.Stat(relPath)
+		return err
+	})
+	if os.IsNotExist(err) {
+		if s.Options.Existing {
+			s.stats.recordSkipped(relPath, "SKIP_MISSING")
+			s.logger.Debug().Str("action", "SKIP_MISSING").Str("path", relPath).Msg("File is not at the destination yet, skipping")
+			return
+		}
+		destInfo = nil
+	} else if err == nil {
+		if s.Options.IgnoreExisting {
+			s.stats.recordSkipped(relPath, "SKIP_EXISTING")
+			s.logger.Debug().Str("action", "SKIP_EXISTING").Str("path", relPath).Msg("File is already at the destination, skipping")
+			return
+		}
+
+		// Changes made at the destination since are kept
+		if s.Options.Update && s.destinationNewer(srcInfo, destInfo) {
+			s.stats.recordKeptNewer()
+			s.logger.Info().Str("action", "SKIP_NEWER").Str("path", relPath).Msg("Destination file is newer, skipping")
+			return
+		}
+
 		// If destination file exists, compare modification times and sizes
-		if !srcInfo.ModTime().After(destInfo.ModTime()) && srcInfo.Size() == destInfo.Size() {
+		if needed, matched := s.needsCopy(job, srcInfo, destInfo); !needed {
+			s.rememberSynced(relPath, srcInfo, nil)
+			s.preserveOwner(relPath, srcInfo, destInfo, s.lchown(relPath))
+			s.stats.recordSkipped(relPath, "SKIP_FILE")
 			s.logger.Debug().Str("action", "SKIP_FILE").Str("path", relPath).Msg("File is up-to-date, skipping")
+
+			// Only a destination known to hold the same contents makes the source redundant
+			if matched {
+				s.removeSource(job, srcInfo)
+			} else if s.Options.RemoveSourceFiles {
+				s.logger.Warn().Str("action", "KEEP_SOURCE").Str("path", relPath).Msg("Destination file may differ from the source, keeping it")
+			}
 			return
 		}
-	} else if !os.IsNotExist(err) {
+	} else {
 		s.logger.Warn().Str("path", destinationPath).Err(err).Msg("Could not stat destination file")
+		s.stats.recordError(relPath, err)
 		return
 	}
 
-	s.logger.Info().Str("action", "COPY_FILE").Str("path", relPath).Str("destination", destinationPath).Msg("Copying file")
-	s.copyFile(srcPath, destinationPath, srcInfo)
+	// A file that was moved in the source may still be at its old place in the destination
+	if destInfo == nil && s.renames != nil && s.moveRenamed(job, srcInfo) {
+		return
+	}
+
+	// An unchanged file may be shared with an earlier snapshot
+	if destInfo == nil && len(s.linkDests) > 0 && s.linkFromPrevious(job, srcInfo) {
+		s.removeSource(job, srcInfo)
+		return
+	}
+
+	s.replaceFile(job, destinationPath, srcInfo, destInfo)
+}
+
+// Copies the source file of job over destInfo, nil if there is nothing at the
+// destination yet, keeping a backup of it first if asked to.
+func (s *Syncer) replaceFile(job fileJob, destinationPath string, srcInfo, destInfo os.FileInfo) {
+	relPath := job.relPath
+
+	// The version being replaced goes to the backup first. Local files replaced in one
+	// step stay where they are until then, the backup is a hard link to them.
+	if destInfo != nil && !destInfo.IsDir() && s.backups {
+		keep := s.local != nil && !s.updatesInPlace(destInfo)
+		if !s.backup(relPath, keep) {
+			return
+		}
+		if !keep && !s.Options.DryRun {
+			destInfo = nil
+		}
+	}
+
+	s.logPlanned(relPath, s.logger.Info().Str("action", "COPY_FILE").Str("path", relPath).Str("destination", destinationPath), "Copying file")
+
+	// Failed copies are retried if asked to. A copy that doesn't read back the same may have
+	// hit bad media, it is written out again in full. One of a source that changed meanwhile
+	// is made again from the new version, as long as UnstableRetries allows.
+	changes := 0
+	for attempt := 1; ; attempt++ {
+		var mismatch bool
+		err := s.withRetries(relPath, func() (err error) {
+			mismatch, err = s.copyFile(job, destinationPath, srcInfo, destInfo)
+			return err
+		})
+		if errors.Is(err, ErrSourceChanged) && changes < s.Options.UnstableRetries {
+			if srcInfo, err = s.awaitSourceChange(job); err == nil {
+				changes++
+				attempt--
+				continue
+			}
+		}
+		if errors.Is(err, ErrSourceChanged) {
+			s.stats.recordUnstable()
+			s.logger.Error().Err(err).Str("action", "UNSTABLE").Str("path", relPath).Int("attempts", changes+1).Msg("Source file kept changing while being copied, not syncing it")
+		}
+		if errors.Is(err, ErrUnreadable) {
+			s.recordUnreadable(relPath, err)
+			return
+		}
+		if err != nil {
+			if s.ctx.Err() == nil { // An abandoned copy is no fault of the file
+				s.stats.recordError(relPath, err)
+			}
+			return
+		}
+		if !mismatch {
+			s.removeSource(job, srcInfo)
+			return
+		}
+
+		if attempt == verifyAttempts {
+			err := fmt.Errorf("destination still differs from source after %d attempts", attempt)
+			s.logger.Error().Err(err).Str("path", destinationPath).Msg("Verification failed")
+			s.stats.recordError(relPath, err)
+			return
+		}
+		s.logger.Warn().Str("action", "RETRY").Str("path", relPath).Int("attempt", attempt+1).Msg("Copy did not verify, retrying")
+		destInfo = nil
+	}
 }
 
 // Function to copy files from source to destination, creating directories as needed.
-func (s *Syncer) copyFile(srcPath, destinationPath string, srcInfo os.FileInfo) {
-	relPath, _ := filepath.Rel(s.Options.SourcePath, srcPath)
+// destInfo describes the file being replaced, nil if there is none. Reports a mismatch
+// when the copy was made but failed verification. Errors are logged, not recorded.
+func (s *Syncer) copyFile(job fileJob, destinationPath string, srcInfo, destInfo os.FileInfo) (mismatch bool, err error) {
+	relPath := job.relPath
 	logEvent := s.logger.Info().Str("action", "COPY").Str("path", relPath)
 
 	if s.Options.DryRun {
-		logEvent.Msg("DRY_RUN: Would copy file")
-		return
+		s.stats.recordCopy(relPath, srcInfo.Size(), 0)
+		s.recordPlanned("COPY", relPath, "")
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would copy file")
+		return false, nil
 	}
 
 	// Create parent directories if they don't exist
-	if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
+	if err := s.dest.MkdirAll(filepath.Dir(relPath)); err != nil {
 		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Failed to create directories")
-		return
+		return false, err
 	}
 
+	startTime := time.Now()
+
 	// Open source file
-	srcFile, err := os.Open(srcPath)
+	srcFile, err := job.src.Open(job.srcPath)
+	if err != nil && unreadable(err) {
+		return false, fmt.Errorf("%w: %w", ErrUnreadable, err)
+	}
 	if err != nil {
-		s.logger.Error().Err(err).Str("path", srcPath).Msg("Error opening source file")
-		return
+		s.logger.Error().Err(err).Str("path", relPath).Msg("Error opening source file")
+		return false, err
 	}
 	defer srcFile.Close()
 
-	// Create/overwrite destination file
-	destinationFile, err := os.Create(destinationPath)
+	// Transformed contents can only be written out whole
+	transformer := s.transformerFor(relPath)
+	verify := s.Options.Verify && transformer == nil
+	storeChecksum := s.Options.StoreChecksums && transformer == nil
+	manifest := s.manifest != nil && transformer == nil
+
+	// Create/overwrite destination file, or rebuild or update it from the blocks it already has
+	var inPlace *localFile
+	if transformer == nil && s.updatesInPlace(destInfo) {
+		if inPlace, err = s.local.openInPlace(relPath); err != nil {
+			s.logger.Debug().Err(err).Str("path", destinationPath).Msg("Could not update file in place, replacing it")
+			inPlace = nil
+		}
+	}
+
+	// A copy interrupted before is continued where it stopped
+	var resumed *localFile
+	var resumeOffset int64
+	if inPlace == nil && transformer == nil {
+		resumed, resumeOffset = s.resumePartial(relPath, srcFile, srcInfo)
+	}
+
+	var destinationFile BackendFile
+	var deltaDest deltaFile
+	sums, blockSize, delta := s.deltaBasis(relPath, destInfo)
+	delta = delta && transformer == nil
+	switch {
+	case delta:
+		deltaDest, err = s.dest.(deltaBackend).CreateDelta(relPath, blockSize)
+		destinationFile = deltaDest
+	case inPlace != nil:
+		destinationFile = inPlace
+	case resumed != nil:
+		destinationFile = resumed
+		logEvent = logEvent.Int64("resumed", resumeOffset)
+	default:
+		destinationFile, err = s.dest.Create(relPath, srcInfo)
+	}
 	if err != nil {
 		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error creating destination file")
-		return
+		return false, err
 	}
 	defer destinationFile.Close()
 
-	// Copy file contents
-	if _, err := io.Copy(destinationFile, srcFile); err != nil {
+	transfer := s.stats.beginTransfer(relPath, srcInfo.Size())
+	defer s.stats.endTransfer(transfer)
+
+	// Copy file contents, hashing them on the way if the checksum is stored or checked
+	srcBudget := s.ioBudget(srcFile)
+	source := s.limitReader(srcFile, srcBudget)
+	var hashes []io.Writer
+	hash := s.Options.Hash.newHash()
+	if storeChecksum || verify || manifest {
+		hashes = append(hashes, hash)
+	}
+	deltaHash := sha256.New() // The protocol always checks deltas with SHA-256
+	if delta {
+		hashes = append(hashes, deltaHash)
+	}
+	var hashWriter io.Writer
+	if len(hashes) > 0 {
+		hashWriter = io.MultiWriter(hashes...)
+		source = io.TeeReader(source, hashWriter)
+	}
+	destBudget := s.ioBudget(destinationFile)
+	writer := &countingWriter{w: destBudget.writer(s.Options.Pool.limitWriter(destinationFile)), count: &transfer.copied}
+	var written int64
+
+	// What a resumed copy already holds is only hashed, not copied again
+	if resumed != nil && hashWriter != nil {
+		if _, err := s.copy(hashWriter, io.NewSectionReader(resumed.File, 0, resumeOffset)); err != nil {
+			s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error reading partial file")
+			return false, err
+		}
+	}
+	transfer.copied.Add(resumeOffset)
+
+	// Between local files the kernel may copy or clone the contents, and holes can be kept
+	localSrc, ok := srcFile.(*os.File)
+	newLocal, isNewLocal := destinationFile.(*localFile)
+	isNewLocal = isNewLocal && ok && !delta && inPlace == nil && resumed == nil && transformer == nil
+	sparse := isNewLocal && s.Options.Sparse
+	kernel := false
+	if isNewLocal && hashWriter == nil && !s.Options.Pool.limited() && !s.ioLimited() {
+		written, kernel, err = copyKernel(s.ctx, newLocal, localSrc, srcInfo.Size(), sparse, &transfer.copied)
+	}
+
+	switch {
+	case kernel:
+	case delta:
+		var sent int64
+		written, sent, err = sendDelta(source, sums, blockSize, writer, func(first, count int64) error {
+			transfer.copied.Add(count * int64(blockSize))
+			return deltaDest.CopyBlocks(first, count)
+		})
+		deltaDest.Expect(deltaHash.Sum(nil))
+		logEvent = logEvent.Int64("sent", sent)
+	case inPlace != nil:
+		var rewritten int64
+		out := io.NewOffsetWriter(inPlace.File, 0)
+		writer.w = destBudget.writer(s.Options.Pool.limitWriter(out))
+		written, rewritten, err = rewriteBlocks(inPlace.File, writer, out, source, int(s.Options.BlockSize), &transfer.copied)
+		logEvent = logEvent.Int64("rewritten", rewritten)
+	case sparse:
+		out := io.NewOffsetWriter(newLocal.File, 0)
+		writer.w = destBudget.writer(s.Options.Pool.limitWriter(out))
+		written, err = copySparse(newLocal.File, writer, out, localSrc, srcInfo.Size(), hashWriter, func(r io.Reader) io.Reader {
+			return s.limitReader(r, srcBudget)
+		}, s.Options.BufferSize, &transfer.copied)
+	case transformer != nil:
+		written, err = s.copyTransformed(writer, source, transformer)
+	default:
+		written, err = s.copy(writer, source)
+	}
+	written += resumeOffset
+	if err != nil {
+		// A half updated file must not look newer than the source, or it is never fixed
+		if inPlace != nil {
+			inPlace.SetModTime(time.Unix(0, 0))
+		}
+		if s.ctx.Err() != nil {
+			s.logger.Warn().Str("action", "ABANDON").Str("path", destinationPath).Msg("Sync stopped, copy abandoned")
+			return false, err
+		}
 		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error copying file contents")
-		return
+		return false, err
 	}
 
-	// Sync and Preserve modification time
-	destinationFile.Sync()
-	if err := os.Chtimes(destinationPath, time.Now(), srcInfo.ModTime()); err != nil {
+	// A source written to while it was read leaves a torn copy, which is not kept
+	if err := s.checkSourceUnchanged(job, srcInfo); err != nil {
+		if inPlace != nil {
+			inPlace.SetModTime(time.Unix(0, 0))
+		}
+		return false, err
+	}
+
+	// Sync and Preserve modification time, all through the open file rather than its path.
+	// A local copy only takes the place of the old version once it is safely on disk.
+	local, isLocal := destinationFile.(*localFile)
+	if err := destinationFile.Sync(); err != nil && isLocal {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error syncing destination file")
+		return false, err
+	}
+	if verify && isLocal {
+		dropCache(local.File) // Read back what is on the media, not what is still cached
+	}
+	// Writing a stream touches the file's modification time, so they come before it is set
+	if localSource, ok := srcFile.(*os.File); ok && isLocal && s.Options.ADS {
+		s.copyStreams(localSource, local.File, relPath)
+	}
+	if err := destinationFile.SetModTime(srcInfo.ModTime()); err != nil {
 		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error preserving modification time")
 	}
 
+	// Store the checksum before permissions are applied, a read-only file can't take xattrs
+	if storeChecksum && isLocal {
+		s.recordChecksum(local.File, hash.Sum(nil), srcInfo.ModTime())
+	}
+	if localSource, ok := srcFile.(*os.File); ok && isLocal && s.Options.Xattrs {
+		s.copyXattrs(localSource, local.File, relPath)
+	}
+
+	// Before permissions, changing the owner clears setuid and setgid bits
+	if isLocal {
+		s.preserveOwner(relPath, srcInfo, nil, local.Chown)
+	}
+
 	// Set file permissions for source
-	if err := os.Chmod(destinationPath, srcInfo.Mode()); err != nil {
+	if err := destinationFile.Chmod(srcInfo.Mode()); err != nil {
 		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error setting file permissions")
 	}
+	if isLocal {
+		if err := copyAttributes(srcInfo, local.File); err != nil {
+			s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error preserving file attributes")
+		}
+	}
+
+	if localSource, ok := srcFile.(*os.File); ok && isLocal {
+		s.copySecurityXattrs(localSource, local.File, relPath)
+	}
+
+	// Remote destinations may only store the file once it is closed, local ones move it into place
+	if k, ok := destinationFile.(keeper); ok {
+		k.keep()
+	}
+	if err := destinationFile.Close(); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error closing destination file")
+		return false, err
+	}
 
+	if verify && !s.verifyCopy(relPath, destinationPath, hash.Sum(nil)) {
+		return true, nil
+	}
+
+	var sum []byte
+	if storeChecksum || verify || manifest {
+		sum = hash.Sum(nil)
+	}
+	s.rememberSynced(relPath, srcInfo, sum)
+	if manifest {
+		s.rememberHash(relPath, sum)
+	}
+
+	s.stats.recordCopy(relPath, written, time.Since(startTime))
 	logEvent.Msg("File copied successfully")
+	return false, nil
+}
+
+// Creates an empty stand-in for a cloud placeholder without reading its contents.
+func (s *Syncer) copyStub(destinationPath, relPath string, srcInfo os.FileInfo) {
+	// An empty file with the same modification time is an up-to-date stub
+	if destInfo, err := s.dest.Stat(relPath); err == nil && destInfo.Size() == 0 && destInfo.ModTime().Equal(srcInfo.ModTime().Truncate(s.dest.ModTimePrecision())) {
+		s.stats.recordSkipped(relPath, "SKIP_FILE")
+		s.logger.Debug().Str("action", "SKIP_FILE").Str("path", relPath).Msg("Stub is up-to-date, skipping")
+		return
+	}
+
+	logEvent := s.logger.Info().Str("action", "STUB").Str("path", relPath)
+
+	if s.Options.DryRun {
+		s.stats.recordCopy(relPath, 0, 0)
+		s.recordPlanned("STUB", relPath, "")
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would create stub file")
+		return
+	}
+
+	if err := s.dest.MkdirAll(filepath.Dir(relPath)); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Failed to create directories")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	destinationFile, err := s.dest.Create(relPath, srcInfo)
+	if err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error creating stub file")
+		s.stats.recordError(relPath, err)
+		return
+	}
+	defer destinationFile.Close()
+
+	if err := destinationFile.SetModTime(srcInfo.ModTime()); err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error preserving modification time")
+	}
+
+	if err := destinationFile.Chmod(srcInfo.Mode()); err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error setting file permissions")
+	}
+
+	if k, ok := destinationFile.(keeper); ok {
+		k.keep()
+	}
+	if err := destinationFile.Close(); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error closing stub file")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	s.stats.recordCopy(relPath, 0, 0)
+	logEvent.Msg("Stub file created successfully")
 }
 
-// Function to find and remove extra files in destination.
-func (s *Syncer) propagateDeletions(sourceFiles map[string]bool) error {
-	s.logger.Info().Msg("START: Propagating deletions in destination")
+// Walks the source tree src and sends every file to the worker pool. relBase is the
+// relative path src is synced to, and chain holds the resolved roots currently being
+// walked so that followed junctions can't loop.
+func (s *Syncer) walkSource(src Backend, relBase string, chain []string, sourceFiles pathIndex) error {
+	localSource, isLocal := src.(*localBackend)
+	if isLocal {
+		if realRoot, err := filepath.EvalSymlinks(localSource.root); err == nil {
+			chain = append(chain[:len(chain):len(chain)], realRoot)
+		}
+	}
+
+	walk := src.Walk
+	if s.listing != nil && src == s.src {
+		walk = func(fn fs.WalkDirFunc) error { return s.listing.walk(src, fn) }
+	}
 
-	err := filepath.WalkDir(s.Options.DestinationPath, func(path string, d os.DirEntry, err error) error {
+	return walk(func(srcPath string, d os.DirEntry, err error) error {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		relPath := filepath.Join(relBase, srcPath)
+		if err != nil && unreadable(err) {
+			// What the destination holds of a directory that can't be listed is kept
+			if d != nil && d.IsDir() {
+				s.unreadableDirectories[relPath] = struct{}{}
+			}
+			s.recordUnreadable(relPath, err)
+			return nil
+		}
 		if err != nil {
-			s.logger.Error().Err(err).Str("path", path).Msg("Error walking destination directory")
+			s.logger.Error().Err(err).Str("path", relPath).Msg("Error walking source directory")
+			s.stats.recordError(relPath, err)
 			return nil
 		}
 
-		relPath, _ := filepath.Rel(s.Options.DestinationPath, path)
-		if relPath == "." {
+		if srcPath == "." {
 			return nil // Skip root
 		}
+		return s.visitSource(src, srcPath, relPath, d, chain, sourceFiles)
+	})
+}
 
-		// If the file is not in the sourceFiles map, mark it for deletion
-		if _, exists := sourceFiles[relPath]; !exists {
-			logEvent := s.logger.Info().Str("action", "DELETE").Str("path", relPath)
+// Handles a single entry of the source found at srcPath in src, queueing it to be copied
+// unless it is filtered out. Returns filepath.SkipDir for directories not to descend into.
+func (s *Syncer) visitSource(src Backend, srcPath, relPath string, d os.DirEntry, chain []string, sourceFiles pathIndex) error {
+	localSource, isLocal := src.(*localBackend)
 
-			if !s.Options.DryRun {
-				if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) {
-					s.logger.Error().Err(rmErr).Str("path", path).Msg("Error deleting file")
-				} else if rmErr == nil {
-					logEvent.Msg("Successfully deleted file")
-				}
-			} else {
-				logEvent.Msg("DRY_RUN: Would delete file")
-			}
+	// Check against ignore patterns
+	if s.matcher.Matches(relPath) {
+		s.logger.Debug().Str("action", "IGNORE").Str("path", relPath).Msg("Path matched .gosyncignore rule, skipping")
+
+		// Skip directory traversing if directory is ignored
+		if d.IsDir() {
+			return filepath.SkipDir
 		}
 
 		return nil
-	})
+	}
+
+	// Then against --include and --exclude rules
+	if s.pathRules.Excludes(relPath, d.IsDir()) {
+		s.logger.Debug().Str("action", "EXCLUDE").Str("path", relPath).Msg("Path matched --exclude rule, skipping")
+		return skipEntry(d)
+	}
+
+	if d.IsDir() {
+		if marker, ok := s.excludingMarker(src, srcPath); ok {
+			s.logger.Debug().Str("action", "SKIP_MARKER").Str("path", relPath).Str("marker", marker).Msg("Directory holds an exclusion marker, skipping")
+			s.markedDirectories[relPath] = struct{}{}
+			return filepath.SkipDir
+		}
+	}
+
+	// A case-insensitive destination can only hold one of the paths differing in case
+	if s.caseFold != nil {
+		var ok bool
+		if relPath, ok = s.checkCaseCollision(relPath, d); !ok {
+			return skipEntry(d)
+		}
+	}
+
+	if isLocal && junction.Is(localSource.path(srcPath), d) {
+		if err := s.handleJunction(localSource.path(srcPath), relPath, chain, sourceFiles); err != nil {
+			return err
+		}
+		return skipEntry(d)
+	}
+
+	if d.Type()&os.ModeSymlink != 0 && (isLocal || s.Options.Symlinks == SymlinkSkip) {
+		path := ""
+		if isLocal {
+			path = localSource.path(srcPath)
+		}
+		if handled, err := s.handleSymlink(path, relPath, chain, sourceFiles); handled || err != nil {
+			return err
+		}
+	}
+
+	if err := sourceFiles.add(relPath); err != nil {
+		return err // Without a complete index deletions can't be propagated safely
+	}
+	s.stats.recordScanned(relPath, d)
+
+	if d.IsDir() {
+		s.logger.Debug().Str("action", "CHECK_DIR").Str("path", relPath).Msg("Directory check started")
+		if s.Options.DirsOnly {
+			s.syncDirectory(src, srcPath, relPath)
+		} else if s.Options.Owner || s.Options.Group {
+			s.rememberDirectoryOwner(relPath, d)
+		}
+		return nil
+	}
+
+	// Files stay in the index so --delete keeps them, they just aren't copied
+	if s.Options.DirsOnly {
+		return nil
+	}
+
+	if s.filteredByOwner(relPath, d) {
+		return nil
+	}
+
+	job := fileJob{src: src, srcPath: srcPath, relPath: relPath}
+	if s.Options.Totals {
+		if info, err := d.Info(); err == nil {
+			job.size = info.Size()
+		}
+	}
+	s.enqueue(job)
+	return nil
+}
+
+// Hands job to the workers, waiting for them while the queue is full.
+func (s *Syncer) enqueue(job fileJob) {
+	s.stats.recordQueued(job.relPath, job.size)
+	var wait time.Duration
+	select {
+	case s.fileOps <- job:
+	default:
+		started := time.Now()
+		select {
+		case s.fileOps <- job:
+		case <-s.ctx.Done():
+			s.stats.recordTaken() // Never queued
+		}
+		wait = time.Since(started)
+	}
+	s.stats.recordEnqueued(len(s.fileOps), wait)
+}
 
-	return err
+// Handles a junction found in the source according to the configured JunctionMode.
+// The junction itself is never descended into by the caller.
+func (s *Syncer) handleJunction(path, relPath string, chain []string, sourceFiles pathIndex) error {
+	target, err := junction.Read(path)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not read junction target, skipping")
+		return nil
+	}
+
+	switch s.Options.Junctions {
+	case JunctionFollow:
+		// Refuse to follow a junction that points back at a directory we are already inside of
+		realTarget, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Str("target", target).Msg("Could not resolve junction target, skipping")
+			return nil
+		}
+		if leadsToAncestor(path, realTarget, chain) {
+			s.logger.Warn().Str("action", "JUNCTION_LOOP").Str("path", relPath).Str("target", target).Msg("Junction points to an ancestor directory, skipping")
+			return nil
+		}
+
+		s.logger.Debug().Str("action", "FOLLOW_JUNCTION").Str("path", relPath).Str("target", target).Msg("Following junction")
+		if err := sourceFiles.add(relPath); err != nil {
+			return err
+		}
+		if err := s.walkSource(&localBackend{root: realTarget}, relPath, chain, sourceFiles); err != nil {
+			s.logger.Error().Err(err).Str("path", relPath).Msg("Error walking junction target")
+			return err
+		}
+
+	case JunctionRecreate:
+		if err := sourceFiles.add(relPath); err != nil {
+			return err
+		}
+		s.recreateJunction(relPath, target)
+
+	default:
+		s.logger.Debug().Str("action", "SKIP_JUNCTION").Str("path", relPath).Str("target", target).Msg("Path is a junction, skipping")
+	}
+
+	return nil
 }
 
-func (s *Syncer) Start() error {
+// Creates a junction at the destination pointing to the same target as the source junction.
+func (s *Syncer) recreateJunction(relPath, target string) {
+	destinationPath := filepath.Join(s.Options.DestinationPath, relPath)
+	logEvent := s.logger.Info().Str("action", "JUNCTION").Str("path", relPath).Str("target", target)
+
+	if err := s.checkContained(relPath, false); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Refusing to write outside of destination")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	// Nothing to do if an identical junction already exists
+	if existing, err := junction.Read(destinationPath); err == nil && existing == target {
+		s.logger.Debug().Str("action", "SKIP_JUNCTION").Str("path", relPath).Msg("Junction is up-to-date, skipping")
+		return
+	}
+
+	if s.Options.DryRun {
+		s.recordPlanned("JUNCTION", relPath, target)
+		s.logPlanned(relPath, logEvent, "DRY_RUN: Would create junction")
+		return
+	}
+
+	// Replace whatever is in the way, as long as it is a file or an empty directory
+	if err := os.Remove(destinationPath); err != nil && !os.IsNotExist(err) {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Could not remove existing destination entry")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Failed to create directories")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	if err := junction.Create(destinationPath, target); err != nil {
+		s.logger.Error().Err(err).Str("path", destinationPath).Msg("Error creating junction")
+		s.stats.recordError(relPath, err)
+		return
+	}
+
+	logEvent.Msg("Junction created successfully")
+}
+
+// Rejects options that rely on features only local destinations have.
+func (s *Syncer) checkRemoteOptions() error {
+	switch {
+	case s.Options.StoreChecksums:
+		return fmt.Errorf("storing checksums needs extended attributes, which remote destinations don't support.")
+	case s.Options.Xattrs:
+		return fmt.Errorf("extended attributes can only be preserved on local destinations.")
+	case s.Options.ADS:
+		return fmt.Errorf("alternate data streams can only be preserved on local destinations.")
+	case s.Options.PartialDir != "":
+		return fmt.Errorf("partial directories can only be used with local destinations.")
+	case s.Options.PreserveSELinux || s.Options.PreserveCapabilities:
+		return fmt.Errorf("security attributes can only be preserved on local destinations.")
+	case s.Options.Junctions == JunctionRecreate:
+		return fmt.Errorf("junctions can only be recreated on local destinations.")
+	case s.Options.HardLinks:
+		return fmt.Errorf("hard links can only be recreated on local destinations.")
+	case s.Options.Symlinks == SymlinkRecreate:
+		return fmt.Errorf("symlinks can only be recreated on local destinations.")
+	case s.Options.Owner || s.Options.Group:
+		return fmt.Errorf("ownership can only be preserved on local destinations.")
+	case s.Options.Devices:
+		return fmt.Errorf("device files can only be recreated on local destinations.")
+	case len(s.Options.LinkDest) > 0:
+		return fmt.Errorf("files can only be hard linked to earlier snapshots on local destinations.")
+	case s.Options.DirsOnly:
+		if _, ok := s.dest.(*s3Backend); ok {
+			return fmt.Errorf("object stores have no directories to recreate.")
+		}
+	}
+	return nil
+}
+
+// Rejects options that need a local source, and reads the ignore file of a remote one.
+func (s *Syncer) loadRemoteSource() error {
+	switch {
+	case s.Options.PreserveSELinux || s.Options.PreserveCapabilities:
+		return fmt.Errorf("security attributes can only be preserved from local sources.")
+	case s.Options.Xattrs:
+		return fmt.Errorf("extended attributes can only be preserved from local sources.")
+	case s.Options.ADS:
+		return fmt.Errorf("alternate data streams can only be preserved from local sources.")
+	case len(s.Options.IncludeOwners) > 0 || len(s.Options.ExcludeOwners) > 0:
+		return fmt.Errorf("owner filters need a local source.")
+	case s.Options.HardLinks:
+		return fmt.Errorf("hard links can only be detected in local sources.")
+	case s.Options.Symlinks == SymlinkRecreate:
+		return fmt.Errorf("symlinks can only be recreated from local sources.")
+	case s.Options.Owner || s.Options.Group:
+		return fmt.Errorf("ownership can only be preserved from local sources.")
+	case s.watchCtx != nil:
+		return fmt.Errorf("only local sources can be watched for changes.")
+	}
+
+	file, err := s.src.Open(filter.IgnoreFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read %s: %w", filter.IgnoreFile, err)
+	}
+	defer file.Close()
+
+	if s.matcher, err = filter.ParseIgnore(file); err != nil {
+		return fmt.Errorf("could not read %s: %w", filter.IgnoreFile, err)
+	}
+	return nil
+}
+
+// Returns what a WalkDir callback should return to not descend into d. SkipDir on
+// anything but a directory would skip the rest of the parent directory instead.
+func skipEntry(d os.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// Reports whether path is dir itself or located somewhere below it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// Start syncs the source to the destination, see StartContext.
+func (s *Syncer) Start() (Result, error) {
+	return s.StartContext(context.Background())
+}
+
+// StartContext syncs the source to the destination until done or ctx is done. Once it
+// is, no more files are looked at, copies in progress are abandoned, leaving the
+// destination files as they were, and nothing is deleted. The error of ctx is returned.
+//
+// The Result tells what was done, also when the sync failed midway. When the sync went
+// through but paths failed on the way, the error is a *FilesFailedError.
+func (s *Syncer) StartContext(ctx context.Context) (Result, error) {
+	startTime := time.Now()
+	err := s.run(ctx)
+	if s.Options.UnreadableList != "" {
+		if listErr := s.writeUnreadableList(); listErr != nil {
+			s.logger.Error().Err(listErr).Str("path", s.Options.UnreadableList).Msg("Could not write unreadable list")
+		}
+	}
+	s.stats.closeEvents()
+	result := s.stats.result(time.Since(startTime))
+	if err == nil && len(result.Errors) > 0 {
+		err = &FilesFailedError{Errors: result.Errors}
+	}
+	return result, err
+}
+
+// Opens the tree at location to be read from, giving back the files earlier syncs stored
+// encrypted, compressed or deduplicated as they were. Without the marker of an encrypted
+// destination it is only decrypted when decrypt is set. what names it in errors.
+func (s *Syncer) openReadable(location, what string, decrypt bool) (Backend, error) {
+	backend, err := OpenBackend(location)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyCompression(backend); err != nil {
+		backend.Close()
+		return nil, err
+	}
+	readable, err := s.decrypting(backend, what, decrypt)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	if holdsStoredFiles(readable) {
+		readable = &storedBackend{Backend: readable}
+	}
+	if holdsDedupFiles(readable) {
+		readable = &dedupBackend{Backend: readable}
+	}
+	return readable, nil
+}
+
+// Runs the sync for StartContext.
+func (s *Syncer) run(ctx context.Context) error {
+	s.ctx = ctx
+
 	// Check paths
 	if s.Options.SourcePath == s.Options.DestinationPath {
 		return fmt.Errorf("source and destination paths cannot be the same.")
 	}
+	if err := s.checkRemoveSourceOptions(); err != nil {
+		return err
+	}
+	// Before a fan-out, so all destinations are synced from the same snapshot
+	if s.Options.VSS && s.shadowSource == "" {
+		release, err := s.snapshotSource()
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	if len(s.Options.ExtraDestinations) > 0 && s.listing == nil {
+		return s.syncFanOut(ctx)
+	}
 
-	// Start worker pool
-	for i := 0; i < s.Options.Workers; i++ {
-		s.wg.Add(1)
-		go s.worker()
+	var err error
+	if s.pathRules, err = filter.CompilePathRules(s.Options.PathRules); err != nil {
+		return err
+	}
+	if s.protectPaths, err = compileProtectPatterns(s.Options.ProtectPatterns); err != nil {
+		return err
+	}
+	if s.transforms, err = compileTransformRules(s.Options.Transforms); err != nil {
+		return err
 	}
 
-	// Start file discovery and send jobs
-	sourceFiles := make(map[string]bool)
-	err := filepath.WalkDir(s.Options.SourcePath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			s.logger.Error().Err(err).Str("path", path).Msg("Error walking source directory")
-			return nil
+	// Resolve owner filters up front so unknown users fail the run instead of every file
+	if s.includeOwners, err = filter.ParseOwnerRules(s.Options.IncludeOwners); err != nil {
+		return err
+	}
+	if s.excludeOwners, err = filter.ParseOwnerRules(s.Options.ExcludeOwners); err != nil {
+		return err
+	}
+
+	source := s.Options.SourcePath
+	if s.shadowSource != "" {
+		source = s.shadowSource
+	}
+	if s.src, err = s.openReadable(source, "the source", s.Options.Decrypt); err != nil {
+		return err
+	}
+	defer s.src.Close()
+
+	if local, ok := s.src.(*localBackend); ok {
+		s.localSource = local
+		if err := s.resolveSourceRoot(); err != nil {
+			return err
 		}
+	} else if err := s.loadRemoteSource(); err != nil {
+		return err
+	}
 
-		relPath, _ := filepath.Rel(s.Options.SourcePath, path)
-		if relPath == "." {
-			return nil // Skip root
+	if s.dest, err = OpenBackend(s.Options.DestinationPath); err != nil {
+		return err
+	}
+	defer s.dest.Close()
+	if err := s.cacheListing(); err != nil {
+		return err
+	}
+	defer s.saveListingCache()
+	if err := s.applyCompression(s.dest); err != nil {
+		return err
+	}
+	if s.Options.Encrypt {
+		if err := s.encryptDestination(); err != nil {
+			return err
+		}
+	}
+	if s.Options.StoreCompressed {
+		if err := s.storeCompressed(); err != nil {
+			return err
+		}
+	}
+	if s.Options.Dedup {
+		if err := s.storeDeduplicated(); err != nil {
+			return err
 		}
+	}
 
-		// Check against ignore patterns
-		if s.matcher != nil && s.matcher.MatchesPath(relPath) {
-			s.logger.Debug().Str("action", "IGNORE").Str("path", relPath).Msg("Path matched .gosyncignore rule, skipping")
+	if local, ok := s.dest.(*localBackend); ok {
+		s.local = local
+		local.partialDir = s.Options.PartialDir
+	}
+	s.applyArchive()
+	s.prepareCaseCollisions()
+	if s.local == nil {
+		if err := s.checkRemoteOptions(); err != nil {
+			return err
+		}
+	}
+	s.prepareStreams()
 
-			// Skip directory traversing if directory is ignored
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
+	if err := s.prepareBackups(time.Now()); err != nil {
+		return err
+	}
+	if err := s.prepareLinkDests(); err != nil {
+		return err
+	}
 
-			return nil
+	s.loadManifest()
+	if s.Options.TwoWay {
+		if err := s.syncTwoWay(); err != nil {
+			return err
 		}
+		return s.writeManifest()
+	}
+	if err := s.loadStateIndex(); err != nil {
+		return err
+	}
 
-		sourceFiles[relPath] = true
+	if s.Options.HardLinks {
+		s.hardLinks = &linkGroups{groups: make(map[inode]*linkGroup)}
+	}
 
-		if d.IsDir() {
-			s.logger.Debug().Str("action", "CHECK_DIR").Str("path", relPath).Msg("Directory check started")
-			return nil
+	// What the source no longer has can only be known with the destination listed up front
+	if s.Options.DetectRenames {
+		if !s.Options.Delete {
+			return fmt.Errorf("detecting renames needs deletions enabled, moved files are taken from where they would be deleted.")
 		}
+		if _, ok := s.dest.(renamer); !ok {
+			return fmt.Errorf("the destination can't move files, renames can't be detected.")
+		}
+		if err := s.findRenameCandidates(); err != nil {
+			return err
+		}
+	}
 
-		s.fileOps <- path // Send full path to worker
-		return nil
-	})
+	s.startWorkers()
+
+	// Keep the source index on disk when memory is capped
+	var sourceFiles pathIndex = memoryIndex{}
+	if s.Options.MaxMemory > 0 {
+		index, err := newDiskIndex()
+		if err != nil {
+			return fmt.Errorf("could not create source index: %w", err)
+		}
+		sourceFiles = index
+	}
+	defer sourceFiles.close()
+
+	// Start file discovery and send jobs
+	s.stats.setPhase("copying")
+	err = s.walkSource(s.src, "", nil, sourceFiles)
+	s.stats.setWalked(time.Now())
+	s.applyDirectoryTimes()
 
 	// Close channel and wait for workers to finish
 	close(s.fileOps)
 	s.wg.Wait()
+	if err == nil {
+		err = ctx.Err() // Files may have been left out after the walk
+	}
+	s.applyDirectoryOwners()
+	if s.local != nil {
+		s.local.removePartialDirs()
+	}
+	if indexErr := s.saveStateIndex(); indexErr != nil {
+		s.logger.Warn().Err(indexErr).Msg("Could not save the state index, the next run checks every file")
+	}
+
+	// Handle deletion propagaton (if enabled), but never from an incomplete source index
+	if s.Options.Delete && err == nil {
+		err = s.propagateDeletions(sourceFiles)
+	}
+	if err == nil {
+		s.pruneChunks()
+		err = s.writeManifest()
+	}
+
+	s.flushPlan()
 
-	// Handle deletion propagaton (if enabled)
-	if s.Options.Delete {
-		return s.propagateDeletions(sourceFiles)
+	// Watching goes on from the state the full sync left
+	if s.watchCtx != nil && err == nil {
+		err = s.watchSource(s.watchCtx)
 	}
+	s.stats.setPhase("done")
 
 	return err // Return error from WalkDir if any
 }
@@ -0,0 +1,116 @@
+package syncer
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+func TestPathRuleOrder(t *testing.T) {
+	src := t.TempDir()
+	files := []string{
+		"keep.txt",
+		"notes.log",
+		"logs/app.log",
+		"logs/important.log",
+		"build/out.bin",
+		"build/keep/readme",
+		"docs/a.md",
+		"docs/b.txt",
+	}
+	for _, file := range files {
+		path := filepath.Join(src, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exclude := func(pattern string) filter.PathRule { return filter.PathRule{Pattern: pattern} }
+	include := func(pattern string) filter.PathRule { return filter.PathRule{Pattern: pattern, Include: true} }
+
+	tests := []struct {
+		name  string
+		rules []filter.PathRule
+		want  []string
+	}{
+		{"no rules", nil, files},
+		{
+			"exclude by name at any depth",
+			[]filter.PathRule{exclude("*.log")},
+			[]string{"keep.txt", "build/out.bin", "build/keep/readme", "docs/a.md", "docs/b.txt"},
+		},
+		{
+			"include before exclude wins",
+			[]filter.PathRule{include("important.log"), exclude("*.log")},
+			[]string{"keep.txt", "logs/important.log", "build/out.bin", "build/keep/readme", "docs/a.md", "docs/b.txt"},
+		},
+		{
+			"exclude before include wins",
+			[]filter.PathRule{exclude("*.log"), include("important.log")},
+			[]string{"keep.txt", "build/out.bin", "build/keep/readme", "docs/a.md", "docs/b.txt"},
+		},
+		{
+			"excluded directories aren't descended into",
+			[]filter.PathRule{include("build/keep/**"), exclude("build/")},
+			[]string{"keep.txt", "notes.log", "logs/app.log", "logs/important.log", "docs/a.md", "docs/b.txt"},
+		},
+		{
+			"including directories lets their contents be included",
+			[]filter.PathRule{include("docs/"), include("*.md"), exclude("*")},
+			[]string{"docs/a.md"},
+		},
+		{
+			"anchored patterns match from the root only",
+			[]filter.PathRule{exclude("/keep*"), exclude("/logs/app.log")},
+			[]string{"notes.log", "logs/important.log", "build/out.bin", "build/keep/readme", "docs/a.md", "docs/b.txt"},
+		},
+		{
+			"regexes see directories with a trailing slash",
+			[]filter.PathRule{{Pattern: `\.md$`, Include: true, Regex: true}, {Pattern: `^docs/`, Regex: true}},
+			[]string{"keep.txt", "notes.log", "logs/app.log", "logs/important.log", "build/out.bin", "build/keep/readme"},
+		},
+		{
+			"regexes and globs in one order",
+			[]filter.PathRule{{Pattern: `/important\.`, Include: true, Regex: true}, exclude("*.log"), {Pattern: `^build/.*\.bin$`, Regex: true}},
+			[]string{"keep.txt", "logs/important.log", "build/keep/readme", "docs/a.md", "docs/b.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			s := NewSyncer(src, dest, WithOptions(&SyncOptions{PathRules: tt.rules, Workers: 2}))
+			if _, err := s.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			err := filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() {
+					relPath, _ := filepath.Rel(dest, path)
+					got = append(got, filepath.ToSlash(relPath))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("synced %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package syncer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bipinmdr07/gosync/pkg/filter"
+)
+
+// Transformer rewrites the contents of files on their way to the destination, like
+// compressing them or normalizing their line endings.
+type Transformer interface {
+	// Wrap returns a writer passing what is written to it on to w, transformed. Its Close
+	// writes out what it held back, without closing w.
+	Wrap(w io.Writer) io.WriteCloser
+}
+
+// TransformerFactory makes a Transformer from the argument given after its name, like the
+// expression of redact:EXPR, empty when there is none.
+type TransformerFactory func(arg string) (Transformer, error)
+
+// TransformRule transforms the files matching Pattern, an --exclude style glob, with the
+// Transformer, or else the one named by Transform, like gzip or redact:EXPR.
+//
+// Transformed files differ from their source in size and contents, they are only copied
+// again when the source was modified after the destination. They can't be updated in
+// place, by deltas or in two-way syncs, and aren't verified or checksummed.
+type TransformRule struct {
+	Pattern     string
+	Transform   string
+	Transformer Transformer `json:"-"`
+}
+
+var (
+	transformersMu sync.RWMutex
+	transformers   = map[string]TransformerFactory{
+		"gzip":   newGzipTransformer,
+		"lf":     func(string) (Transformer, error) { return lineEndings{crlf: false}, nil },
+		"crlf":   func(string) (Transformer, error) { return lineEndings{crlf: true}, nil },
+		"redact": newRedactTransformer,
+	}
+)
+
+// RegisterTransformer makes name usable in TransformRules, so other packages can add
+// transformations. It is meant to be called from init functions, and panics if name is
+// already registered.
+func RegisterTransformer(name string, factory TransformerFactory) {
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+
+	if _, ok := transformers[name]; ok {
+		panic("syncer: transformer registered twice for name " + name)
+	}
+	transformers[name] = factory
+}
+
+// Transformers returns the names of the registered transformers, sorted.
+func Transformers() []string {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+
+	names := make([]string, 0, len(transformers))
+	for name := range transformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTransformer makes the transformer spec names, as name or name:arg.
+func NewTransformer(spec string) (Transformer, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	transformersMu.RLock()
+	factory, ok := transformers[name]
+	transformersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transformer %q, expected one of %s.", name, strings.Join(Transformers(), ", "))
+	}
+	return factory(arg)
+}
+
+// A TransformRule ready to be matched.
+type transformRule struct {
+	match       *filter.PathRules // Reports matching paths as excluded
+	transformer Transformer
+}
+
+// Compiles the TransformRules of the options.
+func compileTransformRules(rules []TransformRule) ([]transformRule, error) {
+	compiled := make([]transformRule, 0, len(rules))
+	for _, rule := range rules {
+		transformer := rule.Transformer
+		if transformer == nil {
+			var err error
+			if transformer, err = NewTransformer(rule.Transform); err != nil {
+				return nil, err
+			}
+		}
+		match, err := filter.CompilePathRules([]filter.PathRule{{Pattern: rule.Pattern}})
+		if err != nil {
+			return nil, fmt.Errorf("%v.", err)
+		}
+		compiled = append(compiled, transformRule{match: match, transformer: transformer})
+	}
+	return compiled, nil
+}
+
+// Returns the transformer of the first rule matching relPath, nil when none does.
+func (s *Syncer) transformerFor(relPath string) Transformer {
+	for _, rule := range s.transforms {
+		if rule.match.Excludes(relPath, false) {
+			return rule.transformer
+		}
+	}
+	return nil
+}
+
+// Copies src through transformer to dst, returning the bytes read from src.
+func (s *Syncer) copyTransformed(dst io.Writer, src io.Reader, transformer Transformer) (int64, error) {
+	w := transformer.Wrap(dst)
+	n, err := s.copy(w, src)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// Compresses files with gzip, at the level given as its argument.
+type gzipTransformer struct {
+	level int
+}
+
+func newGzipTransformer(arg string) (Transformer, error) {
+	if arg == "" {
+		return gzipTransformer{level: gzip.DefaultCompression}, nil
+	}
+	level, err := strconv.Atoi(arg)
+	if err != nil || level < gzip.NoCompression || level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid gzip level %q, expected 0 to 9.", arg)
+	}
+	return gzipTransformer{level: level}, nil
+}
+
+func (t gzipTransformer) Wrap(w io.Writer) io.WriteCloser {
+	gz, _ := gzip.NewWriterLevel(w, t.level) // The level is checked already
+	return gz
+}
+
+// Turns line endings into \r\n, or into \n without crlf.
+type lineEndings struct {
+	crlf bool
+}
+
+func (t lineEndings) Wrap(w io.Writer) io.WriteCloser {
+	return &lineEndingWriter{w: w, crlf: t.crlf}
+}
+
+type lineEndingWriter struct {
+	w    io.Writer
+	crlf bool
+	cr   bool // The last byte written was \r, held back without crlf
+	out  []byte
+}
+
+func (l *lineEndingWriter) Write(p []byte) (int, error) {
+	l.out = l.out[:0]
+	for _, b := range p {
+		if l.crlf {
+			if b == '\n' && !l.cr {
+				l.out = append(l.out, '\r')
+			}
+			l.out = append(l.out, b)
+			l.cr = b == '\r'
+			continue
+		}
+
+		// A \r only goes when the \n it is waiting for follows
+		if l.cr && b != '\n' {
+			l.out = append(l.out, '\r')
+		}
+		l.cr = b == '\r'
+		if !l.cr {
+			l.out = append(l.out, b)
+		}
+	}
+	if _, err := l.w.Write(l.out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *lineEndingWriter) Close() error {
+	if l.cr && !l.crlf {
+		_, err := l.w.Write([]byte{'\r'})
+		return err
+	}
+	return nil
+}
+
+// Replaces what a regular expression matches, line by line, with [REDACTED].
+type redactTransformer struct {
+	expr *regexp.Regexp
+}
+
+var redacted = []byte("[REDACTED]")
+
+func newRedactTransformer(arg string) (Transformer, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("redact needs an expression, like redact:password=\\S+.")
+	}
+	expr, err := regexp.Compile(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact expression %q: %v.", arg, err)
+	}
+	return redactTransformer{expr: expr}, nil
+}
+
+func (t redactTransformer) Wrap(w io.Writer) io.WriteCloser {
+	return &redactWriter{w: w, expr: t.expr}
+}
+
+type redactWriter struct {
+	w    io.Writer
+	expr *regexp.Regexp
+	line []byte // Held back until its end is written
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.line = append(r.line, p...)
+	end := bytes.LastIndexByte(r.line, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	var out []byte
+	for _, line := range bytes.Split(r.line[:end], []byte{'\n'}) {
+		out = append(out, r.expr.ReplaceAllLiteral(line, redacted)...)
+		out = append(out, '\n')
+	}
+	r.line = append(r.line[:0], r.line[end+1:]...)
+	if _, err := r.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *redactWriter) Close() error {
+	if len(r.line) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(r.expr.ReplaceAllLiteral(r.line, redacted))
+	r.line = nil
+	return err
+}
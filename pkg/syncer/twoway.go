@@ -0,0 +1,488 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Two-way sync. Both trees are listed and compared with the state the previous run left
+// them in, which tells a file new on one side from one deleted on the other. Whatever
+// changed on one side only is carried over to the other, deletions included. Files
+// changed on both sides are conflicts, resolved by their ConflictPolicy.
+
+// A path both trees held after the last two-way sync, as each side had it.
+type twoWayEntry struct {
+	Dir         bool
+	Size        int64
+	SrcModTime  time.Time
+	DestModTime time.Time
+}
+
+// The entries of the last run, by relative path.
+type twoWayState map[string]twoWayEntry
+
+// What a two-way sync does with a path.
+type twoWayAction int
+
+const (
+	twoWayNone     twoWayAction = iota // In sync, or only in the state
+	twoWayToDest                       // Copied or created from the source at the destination
+	twoWayToSrc                        // Copied or created from the destination at the source
+	twoWayDelDest                      // Deleted at the destination, it is gone from the source
+	twoWayDelSrc                       // Deleted at the source, it is gone from the destination
+	twoWayConflict                     // Changed on both sides
+)
+
+// Errors recorded for conflicts, so the run fails until they are resolved.
+var errTwoWayConflict = errors.New("changed on both sides since the last sync")
+
+// Rejects options a two-way sync can't honour.
+func (s *Syncer) checkTwoWayOptions() error {
+	switch {
+	case s.Options.DetectRenames:
+		return fmt.Errorf("renames can't be detected in a two-way sync.")
+	case s.Options.HardLinks:
+		return fmt.Errorf("hard links can't be recreated in a two-way sync.")
+	case len(s.Options.LinkDest) > 0:
+		return fmt.Errorf("a two-way sync can't link to earlier snapshots.")
+	case s.Options.DirsOnly:
+		return fmt.Errorf("a two-way sync can't be limited to directories.")
+	case s.Options.Symlinks == SymlinkRecreate:
+		return fmt.Errorf("symlinks can't be recreated in a two-way sync.")
+	case s.Options.RecordPlan:
+		return fmt.Errorf("a two-way sync can't be planned ahead.")
+	case len(s.Options.Transforms) > 0:
+		return fmt.Errorf("a two-way sync can't transform files, they would come back transformed.")
+	case s.Options.StoreCompressed:
+		return fmt.Errorf("a two-way sync can't store files compressed, they would come back compressed.")
+	case s.Options.Dedup:
+		return fmt.Errorf("a two-way sync can't store files deduplicated, they would come back as manifests.")
+	case s.Options.Encrypt:
+		return fmt.Errorf("a two-way sync can't encrypt files, they would come back encrypted.")
+	}
+	return nil
+}
+
+// Lists the files and directories of tree that take part in the sync, leaving out
+// ignored and excluded paths, backups, the manifest and anything neither a file nor a
+// directory.
+func (s *Syncer) listTree(tree Backend) (map[string]fs.FileInfo, error) {
+	entries := make(map[string]fs.FileInfo)
+	err := tree.Walk(func(relPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if relPath == "." && errors.Is(err, fs.ErrNotExist) {
+				return nil // Nothing was synced yet
+			}
+			return err // Missing entries would be taken for deletions
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		if s.matcher.Matches(relPath) || s.pathRules.Excludes(relPath, d.IsDir()) {
+			return skipEntry(d)
+		}
+		if d.IsDir() && (s.isBackupDir(relPath) || s.isPartialDir(relPath)) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && (!d.Type().IsRegular() || s.isBackupFile(relPath)) {
+			s.logger.Debug().Str("action", "SKIP").Str("path", relPath).Msg("Not a regular file, skipping")
+			return nil
+		}
+		if !d.IsDir() && tree == s.dest && s.isManifest(relPath) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries[relPath] = info
+		return nil
+	})
+	return entries, err
+}
+
+// Decides what to do with a path given how the source and destination have it now, nil
+// where missing, and how they had it after the last run.
+func (s *Syncer) twoWayAction(srcInfo, destInfo fs.FileInfo, previous twoWayEntry, synced bool) twoWayAction {
+	precision := max(s.src.ModTimePrecision(), s.dest.ModTimePrecision())
+	changed := func(info fs.FileInfo, modTime time.Time) bool {
+		if !synced || info.IsDir() != previous.Dir {
+			return true
+		}
+		return !info.IsDir() && (info.Size() != previous.Size || !info.ModTime().Truncate(precision).Equal(modTime.Truncate(precision)))
+	}
+
+	switch {
+	case srcInfo != nil && destInfo != nil:
+		if srcInfo.IsDir() && destInfo.IsDir() {
+			return twoWayNone
+		}
+		if !srcInfo.IsDir() && !destInfo.IsDir() && srcInfo.Size() == destInfo.Size() &&
+			srcInfo.ModTime().Truncate(precision).Equal(destInfo.ModTime().Truncate(precision)) {
+			return twoWayNone
+		}
+
+		srcChanged, destChanged := changed(srcInfo, previous.SrcModTime), changed(destInfo, previous.DestModTime)
+		switch {
+		case srcChanged && !destChanged:
+			return twoWayToDest
+		case destChanged && !srcChanged:
+			return twoWayToSrc
+		}
+		return twoWayConflict
+
+	// A change made on one side wins over a deletion on the other
+	case srcInfo != nil:
+		if synced && !changed(srcInfo, previous.SrcModTime) {
+			return twoWayDelSrc
+		}
+		return twoWayToDest
+	case destInfo != nil:
+		if synced && !changed(destInfo, previous.DestModTime) {
+			return twoWayDelDest
+		}
+		return twoWayToSrc
+	}
+	return twoWayNone
+}
+
+// Returns a Syncer copying from the destination to the source, sharing the logger,
+// statistics and filters of s.
+func (s *Syncer) reversed() *Syncer {
+	options := *s.Options
+	options.SourcePath, options.DestinationPath = s.Options.DestinationPath, s.Options.SourcePath
+
+	return &Syncer{
+		Options:      &options,
+		logger:       s.logger,
+		matcher:      s.matcher,
+		stats:        s.stats,
+		ctx:          s.ctx,
+		pathRules:    s.pathRules,
+		protectPaths: s.protectPaths,
+		src:          s.dest,
+		localSource:  s.local,
+		dest:         s.src,
+		local:        s.localSource,
+		backups:      s.backups,
+		backupRoot:   s.backupRoot,
+		backupSuffix: s.backupSuffix,
+	}
+}
+
+// Runs a two-way sync between the source and the destination.
+func (s *Syncer) syncTwoWay() error {
+	if err := s.checkTwoWayOptions(); err != nil {
+		return err
+	}
+	if s.localSource != nil {
+		s.localSource.partialDir = s.Options.PartialDir
+	}
+
+	statePath, err := s.statePath("twoway")
+	if err != nil {
+		return err
+	}
+	previous := twoWayState{}
+	if err := loadState(statePath, &previous); err != nil {
+		return err
+	}
+
+	s.stats.setPhase("listing")
+	srcEntries, err := s.listTree(s.src)
+	if err != nil {
+		return fmt.Errorf("could not list source: %w", err)
+	}
+	destEntries, err := s.listTree(s.dest)
+	if err != nil {
+		return fmt.Errorf("could not list destination: %w", err)
+	}
+
+	// Every path either side has now or had after the last run, parents before children
+	paths := make([]string, 0, len(srcEntries)+len(destEntries))
+	for relPath := range srcEntries {
+		paths = append(paths, relPath)
+	}
+	for relPath := range destEntries {
+		if _, ok := srcEntries[relPath]; !ok {
+			paths = append(paths, relPath)
+		}
+	}
+	for relPath := range previous {
+		_, inSrc := srcEntries[relPath]
+		_, inDest := destEntries[relPath]
+		if !inSrc && !inDest {
+			paths = append(paths, relPath)
+		}
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		switch {
+		case walkOrderLess(a, b):
+			return -1
+		case walkOrderLess(b, a):
+			return 1
+		}
+		return 0
+	})
+
+	if s.conflictRules, err = compileConflictRules(s.Options.ConflictRules); err != nil {
+		return err
+	}
+
+	reverse := s.reversed()
+	actions := make(map[string]twoWayAction, len(paths))
+	var deletions []string
+	s.stats.setPhase("copying")
+	jobs := make(chan func())
+	var wg sync.WaitGroup
+	for i := 0; i < s.Options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job()
+			}
+		}()
+	}
+
+	queueCopy := func(from *Syncer, srcPath, relPath string, fromInfo, toInfo fs.FileInfo) {
+		job := fileJob{src: from.src, srcPath: srcPath, relPath: relPath, size: fromInfo.Size()}
+		s.stats.recordQueued(relPath, job.size)
+		jobs <- func() {
+			if from.ctx.Err() != nil {
+				from.stats.recordProcessed(job.size)
+				return
+			}
+			if err := from.checkContained(relPath, true); err != nil {
+				from.logger.Error().Err(err).Str("path", relPath).Msg("Refusing to write outside of destination")
+				from.stats.recordError(relPath, err)
+			} else {
+				from.replaceFile(job, filepath.Join(from.Options.DestinationPath, relPath), fromInfo, toInfo)
+			}
+			from.stats.recordProcessed(job.size)
+		}
+	}
+
+	var keptPaths []string
+	for _, relPath := range paths {
+		if s.ctx.Err() != nil {
+			break
+		}
+		srcInfo, destInfo := srcEntries[relPath], destEntries[relPath]
+		entry, synced := previous[relPath]
+		action := s.twoWayAction(srcInfo, destInfo, entry, synced)
+		if action == twoWayConflict {
+			var keptPath string
+			if action, keptPath = s.resolveConflict(relPath, srcInfo, destInfo); keptPath != "" {
+				// The destination's version goes to the source under its new name
+				queueCopy(reverse, keptPath, keptPath, destInfo, nil)
+				keptPaths = append(keptPaths, keptPath)
+				destInfo = nil
+			}
+		}
+		actions[relPath] = action
+
+		switch action {
+		case twoWayDelSrc, twoWayDelDest:
+			deletions = append(deletions, relPath)
+		case twoWayToDest, twoWayToSrc:
+			from, fromInfo, toInfo := s, srcInfo, destInfo
+			if action == twoWayToSrc {
+				from, fromInfo, toInfo = reverse, destInfo, srcInfo
+			}
+
+			// Directories are created in order, parents before the files going into them
+			if fromInfo.IsDir() {
+				from.syncDirectory(from.src, relPath, relPath)
+				continue
+			}
+			if toInfo != nil && toInfo.IsDir() {
+				err := fmt.Errorf("a directory is in the way")
+				s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not replace directory with file")
+				s.stats.recordError(relPath, err)
+				continue
+			}
+			queueCopy(from, relPath, relPath, fromInfo, toInfo)
+		}
+	}
+	s.stats.setWalked(time.Now())
+	close(jobs)
+	wg.Wait()
+
+	// Stopped midway, the state stays as the last run left it. Files copied since are the
+	// same on both sides and found in sync next time, deletions are left to the next run.
+	if err := s.ctx.Err(); err != nil {
+		s.stats.setPhase("done")
+		return err
+	}
+
+	for _, keptPath := range keptPaths {
+		paths = append(paths, keptPath)
+		actions[keptPath] = twoWayToSrc
+	}
+
+	deleteErr := s.deleteTwoWay(reverse, deletions, actions, srcEntries, destEntries)
+
+	s.flushPlan()
+	reverse.flushPlan()
+	s.stats.setPhase("done")
+
+	// Deletions refused are left out of the state, so the next run tries them again
+	if s.Options.DryRun {
+		return deleteErr
+	}
+	if err := saveState(statePath, s.settleTwoWay(paths, actions, previous, srcEntries, destEntries)); err != nil {
+		return err
+	}
+	return deleteErr
+}
+
+// Deletes the paths gone from one side on the other, files first and then directories
+// children before parents. Like deletions of a one-way sync they are limited by MaxDelete
+// and MaxDeletePercent, counting the files of both sides, protected paths are kept and
+// ConfirmDeletions is asked about each side. Directories still holding something on that
+// side are kept.
+func (s *Syncer) deleteTwoWay(reverse *Syncer, deletions []string, actions map[string]twoWayAction, srcEntries, destEntries map[string]fs.FileInfo) error {
+	kept := make(map[twoWayAction]map[string]struct{})
+	for _, action := range []twoWayAction{twoWayDelSrc, twoWayDelDest} {
+		entries := srcEntries
+		if action == twoWayDelDest {
+			entries = destEntries
+		}
+		kept[action] = make(map[string]struct{})
+		for relPath := range entries {
+			if actions[relPath] == action {
+				continue
+			}
+			for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+				kept[action][dir] = struct{}{}
+			}
+		}
+	}
+	keepParents := func(action twoWayAction) func(string) {
+		return func(relPath string) {
+			for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+				kept[action][dir] = struct{}{}
+			}
+		}
+	}
+
+	var directories []string
+	files := make(map[twoWayAction][]string)
+	for _, relPath := range deletions {
+		action := actions[relPath]
+		info := destEntries[relPath]
+		if action == twoWayDelSrc {
+			info = srcEntries[relPath]
+		}
+
+		// Protected paths are kept with everything in them on either side
+		if s.protectPaths.Excludes(relPath, info.IsDir()) || s.protectedBelow(relPath) {
+			s.logger.Debug().Str("action", "KEEP_PROTECTED").Str("path", relPath).Msg("Path is protected from deletion, not deleting")
+			keepParents(action)(relPath)
+			continue
+		}
+
+		if !info.IsDir() {
+			files[action] = append(files[action], relPath)
+		} else {
+			directories = append(directories, relPath)
+		}
+	}
+
+	total := 0
+	for _, entries := range []map[string]fs.FileInfo{srcEntries, destEntries} {
+		for _, info := range entries {
+			if !info.IsDir() {
+				total++
+			}
+		}
+	}
+	if err := s.checkDeleteLimit(len(files[twoWayDelSrc])+len(files[twoWayDelDest]), total); err != nil {
+		return err
+	}
+
+	for _, action := range []twoWayAction{twoWayDelSrc, twoWayDelDest} {
+		from := s
+		if action == twoWayDelSrc {
+			from = reverse
+		}
+		pending := files[action]
+		if from.Options.ConfirmDeletions != nil && !from.Options.DryRun && len(pending) > 0 {
+			pending = from.confirmDeletions(pending, keepParents(action))
+		}
+		for _, relPath := range pending {
+			from.deletePath(relPath, false)
+		}
+	}
+
+	directories = slices.DeleteFunc(directories, func(relPath string) bool {
+		_, ok := kept[actions[relPath]][relPath]
+		return ok
+	})
+	for _, level := range byDepthDescending(directories) {
+		for _, relPath := range level {
+			if actions[relPath] == twoWayDelSrc {
+				reverse.deletePath(relPath, true)
+			} else {
+				s.deletePath(relPath, true)
+			}
+		}
+	}
+	return nil
+}
+
+// Reports whether relPath is inside of a protected directory.
+func (s *Syncer) protectedBelow(relPath string) bool {
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		if s.protectPaths.Excludes(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the state to remember for the next run: the paths both sides hold now. Paths
+// that were acted on are looked at again, so a failed copy or deletion keeps its previous
+// state and is tried again. Conflicts keep theirs too.
+func (s *Syncer) settleTwoWay(paths []string, actions map[string]twoWayAction, previous twoWayState, srcEntries, destEntries map[string]fs.FileInfo) twoWayState {
+	next := make(twoWayState, len(paths))
+	for _, relPath := range paths {
+		srcInfo, destInfo := srcEntries[relPath], destEntries[relPath]
+		switch actions[relPath] {
+		case twoWayConflict:
+			if entry, ok := previous[relPath]; ok {
+				next[relPath] = entry
+			}
+			continue
+		case twoWayNone:
+		default:
+			var srcErr, destErr error
+			srcInfo, srcErr = s.src.Stat(relPath)
+			destInfo, destErr = s.dest.Stat(relPath)
+			if os.IsNotExist(srcErr) && os.IsNotExist(destErr) {
+				continue
+			}
+			if srcErr != nil || destErr != nil || srcInfo.IsDir() != destInfo.IsDir() || !srcInfo.IsDir() && srcInfo.Size() != destInfo.Size() {
+				if entry, ok := previous[relPath]; ok {
+					next[relPath] = entry
+				}
+				continue
+			}
+		}
+
+		if srcInfo == nil || destInfo == nil {
+			continue
+		}
+		next[relPath] = twoWayEntry{Dir: srcInfo.IsDir(), Size: srcInfo.Size(), SrcModTime: srcInfo.ModTime(), DestModTime: destInfo.ModTime()}
+	}
+	return next
+}
@@ -0,0 +1,237 @@
+package syncer
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Runs a two-way sync between src and dest with options, keeping its state in stateDir.
+func syncTwoWay(t *testing.T, src, dest, stateDir string, options SyncOptions) error {
+	t.Helper()
+	options.TwoWay = true
+	options.StateDir = stateDir
+	_, err := NewSyncer(src, dest, WithOptions(&options)).Start()
+	return err
+}
+
+func TestTwoWayDeleteLimits(t *testing.T) {
+	zero := 0
+
+	tests := []struct {
+		name     string
+		options  SyncOptions
+		wantErr  bool
+		wantDest []string
+	}{
+		{"no limit", SyncOptions{}, false, []string{"keep"}},
+		{"0 deletes none", SyncOptions{MaxDelete: &zero}, true, []string{"d/f3", "f1", "f2", "keep"}},
+		{"forced", SyncOptions{MaxDelete: &zero, Force: true}, false, []string{"keep"}},
+		{"protected file", SyncOptions{ProtectPatterns: []string{"f1"}}, false, []string{"f1", "keep"}},
+		{"protected directory", SyncOptions{ProtectPatterns: []string{"d/"}}, false, []string{"d/f3", "keep"}},
+		{"0 deletes none, protected or not", SyncOptions{MaxDelete: &zero, ProtectPatterns: []string{"f1"}}, true, []string{"d/f3", "f1", "f2", "keep"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dest, state := t.TempDir(), t.TempDir(), t.TempDir()
+			writeTree(t, src, "f1", "f2", "d/f3", "keep")
+			if err := syncTwoWay(t, src, dest, state, SyncOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, file := range []string{"f1", "f2", "d/f3"} {
+				if err := os.Remove(filepath.Join(src, filepath.FromSlash(file))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := syncTwoWay(t, src, dest, state, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("second sync error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := treeFiles(t, dest); !slices.Equal(got, tt.wantDest) {
+				t.Errorf("destination holds %q, want %q", got, tt.wantDest)
+			}
+
+			// Nothing that was kept comes back to the source
+			if got := treeFiles(t, src); !slices.Equal(got, []string{"keep"}) {
+				t.Errorf("source holds %q, want [keep]", got)
+			}
+		})
+	}
+}
+
+// Returns the contents of the regular files below root by their slash separated paths.
+func treeContents(t *testing.T, root string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	for _, file := range treeFiles(t, root) {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[file] = string(data)
+	}
+	return contents
+}
+
+// Writes data to the file at the slash separated path below root, modified at modTime.
+func writeFileAt(t *testing.T, root, file, data string, modTime time.Time) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTwoWay(t *testing.T) {
+	earlier, later := time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)
+	remove := func(t *testing.T, root, file string) {
+		if err := os.Remove(filepath.Join(root, filepath.FromSlash(file))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Changed on both sides, the destination last
+	conflict := func(t *testing.T, src, dest string) {
+		writeFileAt(t, src, "a", "source", earlier)
+		writeFileAt(t, dest, "a", "dest", later)
+	}
+
+	// Both sides start out with a, b and d/c, each holding its own path. Changes to
+	// empty contents are deletions
+	synced := map[string]string{"a": "a", "b": "b", "d/c": "d/c"}
+	with := func(changes map[string]string) map[string]string {
+		want := maps.Clone(synced)
+		for file, data := range changes {
+			if data == "" {
+				delete(want, file)
+			} else {
+				want[file] = data
+			}
+		}
+		return want
+	}
+
+	tests := []struct {
+		name     string
+		options  SyncOptions
+		change   func(t *testing.T, src, dest string)
+		wantErr  bool
+		wantSrc  map[string]string
+		wantDest map[string]string
+	}{
+		{"nothing changed", SyncOptions{}, func(*testing.T, string, string) {}, false, synced, synced},
+		{
+			"new on both sides",
+			SyncOptions{},
+			func(t *testing.T, src, dest string) {
+				writeFileAt(t, src, "new/s", "s", earlier)
+				writeFileAt(t, dest, "d/new", "d", earlier)
+			},
+			false,
+			with(map[string]string{"new/s": "s", "d/new": "d"}),
+			with(map[string]string{"new/s": "s", "d/new": "d"}),
+		},
+		{
+			"changed at the source",
+			SyncOptions{},
+			func(t *testing.T, src, dest string) { writeFileAt(t, src, "a", "source", later) },
+			false,
+			with(map[string]string{"a": "source"}),
+			with(map[string]string{"a": "source"}),
+		},
+		{
+			"changed at the destination",
+			SyncOptions{},
+			func(t *testing.T, src, dest string) { writeFileAt(t, dest, "d/c", "dest", later) },
+			false,
+			with(map[string]string{"d/c": "dest"}),
+			with(map[string]string{"d/c": "dest"}),
+		},
+		{
+			"deleted on both sides",
+			SyncOptions{},
+			func(t *testing.T, src, dest string) {
+				remove(t, src, "a")
+				remove(t, dest, "d/c")
+			},
+			false,
+			with(map[string]string{"a": "", "d/c": ""}),
+			with(map[string]string{"a": "", "d/c": ""}),
+		},
+		{
+			"a change wins over a deletion",
+			SyncOptions{},
+			func(t *testing.T, src, dest string) {
+				remove(t, src, "a")
+				writeFileAt(t, dest, "a", "dest", later)
+			},
+			false,
+			with(map[string]string{"a": "dest"}),
+			with(map[string]string{"a": "dest"}),
+		},
+		{"conflicts fail by default", SyncOptions{}, conflict, true, with(map[string]string{"a": "source"}), with(map[string]string{"a": "dest"})},
+		{"conflict kept from the source", SyncOptions{Conflicts: ConflictSource}, conflict, false, with(map[string]string{"a": "source"}), with(map[string]string{"a": "source"})},
+		{"conflict kept from the destination", SyncOptions{Conflicts: ConflictDest}, conflict, false, with(map[string]string{"a": "dest"}), with(map[string]string{"a": "dest"})},
+		{"conflict kept from the newest", SyncOptions{Conflicts: ConflictNewest}, conflict, false, with(map[string]string{"a": "dest"}), with(map[string]string{"a": "dest"})},
+		{
+			"conflict kept from both",
+			SyncOptions{Conflicts: ConflictKeepBoth},
+			conflict,
+			false,
+			with(map[string]string{"a": "source", "a.conflict": "dest"}),
+			with(map[string]string{"a": "source", "a.conflict": "dest"}),
+		},
+		{
+			"conflict kept by the first matching rule",
+			SyncOptions{Conflicts: ConflictSource, ConflictRules: []ConflictRule{{Pattern: "b", Policy: ConflictFail}, {Pattern: "a", Policy: ConflictDest}}},
+			conflict,
+			false,
+			with(map[string]string{"a": "dest"}),
+			with(map[string]string{"a": "dest"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dest, state := t.TempDir(), t.TempDir(), t.TempDir()
+			writeTree(t, src, "a", "b", "d/c")
+			if err := syncTwoWay(t, src, dest, state, SyncOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.change(t, src, dest)
+			err := syncTwoWay(t, src, dest, state, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("second sync error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := treeContents(t, src); !maps.Equal(got, tt.wantSrc) {
+				t.Errorf("source holds %q, want %q", got, tt.wantSrc)
+			}
+			if got := treeContents(t, dest); !maps.Equal(got, tt.wantDest) {
+				t.Errorf("destination holds %q, want %q", got, tt.wantDest)
+			}
+
+			// Once settled, another run leaves both sides as they are
+			if tt.wantErr {
+				return
+			}
+			if err := syncTwoWay(t, src, dest, state, tt.options); err != nil {
+				t.Fatal(err)
+			}
+			if got := treeContents(t, src); !maps.Equal(got, tt.wantSrc) {
+				t.Errorf("after another run the source holds %q, want %q", got, tt.wantSrc)
+			}
+			if got := treeContents(t, dest); !maps.Equal(got, tt.wantDest) {
+				t.Errorf("after another run the destination holds %q, want %q", got, tt.wantDest)
+			}
+		})
+	}
+}
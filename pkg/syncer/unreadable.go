@@ -0,0 +1,47 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ErrUnreadable wraps the errors of source files that couldn't be opened for lack of
+// permission, or for a lock another program holds on them.
+var ErrUnreadable = errors.New("source file can't be read")
+
+// Reports whether err means a source entry can't be read for lack of permission, or for a
+// lock another program holds on it like Outlook does on its PST files.
+func unreadable(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || locked(err)
+}
+
+// Records a source entry that couldn't be read. With SkipUnreadable it is skipped with a
+// warning, otherwise it fails like any other. Either way it is counted and listed apart.
+func (s *Syncer) recordUnreadable(relPath string, err error) {
+	s.stats.recordUnreadable(relPath)
+	if s.Options.SkipUnreadable {
+		s.stats.recordSkipped(relPath, "SKIP_UNREADABLE")
+		s.logger.Warn().Err(err).Str("action", "SKIP_UNREADABLE").Str("path", relPath).Msg("Source can't be read, skipping")
+		return
+	}
+	s.logger.Error().Err(err).Str("action", "UNREADABLE").Str("path", relPath).Msg("Source can't be read")
+	s.stats.recordError(relPath, err)
+}
+
+// Writes the paths of the source entries that couldn't be read to UnreadableList, one
+// per line. The file is written after every run, empty when all could be read.
+func (s *Syncer) writeUnreadableList() error {
+	paths := s.stats.unreadablePaths()
+	var list strings.Builder
+	for _, relPath := range paths {
+		list.WriteString(relPath)
+		list.WriteByte('\n')
+	}
+	if err := os.WriteFile(s.Options.UnreadableList, []byte(list.String()), 0o644); err != nil {
+		return fmt.Errorf("could not write the list of unreadable files: %w.", err)
+	}
+	return nil
+}
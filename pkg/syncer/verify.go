@@ -0,0 +1,32 @@
+package syncer
+
+import (
+	"bytes"
+	"time"
+)
+
+// Number of times a file is copied before a destination that keeps reading back
+// differently is given up on.
+const verifyAttempts = 3
+
+// Reads the destination file at relPath back and reports whether it hashes to sum, the
+// hash of the source taken while copying. A file that doesn't is made to look older than
+// any source, so a later run copies it again even if this one gives up.
+func (s *Syncer) verifyCopy(relPath, destinationPath string, sum []byte) bool {
+	destSum, err := s.hashDestination(relPath)
+	if err == nil && bytes.Equal(destSum, sum) {
+		s.logger.Debug().Str("action", "VERIFY").Str("path", relPath).Msg("Copy verified")
+		return true
+	}
+
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Could not read back copied file")
+	} else {
+		s.logger.Warn().Str("action", "MISMATCH").Str("path", relPath).Msg("Copied file differs from source")
+	}
+
+	if err := s.dest.Chtimes(relPath, time.Unix(0, 0)); err != nil {
+		s.logger.Warn().Err(err).Str("path", destinationPath).Msg("Error resetting modification time")
+	}
+	return false
+}
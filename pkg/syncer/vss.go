@@ -0,0 +1,43 @@
+package syncer
+
+import (
+	"fmt"
+
+	"github.com/bipinmdr07/gosync/internal/vss"
+)
+
+// Snapshots the volume of the local source with VSS and has the source read from the
+// shadow copy, where files other programs hold locked can be read consistently. Returns
+// a function deleting the snapshot once the run is done.
+func (s *Syncer) snapshotSource() (func(), error) {
+	switch {
+	case isRemoteLocation(s.Options.SourcePath):
+		return nil, fmt.Errorf("only local sources can be read from a shadow copy.")
+	case s.Options.TwoWay:
+		return nil, fmt.Errorf("two-way syncs write to the source, which a shadow copy can't take.")
+	case s.Options.RemoveSourceFiles:
+		return nil, fmt.Errorf("source files can't be removed from a shadow copy.")
+	case s.watchCtx != nil:
+		return nil, fmt.Errorf("watched sources can't be read from a shadow copy.")
+	}
+
+	s.logger.Info().Str("action", "VSS").Str("path", s.Options.SourcePath).Msg("Creating shadow copy of the source volume")
+	snapshot, err := vss.Create(s.Options.SourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a shadow copy of the source: %w.", err)
+	}
+	release := func() {
+		if err := snapshot.Delete(); err != nil {
+			s.logger.Warn().Err(err).Str("action", "VSS").Str("id", snapshot.ID).Msg("Could not delete shadow copy")
+			return
+		}
+		s.logger.Debug().Str("action", "VSS").Str("id", snapshot.ID).Msg("Shadow copy deleted")
+	}
+
+	if s.shadowSource, err = snapshot.Path(s.Options.SourcePath); err != nil {
+		release()
+		return nil, fmt.Errorf("could not find the source in its shadow copy: %w.", err)
+	}
+	s.logger.Info().Str("action", "VSS").Str("id", snapshot.ID).Str("path", s.shadowSource).Msg("Reading the source from its shadow copy")
+	return release, nil
+}
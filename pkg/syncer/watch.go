@@ -0,0 +1,609 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bipinmdr07/gosync/internal/junction"
+	"github.com/bipinmdr07/gosync/internal/treewatch"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch syncs like Start, then keeps watching the source and syncs the paths created,
+// modified or deleted in it as they change, until ctx is done. Changes are collected
+// until none came for WatchDelay, so a file being written is copied once. Only local
+// sources can be watched. Stopping it with ctx during the first sync is no error.
+func (s *Syncer) Watch(ctx context.Context) error {
+	if s.Options.TwoWay {
+		return fmt.Errorf("two-way syncs can't be watched.")
+	}
+	s.watchCtx = ctx
+	s.deferred = &deferredFiles{paths: make(map[string]time.Time)}
+	defer s.stats.closeEvents()
+	if err := s.run(ctx); err != nil && !errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return nil
+}
+
+// Watches the source after the full sync, until ctx is done.
+func (s *Syncer) watchSource(ctx context.Context) error {
+	watcher, err := s.newSourceWatcher()
+	if err != nil {
+		return fmt.Errorf("could not watch source: %w", err)
+	}
+	defer func() { watcher.Close() }()
+
+	s.stats.setPhase("watching")
+	s.logger.Info().Str("action", "WATCH").Str("path", s.Options.SourcePath).Msg("Watching source for changes")
+
+	changed := make(map[string]struct{})
+	timer := time.NewTimer(s.Options.WatchDelay)
+	timer.Stop()
+
+	// Files deferred for MinAge are looked at again once old enough, changed or not
+	recheck := time.NewTimer(0)
+	recheck.Stop()
+	scheduleRecheck := func() {
+		if due, ok := s.deferred.next(); ok {
+			recheck.Reset(max(time.Until(due), s.Options.WatchDelay))
+		}
+	}
+	scheduleRecheck()
+
+	// Watchers miss changes, on network filesystems or when events overflow, so everything
+	// is compared again now and then
+	var reconcile <-chan time.Time
+	if s.Options.ReconcileInterval > 0 {
+		ticker := time.NewTicker(s.Options.ReconcileInterval)
+		defer ticker.Stop()
+		reconcile = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-reconcile:
+			s.logger.Info().Str("action", "RECONCILE").Msg("Comparing everything again to catch missed changes")
+			changed["."] = struct{}{}
+			timer.Reset(s.Options.WatchDelay)
+
+		case <-recheck.C:
+			for _, relPath := range s.deferred.take(time.Now()) {
+				changed[relPath] = struct{}{}
+			}
+			timer.Reset(s.Options.WatchDelay)
+
+		case relPath, ok := <-watcher.Events():
+			if !ok {
+				return nil
+			}
+			changed[relPath] = struct{}{}
+			timer.Reset(s.Options.WatchDelay)
+
+		case err, ok := <-watcher.Errors():
+			if !ok {
+				return nil
+			}
+			// Out of watches for new directories, polling finds what they missed
+			var limit *watchLimitError
+			if errors.As(err, &limit) {
+				s.logger.Warn().Err(err).Str("hint", limit.hint).Dur("interval", s.Options.PollInterval).Msg("Ran out of watches, polling the source instead")
+				watcher.Close()
+				watcher = s.newPollWatcher()
+				changed["."] = struct{}{}
+				timer.Reset(s.Options.WatchDelay)
+				continue
+			}
+			s.logger.Warn().Err(err).Msg("Error watching source")
+
+		case <-timer.C:
+			s.syncChanges(changed)
+			changed = make(map[string]struct{})
+			scheduleRecheck()
+		}
+	}
+}
+
+// Tells about changes in the source while it is watched.
+type sourceWatcher interface {
+	Events() <-chan string // Paths that changed, relative to the source root, "." for everything
+	Errors() <-chan error
+	Close() error
+}
+
+// Watches the source the way its filesystem allows: by having the system report changes,
+// with one watch for the whole tree where it can, or by polling when asked to, on network
+// filesystems and when out of watches.
+func (s *Syncer) newSourceWatcher() (sourceWatcher, error) {
+	if s.Options.Poll {
+		return s.newPollWatcher(), nil
+	}
+
+	// Changes made by other machines aren't reported, only scanning finds them
+	if fsType, ok := networkFilesystem(s.localSource.root); ok {
+		s.logger.Warn().Str("filesystem", fsType).Dur("interval", s.Options.PollInterval).Msg("Source is on a network filesystem, polling it for changes")
+		return s.newPollWatcher(), nil
+	}
+
+	if watcher, err := treewatch.New(s.localSource.root); err == nil {
+		return s.newTreeWatcher(watcher), nil
+	} else if !errors.Is(err, treewatch.ErrUnsupported) {
+		s.logger.Warn().Err(err).Msg("Could not watch the source tree as a whole, watching every directory instead")
+	}
+
+	watcher, err := s.newDirWatcher()
+	var limit *watchLimitError
+	if errors.As(err, &limit) {
+		s.logger.Warn().Err(err).Str("hint", limit.hint).Dur("interval", s.Options.PollInterval).Msg("Ran out of watches, polling the source instead")
+		return s.newPollWatcher(), nil
+	}
+	return watcher, err
+}
+
+// Returned by source watchers that ran into a system limit, with how to raise it.
+type watchLimitError struct {
+	err  error
+	hint string
+}
+
+func (e *watchLimitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *watchLimitError) Unwrap() error {
+	return e.err
+}
+
+// Wraps err in a watchLimitError when it means the system ran out of watches.
+func limitError(err error) error {
+	if hint, ok := watchLimit(err); ok {
+		return &watchLimitError{err: err, hint: hint}
+	}
+	return err
+}
+
+// Has the system report changes anywhere in the source with a single watch.
+type treeWatcher struct {
+	s       *Syncer
+	watcher *treewatch.Watcher
+	events  chan string
+	errors  chan error
+	done    chan struct{}
+}
+
+func (s *Syncer) newTreeWatcher(watcher *treewatch.Watcher) *treeWatcher {
+	w := &treeWatcher{
+		s:       s,
+		watcher: watcher,
+		events:  make(chan string),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *treeWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *treeWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *treeWatcher) Close() error {
+	close(w.done)
+	return w.watcher.Close()
+}
+
+// Passes on the changes to what is synced, until the watch ends.
+func (w *treeWatcher) run() {
+	defer close(w.events)
+	s := w.s
+
+	for {
+		var relPath string
+		select {
+		case <-w.done:
+			return
+
+		case changed, ok := <-w.watcher.Events():
+			if !ok {
+				return
+			}
+			if s.inSkippedDir(changed) {
+				continue
+			}
+			relPath = changed
+
+		case err := <-w.watcher.Errors():
+			// Changes were lost, only a full sync catches up with them
+			if !errors.Is(err, treewatch.ErrOverflow) {
+				select {
+				case w.errors <- err:
+				case <-w.done:
+				}
+				continue
+			}
+			s.logger.Warn().Err(err).Msg("Too many changes to follow, syncing everything")
+			relPath = "."
+		}
+
+		select {
+		case w.events <- relPath:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Reports whether one of the directories relPath is in is left out of the sync.
+func (s *Syncer) inSkippedDir(relPath string) bool {
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		path := s.localSource.path(dir)
+		if info, err := os.Lstat(path); err == nil && s.skipWatched(path, dir, fs.FileInfoToDirEntry(info)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Has the system report changes, with a watch on every synced source directory.
+type dirWatcher struct {
+	s       *Syncer
+	watcher *fsnotify.Watcher
+	events  chan string
+	errors  chan error
+	done    chan struct{}
+}
+
+func (s *Syncer) newDirWatcher() (*dirWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, limitError(err)
+	}
+
+	w := &dirWatcher{
+		s:       s,
+		watcher: watcher,
+		events:  make(chan string),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+	}
+	if err := w.add("."); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *dirWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *dirWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *dirWatcher) Close() error {
+	close(w.done)
+	return w.watcher.Close()
+}
+
+func (w *dirWatcher) run() {
+	defer close(w.events)
+	s := w.s
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue // Permissions alone don't make a file copied again
+			}
+			relPath, err := filepath.Rel(s.localSource.root, event.Name)
+			if err != nil || !filepath.IsLocal(relPath) {
+				continue
+			}
+
+			// New directories are watched right away, anything created in them before is
+			// picked up by syncing them whole
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := w.add(relPath); err != nil {
+						w.sendError(err)
+					}
+				}
+			}
+			w.sendEvent(relPath)
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Changes were lost, only a full sync catches up with them
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				s.logger.Warn().Err(err).Msg("Too many changes to follow, syncing everything")
+				w.sendEvent(".")
+				continue
+			}
+			w.sendError(err)
+		}
+	}
+}
+
+// Hands a changed path to whoever watches, unless the watcher is closed first.
+func (w *dirWatcher) sendEvent(relPath string) {
+	select {
+	case w.events <- relPath:
+	case <-w.done:
+	}
+}
+
+func (w *dirWatcher) sendError(err error) {
+	select {
+	case w.errors <- err:
+	case <-w.done:
+	}
+}
+
+// Watches the source directory at relPath and the directories below it that are synced.
+// Running out of watches stops it with a watchLimitError.
+func (w *dirWatcher) add(relPath string) error {
+	s := w.s
+	root := s.localSource.path(relPath)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+
+		entryPath, _ := filepath.Rel(s.localSource.root, path)
+		if s.skipWatched(path, entryPath, d) {
+			return filepath.SkipDir
+		}
+
+		if err := w.watcher.Add(path); err != nil {
+			if err := limitError(err); errors.As(err, new(*watchLimitError)) {
+				return err
+			}
+			s.logger.Warn().Err(err).Str("path", entryPath).Msg("Could not watch directory, changes in it are missed")
+		}
+		return nil
+	})
+}
+
+// Reports whether the source directory at path, entryPath relative to the root, is left
+// out of the sync and so needs no watching.
+func (s *Syncer) skipWatched(path, entryPath string, d fs.DirEntry) bool {
+	if entryPath == "." {
+		return false
+	}
+	if s.matcher.Matches(entryPath) || s.pathRules.Excludes(entryPath, true) {
+		return true
+	}
+	if _, ok := s.excludingMarker(s.src, entryPath); ok {
+		return true
+	}
+	return junction.Is(path, d)
+}
+
+// Source files deferred for MinAge while watching, by when they are old enough.
+type deferredFiles struct {
+	mu    sync.Mutex
+	paths map[string]time.Time
+}
+
+func (d *deferredFiles) add(relPath string, due time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.paths[relPath] = due
+}
+
+// Returns when the first of the deferred files is old enough, if any are deferred.
+func (d *deferredFiles) next() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var first time.Time
+	for _, due := range d.paths {
+		if first.IsZero() || due.Before(first) {
+			first = due
+		}
+	}
+	return first, !first.IsZero()
+}
+
+// Removes and returns the deferred files old enough by now.
+func (d *deferredFiles) take(now time.Time) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var due []string
+	for relPath, at := range d.paths {
+		if !at.After(now) {
+			due = append(due, relPath)
+			delete(d.paths, relPath)
+		}
+	}
+	return due
+}
+
+// Syncs the source paths that changed, directories with everything below them. Paths
+// gone from the source are deleted at the destination when deletions are enabled.
+func (s *Syncer) syncChanges(changed map[string]struct{}) {
+	paths := make([]string, 0, len(changed))
+	for relPath := range changed {
+		paths = append(paths, relPath)
+	}
+	slices.Sort(paths)
+
+	// A full sync covers everything, a changed directory everything below it
+	if _, ok := changed["."]; ok {
+		paths = []string{"."}
+	}
+	paths = slices.DeleteFunc(paths, func(relPath string) bool {
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			if _, ok := changed[dir]; ok {
+				return true
+			}
+		}
+		return false
+	})
+
+	s.stats.setPhase("copying")
+	s.fileOps = make(chan fileJob, s.Options.QueueSize)
+	s.startWorkers()
+
+	var removed []string
+	var sourceFiles memoryIndex
+	for _, relPath := range paths {
+		if relPath == "." {
+			sourceFiles = memoryIndex{}
+			if err := s.walkSource(s.src, "", nil, sourceFiles); err != nil {
+				s.logger.Error().Err(err).Msg("Error walking source directory")
+				sourceFiles = nil // Incomplete, nothing can be deleted by it
+			}
+			continue
+		}
+
+		info, err := os.Lstat(s.localSource.path(relPath))
+		if os.IsNotExist(err) {
+			removed = append(removed, relPath)
+			continue
+		} else if err != nil {
+			s.logger.Warn().Err(err).Str("path", relPath).Msg("Could not stat changed source path")
+			continue
+		}
+
+		err = s.visitSource(s.src, relPath, relPath, fs.FileInfoToDirEntry(info), nil, memoryIndex{})
+		if err == nil && info.IsDir() {
+			err = s.walkSource(&localBackend{root: s.localSource.path(relPath)}, relPath, nil, memoryIndex{})
+		}
+		if err != nil && !errors.Is(err, filepath.SkipDir) {
+			s.logger.Error().Err(err).Str("path", relPath).Msg("Error syncing changed directory")
+		}
+	}
+	s.applyDirectoryTimes()
+
+	close(s.fileOps)
+	s.wg.Wait()
+	s.applyDirectoryOwners()
+	if s.local != nil {
+		s.local.removePartialDirs()
+	}
+
+	if s.Options.Delete {
+		if sourceFiles != nil {
+			if err := s.propagateDeletions(sourceFiles); err != nil {
+				s.logger.Error().Err(err).Msg("Error propagating deletions")
+			}
+		} else if err := s.deleteRemoved(removed); err != nil {
+			s.logger.Error().Err(err).Msg("Error propagating deletions")
+		}
+	}
+	s.pruneChunks()
+	if err := s.writeManifest(); err != nil {
+		s.logger.Error().Err(err).Msg("Error writing the manifest")
+	}
+
+	s.flushPlan()
+	s.stats.setPhase("watching")
+	s.logger.Info().Str("action", "SYNC_CHANGES").Int("paths", len(paths)).Msg("Changes synced")
+}
+
+// Deletes what the destination holds at the removed paths, which are gone from the source,
+// along with everything below them. Paths kept by a full sync's deletions are kept here
+// too, and the batch as a whole is limited and confirmed like them.
+func (s *Syncer) deleteRemoved(removed []string) error {
+	var files, directories []string
+	keptDirectories := make(map[string]struct{})
+	keepParents := func(relPath string) {
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			keptDirectories[dir] = struct{}{}
+		}
+	}
+	separator := string(filepath.Separator)
+	kept := func(relPath string, d fs.DirEntry) bool {
+		return s.protectPaths.Excludes(relPath, d.IsDir()) || s.isBackupFile(relPath) ||
+			d.IsDir() && (s.protectedByMarker(relPath) || s.isPartialDir(relPath) || s.isBackupDir(relPath))
+	}
+
+	for _, relPath := range removed {
+		s.dest.Walk(func(entryPath string, d fs.DirEntry, err error) error {
+			if err != nil || entryPath == "." {
+				return nil
+			}
+
+			// Only relPath and what leads to it is looked at, nothing in a kept directory
+			if entryPath != relPath && !strings.HasPrefix(entryPath, relPath+separator) {
+				if d.IsDir() && strings.HasPrefix(relPath, entryPath+separator) && !kept(entryPath, d) {
+					return nil
+				}
+				return skipEntry(d)
+			}
+
+			if kept(entryPath, d) {
+				keepParents(entryPath)
+				return skipEntry(d)
+			}
+
+			if d.IsDir() && !(s.local != nil && junction.Is(s.local.path(entryPath), d)) {
+				directories = append(directories, entryPath)
+				return nil
+			}
+			files = append(files, entryPath)
+			return skipEntry(d)
+		})
+	}
+
+	// Only the percentage needs to know how many files the destination holds
+	total := 0
+	if s.Options.MaxDeletePercent != nil {
+		s.dest.Walk(func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				total++
+			}
+			return nil
+		})
+	}
+	if err := s.checkDeleteLimit(len(files), total); err != nil {
+		return err
+	}
+	if s.Options.ConfirmDeletions != nil && !s.Options.DryRun && len(files) > 0 {
+		files = s.confirmDeletions(files, keepParents)
+	}
+
+	for _, file := range files {
+		s.deletePath(file, false)
+	}
+
+	directories = slices.DeleteFunc(directories, func(dir string) bool {
+		_, kept := keptDirectories[dir]
+		return kept
+	})
+	for _, level := range byDepthDescending(directories) {
+		for _, dir := range level {
+			s.deletePath(dir, true)
+		}
+	}
+	return nil
+}
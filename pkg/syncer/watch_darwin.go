@@ -0,0 +1,31 @@
+//go:build darwin
+
+package syncer
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystems that don't report changes made by other machines, by their type name.
+var networkFilesystems = map[string]bool{"nfs": true, "smbfs": true, "afpfs": true, "webdav": true, "cifs": true}
+
+// Reports whether path is on a network filesystem, and which.
+func networkFilesystem(path string) (string, bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", false
+	}
+	name := unix.ByteSliceToString(stat.Fstypename[:])
+	return name, networkFilesystems[name]
+}
+
+// Reports whether err means the process ran out of file descriptors for watches, with how
+// to raise the limit.
+func watchLimit(err error) (string, bool) {
+	if errors.Is(err, unix.EMFILE) || errors.Is(err, unix.ENFILE) {
+		return "raise the open files limit, e.g. with: ulimit -n 65536", true
+	}
+	return "", false
+}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package syncer
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystems that don't report changes made by other machines, by their statfs magic.
+var networkFilesystems = map[int64]string{
+	unix.NFS_SUPER_MAGIC:  "nfs",
+	unix.SMB_SUPER_MAGIC:  "smb",
+	unix.SMB2_SUPER_MAGIC: "smb2",
+	unix.CIFS_SUPER_MAGIC: "cifs",
+	unix.AFS_SUPER_MAGIC:  "afs",
+	unix.CEPH_SUPER_MAGIC: "ceph",
+	unix.V9FS_MAGIC:       "9p",
+}
+
+// Reports whether path is on a network filesystem, and which.
+func networkFilesystem(path string) (string, bool) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return "", false
+	}
+	name, ok := networkFilesystems[int64(stat.Type)]
+	return name, ok
+}
+
+// Reports whether err means inotify ran out of watches or instances, with how to raise the limit.
+func watchLimit(err error) (string, bool) {
+	switch {
+	case errors.Is(err, unix.ENOSPC):
+		return "raise fs.inotify.max_user_watches, e.g. with: sysctl fs.inotify.max_user_watches=524288", true
+	case errors.Is(err, unix.EMFILE):
+		return "raise fs.inotify.max_user_instances, e.g. with: sysctl fs.inotify.max_user_instances=1024", true
+	}
+	return "", false
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+
+package syncer
+
+func networkFilesystem(path string) (string, bool) {
+	return "", false
+}
+
+func watchLimit(err error) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,79 @@
+package syncer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Waits up to ten seconds for done to report true.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestWatchDeleteLimits(t *testing.T) {
+	zero, tenPercent := 0, 10.0
+
+	tests := []struct {
+		name    string
+		options SyncOptions
+		want    []string
+	}{
+		{"no limit", SyncOptions{}, []string{"keep", "new"}},
+		{"0 deletes none", SyncOptions{MaxDelete: &zero}, []string{"d/f3", "f1", "f2", "keep", "new"}},
+		{"above the percentage", SyncOptions{MaxDeletePercent: &tenPercent}, []string{"d/f3", "f1", "f2", "keep", "new"}},
+		{"forced", SyncOptions{MaxDelete: &zero, Force: true}, []string{"keep", "new"}},
+		{"protected file", SyncOptions{ProtectPatterns: []string{"f1"}}, []string{"f1", "keep", "new"}},
+		{"protected directory", SyncOptions{ProtectPatterns: []string{"d/"}}, []string{"d/f3", "keep", "new"}},
+		{"declined", SyncOptions{ConfirmDeletions: func(_ string, paths []string) []string {
+			return slices.DeleteFunc(paths, func(relPath string) bool { return relPath == "f2" })
+		}}, []string{"f2", "keep", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dest := t.TempDir(), t.TempDir()
+			writeTree(t, src, "f1", "f2", "d/f3", "keep")
+
+			// Polled, so the deletions all come in one batch
+			options := tt.options
+			options.Delete = true
+			options.Poll = true
+			options.PollInterval = 20 * time.Millisecond
+			options.WatchDelay = 50 * time.Millisecond
+			s := NewSyncer(src, dest, WithOptions(&options))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- s.Watch(ctx) }()
+			defer func() {
+				cancel()
+				if err := <-done; err != nil {
+					t.Error(err)
+				}
+			}()
+			waitFor(t, "the source to be watched", func() bool { return s.Progress().Phase == "watching" })
+
+			for _, file := range []string{"f1", "f2", "d/f3"} {
+				if err := os.Remove(filepath.Join(src, filepath.FromSlash(file))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeTree(t, src, "new")
+
+			// The new file is synced along with the deletions, which are done when watching again
+			waitFor(t, "the changes to be synced", func() bool { return slices.Contains(treeFiles(t, dest), "new") })
+			waitFor(t, "the source to be watched again", func() bool { return s.Progress().Phase == "watching" })
+			if got := treeFiles(t, dest); !slices.Equal(got, tt.want) {
+				t.Errorf("destination holds %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
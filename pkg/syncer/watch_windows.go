@@ -0,0 +1,23 @@
+//go:build windows
+
+package syncer
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reports whether path is on a network share.
+func networkFilesystem(path string) (string, bool) {
+	root, err := windows.UTF16PtrFromString(filepath.VolumeName(path) + `\`)
+	if err != nil {
+		return "", false
+	}
+	return "remote", windows.GetDriveType(root) == windows.DRIVE_REMOTE
+}
+
+// Windows has no limit on watches.
+func watchLimit(err error) (string, bool) {
+	return "", false
+}
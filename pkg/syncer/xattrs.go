@@ -0,0 +1,51 @@
+package syncer
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/bipinmdr07/gosync/internal/xattr"
+)
+
+// Reports whether the extended attribute name is carried over by --xattrs. On Linux those
+// are the user and security namespaces, trusted and system ones belong to the kernel and
+// privileged tools. macOS has no namespaces and everything is copied. Checksums recorded
+// by gosync are only valid for the file they were recorded on.
+func copiedXattr(name string) bool {
+	if strings.HasPrefix(name, checksumXattrPrefix) {
+		return false
+	}
+	if runtime.GOOS == "linux" {
+		return strings.HasPrefix(name, "user.") || strings.HasPrefix(name, "security.")
+	}
+	return true
+}
+
+// Copies the extended attributes of the source file to the destination file. Attributes
+// that can't be set are warned about one by one, the copy itself stands.
+func (s *Syncer) copyXattrs(srcFile, destinationFile *os.File, relPath string) {
+	names, err := xattr.FList(srcFile)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("action", "XATTR").Str("path", relPath).Msg("Could not list extended attributes")
+		return
+	}
+
+	for _, name := range names {
+		if !copiedXattr(name) {
+			continue
+		}
+
+		value, err := xattr.FGet(srcFile, name)
+		if errors.Is(err, xattr.ErrNotFound) {
+			continue // Removed since it was listed
+		}
+		if err == nil {
+			err = xattr.FSet(destinationFile, name, value)
+		}
+		if err != nil {
+			s.logger.Warn().Err(err).Str("action", "XATTR").Str("path", relPath).Str("xattr", name).Msg("Could not preserve extended attribute")
+		}
+	}
+}